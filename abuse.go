@@ -0,0 +1,195 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// maxReasonLength bounds the stored reason of abuse reports.
+const maxReasonLength = 1000
+
+// AbuseReport is a report of a link used for abuse, eg: phishing or spam.
+type AbuseReport struct {
+	ID       string    `json:"id"`
+	Domain   string    `json:"domain,omitempty"`
+	URL      string    `json:"url"`
+	Reason   string    `json:"reason"`
+	At       time.Time `json:"at"`
+	Reports  int       `json:"reports"`  // number of reports of the link, this one included
+	Disabled bool      `json:"disabled"` // the link was disabled by this report
+	// RequestID is the id of the request reporting the link, set with WithRequestID.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// abuseRecord is the stored part of an abuse report.
+type abuseRecord struct {
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+// WithAbuseNotifier calls fn for every abuse report, eg: to send a webhook or an email to the moderators.
+func WithAbuseNotifier(fn func(AbuseReport)) Options {
+	return func(h *Handler) {
+		h.abuseNotifier = fn
+	}
+}
+
+// WithAbuseAutoDisable disables the links reported n times, until a moderator enables them back.
+func WithAbuseAutoDisable(n int) Options {
+	return func(h *Handler) {
+		h.abuseThreshold = n
+	}
+}
+
+func abuseReportsPrefix(l linkRef) []byte {
+	return metaPrefixKey("report", l.domain, l.id)
+}
+
+func abuseReportKey(l linkRef, at time.Time) []byte {
+	return append(abuseReportsPrefix(l), fmt.Sprintf("%020d", at.UnixNano())...)
+}
+
+func abuseCountKey(l linkRef) []byte {
+	return metaKey("reports", l.domain, l.id)
+}
+
+// ReportAbuse records a report of the link with the given id, and disables it
+// when it reaches the number of reports set by WithAbuseAutoDisable.
+func (h *Handler) ReportAbuse(id, reason string, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	id = h.normalizeID(id)
+	if !validID(id) || !h.verify(id) {
+		return ErrNotFound
+	}
+	if len(reason) > maxReasonLength {
+		reason = reason[:maxReasonLength]
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	report := AbuseReport{ID: id, Reason: reason, At: time.Now(), RequestID: r.requestID}
+	err := h.updateRetry(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		l := linkRef{domain: r.domain, id: id}
+		report.Domain = l.domain
+
+		b, err := getValue(txn, l.key())
+		if err != nil {
+			return err
+		}
+		e, err := decodeEntry(b)
+		if err != nil {
+			return err
+		}
+		report.URL = e.URL
+
+		rec, err := json.Marshal(abuseRecord{Reason: reason, At: report.At})
+		if err != nil {
+			return err
+		}
+		if err := txn.Set(abuseReportKey(l, report.At), rec); err != nil {
+			return err
+		}
+		if err := incr(txn, abuseCountKey(l), 1); err != nil {
+			return err
+		}
+		n, err := getCounter(txn, abuseCountKey(l))
+		if err != nil {
+			return err
+		}
+		report.Reports = int(n)
+
+		report.Disabled = false // the transaction may be retried
+		if h.abuseThreshold > 0 && report.Reports >= h.abuseThreshold && !e.Disabled {
+			report.Disabled = true
+			err := h.updateEntry(txn, l.key(), func(e *entry) error {
+				e.Disabled = true
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return h.enqueue(txn, EventAbuseReport, report)
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	h.log(LogAdmin).Warningf("Abuse report of entry %s (request %s): %s", id, r.requestID, reason)
+	if report.Disabled {
+		h.log(LogAdmin).Warningf("Disable entry %s after %d abuse reports", id, report.Reports)
+	}
+	if h.abuseNotifier != nil {
+		go h.abuseNotifier(report)
+	}
+	return nil
+}
+
+// AbuseReports returns the abuse reports of a link, oldest first.
+func (h *Handler) AbuseReports(id string, opts ...ReqOptions) ([]AbuseReport, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	id = h.normalizeID(id)
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	var reports []AbuseReport
+	err := h.db.View(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		l := linkRef{domain: r.domain, id: id}
+
+		var url string
+		if b, err := getValue(txn, l.key()); err == nil {
+			e, err := decodeEntry(b)
+			if err != nil {
+				return err
+			}
+			url = e.URL
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		return iteratePrefix(txn, abuseReportsPrefix(l), func(_ string, value []byte) error {
+			var rec abuseRecord
+			if err := json.Unmarshal(value, &rec); err != nil {
+				return err
+			}
+			reports = append(reports, AbuseReport{
+				ID:      id,
+				Domain:  l.domain,
+				URL:     url,
+				Reason:  rec.Reason,
+				At:      rec.At,
+				Reports: len(reports) + 1,
+			})
+			return nil
+		})
+	})
+	return reports, err
+}
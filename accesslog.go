@@ -0,0 +1,122 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type AccessLogFormat int
+
+const (
+	// LogCombined is the Apache combined log format, followed by the latency in microseconds and the request id.
+	LogCombined AccessLogFormat = iota
+	// LogJSON logs a JSON document per request.
+	LogJSON
+)
+
+// accessLogEntry is a request as logged with LogJSON.
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Location  string    `json:"location,omitempty"` // destination of redirects
+	LatencyUS int64     `json:"latency_us"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// AccessLog is a middleware writing a line per request to w, with its result and latency.
+// It can wrap the Handler as well as the routes of an api.
+func AccessLog(w io.Writer, format AccessLogFormat) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			e := accessLogEntry{
+				Time:      start,
+				Remote:    clientIP(r),
+				Method:    r.Method,
+				URI:       r.RequestURI,
+				Proto:     r.Proto,
+				Status:    rec.status,
+				Bytes:     rec.bytes,
+				Referer:   r.Referer(),
+				UserAgent: r.UserAgent(),
+				Location:  rec.Header().Get("Location"),
+				LatencyUS: time.Since(start).Microseconds(),
+				RequestID: rec.Header().Get(RequestIDHeader),
+			}
+
+			var line []byte
+			if format == LogJSON {
+				line, _ = json.Marshal(e)
+				line = append(line, '\n')
+			} else {
+				line = []byte(e.combined())
+			}
+
+			mu.Lock()
+			w.Write(line)
+			mu.Unlock()
+		})
+	}
+}
+
+func (e accessLogEntry) combined() string {
+	size := "-"
+	if e.Bytes > 0 {
+		size = fmt.Sprint(e.Bytes)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s %q %q %d %s\n",
+		e.Remote, e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.URI, e.Proto,
+		e.Status, size, orDash(e.Referer), orDash(e.UserAgent), e.LatencyUS, orDash(e.RequestID))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// statusRecorder records the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streamed responses through the recorder.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
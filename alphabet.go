@@ -0,0 +1,50 @@
+package coopurl
+
+import "math/big"
+
+// WithAlphabet sets the characters generated ids are made of, lowercase hexadecimal by default.
+// eg: "abcdefghjkmnpqrstuvwxyz23456789" excludes the characters that can be confused (0/O, 1/l).
+// Alphabets must be made of at least two distinct characters unreserved in urls: ascii letters, digits,
+// '-', '_' and '~', other alphabets are rejected by New. '.' is unreserved but ends the ids in paths.
+func WithAlphabet(alphabet string) Options {
+	return Checked(func(h *Handler) error {
+		if !validAlphabet(alphabet) {
+			return optionError("alphabet %q isn't made of at least two distinct letters, digits, '-', '_' or '~'", alphabet)
+		}
+		h.alphabet = alphabet
+		return nil
+	})
+}
+
+func validAlphabet(alphabet string) bool {
+	if len(alphabet) < 2 {
+		return false
+	}
+	seen := map[rune]bool{}
+	for _, c := range alphabet {
+		if seen[c] || !unreserved(c) {
+			return false
+		}
+		seen[c] = true
+	}
+	return true
+}
+
+// unreserved tells if c is a character unreserved in urls, but '.'.
+func unreserved(c rune) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '~'
+}
+
+// encodeAlphabet encodes b as a number in base len(alphabet), returning at most n characters.
+func encodeAlphabet(b []byte, alphabet string, n int) string {
+	x := new(big.Int).SetBytes(b)
+	base := big.NewInt(int64(len(alphabet)))
+	mod := new(big.Int)
+
+	out := make([]byte, 0, n)
+	for len(out) < n && x.Sign() > 0 {
+		x.DivMod(x, base, mod)
+		out = append(out, alphabet[mod.Int64()])
+	}
+	return string(out)
+}
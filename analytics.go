@@ -0,0 +1,362 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const dayFormat = "2006-01-02"
+
+type AnalyticsMode int
+
+const (
+	// AnalyticsCounts only counts the clicks, per day and per referrer. It is the default mode.
+	AnalyticsCounts AnalyticsMode = iota
+	// AnalyticsFull also records an event per click with the ip address, user agent and referrer of the client.
+	AnalyticsFull
+	// AnalyticsPrivate records no personal data: the clicks are only counted per day, referrer host and country,
+	// not per hour, and no event is recorded.
+	AnalyticsPrivate
+)
+
+// WithAnalytics sets how the clicks on links are recorded.
+func WithAnalytics(mode AnalyticsMode) Options {
+	return func(h *Handler) {
+		h.analytics = mode
+	}
+}
+
+// ClickEvent is a click recorded with AnalyticsFull.
+type ClickEvent struct {
+	At        time.Time `json:"at"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Referrer  string    `json:"referrer,omitempty"`
+}
+
+// LinkStats are the analytics of a link.
+type LinkStats struct {
+	Clicks    uint64            `json:"clicks"`
+	BotClicks uint64            `json:"bot_clicks"` // requests of bots, not included in the other counts
+	Daily     map[string]uint64 `json:"daily"`      // clicks per day, formatted as "2006-01-02"
+	Referrers map[string]uint64 `json:"referrers"`  // clicks per referrer host, "" for direct clicks
+	// Hourly are the clicks per hour of the last week, formatted as "2006-01-02T15", and Monthly the clicks per
+	// month rolled up, formatted as "2006-01".
+	Hourly  map[string]uint64 `json:"hourly,omitempty"`
+	Monthly map[string]uint64 `json:"monthly,omitempty"`
+	// Countries are the clicks per country, counted with WithCountryLookup.
+	Countries map[string]uint64 `json:"countries,omitempty"`
+	// Visitors are the unique visitors of each day summed, counted with WithUniqueVisitors.
+	Visitors      uint64            `json:"visitors,omitempty"`
+	DailyVisitors map[string]uint64 `json:"daily_visitors,omitempty"`
+	// Buttons are the clicks per button of pages, by position from "0". Clicks are the views of the page.
+	Buttons map[string]uint64 `json:"buttons,omitempty"`
+	// Branches are the clicks per branch of the last canary, see StartCanary.
+	Branches map[string]uint64 `json:"branches,omitempty"`
+}
+
+func dailyPrefix(l linkRef) []byte {
+	return metaPrefixKey("daily", l.domain, l.id)
+}
+
+func referrersPrefix(l linkRef) []byte {
+	return metaPrefixKey("referrer", l.domain, l.id)
+}
+
+func countriesPrefix(l linkRef) []byte {
+	return metaPrefixKey("country", l.domain, l.id)
+}
+
+func eventsPrefix(l linkRef) []byte {
+	return metaPrefixKey("click", l.domain, l.id)
+}
+
+// eventTimeLength is the length of the time ending the keys of the click events.
+const eventTimeLength = 20
+
+func eventKey(l linkRef, at time.Time) []byte {
+	// nanoseconds are zero padded so events are iterated in order
+	return append(eventsPrefix(l), fmt.Sprintf("%0*d", eventTimeLength, at.UnixNano())...)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func referrerHost(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil {
+		return ""
+	}
+	return strings.ReplaceAll(normalizeDomain(u.Host), metaPrefix, "")
+}
+
+// clickEvent builds the event recorded for the request with AnalyticsFull.
+func (h *Handler) clickEvent(r *http.Request, at time.Time) ClickEvent {
+	return ClickEvent{
+		At:        at,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Referrer:  r.Referer(),
+	}
+}
+
+// recordClick counts a click on the link, and records its event depending on the analytics mode.
+// Clicks of bots are only counted apart, to keep the stats about people.
+// The visitor is counted if not empty, see visitor.
+func (h *Handler) recordClick(r *http.Request, l linkRef, visitor, branch string) error {
+	if h.botKind(r) != NotBot {
+		return h.updateRetry(func(txn *badger.Txn) error {
+			return incr(txn, botClicksKey(l), 1)
+		})
+	}
+
+	now := time.Now()
+
+	var country string
+	if h.countryLookup != nil {
+		country = h.countryLookup(clientIP(r))
+	}
+
+	var event []byte
+	if h.analytics == AnalyticsFull {
+		var err error
+		if event, err = json.Marshal(h.clickEvent(r, now)); err != nil {
+			return err
+		}
+	}
+
+	return h.updateRetry(func(txn *badger.Txn) error {
+		if err := incr(txn, clicksKey(l.domain, l.id), 1); err != nil {
+			return err
+		}
+		daily := append(dailyPrefix(l), now.UTC().Format(dayFormat)...)
+		if err := incr(txn, daily, 1); err != nil {
+			return err
+		}
+		if h.analytics != AnalyticsPrivate {
+			if err := incr(txn, append(hourlyPrefix(l), now.UTC().Format(hourFormat)...), 1); err != nil {
+				return err
+			}
+		}
+		if err := incr(txn, append(referrersPrefix(l), referrerHost(r)...), 1); err != nil {
+			return err
+		}
+		if country != "" {
+			if err := incr(txn, append(countriesPrefix(l), country...), 1); err != nil {
+				return err
+			}
+		}
+		if visitor != "" {
+			if err := countVisitor(txn, l, visitor, now); err != nil {
+				return err
+			}
+		}
+		if branch != "" {
+			if err := incr(txn, append(branchesPrefix(l), branch...), 1); err != nil {
+				return err
+			}
+		}
+		if event == nil {
+			return nil
+		}
+		return txn.Set(eventKey(l, now), event)
+	})
+}
+
+// Stats returns the click counts of a link.
+func (h *Handler) Stats(id string, opts ...ReqOptions) (*LinkStats, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	id = h.normalizeID(id)
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	var s *LinkStats
+	err := h.db.View(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+
+		var err error
+		s, err = linkStats(txn, linkRef{domain: r.domain, id: id})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func linkStats(txn *badger.Txn, l linkRef) (*LinkStats, error) {
+	s := LinkStats{Daily: map[string]uint64{}, Hourly: map[string]uint64{}, Monthly: map[string]uint64{}, Referrers: map[string]uint64{}, Countries: map[string]uint64{}, Buttons: map[string]uint64{}, DailyVisitors: map[string]uint64{}, Branches: map[string]uint64{}}
+
+	var err error
+	if s.Clicks, err = getCounter(txn, clicksKey(l.domain, l.id)); err != nil {
+		return nil, err
+	}
+	if s.BotClicks, err = getCounter(txn, botClicksKey(l)); err != nil {
+		return nil, err
+	}
+	if err := iterateCounters(txn, dailyPrefix(l), s.Daily); err != nil {
+		return nil, err
+	}
+	if err := iterateCounters(txn, hourlyPrefix(l), s.Hourly); err != nil {
+		return nil, err
+	}
+	if err := iterateCounters(txn, monthlyPrefix(l), s.Monthly); err != nil {
+		return nil, err
+	}
+	if err := iterateCounters(txn, referrersPrefix(l), s.Referrers); err != nil {
+		return nil, err
+	}
+	if err := iterateCounters(txn, countriesPrefix(l), s.Countries); err != nil {
+		return nil, err
+	}
+	if err := iterateCounters(txn, buttonsPrefix(l), s.Buttons); err != nil {
+		return nil, err
+	}
+	if err := iterateCounters(txn, branchesPrefix(l), s.Branches); err != nil {
+		return nil, err
+	}
+	if s.Visitors, err = getCounter(txn, visitorsKey(l)); err != nil {
+		return nil, err
+	}
+	if err := iterateCounters(txn, dailyVisitorsPrefix(l), s.DailyVisitors); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// statsKeys returns the keys of the stats of the link l: its counters, click events and abuse reports.
+func statsKeys(txn *badger.Txn, l linkRef) ([][]byte, error) {
+	keys := [][]byte{clicksKey(l.domain, l.id), botClicksKey(l), visitorsKey(l), abuseCountKey(l)}
+	for _, prefix := range [][]byte{eventsPrefix(l), dailyPrefix(l), hourlyPrefix(l), monthlyPrefix(l), dailyVisitorsPrefix(l), referrersPrefix(l), countriesPrefix(l), buttonsPrefix(l), branchesPrefix(l), abuseReportsPrefix(l)} {
+		err := iteratePrefix(txn, prefix, func(key string, _ []byte) error {
+			keys = append(keys, append(append([]byte{}, prefix...), key...))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// Events returns the click events of a link recorded since the given time, oldest first.
+func (h *Handler) Events(id string, since time.Time, opts ...ReqOptions) ([]ClickEvent, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	id = h.normalizeID(id)
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	var events []ClickEvent
+	err := h.db.View(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		l := linkRef{domain: r.domain, id: id}
+
+		return iteratePrefix(txn, eventsPrefix(l), func(_ string, value []byte) error {
+			var e ClickEvent
+			if err := json.Unmarshal(value, &e); err != nil {
+				return err
+			}
+			if !e.At.Before(since) {
+				events = append(events, e)
+			}
+			return nil
+		})
+	})
+	return events, err
+}
+
+// WithEventRetention keeps the click events recorded with AnalyticsFull, or formerly with AnalyticsPrivate, for the given
+// duration, eg: 90 days. Older events are purged every hour, the click counts per day, referrer and country are
+// kept forever.
+func WithEventRetention(retention time.Duration) Options {
+	return func(h *Handler) {
+		h.eventRetention = retention
+	}
+}
+
+// PurgeEvents removes the click events older than the event retention duration, and returns their number.
+func (h *Handler) PurgeEvents() (int, error) {
+	if err := h.ready(); err != nil {
+		return 0, err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return 0, err
+	}
+	if h.eventRetention <= 0 {
+		return 0, nil
+	}
+
+	cutoff := time.Now().Add(-h.eventRetention).UnixNano()
+	wb := h.db.NewWriteBatch()
+	defer wb.Cancel()
+	n := 0
+	err := h.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		// the keys end with the zero padded nanoseconds of the click
+		prefix := metaPrefixKey("click")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			k := it.Item().KeyCopy(nil)
+			at, err := strconv.ParseInt(string(k[len(k)-eventTimeLength:]), 10, 64)
+			if err != nil || at >= cutoff {
+				continue
+			}
+			if err := wb.Delete(k); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		h.log(LogAdmin).Infof("Purged %d click events", n)
+	}
+	return n, nil
+}
+
+// iterateCounters reads all the counters starting with prefix into m, by key suffix.
+func iterateCounters(txn *badger.Txn, prefix []byte, m map[string]uint64) error {
+	return iteratePrefix(txn, prefix, func(key string, value []byte) error {
+		m[key] = decodeCounter(value)
+		return nil
+	})
+}
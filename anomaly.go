@@ -0,0 +1,161 @@
+package coopurl
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type AnomalyKind int
+
+const (
+	// AnomalySpike is a link redirecting many times more than usual.
+	AnomalySpike AnomalyKind = iota
+	// AnomalySingleIP is a link mostly requested by a single ip address.
+	AnomalySingleIP
+)
+
+func (k AnomalyKind) String() string {
+	switch k {
+	case AnomalySpike:
+		return "spike"
+	case AnomalySingleIP:
+		return "single-ip"
+	default:
+		return "unknown"
+	}
+}
+
+// Anomaly is an unusual traffic on a link, eg: a link used in a spam campaign or hammered by a script.
+type Anomaly struct {
+	Kind     AnomalyKind
+	ID       string
+	Domain   string
+	Requests int     // requests of the current window
+	Baseline float64 // usual requests per window
+	IP       string  // address sending most of the requests, for AnomalySingleIP
+	At       time.Time
+}
+
+// AnomalyConfig sets when the traffic of a link is reported as an anomaly.
+// Zero values are replaced by the ones of DefaultAnomalyConfig.
+type AnomalyConfig struct {
+	Window      time.Duration // duration over which requests are counted
+	MinRequests int           // requests in a window below which the traffic is never unusual
+	SpikeFactor float64       // ratio to the baseline making a spike
+	IPShare     float64       // share of the requests of a window coming from a single address
+}
+
+var DefaultAnomalyConfig = AnomalyConfig{
+	Window:      time.Minute,
+	MinRequests: 100,
+	SpikeFactor: 10,
+	IPShare:     0.5,
+}
+
+// WithAnomalyDetection tracks the request rate of every link in memory, and calls fn at most
+// once per window and kind when the traffic of a link is unusual.
+func WithAnomalyDetection(cfg AnomalyConfig, fn func(Anomaly)) Options {
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultAnomalyConfig.Window
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = DefaultAnomalyConfig.MinRequests
+	}
+	if cfg.SpikeFactor <= 0 {
+		cfg.SpikeFactor = DefaultAnomalyConfig.SpikeFactor
+	}
+	if cfg.IPShare <= 0 {
+		cfg.IPShare = DefaultAnomalyConfig.IPShare
+	}
+	return func(h *Handler) {
+		h.anomalies = &anomalyDetector{cfg: cfg, notify: fn, links: map[linkRef]*linkRate{}}
+	}
+}
+
+type anomalyDetector struct {
+	cfg    AnomalyConfig
+	notify func(Anomaly)
+
+	mu    sync.Mutex
+	links map[linkRef]*linkRate
+}
+
+// linkRate counts the requests of a link in the current window.
+type linkRate struct {
+	start    time.Time
+	requests int
+	ips      map[string]int
+	baseline float64 // moving average of the requests of the previous windows
+	reported map[AnomalyKind]bool
+}
+
+// baselineWeight is the weight of the last window in the baseline.
+const baselineWeight = 0.2
+
+func (d *anomalyDetector) observe(l linkRef, r *http.Request, now time.Time) {
+	ip := clientIP(r)
+
+	d.mu.Lock()
+	rate, ok := d.links[l]
+	if !ok {
+		rate = &linkRate{start: now}
+		d.links[l] = rate
+	}
+	rate.roll(now, d.cfg.Window)
+	rate.requests++
+	rate.ips[ip]++
+
+	var found []Anomaly
+	if rate.requests >= d.cfg.MinRequests {
+		if !rate.reported[AnomalySpike] && rate.baseline > 0 && float64(rate.requests) > d.cfg.SpikeFactor*rate.baseline {
+			rate.reported[AnomalySpike] = true
+			found = append(found, Anomaly{Kind: AnomalySpike})
+		}
+		if !rate.reported[AnomalySingleIP] && float64(rate.ips[ip]) >= d.cfg.IPShare*float64(rate.requests) {
+			rate.reported[AnomalySingleIP] = true
+			found = append(found, Anomaly{Kind: AnomalySingleIP, IP: ip})
+		}
+	}
+	requests, baseline := rate.requests, rate.baseline
+	d.mu.Unlock()
+
+	for _, a := range found {
+		a.ID, a.Domain = l.id, l.domain
+		a.Requests, a.Baseline, a.At = requests, baseline, now
+		go d.notify(a)
+	}
+}
+
+// roll starts a new window if the current one is over, folding it in the baseline.
+func (r *linkRate) roll(now time.Time, window time.Duration) {
+	if r.ips != nil && now.Sub(r.start) < window {
+		return
+	}
+	if r.ips != nil {
+		r.baseline = (1-baselineWeight)*r.baseline + baselineWeight*float64(r.requests)
+		// the windows without requests since then
+		empty := int(now.Sub(r.start)/window) - 1
+		r.baseline *= math.Pow(1-baselineWeight, float64(empty))
+	}
+	r.start = now
+	r.requests = 0
+	r.ips = map[string]int{}
+	r.reported = map[AnomalyKind]bool{}
+}
+
+// prune forgets the links without recent traffic.
+func (d *anomalyDetector) prune() {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for l, r := range d.links {
+		if now.Sub(r.start) >= d.cfg.Window {
+			r.roll(now, d.cfg.Window)
+		}
+		if r.requests == 0 && r.baseline < 1 {
+			delete(d.links, l)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package coopurl
+
+import (
+	"net/http"
+	"time"
+)
+
+// The public surface of the library is split by concern, so applications can depend only on what they use.
+// Handler implements all of them. These interfaces are meant to be used, not implemented:
+// methods may be added to them in minor versions.
+
+// Shortener creates, resolves, updates and deletes links.
+type Shortener interface {
+	Post(url string, opts ...ReqOptions) (string, error)
+	PostPage(p Page, opts ...ReqOptions) (string, error)
+	PostBatch(links []BatchLink, opts ...ReqOptions) ([]BatchResult, error)
+	Clone(id string, opts ...ReqOptions) (string, error)
+	UpdatePage(id string, p Page, opts ...ReqOptions) error
+	Validate(url string, opts ...ReqOptions) (string, error)
+	IsAvailable(alias string, opts ...ReqOptions) (bool, error)
+	Get(id string, opts ...ReqOptions) (string, error)
+	GetEntry(id string, opts ...ReqOptions) (Entry, error)
+	OwnerLinks(owner string) ([]Entry, error)
+	Preview(id string, opts ...ReqOptions) (*Preview, error)
+	Delete(id string, opts ...ReqOptions) error
+	Touch(id string, ttl time.Duration, opts ...ReqOptions) error
+	TouchBatch(ids []string, ttl time.Duration, opts ...ReqOptions) ([]BatchResult, error)
+	GetTTL(id string, opts ...ReqOptions) (time.Duration, error)
+	Update(id, url string, opts ...ReqOptions) error
+	History(id string, opts ...ReqOptions) ([]Revision, error)
+	Revert(id string, version int, opts ...ReqOptions) error
+	Stats(id string, opts ...ReqOptions) (*LinkStats, error)
+	Events(id string, since time.Time, opts ...ReqOptions) ([]ClickEvent, error)
+	ReportAbuse(id, reason string, opts ...ReqOptions) error
+}
+
+// RedirectHandler serves the links over http.
+type RedirectHandler interface {
+	http.Handler
+}
+
+// Admin manages campaigns, namespaces, links moderation and the store.
+type Admin interface {
+	CreateCampaign(name string, opts ...CampaignOptions) (*Campaign, error)
+	GetCampaign(name string) (*Campaign, error)
+	CampaignStats(name string) (*CampaignStats, error)
+	CreateNamespace(name string, opts ...NamespaceOptions) (*Namespace, error)
+	GetNamespace(name string) (*Namespace, error)
+	Disable(id string, opts ...ReqOptions) error
+	Enable(id string, opts ...ReqOptions) error
+	SetPublic(id string, public bool, opts ...ReqOptions) error
+	Publish(id string, opts ...ReqOptions) error
+	PublicLinks(offset, limit int) ([]Entry, int, error)
+	Restore(id string, opts ...ReqOptions) error
+	AbuseReports(id string, opts ...ReqOptions) ([]AbuseReport, error)
+	PurgeTombstones() (int, error)
+	ListExpiring(within time.Duration) ([]Entry, error)
+	StoreStats() (*StoreStats, error)
+	Subscribe(buffer int) (<-chan RedirectEvent, func())
+}
+
+var (
+	_ Shortener       = (*Handler)(nil)
+	_ RedirectHandler = (*Handler)(nil)
+	_ Admin           = (*Handler)(nil)
+)
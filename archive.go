@@ -0,0 +1,205 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ArchivedLink is an expired link with its stats, as given to an Archiver.
+type ArchivedLink struct {
+	Entry
+	Stats      LinkStats `json:"stats"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// Archiver stores the expired links before their data is removed from the store.
+type Archiver interface {
+	Archive(l ArchivedLink) error
+}
+
+// WithArchiver archives the expired links with the archiver, then removes their stats from the store.
+// Links about to expire are looked for at each interval. They are archived by the first run after
+// their expiry, grace period included, so the clicks of their last moments are archived too.
+func WithArchiver(a Archiver, interval time.Duration) Options {
+	return func(h *Handler) {
+		h.archiver = a
+		h.archiveInterval = interval
+	}
+}
+
+// FileArchiver appends the archived links to a file, one JSON document per line.
+type FileArchiver struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+var _ Archiver = (*FileArchiver)(nil)
+
+// NewFileArchiver opens the file at path for appending, creating it if needed.
+func NewFileArchiver(path string) (*FileArchiver, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileArchiver{f: f}, nil
+}
+
+func (a *FileArchiver) Archive(l ArchivedLink) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+func (a *FileArchiver) Close() error {
+	return a.f.Close()
+}
+
+// pendingArchive is a copy of a link kept until its expiry, as badger drops expired keys silently.
+type pendingArchive struct {
+	Entry     entry  `json:"entry"`
+	ExpiresAt uint64 `json:"expires_at"` // badger expiry of the link
+}
+
+type expiredLink struct {
+	link ArchivedLink
+	keys [][]byte // keys removed once the link is archived
+}
+
+func pendingArchiveKey(domain, id string) []byte {
+	return metaKey("archive", domain, id)
+}
+
+// archiveExpired archives the links that expired since the last run,
+// and keeps a copy of the links expiring before the next one.
+func (h *Handler) archiveExpired() error {
+	now := time.Now()
+	next := uint64(now.Add(h.archiveInterval).Unix())
+
+	pending := map[string][]byte{}
+	var expired []expiredLink
+	var deletes [][]byte
+	err := h.db.View(func(txn *badger.Txn) error {
+		err := forEachLink(txn, func(l linkRef, e entry) error {
+			item, err := txn.Get(l.key())
+			if err != nil {
+				return err
+			}
+			if item.ExpiresAt() == 0 || item.ExpiresAt() > next {
+				return nil
+			}
+			b, err := json.Marshal(pendingArchive{Entry: e, ExpiresAt: item.ExpiresAt()})
+			if err != nil {
+				return err
+			}
+			pending[string(pendingArchiveKey(l.domain, l.id))] = b
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		prefix := metaPrefixKey("archive")
+		return iteratePrefix(txn, prefix, func(key string, value []byte) error {
+			k := append(append([]byte{}, prefix...), key...)
+			if _, ok := pending[string(k)]; ok {
+				// the link is still there, its copy is refreshed
+				return nil
+			}
+
+			var p pendingArchive
+			if err := json.Unmarshal(value, &p); err != nil {
+				return err
+			}
+			if p.ExpiresAt > uint64(now.Unix()) {
+				return nil
+			}
+
+			domain, id := splitRef(key)
+			l := linkRef{domain: domain, id: id}
+
+			// the link was touched or deleted before its expiry
+			if _, err := txn.Get(l.key()); err == nil {
+				deletes = append(deletes, k)
+				return nil
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+			if _, err := txn.Get(tombstoneKey(l.domain, l.id)); err == nil {
+				deletes = append(deletes, k)
+				return nil
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+
+			s, err := linkStats(txn, l)
+			if err != nil {
+				return err
+			}
+			a := ArchivedLink{Entry: p.Entry.export(l, s.Clicks), Stats: *s, ArchivedAt: now}
+			if a.ExpiresAt.IsZero() {
+				a.ExpiresAt = time.Unix(int64(p.ExpiresAt), 0)
+			}
+			x := expiredLink{link: a}
+			x.keys = append(x.keys, k, graceNotifiedKey(l.domain, l.id), expiryNotifiedKey(l), thumbnailKey(l))
+			revs, err := historyKeys(txn, l)
+			if err != nil {
+				return err
+			}
+			stats, err := statsKeys(txn, l)
+			if err != nil {
+				return err
+			}
+			x.keys = append(append(x.keys, revs...), stats...)
+			expired = append(expired, x)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// The data of a link is only removed once it is archived.
+	var archived int
+	var archiveErr error
+	for _, x := range expired {
+		if archiveErr = h.archiver.Archive(x.link); archiveErr != nil {
+			break
+		}
+		archived++
+		deletes = append(deletes, x.keys...)
+	}
+
+	wb := h.db.NewWriteBatch()
+	defer wb.Cancel()
+	for k, v := range pending {
+		if err := wb.Set([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	for _, k := range deletes {
+		if err := wb.Delete(k); err != nil {
+			return err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return err
+	}
+
+	if archived > 0 {
+		h.log(LogAdmin).Infof("Archived %d expired links", archived)
+	}
+	return archiveErr
+}
@@ -0,0 +1,160 @@
+package coopurl
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// WithAsyncWrites buffers the new links and writes them in batches, every flushInterval or once maxBatch links
+// are buffered, for bulk producers creating more links than the store can commit one by one.
+// Buffered links are lost if the process crashes, and are only served once written. The handler writes them
+// when closed. Links with an owner, a namespace or a campaign are written synchronously, as their quotas and
+// campaign are checked within the creation.
+func WithAsyncWrites(flushInterval time.Duration, maxBatch int) Options {
+	return func(h *Handler) {
+		h.writes = &writeBuffer{
+			interval: flushInterval,
+			maxBatch: maxBatch,
+			pending:  map[string]struct{}{},
+		}
+	}
+}
+
+// writeBuffer holds the links posted in async mode until they're written.
+type writeBuffer struct {
+	interval time.Duration
+	maxBatch int
+
+	mu      sync.Mutex
+	pending map[string]struct{} // keys of the buffered links, which can't be taken by other links
+	entries []*badger.Entry     // the buffered links and their index keys
+}
+
+// buffers tells if the creation of the link e is buffered.
+func (b *writeBuffer) buffers(e entry, r req) bool {
+	return b != nil && !r.dryRun && e.Owner == "" && r.namespace == "" && r.campaign == "" && r.idempotencyKey == ""
+}
+
+// holds tells if the link with the given key is buffered.
+func (b *writeBuffer) holds(key []byte) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.pending[string(key)]
+	return ok
+}
+
+// putAsync buffers the new link e at id, flushing the buffer if it's full.
+func (h *Handler) putAsync(id string, e entry, r req, ttl *time.Duration) error {
+	err := h.db.View(func(txn *badger.Txn) error {
+		return h.prepare(txn, id, &e, &r, ttl)
+	})
+	if err != nil {
+		return err
+	}
+
+	storedTTL := *ttl
+	if *ttl != 0 {
+		storedTTL += h.grace
+	}
+	v, err := h.encodeEntry(e)
+	if err != nil {
+		return err
+	}
+	be := badger.NewEntry(linkKey(r.domain, id), v)
+	if storedTTL > 0 {
+		be = be.WithTTL(storedTTL)
+	}
+	xe, err := h.expiredEntry(linkRef{domain: r.domain, id: id}, e.ExpiresAt, storedTTL)
+	if err != nil {
+		return err
+	}
+
+	b := h.writes
+	b.mu.Lock()
+	// the link may have been buffered or written since it was prepared
+	if _, ok := b.pending[string(be.Key)]; ok {
+		b.mu.Unlock()
+		return ErrExists
+	}
+	err = h.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(be.Key)
+		return err
+	})
+	if err == nil {
+		err = ErrExists
+	}
+	if !errors.Is(err, badger.ErrKeyNotFound) {
+		b.mu.Unlock()
+		return err
+	}
+	b.pending[string(be.Key)] = struct{}{}
+	b.entries = append(b.entries, be)
+	for _, k := range h.indexKeys(linkRef{domain: r.domain, id: id}, e) {
+		ie := badger.NewEntry(k, nil)
+		ie.ExpiresAt = be.ExpiresAt
+		b.entries = append(b.entries, ie)
+	}
+	if xe != nil {
+		b.entries = append(b.entries, xe)
+	}
+	full := len(b.pending) >= b.maxBatch
+	b.mu.Unlock()
+
+	if full {
+		h.flushWrites()
+	}
+	return nil
+}
+
+// flushWrites writes the buffered links in a batch.
+func (h *Handler) flushWrites() {
+	b := h.writes
+	b.mu.Lock()
+	entries := b.entries
+	b.entries = nil
+	b.mu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	links := 0
+	c := counts{}
+	wb := h.db.NewWriteBatch()
+	defer wb.Cancel()
+	err := func() error {
+		for _, e := range entries {
+			// the index keys have no value, unlike the links and their expired tombstones
+			switch kind, parts := (KeyCodec{}).Parse(e.Key); {
+			case kind == "link":
+				links++
+			case e.Value == nil && len(parts) == 4:
+				c.add(indexTerm{parts[0], parts[1]}, e.ExpiresAt, 1)
+			}
+			if err := wb.SetEntry(e); err != nil {
+				return err
+			}
+		}
+		if err := wb.Flush(); err != nil {
+			return err
+		}
+		return h.updateRetry(c.write)
+	}()
+	if err != nil {
+		h.log(LogStore).Errorf("Couldn't write %d buffered links: %s", links, err)
+	} else {
+		h.log(LogStore).Debugf("Wrote %d buffered links", links)
+	}
+
+	// the written links are found in the store from now on
+	b.mu.Lock()
+	for _, e := range entries {
+		delete(b.pending, string(e.Key))
+	}
+	b.mu.Unlock()
+}
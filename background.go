@@ -0,0 +1,86 @@
+package coopurl
+
+import (
+	"context"
+	"time"
+)
+
+// every runs fn at each interval in a background goroutine until the handler is closed.
+// Nothing runs if the handler is closed.
+func (h *Handler) every(interval time.Duration, fn func()) {
+	h.mu.Lock()
+	done := h.done
+	if done == nil {
+		h.mu.Unlock()
+		return
+	}
+	h.jobs.Add(1)
+	h.mu.Unlock()
+
+	go func() {
+		defer h.jobs.Done()
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				fn()
+			}
+		}
+	}()
+}
+
+// async runs fn in a background goroutine, its context is canceled when the handler is closed.
+// Nothing runs if the handler is closed.
+func (h *Handler) async(fn func(ctx context.Context)) {
+	h.mu.Lock()
+	if h.done == nil {
+		h.mu.Unlock()
+		return
+	}
+	h.jobs.Add(1)
+	h.mu.Unlock()
+
+	go func() {
+		defer h.jobs.Done()
+
+		ctx, cancel := h.untilClosed(context.Background())
+		defer cancel()
+		fn(ctx)
+	}()
+}
+
+// untilClosed returns a copy of parent canceled when the handler is closed.
+func (h *Handler) untilClosed(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	h.mu.Lock()
+	done := h.done
+	h.mu.Unlock()
+	if done == nil {
+		cancel()
+		return ctx, cancel
+	}
+
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// stopJobs stops the background jobs and waits for the running ones to return.
+func (h *Handler) stopJobs() {
+	h.mu.Lock()
+	if h.done != nil {
+		close(h.done)
+		h.done = nil
+	}
+	h.mu.Unlock()
+	h.jobs.Wait()
+}
@@ -0,0 +1,70 @@
+package coopurl
+
+import "time"
+
+// BatchLink is a link given to PostBatch. Alias, TTL and Draft are optional, like WithAlias, WithTTL and WithDraft.
+type BatchLink struct {
+	URL   string
+	Alias string
+	TTL   time.Duration
+	Draft bool
+}
+
+// BatchResult is the outcome of a link of PostBatch or TouchBatch: its id, or why it failed.
+type BatchResult struct {
+	ID  string
+	Err error
+}
+
+// PostBatch posts the links with the given options, and returns the result of each link in the same order.
+// Links are posted one by one, a link failing doesn't stop the others, eg: an alias already taken.
+// The error is only set when no link could be posted, eg: on a replica.
+func (h *Handler) PostBatch(links []BatchLink, opts ...ReqOptions) ([]BatchResult, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(links))
+	for i, l := range links {
+		results[i].ID, results[i].Err = h.post(l.URL, l.options(opts)...)
+	}
+	return results, nil
+}
+
+// TouchBatch sets the ttl of the links with the given ids to ttl from now, like Touch, and returns the result of
+// each link in the same order, eg: to extend all the links of a campaign when the event is prolonged.
+// A link failing doesn't stop the others. The error is only set when no link could be touched.
+func (h *Handler) TouchBatch(ids []string, ttl time.Duration, opts ...ReqOptions) ([]BatchResult, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(ids))
+	for i, id := range ids {
+		results[i].ID, results[i].Err = id, h.touch(id, ttl, opts...)
+	}
+	return results, nil
+}
+
+// options returns opts followed by the options of the link.
+func (l BatchLink) options(opts []ReqOptions) []ReqOptions {
+	o := append(make([]ReqOptions, 0, len(opts)+2), opts...)
+	if l.Alias != "" {
+		o = append(o, WithAlias(l.Alias))
+	}
+	if l.TTL != 0 {
+		o = append(o, WithTTL(l.TTL))
+	}
+	if l.Draft {
+		o = append(o, WithDraft())
+	}
+	return o
+}
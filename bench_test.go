@@ -0,0 +1,123 @@
+package coopurl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Benchmarks are compared before and after a change with benchstat:
+//	go test -run '^$' -bench . -count 10 > old.txt
+//	go test -run '^$' -bench . -count 10 > new.txt
+//	benchstat old.txt new.txt
+
+// benchLinks is the number of links posted before the benchmarks reading them.
+const benchLinks = 1000
+
+func postBenchLinks(b *testing.B, h *Handler) []string {
+	b.Helper()
+	ids := make([]string, benchLinks)
+	for i := range ids {
+		id, err := h.Post("https://coopgo.fr/" + strconv.Itoa(i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+func BenchmarkPost(b *testing.B) {
+	h := newTestHandler(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := h.Post("https://coopgo.fr/" + strconv.Itoa(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPostParallel posts links concurrently, the way a batch of links is imported.
+func BenchmarkPostParallel(b *testing.B) {
+	h := newTestHandler(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, err := h.Post("https://coopgo.fr/" + strconv.Itoa(i)); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkPostAsync(b *testing.B) {
+	h := newTestHandler(b, WithAsyncWrites(10*time.Millisecond, 1000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := h.Post("https://coopgo.fr/" + strconv.Itoa(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	h := newTestHandler(b)
+	ids := postBenchLinks(b, h)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := h.Get(ids[i%len(ids)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []Options
+	}{
+		{"NoCache", nil},
+		{"Cache", []Options{WithCache(benchLinks)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			h := newTestHandler(b, bc.opts...)
+			ids := postBenchLinks(b, h)
+			reqs := make([]*http.Request, len(ids))
+			for i, id := range ids {
+				reqs[i] = httptest.NewRequest(http.MethodGet, "/r/"+id, nil)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, reqs[i%len(reqs)])
+				if w.Code != http.StatusMovedPermanently {
+					b.Fatalf("status %d", w.Code)
+				}
+			}
+		})
+		b.Run(bc.name+"Parallel", func(b *testing.B) {
+			h := newTestHandler(b, bc.opts...)
+			ids := postBenchLinks(b, h)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					w := httptest.NewRecorder()
+					h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/r/"+ids[i%len(ids)], nil))
+					if w.Code != http.StatusMovedPermanently {
+						b.Errorf("status %d", w.Code)
+						return
+					}
+				}
+			})
+		})
+	}
+}
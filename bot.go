@@ -0,0 +1,69 @@
+package coopurl
+
+import (
+	"net/http"
+	"strings"
+)
+
+// BotKind classifies the clients that aren't people following a link.
+type BotKind int
+
+const (
+	NotBot BotKind = iota
+	// Crawler is a search engine, monitoring or scripting client.
+	Crawler
+	// PreviewBot fetches links shared in a message to display a preview, eg: Slackbot or WhatsApp.
+	PreviewBot
+)
+
+// previewBots are user agent substrings of link preview fetchers, lowercased.
+var previewBots = []string{
+	"slackbot", "slack-imgproxy", "whatsapp", "facebookexternalhit", "facebot", "twitterbot",
+	"discordbot", "telegrambot", "linkedinbot", "skypeuripreview", "microsoftpreview",
+	"pinterestbot", "redditbot", "embedly", "iframely", "mastodon", "signal", "viber", "snapchat",
+}
+
+// crawlers are user agent substrings of crawlers and non browser clients, lowercased.
+var crawlers = []string{
+	"bot", "crawler", "spider", "slurp", "bingpreview", "mediapartners-google", "headlesschrome",
+	"curl/", "wget/", "python-requests", "python-urllib", "go-http-client", "okhttp", "java/",
+	"libwww-perl", "httpclient", "axios", "node-fetch", "lighthouse", "pingdom", "uptimerobot",
+}
+
+// ClassifyBot tells if the user agent is a known bot, and which kind.
+func ClassifyBot(userAgent string) BotKind {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return Crawler
+	}
+	for _, b := range previewBots {
+		if strings.Contains(ua, b) {
+			return PreviewBot
+		}
+	}
+	for _, b := range crawlers {
+		if strings.Contains(ua, b) {
+			return Crawler
+		}
+	}
+	return NotBot
+}
+
+// WithBotDetector replaces ClassifyBot to detect the requests made by bots.
+// Redirects requested by bots are served but not counted as clicks.
+func WithBotDetector(detect func(r *http.Request) BotKind) Options {
+	return func(h *Handler) {
+		h.detectBot = detect
+	}
+}
+
+func (h *Handler) botKind(r *http.Request) BotKind {
+	if h.detectBot != nil {
+		return h.detectBot(r)
+	}
+	return ClassifyBot(r.UserAgent())
+}
+
+func botClicksKey(l linkRef) []byte {
+	return metaKey("bot-clicks", l.domain, l.id)
+}
@@ -0,0 +1,104 @@
+package coopurl
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WithCircuitBreaker stops reading the store for the redirects after the given number of consecutive
+// failures or timeouts, for cooldown, so that a failing store isn't hammered by every request.
+// Meanwhile the redirects are served from the cache set by WithCache, which may be stale or miss links
+// specific to a domain, and the other ones are answered with a 503 status. A single redirect then probes
+// the store, closing the circuit if it succeeds.
+func WithCircuitBreaker(failures int, cooldown time.Duration) Options {
+	return func(h *Handler) {
+		h.breaker = &breaker{failures: failures, cooldown: cooldown}
+	}
+}
+
+type breaker struct {
+	failures int
+	cooldown time.Duration
+
+	mu          sync.Mutex
+	consecutive int
+	openUntil   time.Time
+	probing     bool
+}
+
+// allow tells if the store can be read, and how long until it can be otherwise.
+func (b *breaker) allow(now time.Time) (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.consecutive < b.failures {
+		return true, 0
+	}
+	if now.Before(b.openUntil) {
+		return false, b.openUntil.Sub(now)
+	}
+	if b.probing {
+		return false, b.cooldown
+	}
+	b.probing = true
+	return true, 0
+}
+
+// record records the result of a read of the store, it tells if the circuit just opened or closed.
+// Links not found aren't failures.
+func (b *breaker) record(err error, now time.Time) (opened, closed bool) {
+	if b == nil {
+		return false, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.consecutive >= b.failures
+	b.probing = false
+	if err == nil || errors.Is(err, ErrNotFound) {
+		b.consecutive = 0
+		return false, wasOpen
+	}
+	if b.consecutive++; b.consecutive >= b.failures {
+		b.openUntil = now.Add(b.cooldown)
+		return !wasOpen, false
+	}
+	return false, false
+}
+
+// resolveCached resolves the link like resolve, only from the cache, stale entries included.
+func (h *Handler) resolveCached(domain, id string) (entry, string, bool) {
+	if h.cache == nil {
+		return entry{}, "", false
+	}
+	id = h.normalizeID(id)
+	now := time.Now()
+	get := func(key []byte) (entry, bool) {
+		e, ok := h.cache.get(key, now)
+		if !ok && h.cache.staleWindow > 0 {
+			e, _, ok = h.cache.getStale(key, now, false)
+		}
+		return e, ok
+	}
+	if domain != "" {
+		if e, ok := get(linkKey(domain, id)); ok {
+			return e, domain, true
+		}
+	}
+	e, ok := get(linkKey("", id))
+	return e, "", ok
+}
+
+// serveUnavailable answers a request the store couldn't serve, which can be retried after retry.
+func serveUnavailable(w http.ResponseWriter, r *http.Request, retry time.Duration) {
+	s := int(retry.Round(time.Second) / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(s))
+	serveError(w, r, http.StatusServiceUnavailable)
+}
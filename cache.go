@@ -0,0 +1,284 @@
+package coopurl
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// WithCache keeps the last size links served in memory, sparing a store read per redirect.
+// Cached links are evicted when their key changes in the store, including the changes
+// received from a primary or a cluster leader, or published by other instances, see WithInvalidationBus.
+func WithCache(size int) Options {
+	return func(h *Handler) {
+		if size > 0 {
+			h.cache = &entryCache{
+				size:    size,
+				ll:      list.New(),
+				items:   map[string]*list.Element{},
+				loading: map[string]*cacheLoad{},
+			}
+		}
+	}
+}
+
+// WithStaleWhileRevalidate keeps serving the cached links of WithCache for the given window after they changed in
+// the store, while they are read again in the background, so that the redirects don't wait for a slow store, eg:
+// a remote one, during its latency spikes. Past the window, the redirects wait for the store again. Deleted links
+// may so be redirected for the window at most.
+func WithStaleWhileRevalidate(window time.Duration) Options {
+	return func(h *Handler) {
+		h.staleWindow = window
+	}
+}
+
+// InvalidationBus carries the keys of the links changed by an instance to the other instances sharing its store,
+// so that they evict them from their cache, eg: a Redis or NATS channel. The instances may receive the keys they
+// published too.
+type InvalidationBus interface {
+	// Publish sends the keys of the links changed by the instance.
+	Publish(ctx context.Context, keys [][]byte) error
+	// Subscribe calls fn with the keys published by the instances, until ctx is done or the subscription fails.
+	Subscribe(ctx context.Context, fn func(keys [][]byte)) error
+}
+
+// WithInvalidationBus publishes the links changed in the store with b, and evicts the links published by the
+// other instances from the cache of WithCache, eg: for several instances with their own cache over a shared store.
+// The cache is disabled if the subscription fails, as the links changed by the others wouldn't be evicted anymore.
+func WithInvalidationBus(b InvalidationBus) Options {
+	return func(h *Handler) {
+		h.cacheBus = b
+	}
+}
+
+// entryCache is a LRU cache of entries by store key.
+type entryCache struct {
+	size        int
+	staleWindow time.Duration // changed entries are kept stale meanwhile, see WithStaleWhileRevalidate
+
+	mu      sync.Mutex
+	ll      *list.List
+	items   map[string]*list.Element
+	loading map[string]*cacheLoad
+	broken  bool // the store changes aren't followed anymore
+}
+
+type cachedEntry struct {
+	key        string
+	e          entry
+	expiresAt  uint64    // badger expiry of the key
+	staleSince time.Time // zero while the entry is fresh
+	refreshing bool
+}
+
+// cacheLoad tracks the reads of a key from the store, which aren't cached if the key changed meanwhile.
+type cacheLoad struct {
+	readers int
+	stale   bool
+}
+
+func (c *entryCache) get(key []byte, now time.Time) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[string(key)]
+	if !ok {
+		return entry{}, false
+	}
+	ce := el.Value.(*cachedEntry)
+	if ce.expiresAt > 0 && uint64(now.Unix()) >= ce.expiresAt {
+		c.ll.Remove(el)
+		delete(c.items, ce.key)
+		return entry{}, false
+	}
+	if !ce.staleSince.IsZero() {
+		return entry{}, false
+	}
+	c.ll.MoveToFront(el)
+	return ce.e, true
+}
+
+// getStale returns the entry of the key that changed in the store less than the stale window ago. With claim,
+// refresh tells the first caller to read it again, until loaded.
+func (c *entryCache) getStale(key []byte, now time.Time, claim bool) (e entry, refresh, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[string(key)]
+	if !ok {
+		return entry{}, false, false
+	}
+	ce := el.Value.(*cachedEntry)
+	if ce.staleSince.IsZero() || ce.expiresAt > 0 && uint64(now.Unix()) >= ce.expiresAt {
+		return entry{}, false, false
+	}
+	if now.Sub(ce.staleSince) >= c.staleWindow {
+		c.ll.Remove(el)
+		delete(c.items, ce.key)
+		return entry{}, false, false
+	}
+	if claim && !ce.refreshing {
+		refresh, ce.refreshing = true, true
+	}
+	return ce.e, refresh, true
+}
+
+// load is called before reading a key missing from the cache, the read ends with loaded.
+func (c *entryCache) load(key []byte) *cacheLoad {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.loading[string(key)]
+	if !ok {
+		l = &cacheLoad{}
+		c.loading[string(key)] = l
+	}
+	l.readers++
+	return l
+}
+
+// loaded caches the entry read from the store, if found.
+func (c *entryCache) loaded(key []byte, l *cacheLoad, e entry, expiresAt uint64, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l.readers--; l.readers == 0 {
+		delete(c.loading, string(key))
+	}
+	el, cached := c.items[string(key)]
+	if cached {
+		// a stale entry can be refreshed again
+		el.Value.(*cachedEntry).refreshing = false
+	}
+	if cached && !found {
+		c.ll.Remove(el)
+		delete(c.items, string(key))
+	}
+	if !found || l.stale || c.broken {
+		return
+	}
+
+	if cached {
+		ce := el.Value.(*cachedEntry)
+		ce.e, ce.expiresAt, ce.staleSince = e, expiresAt, time.Time{}
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[string(key)] = c.ll.PushFront(&cachedEntry{key: string(key), e: e, expiresAt: expiresAt})
+	if c.ll.Len() > c.size {
+		last := c.ll.Back()
+		c.ll.Remove(last)
+		delete(c.items, last.Value.(*cachedEntry).key)
+	}
+}
+
+// evict removes the entries of the keys, or marks them stale with a stale window.
+func (c *entryCache) evict(keys [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, k := range keys {
+		if l, ok := c.loading[string(k)]; ok {
+			l.stale = true
+		}
+		if el, ok := c.items[string(k)]; ok {
+			if ce := el.Value.(*cachedEntry); c.staleWindow > 0 {
+				if ce.staleSince.IsZero() {
+					ce.staleSince = now
+				}
+				continue
+			}
+			c.ll.Remove(el)
+			delete(c.items, string(k))
+		}
+	}
+}
+
+// disable empties the cache for good.
+func (c *entryCache) disable() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.broken = true
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+}
+
+// reset empties the cache, and enables it back if it was disabled.
+func (c *entryCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.broken = false
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+	for _, l := range c.loading {
+		l.stale = true
+	}
+}
+
+// isLinkKey tells if the key is the key of a link, the only ones cached.
+func isLinkKey(key []byte) bool {
+	return !bytes.HasPrefix(key, []byte(metaPrefix)) || bytes.HasPrefix(key, metaPrefixKey("link"))
+}
+
+// followCache evicts the cached entries changed in the store, and the ones published on the invalidation bus,
+// until ctx is done.
+func (h *Handler) followCache(ctx context.Context) error {
+	sub, err := h.subscribe(ctx)
+	if err != nil {
+		return err
+	}
+
+	if h.cacheBus != nil {
+		h.jobs.Add(1)
+		go func() {
+			defer h.jobs.Done()
+			err := h.cacheBus.Subscribe(ctx, h.cache.evict)
+			if ctx.Err() != nil {
+				return
+			}
+			h.log(LogStore).Errorf("Couldn't follow the invalidation bus, disable the cache: %s", err)
+			h.cache.disable()
+		}()
+	}
+
+	h.jobs.Add(1)
+	go func() {
+		defer h.jobs.Done()
+		for {
+			kvs, err := sub.next(ctx)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				h.log(LogStore).Errorf("Couldn't follow the store changes, disable the cache: %s", err)
+				h.cache.disable()
+				return
+			}
+
+			keys := make([][]byte, len(kvs.Kv))
+			for i, kv := range kvs.Kv {
+				keys[i] = kv.Key
+			}
+			h.cache.evict(keys)
+			if h.cacheBus != nil {
+				h.publishInvalidations(ctx, keys)
+			}
+		}
+	}()
+	return nil
+}
+
+// publishInvalidations publishes the keys of the links among the changed keys on the invalidation bus.
+func (h *Handler) publishInvalidations(ctx context.Context, keys [][]byte) {
+	var links [][]byte
+	for _, k := range keys {
+		if isLinkKey(k) {
+			links = append(links, k)
+		}
+	}
+	if len(links) == 0 {
+		return
+	}
+	if err := h.cacheBus.Publish(ctx, links); err != nil && ctx.Err() == nil {
+		h.log(LogStore).Warningf("Couldn't publish %d changed links on the invalidation bus: %s", len(links), err)
+	}
+}
@@ -0,0 +1,188 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Campaign groups links sharing the same tags and expiry, eg: all the links of a product launch.
+// Links are added to a campaign with the WithCampaign() option of Post.
+type Campaign struct {
+	Name      string    `json:"name"`
+	Tags      []string  `json:"tags,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // zero means the campaign links don't expire.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CampaignStats are the aggregated stats of the links of a campaign.
+type CampaignStats struct {
+	Name   string
+	Links  int    // number of links ever added to the campaign
+	Active int    // number of links that didn't expire yet
+	Clicks uint64 // total number of redirects of the campaign links
+	// LinkClicks is the number of redirects per link id, prefixed by "{domain}/" for links with a domain.
+	LinkClicks map[string]uint64
+}
+
+type CampaignOptions func(*Campaign)
+
+func WithCampaignTags(tags ...string) CampaignOptions {
+	return func(c *Campaign) {
+		c.Tags = append(c.Tags, tags...)
+	}
+}
+
+func WithCampaignExpiry(t time.Time) CampaignOptions {
+	return func(c *Campaign) {
+		c.ExpiresAt = t
+	}
+}
+
+func campaignKey(name string) []byte {
+	return metaKey("campaign", name)
+}
+
+func campaignLinksPrefix(name string) []byte {
+	return metaPrefixKey("campaign-link", name)
+}
+
+func campaignLinkKey(name, id string) []byte {
+	return metaKey("campaign-link", name, id)
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.Contains(name, metaPrefix)
+}
+
+// CreateCampaign creates a new campaign.
+func (h *Handler) CreateCampaign(name string, opts ...CampaignOptions) (*Campaign, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return nil, err
+	}
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+
+	c := Campaign{Name: name, CreatedAt: time.Now()}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(campaignKey(name)); err == nil {
+			return ErrCampaignExists
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		return txn.Set(campaignKey(name), b)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.log(LogAdmin).Infof("New campaign: %s", name)
+	return &c, nil
+}
+
+// GetCampaign returns the campaign with the given name.
+func (h *Handler) GetCampaign(name string) (*Campaign, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	var c *Campaign
+	err := h.db.View(func(txn *badger.Txn) error {
+		var err error
+		c, err = getCampaign(txn, name)
+		return err
+	})
+	return c, err
+}
+
+func getCampaign(txn *badger.Txn, name string) (*Campaign, error) {
+	b, err := getValue(txn, campaignKey(name))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrCampaignNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var c Campaign
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// CampaignStats aggregates the stats of all the links of a campaign.
+func (h *Handler) CampaignStats(name string) (*CampaignStats, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	s := CampaignStats{Name: name, LinkClicks: map[string]uint64{}}
+	err := h.db.View(func(txn *badger.Txn) error {
+		if _, err := getCampaign(txn, name); err != nil {
+			return err
+		}
+
+		return iteratePrefix(txn, campaignLinksPrefix(name), func(id string, domain []byte) error {
+			s.Links++
+			if _, err := txn.Get(linkKey(string(domain), unescapePart(id))); err == nil {
+				s.Active++
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+
+			clicks, err := getCounter(txn, clicksKey(string(domain), id))
+			if err != nil {
+				return err
+			}
+			if len(domain) > 0 {
+				id = string(domain) + "/" + id
+			}
+			s.LinkClicks[id] = clicks
+			s.Clicks += clicks
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// applyCampaign adds the campaign tags to the entry and bounds the ttl to the campaign expiry.
+func applyCampaign(c *Campaign, e *entry, ttl time.Duration) (time.Duration, error) {
+	e.Campaign = c.Name
+	e.Tags = append(e.Tags, c.Tags...)
+
+	if c.ExpiresAt.IsZero() {
+		return ttl, nil
+	}
+
+	left := time.Until(c.ExpiresAt)
+	if left <= 0 {
+		return 0, ErrCampaignExpired
+	}
+	if ttl == 0 || ttl > left {
+		return left, nil
+	}
+	return ttl, nil
+}
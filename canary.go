@@ -0,0 +1,177 @@
+package coopurl
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// The branches of a link with a canary, counted in LinkStats.Branches and given in Redirect.Branch.
+const (
+	BranchStable = "stable"
+	BranchCanary = "canary"
+)
+
+// Canary sends a share of the redirects of a link to a new destination, eg: 5% to the new site of a migration,
+// see StartCanary.
+type Canary struct {
+	URL     string    `json:"url"`
+	Percent int       `json:"percent"` // of the visitors redirected to URL, from 1 to 99
+	Since   time.Time `json:"since"`
+}
+
+func branchesPrefix(l linkRef) []byte {
+	return metaPrefixKey("branch", l.domain, l.id)
+}
+
+// StartCanary redirects percent of the visitors of the link to url, the others keep its destination. A visitor
+// stays on the same branch while the canary runs. It returns ErrInvalidCanary if percent isn't between 1 and 99.
+// The clicks of the branches, counted again from 0, are in the Branches of Stats. Updating the destination of the
+// link ends its canary.
+func (h *Handler) StartCanary(id, url string, percent int, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	id = h.normalizeID(id)
+	if !validID(id) {
+		return ErrNotFound
+	}
+	if percent < 1 || percent > 99 {
+		return ErrInvalidCanary
+	}
+	u, _, err := h.destination(url)
+	if err != nil {
+		return err
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err = h.db.Update(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		l := linkRef{domain: r.domain, id: id}
+		err := h.updateEntry(txn, l.key(), func(e *entry) error {
+			if e.Page != nil {
+				return ErrInvalidCanary
+			}
+			e.Canary = &Canary{URL: u.String(), Percent: percent, Since: time.Now()}
+			return r.nextVersion(e)
+		})
+		if err != nil {
+			return err
+		}
+		var counters [][]byte
+		err = iterateKeys(txn, branchesPrefix(l), func(key []byte) error {
+			counters = append(counters, key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range counters {
+			if err := txn.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	h.log(LogAdmin).Infof("Start canary of %s: %d%% to %s", id, percent, u.String())
+	return nil
+}
+
+// EndCanary sends all the visitors of the link back to its destination. The clicks of the branches are kept until
+// the next canary.
+func (h *Handler) EndCanary(id string, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	id = h.normalizeID(id)
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err := h.db.Update(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		return h.updateEntry(txn, linkKey(r.domain, id), func(e *entry) error {
+			if e.Canary == nil {
+				return nil
+			}
+			e.Canary = nil
+			return r.nextVersion(e)
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	h.log(LogAdmin).Infof("End canary of %s", id)
+	return nil
+}
+
+// PromoteCanary updates the destination of the link to the one of its canary, as Update does, which ends the
+// canary. It returns ErrInvalidCanary if the link has no canary.
+func (h *Handler) PromoteCanary(id string, opts ...ReqOptions) error {
+	e, err := h.GetEntry(id, opts...)
+	if err != nil {
+		return err
+	}
+	if e.Canary == nil {
+		return ErrInvalidCanary
+	}
+	return h.Update(id, e.Canary.URL, opts...)
+}
+
+// canaryBranch returns the branch of the link l the request is redirected to, empty if the link has no canary.
+// Visitors are split by their ip so that they stay on the same branch.
+func canaryBranch(r *http.Request, l linkRef, e entry) string {
+	if e.Canary == nil {
+		return ""
+	}
+	f := fnv.New32a()
+	f.Write([]byte(l.String()))
+	f.Write([]byte(clientIP(r)))
+	if int(f.Sum32()%100) < e.Canary.Percent {
+		return BranchCanary
+	}
+	return BranchStable
+}
+
+// canaryEntry returns the entry redirected to on the branch, the canary one redirecting to its url only.
+func canaryEntry(e entry, branch string) entry {
+	if branch != BranchCanary {
+		return e
+	}
+	c := e
+	c.URL, c.Languages = e.Canary.URL, nil
+	return c
+}
@@ -0,0 +1,19 @@
+package coopurl
+
+import "strings"
+
+// WithCaseInsensitiveIDs stores and looks up ids in lowercase, so links typed by hand from print work
+// regardless of capitalization. Links with uppercase ids stored before enabling it can't be resolved anymore.
+func WithCaseInsensitiveIDs() Options {
+	return func(h *Handler) {
+		h.caseInsensitive = true
+	}
+}
+
+// normalizeID returns the id as it is stored.
+func (h *Handler) normalizeID(id string) string {
+	if h.caseInsensitive {
+		return strings.ToLower(id)
+	}
+	return id
+}
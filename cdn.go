@@ -0,0 +1,116 @@
+package coopurl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// CDN sets how the redirects are cached by a CDN or a caching proxy in front of the Handler, eg: Cloudflare or
+// Fastly. Redirects served by the CDN don't reach the Handler, so their clicks aren't counted.
+type CDN struct {
+	MaxAge       time.Duration // max-age of the redirects in browsers, not cached if 0
+	SharedMaxAge time.Duration // s-maxage of the redirects in the CDN
+	// Purge is called with the surrogate keys of the links changed in the store, created, updated or deleted,
+	// to invalidate them in the CDN. It's called in the background, one call after the other.
+	Purge func(keys []string)
+}
+
+// WithCDN emits the Cache-Control headers of the CDN on the redirects, along with the surrogate key of the link
+// in the Surrogate-Key and Cache-Tag headers, and calls the purge hook of the CDN when links change.
+// Links expiring are cached until their expiry at most.
+func WithCDN(cdn CDN) Options {
+	return func(h *Handler) {
+		h.cdn = &cdn
+	}
+}
+
+// SurrogateKey returns the key tagging the cached redirects of a link, as given to the purge hook of the CDN.
+func SurrogateKey(domain, id string) string {
+	if domain == "" {
+		return "coopurl-" + id
+	}
+	return "coopurl-" + domain + "-" + id
+}
+
+// writeCacheHeaders sets the cache headers of the redirect of the link e.
+func (c *CDN) writeCacheHeaders(w http.ResponseWriter, l linkRef, e entry, now time.Time) {
+	if c == nil {
+		return
+	}
+	maxAge, shared := c.MaxAge, c.SharedMaxAge
+	if !e.ExpiresAt.IsZero() {
+		left := e.ExpiresAt.Sub(now)
+		if left < 0 {
+			left = 0
+		}
+		if maxAge > left {
+			maxAge = left
+		}
+		if shared > left {
+			shared = left
+		}
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, s-maxage=%d", maxAge/time.Second, shared/time.Second))
+	key := SurrogateKey(l.domain, l.id)
+	w.Header().Set("Surrogate-Key", key)
+	w.Header().Set("Cache-Tag", key)
+}
+
+// followPurges calls the purge hook with the links changed in the store, until ctx is done.
+func (h *Handler) followPurges(ctx context.Context) error {
+	sub, err := h.subscribe(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.jobs.Add(1)
+	go func() {
+		defer h.jobs.Done()
+		for {
+			kvs, err := sub.next(ctx)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				h.log(LogStore).Errorf("Couldn't follow the store changes, links aren't purged from the CDN anymore: %s", err)
+				return
+			}
+
+			var keys []string
+			seen := map[string]bool{}
+			for _, kv := range kvs.Kv {
+				if bytes.HasPrefix(kv.Key, badgerPrefix) {
+					continue
+				}
+				kind, parts := KeyCodec{}.Parse(kv.Key)
+				if kind != "link" || len(parts) != 2 {
+					continue
+				}
+				if k := SurrogateKey(parts[0], parts[1]); !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+			if len(keys) == 0 {
+				continue
+			}
+			// replicas leave the purges to their primary
+			if h.outbox.queues(EventPurge) && h.writable() == nil {
+				err := h.updateRetry(func(txn *badger.Txn) error { return h.enqueue(txn, EventPurge, keys) })
+				if err != nil {
+					h.log(LogStore).Errorf("Couldn't queue the purge of %d keys: %s", len(keys), err)
+				}
+			}
+			if h.cdn.Purge != nil {
+				h.cdn.Purge(keys)
+			}
+		}
+	}()
+	return nil
+}
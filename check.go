@@ -0,0 +1,94 @@
+package coopurl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const DefaultCheckTimeout = 5 * time.Second
+
+// checker checks that link destinations are reachable.
+type checker struct {
+	client      *http.Client
+	timeout     time.Duration
+	flagOnly    bool
+	autoDisable bool
+}
+
+type CheckOptions func(*checker)
+
+// CheckTimeout sets the timeout of a destination check, DefaultCheckTimeout by default.
+func CheckTimeout(timeout time.Duration) CheckOptions {
+	return func(c *checker) {
+		c.timeout = timeout
+	}
+}
+
+// CheckClient sets the http client used to check destinations, instead of a client connecting only to public
+// addresses.
+func CheckClient(client *http.Client) CheckOptions {
+	return func(c *checker) {
+		c.client = client
+	}
+}
+
+// CheckFlagOnly flags unreachable links as broken instead of rejecting them.
+func CheckFlagOnly() CheckOptions {
+	return func(c *checker) {
+		c.flagOnly = true
+	}
+}
+
+func newChecker(opts ...CheckOptions) *checker {
+	c := checker{client: publicClient, timeout: DefaultCheckTimeout}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// WithValidateDestination checks that the destination is reachable when a link is posted.
+// Urls failing because of DNS, network errors, private addresses or 4xx/5xx statuses are rejected with ErrUnreachable,
+// or stored flagged as broken with CheckFlagOnly().
+func WithValidateDestination(opts ...CheckOptions) Options {
+	return func(h *Handler) {
+		h.validator = newChecker(opts...)
+	}
+}
+
+// check requests the destination with HEAD, falling back to GET for servers not supporting HEAD.
+func (c *checker) check(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	status, err := c.do(ctx, http.MethodHead, url)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = c.do(ctx, http.MethodGet, url)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnreachable, err)
+	}
+	if status >= 400 {
+		return fmt.Errorf("%w: %s returned %d", ErrUnreachable, url, status)
+	}
+	return nil
+}
+
+func (c *checker) do(ctx context.Context, method, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,47 @@
+package coopurl
+
+// Clone creates a link with the destination and metadata of the link with the given id: its languages,
+// headers, tags, campaign, owner, namespace and flags, and returns its id, eg: to reuse a configured link for
+// a new campaign. The options are applied after the copied ones, to give the clone its own alias, ttl or
+// campaign, tags are added to the copied ones. The clone has the default ttl unless WithTTL is given, and
+// starts without clicks, history nor preview.
+func (h *Handler) Clone(id string, opts ...ReqOptions) (string, error) {
+	e, err := h.GetEntry(id, opts...)
+	if err != nil {
+		return "", err
+	}
+	if e.Page != nil {
+		return h.PostPage(*e.Page, e.cloneOptions(opts)...)
+	}
+	return h.Post(e.URL, e.cloneOptions(opts)...)
+}
+
+// cloneOptions returns the options copying the metadata of the link, followed by opts.
+func (e Entry) cloneOptions(opts []ReqOptions) []ReqOptions {
+	o := []ReqOptions{WithDomain(e.Domain), WithOwner(e.Owner), WithTags(e.Tags...)}
+	if e.Namespace != "" {
+		o = append(o, WithNamespace(e.Namespace))
+	}
+	if e.Campaign != "" {
+		o = append(o, WithCampaign(e.Campaign))
+	}
+	for lang, url := range e.Languages {
+		o = append(o, WithLanguageURL(lang, url))
+	}
+	for name, value := range e.Headers {
+		o = append(o, WithHeader(name, value))
+	}
+	if e.NoIndex {
+		o = append(o, WithNoIndex())
+	}
+	if e.Public {
+		o = append(o, WithPublic())
+	}
+	if e.Template {
+		o = append(o, WithTemplate())
+	}
+	if e.Draft {
+		o = append(o, WithDraft())
+	}
+	return append(o, opts...)
+}
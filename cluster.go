@@ -0,0 +1,180 @@
+package coopurl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+)
+
+// The methods of this file let the cluster package replicate the store of a leader to its followers.
+
+// WithFollower starts the handler read-only, until Lead is called.
+// Background jobs writing to the store only run while the handler leads.
+func WithFollower() Options {
+	return func(h *Handler) {
+		h.follower = 1
+	}
+}
+
+// Lead makes a handler created with WithFollower writable.
+func (h *Handler) Lead() error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if h.primaryURL != "" {
+		return ErrReadOnly
+	}
+	atomic.StoreInt32(&h.follower, 0)
+	h.log(LogAdmin).Infof("Handler leads")
+	if err := h.openSequence(); err != nil {
+		return err
+	}
+	// the count and the screening lists followed the copied store
+	if err := h.loadLinkCount(); err != nil {
+		return err
+	}
+	return h.loadScreening()
+}
+
+// Follow makes the handler read-only, until Lead is called.
+func (h *Handler) Follow() {
+	atomic.StoreInt32(&h.follower, 1)
+	h.log(LogAdmin).Infof("Handler follows")
+	h.releaseSequence()
+}
+
+// Changes returns the changes committed to the store from its return on, until ctx is done.
+// Each change is an opaque batch of keys to give to ApplyChanges on the copies of the store.
+// The channel is closed when ctx is done or the subscription fails.
+func (h *Handler) Changes(ctx context.Context) (<-chan []byte, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	sub, err := h.subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(chan []byte, 16)
+	go func() {
+		defer close(changes)
+		for {
+			kvs, err := sub.next(ctx)
+			if err == nil {
+				kvs, err = h.changesAfter(kvs, 0)
+			}
+			var b []byte
+			if err == nil && len(kvs.Kv) > 0 {
+				b, err = kvs.Marshal()
+			}
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					h.log(LogStore).Errorf("Changes subscription stopped: %s", err)
+				}
+				return
+			}
+			if b == nil {
+				continue
+			}
+
+			select {
+			case changes <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// ApplyChanges writes a batch of keys returned by Changes.
+func (h *Handler) ApplyChanges(b []byte) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	kvs := &pb.KVList{}
+	if err := kvs.Unmarshal(b); err != nil {
+		return err
+	}
+	return h.apply(kvs)
+}
+
+func markKey() []byte {
+	return metaKey("mark")
+}
+
+// Mark commits the given marker to the store of a writable handler. Once the batch of Changes holding it is
+// applied to the copies of the store, so are all the changes committed before the call.
+func (h *Handler) Mark(marker []byte) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	return h.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(markKey(), marker)
+	})
+}
+
+// Markers returns the markers of Mark held by a batch of keys returned by Changes, in commit order.
+func Markers(b []byte) ([][]byte, error) {
+	kvs := &pb.KVList{}
+	if err := kvs.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	var markers [][]byte
+	for _, kv := range kvs.Kv {
+		if bytes.Equal(kv.Key, markKey()) {
+			markers = append(markers, kv.Value)
+		}
+	}
+	return markers, nil
+}
+
+// WriteSnapshot writes all the keys of the store to w.
+func (h *Handler) WriteSnapshot(w io.Writer) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	bw := bufio.NewWriter(w)
+	if _, err := h.writeSnapshot(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LoadSnapshot replaces the content of the store by a snapshot written by WriteSnapshot.
+func (h *Handler) LoadSnapshot(r io.Reader) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	return h.loadSnapshot(bufio.NewReader(r))
+}
+
+// Resync replaces the content of the store by the one of the handler serving ServeReplication at url,
+// authenticated with the given bearer token if not empty.
+func (h *Handler) Resync(ctx context.Context, url, token string) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	resp, err := h.connect(ctx, url, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return h.loadSnapshot(bufio.NewReader(resp.Body))
+}
@@ -0,0 +1,123 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	kindChanges = "changes" // keys changed in the store of the leader
+	kindLeader  = "leader"  // a node starting to lead
+)
+
+// command is an entry of the raft log.
+type command struct {
+	Kind string `json:"kind"`
+	Node string `json:"node"`
+	// Session changes at every start of a node, telling the changes it made from the ones it replays.
+	Session        string `json:"session"`
+	Changes        []byte `json:"changes,omitempty"`
+	APIURL         string `json:"api_url,omitempty"`
+	ReplicationURL string `json:"replication_url,omitempty"`
+}
+
+// leaderKey stores the last leader announced in the log.
+var leaderKey = []byte("leader")
+
+// resyncAttempts bounds the attempts of a former leader to load the store of the new one.
+const resyncAttempts = 3
+
+// fsm applies the raft log to the store of the node.
+type fsm struct {
+	n *Node
+}
+
+var _ raft.FSM = fsm{}
+
+func (f fsm) Apply(l *raft.Log) interface{} {
+	if l.Type != raft.LogCommand {
+		return nil
+	}
+	var cmd command
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return err
+	}
+
+	switch cmd.Kind {
+	case kindChanges:
+		if cmd.Session == f.n.session {
+			// already in the store, the writes held by serveCommitted are answered once their markers are applied
+			return nil
+		}
+		return f.n.h.ApplyChanges(cmd.Changes)
+	case kindLeader:
+		return f.n.announced(cmd)
+	}
+	return nil
+}
+
+// Snapshot copies the whole store. The store may be newer than the log index of the snapshot,
+// replaying the log from there brings it back to the same state.
+func (f fsm) Snapshot() (raft.FSMSnapshot, error) {
+	return snapshot{f.n}, nil
+}
+
+func (f fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	return f.n.h.LoadSnapshot(rc)
+}
+
+type snapshot struct {
+	n *Node
+}
+
+func (s snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := s.n.h.WriteSnapshot(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s snapshot) Release() {}
+
+// announced records the new leader, and reloads the store of a former leader,
+// which may hold changes that didn't reach the log.
+func (n *Node) announced(cmd command) error {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	if err := n.store.Set(leaderKey, b); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	n.leader = cmd
+	stale := n.stale && cmd.Node != n.cfg.ID
+	n.mu.Unlock()
+	if !stale || cmd.ReplicationURL == "" {
+		return nil
+	}
+
+	for i := 1; i <= resyncAttempts; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), resyncTimeout)
+		err = n.h.Resync(ctx, cmd.ReplicationURL, n.cfg.Token)
+		cancel()
+		if err == nil {
+			n.mu.Lock()
+			n.stale = false
+			n.mu.Unlock()
+			n.logger.Infof("Store reloaded from the leader %s", cmd.Node)
+			return nil
+		}
+		n.logger.Warningf("Couldn't reload the store from the leader %s: %s", cmd.Node, err)
+		time.Sleep(time.Duration(i) * time.Second)
+	}
+	n.logger.Errorf("Store may hold changes lost by the cluster, restart the node with an empty store")
+	return nil
+}
@@ -0,0 +1,43 @@
+package cluster
+
+import (
+	"strings"
+
+	"github.com/coopgo/coopurl/v2"
+	"github.com/hashicorp/go-hclog"
+)
+
+// raftLogger returns a logger of the raft library writing to l.
+func raftLogger(l coopurl.Logger) hclog.Logger {
+	return hclog.New(&hclog.LoggerOptions{Name: "raft", Level: hclog.Info, Output: logWriter{l}})
+}
+
+// logWriter writes the lines of hclog to a coopurl logger, at their level.
+type logWriter struct {
+	l coopurl.Logger
+}
+
+var _ hclog.LevelWriter = logWriter{}
+
+func (w logWriter) Write(b []byte) (int, error) {
+	return w.LevelWrite(hclog.Info, b)
+}
+
+func (w logWriter) LevelWrite(level hclog.Level, b []byte) (int, error) {
+	// the lines start with their time and level, eg: "2006-01-02T15:04:05.000Z [INFO]  raft: ..."
+	line := strings.TrimSpace(string(b))
+	if i := strings.Index(line, "] "); i >= 0 {
+		line = strings.TrimSpace(line[i+2:])
+	}
+	switch {
+	case level >= hclog.Error:
+		w.l.Errorf("%s", line)
+	case level == hclog.Warn:
+		w.l.Warningf("%s", line)
+	case level == hclog.Info:
+		w.l.Infof("%s", line)
+	default:
+		w.l.Debugf("%s", line)
+	}
+	return len(b), nil
+}
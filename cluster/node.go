@@ -0,0 +1,460 @@
+// Package cluster replicates the store of coopurl handlers within a raft group,
+// giving high availability without an external database.
+//
+// The leader of the group is the only writable handler: it writes to its store, then appends
+// the changes to the raft log which applies them to the stores of the followers.
+// Followers serve redirects from their copy and forward the writes they receive to the leader.
+// The leader answers the writes once their changes are committed to the log by a quorum of the nodes,
+// see Commit, so that acknowledged writes survive its failure. Changes of a leader failing before
+// they are committed were never acknowledged: when a former leader rejoins, it reloads the store of
+// the new one. Clicks are only counted on the leader.
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+	"github.com/hashicorp/raft"
+)
+
+var ErrNotLeader = errors.New("cluster: node isn't the leader")
+
+const (
+	applyTimeout    = 10 * time.Second
+	resyncTimeout   = 10 * time.Minute
+	forwardedHeader = "X-Coopurl-Forwarded"
+)
+
+// Config sets up a node of a cluster.
+type Config struct {
+	ID        string // unique and stable id of the node
+	RaftAddr  string // address the raft transport listens on
+	Advertise string // address of the raft transport reachable by the other nodes, RaftAddr if empty
+	Dir       string // directory of the raft log and snapshots, to keep along with the store of the handler
+	Bootstrap bool   // starts a new cluster made of this node, ignored if the cluster already exists
+
+	APIURL         string // url of the api of the node, followers forward the writes there
+	ReplicationURL string // url of ServeReplication of the node, former leaders reload their store from there
+	Token          string // bearer token of ServeReplication
+
+	Logger coopurl.Logger
+}
+
+// Node is a member of a cluster, making its handler writable while it leads.
+type Node struct {
+	cfg     Config
+	h       *coopurl.Handler
+	logger  coopurl.Logger
+	session string
+
+	raft      *raft.Raft
+	store     *logStore
+	transport *raft.NetworkTransport
+	notify    chan bool
+
+	leading int32 // 1 while the handler is writable
+
+	marks uint64 // last marker of Commit, accessed atomically
+
+	mu        sync.Mutex
+	leader    command       // last leader announced
+	stale     bool          // the store may hold changes that didn't reach the log
+	committed uint64        // last marker of Commit committed to the log
+	advanced  chan struct{} // closed when committed advances or the node stops leading
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New starts a node replicating the store of h, which must be created with coopurl.WithFollower.
+func New(h *coopurl.Handler, cfg Config) (*Node, error) {
+	n := &Node{cfg: cfg, h: h, logger: cfg.Logger, advanced: make(chan struct{}), stop: make(chan struct{}), done: make(chan struct{})}
+	if n.logger == nil {
+		n.logger = coopurl.NilLogger{}
+	}
+	h.Follow()
+
+	session := make([]byte, 8)
+	if _, err := rand.Read(session); err != nil {
+		return nil, err
+	}
+	n.session = cfg.ID + "-" + hex.EncodeToString(session)
+
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	var err error
+	if n.store, err = openLogStore(filepath.Join(cfg.Dir, "log")); err != nil {
+		return nil, err
+	}
+	if b, err := n.store.Get(leaderKey); err == nil {
+		if err := json.Unmarshal(b, &n.leader); err != nil {
+			n.store.Close()
+			return nil, err
+		}
+		// the node may have stopped while leading
+		n.stale = n.leader.Node == cfg.ID
+	}
+
+	if err := n.start(); err != nil {
+		if n.transport != nil {
+			n.transport.Close()
+		}
+		n.store.Close()
+		return nil, err
+	}
+	go n.watch()
+	return n, nil
+}
+
+func (n *Node) start() error {
+	logger := raftLogger(n.logger)
+	snapshots, err := raft.NewFileSnapshotStoreWithLogger(n.cfg.Dir, 2, logger)
+	if err != nil {
+		return err
+	}
+
+	advertise := n.cfg.Advertise
+	if advertise == "" {
+		advertise = n.cfg.RaftAddr
+	}
+	addr, err := net.ResolveTCPAddr("tcp", advertise)
+	if err != nil {
+		return err
+	}
+	if n.transport, err = raft.NewTCPTransportWithLogger(n.cfg.RaftAddr, addr, 3, 10*time.Second, logger); err != nil {
+		return err
+	}
+
+	notify := make(chan bool, 16)
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(n.cfg.ID)
+	conf.NotifyCh = notify
+	conf.Logger = logger
+	// the store is kept on disk, replaying the log from the last snapshot is enough
+	conf.NoSnapshotRestoreOnStart = true
+
+	if n.cfg.Bootstrap {
+		err := raft.BootstrapCluster(conf, n.store, n.store, snapshots, n.transport, raft.Configuration{
+			Servers: []raft.Server{{ID: conf.LocalID, Address: n.transport.LocalAddr()}},
+		})
+		if err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+			return err
+		}
+	}
+
+	n.notify = notify
+	n.raft, err = raft.NewRaft(conf, fsm{n}, n.store, n.store, snapshots, n.transport)
+	return err
+}
+
+// watch makes the handler writable while the node leads.
+func (n *Node) watch() {
+	defer close(n.done)
+
+	var stop context.CancelFunc
+	for {
+		select {
+		case <-n.stop:
+			if stop != nil {
+				stop()
+			}
+			return
+		case leading := <-n.notify:
+			if leading && stop == nil {
+				stop = n.lead()
+			} else if !leading && stop != nil {
+				n.follow()
+				stop()
+				stop = nil
+			}
+		}
+	}
+}
+
+// lead waits for the log to be applied, then makes the handler writable and appends its changes to the log.
+func (n *Node) lead() context.CancelFunc {
+	if err := n.raft.Barrier(applyTimeout).Error(); err != nil {
+		n.logger.Errorf("Couldn't apply the log before leading: %s", err)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := n.h.Changes(ctx)
+	if err != nil {
+		n.logger.Errorf("Couldn't subscribe to the store changes: %s", err)
+		cancel()
+		return nil
+	}
+	if err := n.h.Lead(); err != nil {
+		n.logger.Errorf("Couldn't lead: %s", err)
+		cancel()
+		return nil
+	}
+	atomic.StoreInt32(&n.leading, 1)
+	n.mu.Lock()
+	n.stale = false
+	n.mu.Unlock()
+
+	err = n.apply(command{
+		Kind:           kindLeader,
+		APIURL:         n.cfg.APIURL,
+		ReplicationURL: n.cfg.ReplicationURL,
+	})
+	if err != nil {
+		n.logger.Warningf("Couldn't announce the leader: %s", err)
+	}
+	n.logger.Infof("Node %s leads the cluster", n.cfg.ID)
+
+	go n.replicate(ctx, changes)
+	return cancel
+}
+
+// follow makes the handler read-only once the node stopped leading.
+func (n *Node) follow() {
+	n.h.Follow()
+	atomic.StoreInt32(&n.leading, 0)
+	n.mu.Lock()
+	n.stale = true
+	n.signal()
+	n.mu.Unlock()
+	n.logger.Infof("Node %s follows the cluster", n.cfg.ID)
+}
+
+// replicate appends the changes of the store to the log.
+func (n *Node) replicate(ctx context.Context, changes <-chan []byte) {
+	for b := range changes {
+		err := n.apply(command{Kind: kindChanges, Changes: b})
+		if err == nil {
+			n.markersCommitted(b)
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		n.logger.Errorf("Couldn't append changes to the log, stop leading: %s", err)
+		break
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	// the store holds changes the log doesn't have
+	n.h.Follow()
+	atomic.StoreInt32(&n.leading, 0)
+	n.mu.Lock()
+	n.signal()
+	n.mu.Unlock()
+	if err := n.raft.LeadershipTransfer().Error(); err != nil {
+		n.logger.Errorf("Couldn't transfer the leadership: %s", err)
+	}
+}
+
+func (n *Node) apply(cmd command) error {
+	cmd.Node, cmd.Session = n.cfg.ID, n.session
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	f := n.raft.Apply(b, applyTimeout)
+	if err := f.Error(); err != nil {
+		return err
+	}
+	if err, ok := f.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// markersCommitted records the markers of Commit held by changes committed to the log.
+func (n *Node) markersCommitted(b []byte) {
+	markers, err := coopurl.Markers(b)
+	if err != nil || len(markers) == 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, m := range markers {
+		if len(m) == 8 && binary.BigEndian.Uint64(m) > n.committed {
+			n.committed = binary.BigEndian.Uint64(m)
+		}
+	}
+	n.signal()
+}
+
+// signal wakes up the calls to Commit, n.mu must be held.
+func (n *Node) signal() {
+	close(n.advanced)
+	n.advanced = make(chan struct{})
+}
+
+// Commit waits until the changes committed to the store of the leader before the call are committed to the
+// raft log, by a quorum of the nodes. The writes received by the api are answered once committed, the writes
+// made with the handler itself must be committed to survive a failure of the leader.
+// It fails with ErrNotLeader if the node doesn't lead, or stops leading before the changes are committed.
+func (n *Node) Commit(ctx context.Context) error {
+	if !n.IsLeader() {
+		return ErrNotLeader
+	}
+	mark := atomic.AddUint64(&n.marks, 1)
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, mark)
+	if err := n.h.Mark(b); err != nil {
+		return err
+	}
+
+	for {
+		n.mu.Lock()
+		committed, advanced := n.committed, n.advanced
+		n.mu.Unlock()
+		if committed >= mark {
+			return nil
+		}
+		if !n.IsLeader() {
+			return ErrNotLeader
+		}
+		select {
+		case <-advanced:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// IsLeader tells if the handler of the node is writable.
+func (n *Node) IsLeader() bool {
+	return atomic.LoadInt32(&n.leading) == 1
+}
+
+// LeaderURL returns the api url of the last leader announced, empty if unknown.
+func (n *Node) LeaderURL() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader.APIURL
+}
+
+// Join adds a voting node to the cluster, it must be called on the leader.
+func (n *Node) Join(id, addr string) error {
+	if n.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	return n.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, applyTimeout).Error()
+}
+
+// Leave removes a node from the cluster, it must be called on the leader.
+func (n *Node) Leave(id string) error {
+	if n.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	return n.raft.RemoveServer(raft.ServerID(id), 0, applyTimeout).Error()
+}
+
+// ForwardWrites is a middleware forwarding the requests other than GET, HEAD and OPTIONS
+// to the leader while the node follows. While it leads, their responses are held until their
+// changes are committed to the log, or replaced by 503 Service Unavailable if they can't be.
+func (n *Node) ForwardWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if n.IsLeader() {
+			n.serveCommitted(w, r, next)
+			return
+		}
+		// requests are forwarded once, in case the leader isn't known yet by the node it was forwarded to
+		if r.Header.Get(forwardedHeader) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		leader, err := url.Parse(n.LeaderURL())
+		if err != nil || leader.Host == "" {
+			http.Error(w, "no cluster leader", http.StatusServiceUnavailable)
+			return
+		}
+		proxy := httputil.NewSingleHostReverseProxy(leader)
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			n.logger.Warningf("Couldn't forward %s %s to the leader: %s", r.Method, r.URL.Path, err)
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		r.Header.Set(forwardedHeader, n.cfg.ID)
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// serveCommitted serves a write on the leader, and answers it once its changes are committed to the log.
+func (n *Node) serveCommitted(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	held := &heldResponse{header: http.Header{}}
+	next.ServeHTTP(held, r)
+
+	// failed writes have nothing to commit
+	if held.status < http.StatusBadRequest {
+		ctx, cancel := context.WithTimeout(r.Context(), applyTimeout)
+		err := n.Commit(ctx)
+		cancel()
+		if err != nil {
+			n.logger.Errorf("Couldn't commit %s %s to the cluster: %s", r.Method, r.URL.Path, err)
+			http.Error(w, "write not committed to the cluster", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	for k, v := range held.header {
+		w.Header()[k] = v
+	}
+	if held.status != 0 {
+		w.WriteHeader(held.status)
+	}
+	w.Write(held.body.Bytes())
+}
+
+// heldResponse keeps a response until it can be sent.
+type heldResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (h *heldResponse) Header() http.Header {
+	return h.header
+}
+
+func (h *heldResponse) WriteHeader(status int) {
+	if h.status == 0 {
+		h.status = status
+	}
+}
+
+func (h *heldResponse) Write(b []byte) (int, error) {
+	h.WriteHeader(http.StatusOK)
+	return h.body.Write(b)
+}
+
+// Close leaves the raft group, without closing the handler.
+func (n *Node) Close() error {
+	close(n.stop)
+	<-n.done
+	err := n.raft.Shutdown().Error()
+	n.transport.Close()
+	if cerr := n.store.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/hashicorp/raft"
+)
+
+var (
+	logPrefix    = []byte("log\x00")
+	stablePrefix = []byte("stable\x00")
+)
+
+// errNotFound is the error raft expects from a StableStore for missing keys.
+var errNotFound = errors.New("not found")
+
+// logStore keeps the raft log and state in a badger store.
+type logStore struct {
+	db *badger.DB
+}
+
+var (
+	_ raft.LogStore    = (*logStore)(nil)
+	_ raft.StableStore = (*logStore)(nil)
+)
+
+func openLogStore(path string) (*logStore, error) {
+	opt := badger.DefaultOptions(path).WithLogger(nil).WithSyncWrites(true)
+	db, err := badger.Open(opt)
+	if err != nil {
+		return nil, err
+	}
+	return &logStore{db: db}, nil
+}
+
+func (s *logStore) Close() error {
+	return s.db.Close()
+}
+
+func logKey(index uint64) []byte {
+	k := make([]byte, len(logPrefix)+8)
+	copy(k, logPrefix)
+	binary.BigEndian.PutUint64(k[len(logPrefix):], index)
+	return k
+}
+
+func stableKey(key []byte) []byte {
+	return append(append([]byte{}, stablePrefix...), key...)
+}
+
+func (s *logStore) FirstIndex() (uint64, error) {
+	return s.edge(false)
+}
+
+func (s *logStore) LastIndex() (uint64, error) {
+	return s.edge(true)
+}
+
+// edge returns the first or last index of the log, 0 if it's empty.
+func (s *logStore) edge(last bool) (uint64, error) {
+	var index uint64
+	err := s.db.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		opt.Reverse = last
+		it := txn.NewIterator(opt)
+		defer it.Close()
+
+		seek := logPrefix
+		if last {
+			seek = logKey(math.MaxUint64)
+		}
+		if it.Seek(seek); it.ValidForPrefix(logPrefix) {
+			index = binary.BigEndian.Uint64(it.Item().Key()[len(logPrefix):])
+		}
+		return nil
+	})
+	return index, err
+}
+
+func (s *logStore) GetLog(index uint64, l *raft.Log) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(logKey(index))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return raft.ErrLogNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			return json.Unmarshal(v, l)
+		})
+	})
+}
+
+func (s *logStore) StoreLog(l *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{l})
+}
+
+func (s *logStore) StoreLogs(logs []*raft.Log) error {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, l := range logs {
+		b, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		if err := wb.Set(logKey(l.Index), b); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+func (s *logStore) DeleteRange(min, max uint64) error {
+	var keys [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		for it.Seek(logKey(min)); it.ValidForPrefix(logPrefix); it.Next() {
+			k := it.Item().KeyCopy(nil)
+			if binary.BigEndian.Uint64(k[len(logPrefix):]) > max {
+				break
+			}
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range keys {
+		if err := wb.Delete(k); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+func (s *logStore) Set(key, val []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(stableKey(key), val)
+	})
+}
+
+func (s *logStore) Get(key []byte) ([]byte, error) {
+	var val []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(stableKey(key))
+		if err != nil {
+			return err
+		}
+		val, err = item.ValueCopy(nil)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, errNotFound
+	}
+	return val, err
+}
+
+func (s *logStore) SetUint64(key []byte, val uint64) error {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, val)
+	return s.Set(key, b)
+}
+
+func (s *logStore) GetUint64(key []byte) (uint64, error) {
+	b, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 8 {
+		return 0, errors.New("invalid uint64 value")
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
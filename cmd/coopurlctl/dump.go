@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// export writes a portable dump of a badger store, which must not be opened by a server, to a file.
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	db := fs.String("db", "", "badger store directory, eg: /var/lib/coopurl")
+	out := fs.String("out", "", "dump file to write, eg: /backup/coopurl.ndjson")
+	fs.Parse(args)
+	if *db == "" || *out == "" {
+		fs.Usage()
+		return errors.New("export needs --db and --out")
+	}
+
+	h, err := coopurl.New(coopurl.WithDbPath(*db))
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := h.ExportDump(f); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %s to %s\n", *db, *out)
+	return nil
+}
+
+// importDump imports a dump written by export, or by GET /admin/dump, into a badger store which must not be
+// opened by a server. The links are indexed with the default indexes.
+func importDump(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	db := fs.String("db", "", "badger store directory, eg: /var/lib/coopurl")
+	in := fs.String("in", "", "dump file to read, eg: /backup/coopurl.ndjson")
+	fs.Parse(args)
+	if *db == "" || *in == "" {
+		fs.Usage()
+		return errors.New("import needs --db and --in")
+	}
+
+	h, err := coopurl.New(coopurl.WithDbPath(*db))
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := h.ImportDump(f); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %s into %s\n", *in, *db)
+	return nil
+}
@@ -0,0 +1,67 @@
+// Command coopurlctl manages the stores of coopurl servers.
+//
+// Usage:
+//
+//	coopurlctl migrate --from badger:/var/lib/coopurl --to file:/backup/coopurl.dump
+//	coopurlctl tokens create --db /var/lib/coopurl --owner alice --name ci --scopes create,read-stats
+//	coopurlctl verify --db /var/lib/coopurl --repair
+//	coopurlctl export --db /var/lib/coopurl --out /backup/coopurl.ndjson
+//	coopurlctl import --db /var/lib/coopurl --in /backup/coopurl.ndjson
+//	coopurlctl shorten --url https://s.example.org --token $TOKEN --ttl 720h - < urls.txt
+//	coopurlctl shorten --db /var/lib/coopurl --ttl 720h --tag newsletter - < urls.txt
+//
+// Stores are given as scheme:path, with the schemes:
+//   - badger: a badger store directory, as used by the server
+//   - file: a dump of a store, keeping the TTLs and metadata of every key
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "migrate":
+		err = migrate(args)
+	case "tokens":
+		err = tokens(args)
+	case "verify":
+		err = verify(args)
+	case "export":
+		err = export(args)
+	case "import":
+		err = importDump(args)
+	case "shorten":
+		err = shorten(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: coopurlctl <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  migrate --from <store> --to <store>  copy all the keys of a store to another one")
+	fmt.Fprintln(os.Stderr, "  tokens create|list|revoke --db <dir>  manage the api tokens of the owners")
+	fmt.Fprintln(os.Stderr, "  verify --db <dir> [--repair]         check the integrity of a store, and repair it")
+	fmt.Fprintln(os.Stderr, "  export --db <dir> --out <file>       write a portable dump of a store, with its stats and history")
+	fmt.Fprintln(os.Stderr, "  import --db <dir> --in <file>        import a dump written by export into a store")
+	fmt.Fprintln(os.Stderr, "  shorten --url <server>|--db <dir> -  shorten the urls read from stdin, printing url<TAB>short")
+}
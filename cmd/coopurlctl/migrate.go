@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// migrate copies all the keys of a store, with their TTLs and metadata, to another store whose content is replaced.
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "store to copy, eg: badger:/var/lib/coopurl")
+	to := fs.String("to", "", "store to replace, eg: file:/backup/coopurl.dump")
+	fs.Parse(args)
+	if *from == "" || *to == "" {
+		fs.Usage()
+		return errors.New("migrate needs --from and --to")
+	}
+
+	src, err := openSource(*from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := openDestination(*to)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	// the dump is streamed from one store to the other
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(src.dump(w))
+	}()
+	cr := &countReader{r: r}
+	if err := dst.load(cr); err != nil {
+		r.CloseWithError(err)
+		return err
+	}
+	fmt.Printf("Migrated %d bytes from %s to %s\n", cr.n, *from, *to)
+	return nil
+}
+
+// store is either side of a migration.
+type store struct {
+	h    *coopurl.Handler
+	file *os.File
+}
+
+func parseStore(s string) (scheme, path string, err error) {
+	i := strings.Index(s, ":")
+	if i < 0 || s[i+1:] == "" {
+		return "", "", fmt.Errorf("invalid store %q, expected scheme:path", s)
+	}
+	switch scheme := s[:i]; scheme {
+	case "badger", "file":
+		return scheme, s[i+1:], nil
+	default:
+		return "", "", fmt.Errorf("unsupported store %q, expected badger or file", scheme)
+	}
+}
+
+func openSource(s string) (*store, error) {
+	scheme, path, err := parseStore(s)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == "file" {
+		f, err := os.Open(path)
+		return &store{file: f}, err
+	}
+	h, err := coopurl.New(coopurl.WithDbPath(path))
+	return &store{h: h}, err
+}
+
+func openDestination(s string) (*store, error) {
+	scheme, path, err := parseStore(s)
+	if err != nil {
+		return nil, err
+	}
+	if scheme == "file" {
+		f, err := os.Create(path)
+		return &store{file: f}, err
+	}
+	h, err := coopurl.New(coopurl.WithDbPath(path))
+	return &store{h: h}, err
+}
+
+func (s *store) dump(w io.Writer) error {
+	if s.file != nil {
+		_, err := io.Copy(w, s.file)
+		return err
+	}
+	return s.h.WriteSnapshot(w)
+}
+
+func (s *store) load(r io.Reader) error {
+	if s.file != nil {
+		if _, err := io.Copy(s.file, r); err != nil {
+			return err
+		}
+		return s.file.Sync()
+	}
+	return s.h.LoadSnapshot(r)
+}
+
+func (s *store) Close() {
+	if s.file != nil {
+		s.file.Close()
+		return
+	}
+	s.h.Close()
+}
+
+// countReader counts the bytes read.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
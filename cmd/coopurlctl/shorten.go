@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// maxBatch is the number of links the server accepts in a batch.
+const maxBatch = 1000
+
+// shorten posts the urls read from stdin, one per line, to the batch api of a server, or with --db to a badger
+// store which must not be opened by a server, and prints them with their ids, or their short urls with --base,
+// separated by a tab, in the order read.
+func shorten(args []string) error {
+	fs := flag.NewFlagSet("shorten", flag.ExitOnError)
+	server := fs.String("url", "", "url of the server, eg: https://s.example.org")
+	token := fs.String("token", "", "api token of the server, with the create scope")
+	db := fs.String("db", "", "badger store directory to post to offline, instead of a server, eg: /var/lib/coopurl")
+	ttl := fs.Duration("ttl", 0, "time the links are kept, forever if 0")
+	tags := fs.String("tag", "", "comma separated tags of the links, only with --db")
+	base := fs.String("base", "", "url the ids are printed after, eg: https://s.example.org/")
+	batch := fs.Int("batch", 100, "number of links posted per request")
+	concurrency := fs.Int("concurrency", 4, "number of batches posted at once")
+	fs.Parse(args)
+	if (*server == "") == (*db == "") || fs.NArg() != 1 || *concurrency < 1 || *batch < 1 || *batch > maxBatch {
+		fs.Usage()
+		return fmt.Errorf("shorten needs either --url or --db, a positive --concurrency, a --batch up to %d and - to read the urls from stdin", maxBatch)
+	}
+	if *server != "" && *tags != "" {
+		return errors.New("--tag is only supported with --db, the batch api doesn't tag links")
+	}
+
+	in := io.Reader(os.Stdin)
+	if path := fs.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var post func(urls []string) ([]coopurl.BatchResult, error)
+	if *server != "" {
+		c := &batchClient{url: strings.TrimSuffix(*server, "/") + "/api/v1/links:batch", token: *token, ttl: *ttl}
+		post = c.post
+	} else {
+		h, err := coopurl.New(coopurl.WithDbPath(*db))
+		if err != nil {
+			return err
+		}
+		defer h.Close()
+
+		var opts []coopurl.ReqOptions
+		if *ttl != 0 {
+			opts = append(opts, coopurl.WithTTL(*ttl))
+		}
+		if *tags != "" {
+			opts = append(opts, coopurl.WithTags(strings.Split(*tags, ",")...))
+		}
+		post = func(urls []string) ([]coopurl.BatchResult, error) {
+			links := make([]coopurl.BatchLink, len(urls))
+			for i, u := range urls {
+				links[i].URL = u
+			}
+			return h.PostBatch(links, opts...)
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	failed := 0
+	err := shortenLines(in, *batch, *concurrency, post, func(url, id string, err error) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s\t%s\n", url, err)
+			return
+		}
+		fmt.Fprintf(out, "%s\t%s%s\n", url, *base, id)
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("couldn't shorten %d urls", failed)
+	}
+	return nil
+}
+
+// batchClient posts batches of links to the batch api of a server.
+type batchClient struct {
+	url   string
+	token string
+	ttl   time.Duration
+}
+
+// batchLink and batchResult are the links and results of the batch api.
+type batchLink struct {
+	URL string `json:"url"`
+	TTL string `json:"ttl,omitempty"`
+}
+
+type batchResult struct {
+	ID      string `json:"id"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+func (c *batchClient) post(urls []string) ([]coopurl.BatchResult, error) {
+	links := make([]batchLink, len(urls))
+	for i, u := range urls {
+		links[i].URL = u
+		if c.ttl != 0 {
+			links[i].TTL = c.ttl.String()
+		}
+	}
+	body, err := json.Marshal(links)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return nil, fmt.Errorf("server answered %s: %s", resp.Status, e.Message)
+	}
+
+	var out []batchResult
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out) != len(urls) {
+		return nil, fmt.Errorf("server answered %d results for %d links", len(out), len(urls))
+	}
+	results := make([]coopurl.BatchResult, len(out))
+	for i, res := range out {
+		results[i].ID = res.ID
+		if res.Error != "" {
+			results[i].Err = fmt.Errorf("%s: %s", res.Error, res.Message)
+		}
+	}
+	return results, nil
+}
+
+// shortenLines calls post with the non-empty lines of r, by batches of size, concurrency batches at a time, and
+// print with their results in the order of the lines. The error of a batch is printed with each of its lines.
+func shortenLines(r io.Reader, size, concurrency int, post func(urls []string) ([]coopurl.BatchResult, error), print func(url, id string, err error)) error {
+	type batch struct {
+		urls    []string
+		results []coopurl.BatchResult
+		err     error
+		done    chan struct{}
+	}
+	batches := make(chan *batch, concurrency)
+	jobs := make(chan *batch)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range jobs {
+				b.results, b.err = post(b.urls)
+				close(b.done)
+			}
+		}()
+	}
+
+	// the batches are printed in order as they complete
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		for b := range batches {
+			<-b.done
+			for i, url := range b.urls {
+				if b.err != nil {
+					print(url, "", b.err)
+				} else {
+					print(url, b.results[i].ID, b.results[i].Err)
+				}
+			}
+		}
+	}()
+
+	sc := bufio.NewScanner(r)
+	start := time.Now()
+	n := 0
+	send := func(urls []string) {
+		b := &batch{urls: urls, done: make(chan struct{})}
+		batches <- b
+		jobs <- b
+	}
+	var urls []string
+	for sc.Scan() {
+		url := strings.TrimSpace(sc.Text())
+		if url == "" {
+			continue
+		}
+		urls = append(urls, url)
+		n++
+		if len(urls) == size {
+			send(urls)
+			urls = nil
+		}
+	}
+	if len(urls) > 0 {
+		send(urls)
+	}
+	close(jobs)
+	wg.Wait()
+	close(batches)
+	<-printed
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Posted %d urls in %s\n", n, time.Since(start).Round(time.Millisecond))
+	return nil
+}
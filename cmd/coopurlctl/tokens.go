@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// tokens manages the api tokens of a badger store, which must not be opened by a server.
+func tokens(args []string) error {
+	if len(args) == 0 {
+		return errors.New("tokens needs a subcommand: create, list or revoke")
+	}
+
+	fs := flag.NewFlagSet("tokens "+args[0], flag.ExitOnError)
+	db := fs.String("db", "", "badger store directory, eg: /var/lib/coopurl")
+	owner := fs.String("owner", "", "owner of the token")
+	name := fs.String("name", "", "name of the token, eg: the script using it")
+	scopes := fs.String("scopes", "", "comma separated scopes of the token: create, read-stats, delete")
+	fs.Parse(args[1:])
+	if *db == "" {
+		fs.Usage()
+		return errors.New("tokens needs --db")
+	}
+
+	h, err := coopurl.New(coopurl.WithDbPath(*db))
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	switch args[0] {
+	case "create":
+		var s []coopurl.Scope
+		for _, scope := range strings.Split(*scopes, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				s = append(s, coopurl.Scope(scope))
+			}
+		}
+		token, t, err := h.CreateToken(*owner, *name, s...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created token %s of %s, it won't be shown again:\n%s\n", t.ID, t.Owner, token)
+	case "list":
+		list, err := h.Tokens(*owner)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tOWNER\tNAME\tSCOPES\tCREATED")
+		for _, t := range list {
+			s := make([]string, len(t.Scopes))
+			for i, scope := range t.Scopes {
+				s[i] = string(scope)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.Owner, t.Name, strings.Join(s, ","), t.CreatedAt.Format("2006-01-02 15:04"))
+		}
+		return tw.Flush()
+	case "revoke":
+		if fs.NArg() != 1 {
+			return errors.New("tokens revoke needs the id of the token")
+		}
+		if err := h.RevokeToken(fs.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked token %s\n", fs.Arg(0))
+	default:
+		return fmt.Errorf("unknown tokens subcommand %q", args[0])
+	}
+	return nil
+}
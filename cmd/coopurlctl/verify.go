@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// verify checks the integrity of a badger store, which must not be opened by a server, and repairs it if asked.
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	db := fs.String("db", "", "badger store directory, eg: /var/lib/coopurl")
+	repair := fs.Bool("repair", false, "delete the corrupt links, dangling index keys and orphaned records")
+	fs.Parse(args)
+	if *db == "" {
+		fs.Usage()
+		return errors.New("verify needs --db")
+	}
+
+	h, err := coopurl.New(coopurl.WithDbPath(*db))
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	report, err := h.Verify(*repair)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d links\n", report.Links)
+	for _, id := range report.Corrupt {
+		fmt.Printf("corrupt link %s\n", id)
+	}
+	fmt.Printf("%d dangling index keys\n", report.DanglingIndexes)
+	fmt.Printf("%d orphaned records\n", report.OrphanedRecords)
+	switch {
+	case report.Repaired:
+		fmt.Println("Repaired the store")
+	case !report.OK():
+		return errors.New("the store has problems, run verify --repair to fix them")
+	}
+	return nil
+}
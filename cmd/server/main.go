@@ -1,98 +1,134 @@
+// Command server serves the url shortener of the server package, configured by its flags.
 package main
 
 import (
+	"flag"
 	"fmt"
-	"html/template"
 	"log"
-	"net/http"
-	"net/url"
+	"os"
 	"strings"
 	"time"
 
-	"github.com/coopgo/coopurl"
-
-	"github.com/gorilla/mux"
-	"github.com/sirupsen/logrus"
+	"github.com/coopgo/coopurl/v2"
+	"github.com/coopgo/coopurl/v2/server"
 )
 
+// version and commit are set at build time, eg: go build -ldflags "-X main.version=v2.4.0 -X main.commit=$(git rev-parse HEAD)".
+var version, commit string
+
 func main() {
+	var cfg server.Config
+	cfg.Version, cfg.Commit = version, commit
+	flag.StringVar(&cfg.Addr, "addr", "0.0.0.0:8080", "address the server listens on")
+	flag.StringVar(&cfg.AdminToken, "admin-token", os.Getenv("COOPURL_ADMIN_TOKEN"), "bearer token of the admin api, the admin api is disabled if empty")
+	flag.StringVar(&cfg.DBPath, "db", "", "badger store directory, "+coopurl.DefaultDbDir()+" by default, "+coopurl.LegacyDbPath+" for the stores of the previous versions")
+	flag.BoolVar(&cfg.ValidateDestination, "validate-destination", false, "reject urls whose destination is unreachable")
+	flag.StringVar(&cfg.BaseURL, "base-url", "", "url the links are served at, eg: https://coopgo.fr/r/, links to it are refused")
+	redirectPrefixes := flag.String("redirect-prefixes", "", "comma separated paths the links are served under, / serving root-level codes alongside the other routes, eg: /r/,/ (default /r/)")
+	reservedRoutes := flag.String("reserved-routes", "", "comma separated first path segments served by a proxy in front of the server, which root-level codes can't take")
+	flag.DurationVar(&cfg.SoftDelete, "soft-delete", 0, "how long deleted links can be restored, links are deleted immediately if 0")
+	flag.StringVar(&cfg.Analytics, "analytics", "counts", "how clicks are recorded: counts, full or private")
+	flag.DurationVar(&cfg.EventRetention, "event-retention", 0, "how long the click events of full analytics are kept, forever if 0, eg: 2160h")
+	flag.StringVar(&cfg.UniqueVisitors, "unique-visitors", "", "how unique visitors are counted: cookie or hash, a daily salted hash of the ip and user agent, not counted if empty")
+	flag.DurationVar(&cfg.LockWait, "lock-wait", 0, "how long to wait for the store while another process holds its lock, eg: 30s during a rolling restart")
+	flag.BoolVar(&cfg.Verify, "verify", false, "check the store at startup, deleting its corrupt links, dangling index keys and orphaned records, eg: after an unclean shutdown")
+	flag.StringVar(&cfg.TemplatesDir, "templates", "", "directory of templates replacing the default page templates of the same name, eg: home.html, to brand the pages, their forms must write {{csrfField}}")
+	flag.StringVar(&cfg.RobotsPath, "robots", "", "robots.txt file to serve, crawlers are kept away from links by default")
+	flag.BoolVar(&cfg.PublicIndex, "public-index", false, "serve the links made public with the admin api at /links and /sitemap.xml, and let crawlers follow them")
+	flag.BoolVar(&cfg.NoIndex, "noindex", false, "ask search engines not to index any link")
+	flag.DurationVar(&cfg.CheckInterval, "check-interval", 0, "interval between two checks of all the stored destinations, disabled if 0")
+	disabledJobs := flag.String("disable-jobs", "", "comma separated maintenance jobs not run by this instance, eg: dead-links,rollup")
+	jobJitter := flag.Float64("job-jitter", coopurl.DefaultJobJitter, "fraction of their interval by which the runs of the maintenance jobs are delayed at random")
+	flag.StringVar(&cfg.ArchivePath, "archive", "", "file the expired links and their stats are appended to before being removed, disabled if empty")
+	flag.StringVar(&cfg.AbuseWebhook, "abuse-webhook", "", "url the abuse reports are posted to as JSON")
+	flag.IntVar(&cfg.AbuseDisable, "abuse-disable", 0, "number of abuse reports disabling a link, never disabled if 0")
+	flag.DurationVar(&cfg.ExpiryNotice, "expiry-notice", 0, "how long before their expiry the owners of links are warned, disabled if 0")
+	flag.StringVar(&cfg.ExpiryWebhook, "expiry-webhook", "", "url the expiry notices are posted to as JSON, with -expiry-notice")
+	flag.StringVar(&cfg.WebhookSecret, "webhook-secret", os.Getenv("COOPURL_WEBHOOK_SECRET"), "secret signing the abuse, expiry and purge webhooks in their Coopurl-Signature header, unsigned if empty")
+	flag.StringVar(&cfg.SMTP.Addr, "smtp-addr", "", "mail server emailing the expiry notices to the owners, eg: smtp.coopgo.fr:587, with -expiry-notice")
+	flag.StringVar(&cfg.SMTP.From, "smtp-from", "", "sender of the expiry notices")
+	flag.StringVar(&cfg.SMTP.Username, "smtp-username", "", "username on the mail server, no authentication if empty")
+	flag.StringVar(&cfg.SMTP.Password, "smtp-password", os.Getenv("COOPURL_SMTP_PASSWORD"), "password on the mail server")
+	flag.BoolVar(&cfg.Anomalies, "anomalies", false, "log the links with an unusual traffic")
+	flag.StringVar(&cfg.AccessLog, "access-log", "", "format of the access log written to stdout: combined or json, disabled if empty")
+	flag.StringVar(&cfg.DebugAddr, "debug-addr", "", "private address serving pprof, expvar and the prometheus metrics, eg: localhost:6060, disabled if empty")
+	metricsDomains := flag.String("metrics-domains", "", "comma separated destination domains counted apart in the redirect metrics, the others are counted as other")
+	flag.BoolVar(&cfg.Previews, "previews", false, "fetch the title, description and image of destinations, shown in the link details")
+	flag.BoolVar(&cfg.Unfurl, "unfurl", false, "answer link preview bots with the preview of the destination instead of a redirect, with -previews")
+	flag.StringVar(&cfg.ScreenshotURL, "screenshot-url", "", "endpoint of a headless browser service capturing the thumbnails of the destinations, eg: http://browserless:3000/screenshot, disabled if empty")
+	flag.BoolVar(&cfg.PublicStats, "public-stats", false, "serve the clicks and top referrers of each link at /r/{id}.json and /api/v1/links/{id}/stats to anyone")
+	flag.DurationVar(&cfg.StoreTimeout, "store-timeout", 0, "time the redirects wait for the store before answering 503, unbounded if 0")
+	flag.IntVar(&cfg.CircuitFailures, "circuit-failures", 0, "consecutive store failures opening the circuit, the redirects are then served from the cache only, disabled if 0")
+	flag.DurationVar(&cfg.CircuitCooldown, "circuit-cooldown", 10*time.Second, "time the circuit stays open before the store is tried again")
+	flag.BoolVar(&cfg.SyncWrites, "sync-writes", false, "sync every write to disk before answering, so that no write is lost if the machine crashes")
+	flag.StringVar(&cfg.Compression, "compression", "", "compression of the stored links: snappy or zstd, uncompressed if empty")
+	flag.IntVar(&cfg.CacheSize, "cache", 0, "number of links kept in memory, disabled if 0")
+	flag.DurationVar(&cfg.StaleWindow, "stale-window", 0, "time the cached links are still served after they changed while they are read again, with -cache, disabled if 0")
+	flag.StringVar(&cfg.CacheRedis, "cache-redis", "", "address of a Redis server whose channel carries the links changed by the instances sharing the store, evicted from their cache, with -cache")
+	flag.StringVar(&cfg.CacheRedisPassword, "cache-redis-password", "", "password of the -cache-redis server")
+	flag.DurationVar(&cfg.CDNMaxAge, "cdn-max-age", 0, "time the redirects are cached by browsers when served through a cdn, with -cdn-shared-max-age")
+	flag.DurationVar(&cfg.CDNSharedMaxAge, "cdn-shared-max-age", 0, "time the redirects are cached by a cdn in front of the server, which doesn't count their clicks, disabled if 0")
+	flag.StringVar(&cfg.CDNPurgeURL, "cdn-purge-url", "", "url the surrogate keys of the changed links are posted to as JSON, to purge them from the cdn")
+	flag.IntVar(&cfg.WarmUp, "warm-up", 0, "number of the most clicked links read at startup, loaded in the cache with -cache, disabled if 0")
+	flag.IntVar(&cfg.RedirectLogSampling, "redirect-log-sampling", 1, "log one redirect out of n")
+	flag.StringVar(&cfg.ReplicationAddr, "replication-addr", "", "address serving the changes of the store to replicas at / and standbys at /deltas, authenticated with the admin token, disabled if empty")
+	flag.StringVar(&cfg.ReplicaOf, "replica-of", "", "replication url of the primary to follow, authenticated with the admin token, eg: http://primary:8081/")
+	flag.StringVar(&cfg.ReplicaFallback, "replica-fallback", "", "replication url of the process holding the store, followed when the store is locked then taken over once it exits, eg: http://localhost:8081/ for blue/green deploys")
+	flag.StringVar(&cfg.StandbyOf, "standby-of", "", "deltas url of the primary to pull, authenticated with the admin token, eg: http://primary:8081/deltas")
+	flag.DurationVar(&cfg.StandbyInterval, "standby-interval", 10*time.Second, "interval between two pulls of the primary changes")
+	flag.StringVar(&cfg.ClusterID, "cluster-id", "", "id of the node in a raft cluster of servers, clustering is disabled if empty")
+	flag.StringVar(&cfg.ClusterAddr, "cluster-addr", "", "address of the raft transport reachable by the other nodes, eg: 10.0.0.1:7000")
+	flag.StringVar(&cfg.ClusterDir, "cluster-dir", "raft", "directory of the raft log and snapshots")
+	flag.BoolVar(&cfg.ClusterBootstrap, "cluster-bootstrap", false, "start a new cluster made of this node")
+	flag.StringVar(&cfg.ClusterJoin, "cluster-join", "", "api url of a member of the cluster to join")
+	flag.StringVar(&cfg.ClusterAPIURL, "cluster-api-url", "", "api url of this node, the other nodes forward the writes there while it leads")
+	flag.StringVar(&cfg.ClusterReplicationURL, "cluster-replication-url", "", "url of the replication address of this node, reachable by the other nodes")
+	flag.StringVar(&cfg.OwnerHeader, "owner-header", "", "header carrying the user authenticated by a proxy in front of the server, enables the /my/links pages and attributes the new links to the user, disabled if empty")
+	flag.StringVar(&cfg.OIDCIssuer, "oidc-issuer", "", "url of the OpenID Connect provider logging the users of the /my/links pages and the admins in, eg: https://accounts.example.org, disabled if empty")
+	flag.StringVar(&cfg.OIDCClientID, "oidc-client-id", "", "client id of the server at the OpenID Connect provider")
+	flag.StringVar(&cfg.OIDCClientSecret, "oidc-client-secret", os.Getenv("COOPURL_OIDC_CLIENT_SECRET"), "client secret of the server at the OpenID Connect provider")
+	flag.StringVar(&cfg.OIDCRedirectURL, "oidc-redirect-url", "", "public url of /auth/callback, eg: https://short.example.org/auth/callback")
+	flag.StringVar(&cfg.OIDCOwnerClaim, "oidc-owner-claim", "sub", "claim of the id tokens naming the owner of the links, eg: email")
+	oidcAdmins := flag.String("oidc-admins", "", "comma separated owners allowed on the admin api with their session")
+	flag.StringVar(&cfg.SessionKey, "session-key", os.Getenv("COOPURL_SESSION_KEY"), "key signing the sessions of the OpenID Connect users, random if empty so the sessions end with the server")
+	flag.StringVar(&cfg.SlackSigningSecret, "slack-signing-secret", os.Getenv("COOPURL_SLACK_SIGNING_SECRET"), "signing secret of the Slack app whose slash command is served at /slack/command, disabled if empty")
+	flag.StringVar(&cfg.CreateToken, "create-token", os.Getenv("COOPURL_CREATE_TOKEN"), "static bearer token required to create links, in place of the api tokens, disabled if empty")
+	flag.StringVar(&cfg.Htpasswd, "htpasswd", "", "htpasswd file of the users allowed to create links with basic auth, besides the api tokens, disabled if empty")
+	flag.StringVar(&cfg.ShareSecret, "share-secret", os.Getenv("COOPURL_SHARE_SECRET"), "secret signing the temporary links to the stats of a link, served at /shared/{token}, disabled if empty")
+	flag.StringVar(&cfg.IDSecret, "id-secret", os.Getenv("COOPURL_ID_SECRET"), "secret mixed into the hash of the generated ids, so that they can't be predicted from the urls")
+	flag.StringVar(&cfg.Captcha, "captcha", "", "captcha required by the creation form of the home page: hcaptcha or turnstile, disabled if empty")
+	flag.StringVar(&cfg.CaptchaSiteKey, "captcha-site-key", "", "site key of the captcha widget")
+	flag.StringVar(&cfg.CaptchaSecret, "captcha-secret", os.Getenv("COOPURL_CAPTCHA_SECRET"), "secret of the captcha verification api")
+	flag.IntVar(&cfg.CreationBurst, "creation-burst", 0, "links an ip can create from the home page at once, then one per -creation-interval, not limited if 0")
+	flag.StringVar(&cfg.SettingsPath, "settings", "", "json file of the runtime settings reloaded on SIGHUP and POST /admin/reload: blocked_words, reserved_prefixes, reserved_ids, creation_limit, log_levels and redirect_log_sampling")
+	flag.DurationVar(&cfg.CreationInterval, "creation-interval", time.Minute, "time for an ip to create one more link, with -creation-burst")
+	allowCIDRs := flag.String("allow-cidr", "", "comma separated ip ranges allowed to create links and use the admin api, eg: 10.0.0.0/8, everyone if empty")
+	flag.Parse()
+
+	cfg.DisabledJobs = split(*disabledJobs)
+	cfg.RedirectPrefixes = split(*redirectPrefixes)
+	cfg.ReservedRoutes = split(*reservedRoutes)
+	cfg.MetricsDomains = split(*metricsDomains)
+	cfg.OIDCAdmins = split(*oidcAdmins)
+	cfg.AllowCIDRs = split(*allowCIDRs)
+	cfg.JobJitter = *jobJitter
+	if cfg.JobJitter == 0 {
+		cfg.JobJitter = -1
+	}
 
-	h, err := coopurl.New(coopurl.WithLogger(logrus.New()))
+	srv, err := server.New(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer h.Close()
-
-	r := mux.NewRouter()
-
-	// HomePage
-	r.HandleFunc("/", ServeHome).Methods("GET")
-	r.HandleFunc("/", ServeShort(h)).Methods("POST")
-
-	// Redirect
-	r.Handle("/r/{key}", h).Methods("GET")
-
-	srv := &http.Server{
-		Handler:      r,
-		Addr:         "0.0.0.0:8080",
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
-	}
 
 	fmt.Println("Starting server on : " + srv.Addr)
 	log.Fatal(srv.ListenAndServe())
 }
 
-func ServeHome(w http.ResponseWriter, r *http.Request) {
-	tmpl := template.Must(template.ParseFiles("templates/layout.html", "templates/home.html"))
-	tmpl.ExecuteTemplate(w, "layout", nil)
-}
-
-type ShortData struct {
-	ShortURL    string
-	PrevURL     string
-	PrevURLLink string
-}
-
-func ServeShort(h *coopurl.Handler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if err := r.ParseForm(); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		us := r.Form["u"]
-		if len(us) == 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		u := us[0]
-
-		id, err := h.Post(u)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		ur, err := url.Parse(u)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		if ur.Scheme == "" {
-			ur.Scheme = "http"
-		}
-
-		surl := url.URL{Host: r.Host, Path: "r/" + id}
-
-		data := ShortData{
-			ShortURL:    strings.TrimLeft(surl.String(), "/"),
-			PrevURL:     u,
-			PrevURLLink: ur.String(),
-		}
-
-		tmpl := template.Must(template.ParseFiles("templates/layout.html", "templates/short.html"))
-		tmpl.ExecuteTemplate(w, "layout", data)
+// split returns the comma separated values of s, none if empty.
+func split(s string) []string {
+	if s == "" {
+		return nil
 	}
+	return strings.Split(s, ",")
 }
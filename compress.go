@@ -0,0 +1,111 @@
+package coopurl
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+type Compression int
+
+const (
+	// CompressionNone stores the links as json. It is the default.
+	CompressionNone Compression = iota
+	// CompressionSnappy is fast and compresses less.
+	CompressionSnappy
+	// CompressionZstd compresses more, for links carrying previews or pages.
+	CompressionZstd
+)
+
+// WithCompression compresses the stored links with the given algorithm.
+// Links are read whatever their compression, so it can be enabled or changed on an existing store,
+// but older versions of the library can't read compressed links.
+func WithCompression(c Compression) Options {
+	return func(h *Handler) {
+		h.compression = c
+	}
+}
+
+// compressedPrefix starts the compressed values, followed by the algorithm.
+// It can't start the other values, which are json or, for the oldest links, a bare url.
+const compressedPrefix = 0x00
+
+// minCompressSize is the size under which values aren't worth compressing.
+const minCompressSize = 128
+
+// maxDecompressedSize bounds the decompressed values, as a corrupted value could claim any size.
+const maxDecompressedSize = 1 << 24
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec returns the zstd encoder and decoder, shared by all the handlers as they're safe for concurrent use.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+func validCompression(c Compression) bool {
+	return c >= CompressionNone && c <= CompressionZstd
+}
+
+// encodeEntry encodes a link to be stored, compressed if the handler compresses links and it's worth it.
+func (h *Handler) encodeEntry(e entry) ([]byte, error) {
+	b, err := e.encode()
+	if err != nil || h.compression == CompressionNone || len(b) < minCompressSize {
+		return b, err
+	}
+
+	c := []byte{compressedPrefix, byte(h.compression)}
+	switch h.compression {
+	case CompressionSnappy:
+		c = append(c, snappy.Encode(nil, b)...)
+	case CompressionZstd:
+		enc, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		c = enc.EncodeAll(b, c)
+	}
+	if len(c) >= len(b) {
+		return b, nil
+	}
+	return c, nil
+}
+
+// decompress returns the json of a compressed value.
+func decompress(b []byte) ([]byte, error) {
+	if len(b) < 2 {
+		return nil, errors.New("truncated compressed value")
+	}
+	switch Compression(b[1]) {
+	case CompressionSnappy:
+		n, err := snappy.DecodedLen(b[2:])
+		if err != nil {
+			return nil, err
+		}
+		if n > maxDecompressedSize {
+			return nil, fmt.Errorf("compressed value of %d bytes is too large", n)
+		}
+		return snappy.Decode(nil, b[2:])
+	case CompressionZstd:
+		_, dec, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(b[2:], nil)
+	}
+	return nil, fmt.Errorf("unknown compression %d", b[1])
+}
@@ -0,0 +1,78 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// etag returns a weak entity tag for the representation v.
+func etag(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	f := fnv.New64a()
+	f.Write(b)
+	return fmt.Sprintf(`W/"%016x"`, f.Sum64())
+}
+
+// ETag returns an entity tag that changes with the link and its clicks, but not with its time left.
+func (e Entry) ETag() string {
+	e.TTL = 0
+	return etag(e)
+}
+
+// modified returns the time the link was last changed, zero if unknown.
+func (e entry) modified() time.Time {
+	if !e.UpdatedAt.IsZero() {
+		return e.UpdatedAt
+	}
+	return e.CreatedAt
+}
+
+// NotModified sets the ETag and, if modified isn't zero, the Last-Modified headers of the response, and answers
+// GET and HEAD requests whose If-None-Match or If-Modified-Since show the client already has it with a 304 status.
+// It returns true when the response was written.
+func NotModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// If-Modified-Since is ignored when If-None-Match is present
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" || !matchETag(inm, etag) {
+			return false
+		}
+	} else {
+		t, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || modified.IsZero() || modified.Truncate(time.Second).After(t) {
+			return false
+		}
+	}
+
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// matchETag tells if the If-None-Match header lists etag, with the weak comparison.
+func matchETag(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,316 @@
+package coopurl
+
+import (
+	"crypto"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration read from and written to config files as a string, eg: "72h".
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return optionError("invalid duration %q", b)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config configures a handler with plain values, eg: decoded from a json or yaml file, in place of the options
+// taking values. The options taking functions, eg: WithLogger or WithOutbox, are given to NewFromConfig along
+// with it. The zero values keep the defaults of the options, and modes are given by name, eg: "zstd".
+type Config struct {
+	// Store
+	DBPath         string                `json:"db_path,omitempty" yaml:"db_path,omitempty"`
+	LegacyDBPath   bool                  `json:"legacy_db_path,omitempty" yaml:"legacy_db_path,omitempty"`
+	TemporaryStore bool                  `json:"temporary_store,omitempty" yaml:"temporary_store,omitempty"`
+	LazyOpen       bool                  `json:"lazy_open,omitempty" yaml:"lazy_open,omitempty"`
+	LockWait       Duration              `json:"lock_wait,omitempty" yaml:"lock_wait,omitempty"`
+	SyncWrites     bool                  `json:"sync_writes,omitempty" yaml:"sync_writes,omitempty"`
+	Compression    string                `json:"compression,omitempty" yaml:"compression,omitempty"` // none, snappy or zstd
+	Consistency    string                `json:"consistency,omitempty" yaml:"consistency,omitempty"` // strong or eventual
+	AsyncWrites    *AsyncWritesConfig    `json:"async_writes,omitempty" yaml:"async_writes,omitempty"`
+	StoreTimeout   Duration              `json:"store_timeout,omitempty" yaml:"store_timeout,omitempty"`
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker,omitempty" yaml:"circuit_breaker,omitempty"`
+	Cache          int                   `json:"cache,omitempty" yaml:"cache,omitempty"`
+	StaleWindow    Duration              `json:"stale_window,omitempty" yaml:"stale_window,omitempty"`
+	WarmUp         int                   `json:"warm_up,omitempty" yaml:"warm_up,omitempty"`
+	StoreSampling  *StoreSamplingConfig  `json:"store_sampling,omitempty" yaml:"store_sampling,omitempty"`
+
+	// Ids
+	DefaultLength      int      `json:"default_length,omitempty" yaml:"default_length,omitempty"`
+	AdaptiveLength     float64  `json:"adaptive_length,omitempty" yaml:"adaptive_length,omitempty"` // max density of the ids
+	Alphabet           string   `json:"alphabet,omitempty" yaml:"alphabet,omitempty"`
+	CaseInsensitiveIDs bool     `json:"case_insensitive_ids,omitempty" yaml:"case_insensitive_ids,omitempty"`
+	IDMode             string   `json:"id_mode,omitempty" yaml:"id_mode,omitempty"` // hashed, random or sequential
+	Hash               string   `json:"hash,omitempty" yaml:"hash,omitempty"`       // eg: SHA-512, a crypto.Hash linked in the binary, not MD4, MD5 nor SHA-1
+	IDSecret           string   `json:"id_secret,omitempty" yaml:"id_secret,omitempty"`
+	SignSecret         string   `json:"sign_secret,omitempty" yaml:"sign_secret,omitempty"`
+	SignLength         int      `json:"sign_length,omitempty" yaml:"sign_length,omitempty"`
+	ReservedPrefixes   []string `json:"reserved_prefixes,omitempty" yaml:"reserved_prefixes,omitempty"`
+	ReservedIDs        []string `json:"reserved_ids,omitempty" yaml:"reserved_ids,omitempty"`
+	BlockedWords       []string `json:"blocked_words,omitempty" yaml:"blocked_words,omitempty"`
+	NoDefaultWords     bool     `json:"no_default_blocked_words,omitempty" yaml:"no_default_blocked_words,omitempty"` // see WithoutDefaultBlockedWords
+	ProtectedBrands    []string `json:"protected_brands,omitempty" yaml:"protected_brands,omitempty"`
+
+	// Links
+	DefaultTTL          Duration             `json:"default_ttl,omitempty" yaml:"default_ttl,omitempty"`
+	ExpiryGrace         Duration             `json:"expiry_grace,omitempty" yaml:"expiry_grace,omitempty"`
+	ExpiredRetention    Duration             `json:"expired_retention,omitempty" yaml:"expired_retention,omitempty"`
+	SoftDelete          Duration             `json:"soft_delete,omitempty" yaml:"soft_delete,omitempty"`
+	IdempotencyWindow   Duration             `json:"idempotency_window,omitempty" yaml:"idempotency_window,omitempty"`
+	BaseURLs            []string             `json:"base_urls,omitempty" yaml:"base_urls,omitempty"`
+	ChainDepth          int                  `json:"chain_depth,omitempty" yaml:"chain_depth,omitempty"`
+	RedirectStatus      int                  `json:"redirect_status,omitempty" yaml:"redirect_status,omitempty"`
+	AllowedSchemes      []string             `json:"allowed_schemes,omitempty" yaml:"allowed_schemes,omitempty"`
+	RewriteRules        []RewriteRule        `json:"rewrite_rules,omitempty" yaml:"rewrite_rules,omitempty"`
+	MaxURLLength        int                  `json:"max_url_length,omitempty" yaml:"max_url_length,omitempty"`
+	MaxMetadataSize     int                  `json:"max_metadata_size,omitempty" yaml:"max_metadata_size,omitempty"`
+	ValidateDestination bool                 `json:"validate_destination,omitempty" yaml:"validate_destination,omitempty"`
+	HomographProtection bool                 `json:"homograph_protection,omitempty" yaml:"homograph_protection,omitempty"`
+	DefaultNoIndex      bool                 `json:"default_no_index,omitempty" yaml:"default_no_index,omitempty"`
+	Previews            bool                 `json:"previews,omitempty" yaml:"previews,omitempty"`
+	Unfurl              bool                 `json:"unfurl,omitempty" yaml:"unfurl,omitempty"`
+	OwnerQuota          *Quota               `json:"owner_quota,omitempty" yaml:"owner_quota,omitempty"`
+	NamespaceQuota      *Quota               `json:"namespace_quota,omitempty" yaml:"namespace_quota,omitempty"`
+	CreationLimit       *CreationLimitConfig `json:"creation_limit,omitempty" yaml:"creation_limit,omitempty"`
+	CDN                 *CDNConfig           `json:"cdn,omitempty" yaml:"cdn,omitempty"`
+
+	// Analytics
+	Analytics          string   `json:"analytics,omitempty" yaml:"analytics,omitempty"` // counts, full or private
+	EventRetention     Duration `json:"event_retention,omitempty" yaml:"event_retention,omitempty"`
+	UniqueVisitors     string   `json:"unique_visitors,omitempty" yaml:"unique_visitors,omitempty"` // none, cookie or hash
+	PublicStats        bool     `json:"public_stats,omitempty" yaml:"public_stats,omitempty"`
+	StatsSharingSecret string   `json:"stats_sharing_secret,omitempty" yaml:"stats_sharing_secret,omitempty"`
+
+	// Jobs
+	DisabledJobs     []string `json:"disabled_jobs,omitempty" yaml:"disabled_jobs,omitempty"`
+	JobJitter        *float64 `json:"job_jitter,omitempty" yaml:"job_jitter,omitempty"`
+	DeadLinkInterval Duration `json:"dead_link_interval,omitempty" yaml:"dead_link_interval,omitempty"`
+
+	// Replication
+	ReplicaOf        string   `json:"replica_of,omitempty" yaml:"replica_of,omitempty"`
+	ReplicaFallback  string   `json:"replica_fallback,omitempty" yaml:"replica_fallback,omitempty"`
+	StandbyOf        string   `json:"standby_of,omitempty" yaml:"standby_of,omitempty"`
+	StandbyInterval  Duration `json:"standby_interval,omitempty" yaml:"standby_interval,omitempty"`
+	ReplicationToken string   `json:"replication_token,omitempty" yaml:"replication_token,omitempty"`
+	Follower         bool     `json:"follower,omitempty" yaml:"follower,omitempty"`
+
+	// Logs
+	LogLevels           map[string]string `json:"log_levels,omitempty" yaml:"log_levels,omitempty"` // level by category, eg: redirects: warning
+	RedirectLogSampling int               `json:"redirect_log_sampling,omitempty" yaml:"redirect_log_sampling,omitempty"`
+}
+
+// AsyncWritesConfig configures WithAsyncWrites.
+type AsyncWritesConfig struct {
+	FlushInterval Duration `json:"flush_interval" yaml:"flush_interval"`
+	MaxBatch      int      `json:"max_batch" yaml:"max_batch"`
+}
+
+// CircuitBreakerConfig configures WithCircuitBreaker.
+type CircuitBreakerConfig struct {
+	Failures int      `json:"failures" yaml:"failures"`
+	Cooldown Duration `json:"cooldown" yaml:"cooldown"`
+}
+
+// StoreSamplingConfig configures WithStoreSampling.
+type StoreSamplingConfig struct {
+	Interval Duration `json:"interval" yaml:"interval"`
+	Samples  int      `json:"samples" yaml:"samples"`
+}
+
+// CreationLimitConfig configures WithCreationLimit.
+type CreationLimitConfig struct {
+	Burst    int      `json:"burst" yaml:"burst"`
+	Interval Duration `json:"interval" yaml:"interval"`
+}
+
+// CDNConfig configures WithCDN, without purges.
+type CDNConfig struct {
+	MaxAge       Duration `json:"max_age" yaml:"max_age"`
+	SharedMaxAge Duration `json:"shared_max_age" yaml:"shared_max_age"`
+}
+
+var (
+	compressionNames = map[string]Compression{"": CompressionNone, "none": CompressionNone, "snappy": CompressionSnappy, "zstd": CompressionZstd}
+	consistencyNames = map[string]Consistency{"strong": ConsistencyStrong, "eventual": ConsistencyEventual}
+	idModeNames      = map[string]IDMode{"": IDHashed, "hashed": IDHashed, "random": IDRandom, "sequential": IDSequential}
+	analyticsNames   = map[string]AnalyticsMode{"": AnalyticsCounts, "counts": AnalyticsCounts, "full": AnalyticsFull, "private": AnalyticsPrivate}
+	visitorNames     = map[string]VisitorMode{"": VisitorsNone, "none": VisitorsNone, "cookie": VisitorsCookie, "hash": VisitorsDailyHash}
+	logCategoryNames = map[string]LogCategory{"store": LogStore, "redirects": LogRedirects, "admin": LogAdmin}
+	logLevelNames    = map[string]LogLevel{"debug": LevelDebug, "info": LevelInfo, "warning": LevelWarning, "error": LevelError}
+)
+
+// Options returns the options configured by c, failing on unknown names.
+func (c Config) Options() ([]Options, error) {
+	var opts []Options
+	add := func(ok bool, opt Options) {
+		if ok {
+			opts = append(opts, opt)
+		}
+	}
+
+	add(c.DBPath != "", WithDbPath(c.DBPath))
+	add(c.LegacyDBPath, WithLegacyDbPath())
+	add(c.TemporaryStore, WithTemporaryStore())
+	add(c.LazyOpen, WithLazyOpen())
+	add(c.LockWait != 0, WithLockWait(time.Duration(c.LockWait)))
+	add(c.SyncWrites, WithSyncWrites(true))
+	compression, ok := compressionNames[strings.ToLower(c.Compression)]
+	if !ok {
+		return nil, optionError("unknown compression %q", c.Compression)
+	}
+	add(compression != CompressionNone, WithCompression(compression))
+	if c.Consistency != "" {
+		consistency, ok := consistencyNames[strings.ToLower(c.Consistency)]
+		if !ok {
+			return nil, optionError("unknown consistency %q", c.Consistency)
+		}
+		add(true, WithConsistency(consistency))
+	}
+	if w := c.AsyncWrites; w != nil {
+		add(true, WithAsyncWrites(time.Duration(w.FlushInterval), w.MaxBatch))
+	}
+	add(c.StoreTimeout != 0, WithStoreTimeout(time.Duration(c.StoreTimeout)))
+	if b := c.CircuitBreaker; b != nil {
+		add(true, WithCircuitBreaker(b.Failures, time.Duration(b.Cooldown)))
+	}
+	add(c.Cache != 0, WithCache(c.Cache))
+	add(c.StaleWindow != 0, WithStaleWhileRevalidate(time.Duration(c.StaleWindow)))
+	add(c.WarmUp != 0, WithWarmUp(c.WarmUp))
+	if s := c.StoreSampling; s != nil {
+		add(true, WithStoreSampling(time.Duration(s.Interval), s.Samples))
+	}
+
+	add(c.DefaultLength != 0, WithDefaultLength(c.DefaultLength))
+	add(c.AdaptiveLength != 0, WithAdaptiveLength(c.AdaptiveLength))
+	add(c.Alphabet != "", WithAlphabet(c.Alphabet))
+	add(c.CaseInsensitiveIDs, WithCaseInsensitiveIDs())
+	mode, ok := idModeNames[strings.ToLower(c.IDMode)]
+	if !ok {
+		return nil, optionError("unknown id mode %q", c.IDMode)
+	}
+	add(mode != IDHashed, WithIDMode(mode))
+	if c.Hash != "" {
+		hash, ok := hashNamed(c.Hash)
+		if !ok {
+			return nil, optionError("unknown, broken or unlinked hash %q", c.Hash)
+		}
+		add(true, WithHash(hash))
+	}
+	add(c.IDSecret != "", WithIDSecret([]byte(c.IDSecret)))
+	add(c.SignSecret != "", WithSignedIDs([]byte(c.SignSecret), c.SignLength))
+	add(len(c.ReservedPrefixes) > 0, WithReservedPrefixes(c.ReservedPrefixes...))
+	add(len(c.ReservedIDs) > 0, WithReservedIDs(c.ReservedIDs...))
+	add(len(c.BlockedWords) > 0, WithBlockedWords(c.BlockedWords...))
+	add(c.NoDefaultWords, WithoutDefaultBlockedWords())
+	add(len(c.ProtectedBrands) > 0, WithProtectedBrands(c.ProtectedBrands...))
+
+	add(c.DefaultTTL != 0, WithDefaultTTL(time.Duration(c.DefaultTTL)))
+	add(c.ExpiryGrace != 0, WithExpiryGrace(time.Duration(c.ExpiryGrace)))
+	add(c.ExpiredRetention != 0, WithExpiredRetention(time.Duration(c.ExpiredRetention)))
+	add(c.SoftDelete != 0, WithSoftDelete(time.Duration(c.SoftDelete)))
+	add(c.IdempotencyWindow != 0, WithIdempotencyWindow(time.Duration(c.IdempotencyWindow)))
+	add(len(c.BaseURLs) > 0, WithBaseURL(c.BaseURLs...))
+	add(c.ChainDepth != 0, WithChainDepth(c.ChainDepth))
+	add(c.RedirectStatus != 0, WithRedirectStatus(c.RedirectStatus))
+	add(len(c.AllowedSchemes) > 0, WithAllowedSchemes(c.AllowedSchemes...))
+	add(len(c.RewriteRules) > 0, WithRewriteRules(c.RewriteRules...))
+	add(c.MaxURLLength != 0, WithMaxURLLength(c.MaxURLLength))
+	add(c.MaxMetadataSize != 0, WithMaxMetadataSize(c.MaxMetadataSize))
+	add(c.ValidateDestination, WithValidateDestination())
+	add(c.HomographProtection, WithHomographProtection())
+	add(c.DefaultNoIndex, WithDefaultNoIndex())
+	add(c.Previews, WithPreviews())
+	add(c.Unfurl, WithUnfurl())
+	if c.OwnerQuota != nil {
+		add(true, WithOwnerQuota(*c.OwnerQuota))
+	}
+	if c.NamespaceQuota != nil {
+		add(true, WithNamespaceQuota(*c.NamespaceQuota))
+	}
+	if l := c.CreationLimit; l != nil {
+		add(true, WithCreationLimit(CreationLimit{Burst: l.Burst, Interval: time.Duration(l.Interval)}))
+	}
+	if cdn := c.CDN; cdn != nil {
+		add(true, WithCDN(CDN{MaxAge: time.Duration(cdn.MaxAge), SharedMaxAge: time.Duration(cdn.SharedMaxAge)}))
+	}
+
+	analytics, ok := analyticsNames[strings.ToLower(c.Analytics)]
+	if !ok {
+		return nil, optionError("unknown analytics mode %q", c.Analytics)
+	}
+	add(analytics != AnalyticsCounts, WithAnalytics(analytics))
+	add(c.EventRetention != 0, WithEventRetention(time.Duration(c.EventRetention)))
+	visitors, ok := visitorNames[strings.ToLower(c.UniqueVisitors)]
+	if !ok {
+		return nil, optionError("unknown visitor mode %q", c.UniqueVisitors)
+	}
+	add(visitors != VisitorsNone, WithUniqueVisitors(visitors))
+	add(c.PublicStats, WithPublicStats())
+	add(c.StatsSharingSecret != "", WithStatsSharing([]byte(c.StatsSharingSecret)))
+
+	add(len(c.DisabledJobs) > 0, WithoutJobs(c.DisabledJobs...))
+	if c.JobJitter != nil {
+		add(true, WithJobJitter(*c.JobJitter))
+	}
+	add(c.DeadLinkInterval != 0, WithDeadLinkChecker(time.Duration(c.DeadLinkInterval)))
+
+	add(c.ReplicaOf != "", WithReplicaOf(c.ReplicaOf, c.ReplicationToken))
+	add(c.ReplicaFallback != "", WithReplicaFallback(c.ReplicaFallback, c.ReplicationToken))
+	add(c.StandbyOf != "", WithStandbyOf(c.StandbyOf, c.ReplicationToken, time.Duration(c.StandbyInterval)))
+	add(c.Follower, WithFollower())
+
+	for category, level := range c.LogLevels {
+		cat, ok := logCategoryNames[strings.ToLower(category)]
+		if !ok {
+			return nil, optionError("unknown log category %q", category)
+		}
+		lvl, ok := logLevelNames[strings.ToLower(level)]
+		if !ok {
+			return nil, optionError("unknown log level %q", level)
+		}
+		add(true, WithLogLevel(cat, lvl))
+	}
+	add(c.RedirectLogSampling != 0, WithRedirectLogSampling(c.RedirectLogSampling))
+	return opts, nil
+}
+
+// brokenHashes are the hashes whose collisions can be computed, not used for ids.
+var brokenHashes = map[crypto.Hash]bool{crypto.MD4: true, crypto.MD5: true, crypto.SHA1: true, crypto.MD5SHA1: true}
+
+// hashNamed returns the hash of the given name, as written by crypto.Hash.String, eg: SHA-512, if it is linked
+// in the binary and not broken.
+func hashNamed(name string) (crypto.Hash, bool) {
+	for h := crypto.MD4; h <= crypto.BLAKE2b_512; h++ {
+		if strings.EqualFold(h.String(), name) {
+			return h, h.Available() && !brokenHashes[h]
+		}
+	}
+	return 0, false
+}
+
+// Validate checks the configuration like New checks its options, without opening the store.
+func (c Config) Validate() error {
+	opts, err := c.Options()
+	if err != nil {
+		return err
+	}
+	_, err = configure(opts)
+	return err
+}
+
+// NewFromConfig returns a handler configured by c, then by opts, eg: WithLogger.
+func NewFromConfig(c Config, opts ...Options) (*Handler, error) {
+	copts, err := c.Options()
+	if err != nil {
+		return nil, err
+	}
+	return New(append(copts, opts...)...)
+}
@@ -0,0 +1,60 @@
+package coopurl
+
+type Consistency int
+
+const (
+	// ConsistencyStrong guarantees that a link is served as soon as the call writing it returns, so that a link
+	// can be created then redirected in the same request. It is the guarantee of a writable handler.
+	ConsistencyStrong Consistency = iota + 1
+	// ConsistencyEventual serves the links once they reach the store read by the handler: the buffered links of
+	// WithAsyncWrites once flushed, the links of a primary or a cluster leader once replicated.
+	ConsistencyEventual
+)
+
+func (c Consistency) String() string {
+	switch c {
+	case ConsistencyStrong:
+		return "strong"
+	case ConsistencyEventual:
+		return "eventual"
+	default:
+		return "unknown"
+	}
+}
+
+// WithConsistency requires the given consistency: with ConsistencyStrong, New rejects the options
+// serving links eventually, WithAsyncWrites and WithReplicaOf.
+// Followers and standbys are eventually consistent until they lead, see Handler.Consistency.
+func WithConsistency(c Consistency) Options {
+	return func(h *Handler) {
+		h.consistency = c
+	}
+}
+
+// Consistency returns the current guarantee of the handler, eg: to check that a redirect can follow a creation
+// on the same handler. It changes when a follower leads.
+func (h *Handler) Consistency() Consistency {
+	if h.writes != nil || h.writable() != nil {
+		return ConsistencyEventual
+	}
+	return ConsistencyStrong
+}
+
+// checkConsistency checks that the options give the consistency required with WithConsistency.
+func (h *Handler) checkConsistency() error {
+	switch h.consistency {
+	case 0, ConsistencyEventual:
+		return nil
+	case ConsistencyStrong:
+	default:
+		return optionError("unknown consistency %d", h.consistency)
+	}
+
+	if h.writes != nil {
+		return optionError("async writes aren't strongly consistent")
+	}
+	if h.primaryURL != "" || h.fallbackURL != "" {
+		return optionError("replicas aren't strongly consistent")
+	}
+	return nil
+}
@@ -1,31 +1,191 @@
 // coopurl is an url shortener library using badger.
+//
+// Handler implements the Shortener, RedirectHandler and Admin interfaces.
+// The compatibility promise of the v2 module is described in the README.
 package coopurl
 
 import (
-	"crypto/sha256"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"html/template"
 	"net/http"
 	"net/url"
-	"path"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/badger/v3"
 )
 
 const (
-	DefaultDbPath = "/tmp/badger"
+	// LegacyDbPath is the store directory used by default before, see WithLegacyDbPath.
+	LegacyDbPath = "/tmp/badger"
+	// Deprecated: the store is in DefaultDbDir by default, use LegacyDbPath.
+	DefaultDbPath = LegacyDbPath
 	DefaultLength = 8
 )
 
 // Handler is the handler for our library.
-// It should be created with the New() function.
+// It should be created with the New() function, the operations of other handlers return ErrClosed.
+// A Handler is safe for concurrent use, Close waits for the operations in progress.
 type Handler struct {
 	db     *badger.DB
 	mu     sync.Mutex
 	path   string // will only affect the database if it's set before the database is initialized.
 	logger Logger
 
+	temporary bool   // set with WithTemporaryStore
+	tempDir   string // store of a temporary handler, removed on Close
+
+	state     int32        // stateNew, stateOpen or stateClosed, accessed atomically
+	lazyOpen  bool         // open the store at the first use
+	lifecycle sync.Mutex   // serializes the opening and closing of the store
+	inflight  sync.RWMutex // read locked by the operations on the store, locked to close it
+	openedAt  time.Time    // when the store was last opened, given in Info
+
+	logLevels           [logCategories]LogLevel
+	redirectLogSampling uint64 // accessed atomically
+	redirectLogCount    uint64
+
+	// settingsMu guards the settings changed by Reload: the log levels, the reserved ids, prefixes and blocked
+	// words, and the creation limit
+	settingsMu sync.RWMutex
+
+	authorizeDelete func(*http.Request) bool
+	disabledHandler http.Handler
+	grace           time.Duration
+	graceNotifier   func(GraceNotice)
+
+	expiredRetention time.Duration
+	staleWindow      time.Duration
+
+	rewriteRules []RewriteRule // guarded by settingsMu
+	rewriter     func(*url.URL) error
+
+	redirectRewriter func(dest string, r *http.Request) string
+
+	expiryNotifier       func(ExpiryNotice)
+	expiryNoticeBefore   time.Duration
+	expiryNoticeInterval time.Duration
+
+	rejectHomographs bool
+	validator        *checker
+
+	signSecret []byte
+	signLength int
+
+	alphabet         string
+	hash             func([]byte) []byte
+	idSecret         []byte // set with WithIDSecret
+	idMode           IDMode
+	sequence         *badger.Sequence
+	sequenceMu       sync.Mutex
+	caseInsensitive  bool
+	reservedPrefixes []string
+	reservedIDs      []string
+	routeConflicts   func(id string) bool // set with WithRoutes
+	blockedWords     []string
+	noDefaultWords   bool // set with WithoutDefaultBlockedWords
+	protectedBrands  []string
+	screening        Screening // kept in the store
+
+	baseURLs   []*url.URL
+	chainDepth int
+
+	redirectStatus int      // set with WithRedirectStatus, 301 if 0
+	schemes        []string // set with WithAllowedSchemes, any if empty
+
+	retention time.Duration
+	analytics AnalyticsMode
+	noIndex   bool
+	detectBot func(*http.Request) BotKind
+
+	visitors    VisitorMode
+	visitorSalt visitorSalt
+
+	eventRetention time.Duration // set with WithEventRetention
+
+	idempotencyWindow time.Duration // set with WithIdempotencyWindow
+	creationLimit     *CreationLimit
+
+	previews     *previewer
+	unfurl       bool
+	publicStats  bool
+	shareSecret  []byte // set with WithStatsSharing
+	pageTemplate *template.Template
+	infoTemplate *template.Template // set with WithInfoTemplate
+	thumbnails   *thumbnailer
+	indexes      []index // builtinIndexes and those added with WithIndex
+
+	compression     Compression
+	writes          *writeBuffer // set with WithAsyncWrites
+	syncWrites      bool
+	lockWait        time.Duration
+	consistency     Consistency // required with WithConsistency
+	warmUp          int
+	storeTimeout    time.Duration
+	breaker         *breaker       // set with WithCircuitBreaker
+	detached        sync.WaitGroup // store accesses given up by withTimeout
+	maxURLLength    int
+	maxMetadataSize int
+
+	deadLinks         *checker
+	deadLinksInterval time.Duration
+
+	anomalies     *anomalyDetector
+	redirects     broadcaster
+	observers     []RedirectObserver
+	countryLookup func(ip string) string
+
+	abuseNotifier  func(AbuseReport)
+	outbox         *Outbox
+	abuseThreshold int
+
+	ownerQuota     Quota
+	namespaceQuota Quota
+
+	primaryURL    string
+	primaryToken  string
+	fallbackURL   string // set with WithReplicaFallback
+	fallbackToken string
+	stopReplica   context.CancelFunc
+	follower      int32        // 1 while read-only in a cluster or on a standby
+	maintenance   atomic.Value // message of the maintenance, with StartMaintenance
+
+	standbyURL      string
+	standbyToken    string
+	standbyInterval time.Duration
+	standbyMu       sync.Mutex
+
+	cache     *entryCache
+	cacheBus  InvalidationBus
+	stopCache context.CancelFunc
+
+	cdn        *CDN // set with WithCDN
+	stopPurges context.CancelFunc
+
+	archiver        Archiver
+	archiveInterval time.Duration
+
+	maxDensity float64
+	links      linkCounter
+
+	sampling time.Duration
+	sampler  storeSampler
+
+	done chan struct{} // closed to stop the background jobs
+	jobs sync.WaitGroup
+
+	scheduler    scheduler
+	disabledJobs map[string]bool
+	jobJitter    float64
+
+	optionErr error // first error of the options, returned by New
+
 	TTL    time.Duration
 	Length int
 	Scheme string
@@ -34,22 +194,137 @@ type Handler struct {
 // New creates a new Handler.
 // It implements the option pattern to change the default value of our handler.
 func New(opts ...Options) (*Handler, error) {
+	h, err := configure(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.lazyOpen {
+		return h, nil
+	}
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// configure returns the handler set up and validated with opts, its store not opened yet.
+func configure(opts []Options) (*Handler, error) {
 	var h Handler
 	h.logger = NilLogger{}
+	h.jobJitter = DefaultJobJitter
+	h.indexes = append([]index(nil), builtinIndexes...)
 
 	for _, opt := range opts {
 		opt(&h)
 	}
-
-	if err := h.open(); err != nil {
+	if h.optionErr != nil {
+		return nil, h.optionErr
+	}
+	if err := h.validate(); err != nil {
 		return nil, err
 	}
-
+	if h.cache != nil {
+		h.cache.staleWindow = h.staleWindow
+	}
 	return &h, nil
 }
 
+// start starts the background jobs of the store just opened.
+func (h *Handler) start() error {
+	h.mu.Lock()
+	h.done = make(chan struct{})
+	h.scheduler.reset()
+	h.mu.Unlock()
+
+	if h.cache != nil {
+		// the store may have changed while it was closed
+		h.cache.reset()
+		var ctx context.Context
+		ctx, h.stopCache = context.WithCancel(context.Background())
+		if err := h.followCache(ctx); err != nil {
+			h.stopCache()
+			return err
+		}
+	}
+	if h.cdn != nil && (h.cdn.Purge != nil || h.outbox.queues(EventPurge)) {
+		var ctx context.Context
+		ctx, h.stopPurges = context.WithCancel(context.Background())
+		if err := h.followPurges(ctx); err != nil {
+			h.stopPurges()
+			return err
+		}
+	}
+	if h.warmUp > 0 {
+		h.async(h.warm)
+	}
+	if h.standbyURL != "" {
+		h.every(h.standbyInterval, h.pullDeltas)
+	}
+	if h.writes != nil {
+		h.every(h.writes.interval, h.flushWrites)
+	}
+	if h.sampling > 0 {
+		h.sampleStore()
+		h.every(h.sampling, h.sampleStore)
+	}
+	if h.primaryURL != "" {
+		// The jobs writing to the store run on the primary.
+		var ctx context.Context
+		ctx, h.stopReplica = context.WithCancel(context.Background())
+		h.jobs.Add(1)
+		go h.replicate(ctx)
+		return nil
+	}
+	if h.retention > 0 {
+		h.schedule(JobPurgeTombstones, time.Hour, func() error {
+			_, err := h.PurgeTombstones()
+			return err
+		})
+	}
+	h.schedule(JobRollUp, time.Hour, h.RollUp)
+	if h.eventRetention > 0 {
+		h.schedule(JobPurgeEvents, time.Hour, func() error {
+			_, err := h.PurgeEvents()
+			return err
+		})
+	}
+	if h.anomalies != nil {
+		h.every(h.anomalies.cfg.Window, h.anomalies.prune)
+	}
+	if h.archiver != nil {
+		h.schedule(JobArchive, h.archiveInterval, h.archiveExpired)
+	}
+	if h.expiryNotifier != nil {
+		h.schedule(JobExpiryNotices, h.expiryNoticeInterval, h.notifyExpiring)
+	}
+	h.schedule(JobScheduledUpdates, scheduleInterval, h.applySchedule)
+	h.schedule(JobCounts, countInterval, h.mergeCounts)
+	if h.outbox != nil {
+		h.schedule(JobOutbox, outboxInterval, h.deliverOutbox)
+	}
+	if h.deadLinks != nil {
+		h.schedule(JobDeadLinks, h.deadLinksInterval, func() error {
+			return h.checkLinks(context.Background(), h.deadLinks)
+		})
+	}
+	return nil
+}
+
 type Options func(*Handler)
 
+// OptionE is an option rejecting invalid arguments with an error, returned by New rather than ignored.
+type OptionE func(*Handler) error
+
+// Checked returns the option applying opt, New fails with the first error of the checked options.
+func Checked(opt OptionE) Options {
+	return func(h *Handler) {
+		if err := opt(h); err != nil && h.optionErr == nil {
+			h.optionErr = err
+		}
+	}
+}
+
 func WithDbPath(path string) Options {
 	return func(h *Handler) {
 		h.path = path
@@ -68,28 +343,99 @@ func WithDefaultLength(length int) Options {
 	}
 }
 
-func WithLogger(logger badger.Logger) Options {
+// WithLogger sets the logger of the handler and of its store, eg: ZapLogger(l) or LevelFilter(l, LevelWarning).
+func WithLogger(logger Logger) Options {
 	return func(h *Handler) {
 		h.logger = logger
 	}
 }
 
-func (h *Handler) init() error {
-	if h.logger == nil {
-		h.logger = NilLogger{}
+// WithDefaultNoIndex asks search engines not to index any link, as the WithNoIndex request option.
+func WithDefaultNoIndex() Options {
+	return func(h *Handler) {
+		h.noIndex = true
 	}
+}
 
-	if h.db == nil {
-		return h.open()
+// WithDeleteMethod makes ServeHTTP delete the link on DELETE requests authorized by the given function.
+func WithDeleteMethod(authorize func(*http.Request) bool) Options {
+	return func(h *Handler) {
+		h.authorizeDelete = authorize
+	}
+}
+
+// WithLazyOpen opens the store at the first use of the handler instead of in New,
+// eg: to create the handler before its store is available. The background jobs start with the store.
+func WithLazyOpen() Options {
+	return func(h *Handler) {
+		h.lazyOpen = true
+	}
+}
+
+// WithSyncWrites sets if every write is synced to disk before it returns, false by default like badger.
+// Without synced writes, the last writes may be lost if the machine crashes, but not if only the process does.
+// Synced writes are durable at the cost of the latency of the writes, eg: every Post and click count.
+func WithSyncWrites(sync bool) Options {
+	return func(h *Handler) {
+		h.syncWrites = sync
+	}
+}
+
+// States of the handler.
+const (
+	stateNew    int32 = iota // created with WithLazyOpen and not used yet, or not created with New
+	stateOpen                // the store is open
+	stateClosed              // closed, or failed to open
+)
+
+// ready starts an operation on the store, which must be ended with release if ready returns no error.
+// It returns ErrClosed if the handler isn't open, and opens the store of handlers created with WithLazyOpen.
+// Operations don't call other operations: a nested ready waits for a pending Close which waits for the operation.
+func (h *Handler) ready() error {
+	if atomic.LoadInt32(&h.state) == stateNew {
+		if !h.lazyOpen {
+			return ErrClosed
+		}
+		if err := h.lazilyOpen(); err != nil {
+			return err
+		}
+	}
+
+	h.inflight.RLock()
+	if atomic.LoadInt32(&h.state) != stateOpen {
+		h.inflight.RUnlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+// release ends an operation started with ready.
+func (h *Handler) release() {
+	h.inflight.RUnlock()
+}
+
+func (h *Handler) lazilyOpen() error {
+	h.lifecycle.Lock()
+	defer h.lifecycle.Unlock()
+	if atomic.LoadInt32(&h.state) != stateNew {
+		return nil
+	}
+	if err := h.open(); err != nil {
+		atomic.StoreInt32(&h.state, stateClosed)
+		return err
 	}
 	return nil
 }
 
 func (h *Handler) getPath() string {
-	if h.path == "" {
-		return DefaultDbPath
+	switch {
+	case h.path != "":
+		return h.path
+	case h.temporary:
+		return h.tempDir
+	default:
+		return defaultDbPath()
 	}
-	return h.path
 }
 
 func (h *Handler) getLength(r req) int {
@@ -97,116 +443,594 @@ func (h *Handler) getLength(r req) int {
 		return r.length
 	}
 	if h.Length > 0 {
-		return h.Length
+		return h.adaptiveLength(h.Length)
 	}
-	return DefaultLength
+	return h.adaptiveLength(DefaultLength)
 }
 
+// open opens the store and starts the background jobs, with the lifecycle lock held or from New.
 func (h *Handler) open() error {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
 	var err error
+	if h.temporary && h.tempDir == "" {
+		if h.tempDir, err = os.MkdirTemp("", "coopurl-"); err != nil {
+			return err
+		}
+	}
+	if h.path == "" && !h.temporary && h.getPath() == LegacyDbPath {
+		h.log(LogStore).Warningf("Opening the store in %s, move it with MoveTo or set its directory with WithDbPath", LegacyDbPath)
+	}
 	opt := badger.DefaultOptions(h.getPath())
-	opt = opt.WithLogger(h.logger)
-	h.db, err = badger.Open(opt)
-	return err
+	opt = opt.WithLogger(h.log(LogStore)).WithSyncWrites(h.syncWrites)
+	if h.fallbackURL != "" {
+		h.primaryURL, h.primaryToken = "", ""
+	}
+	h.db, err = h.openStore(opt)
+	if errors.Is(err, ErrStoreLocked) && h.fallbackURL != "" {
+		h.db, err = h.openFallback(opt)
+	}
+	if err != nil {
+		return err
+	}
+	if err := h.openSequence(); err != nil {
+		h.db.Close()
+		return err
+	}
+	if err := h.loadLinkCount(); err != nil {
+		h.releaseSequence()
+		h.db.Close()
+		return err
+	}
+	if err := h.loadScreening(); err != nil {
+		h.releaseSequence()
+		h.db.Close()
+		return err
+	}
+	if err := h.start(); err != nil {
+		h.stopJobs()
+		h.releaseSequence()
+		h.db.Close()
+		return err
+	}
+	h.openedAt = time.Now()
+	atomic.StoreInt32(&h.state, stateOpen)
+	return nil
 }
 
-// Close stops the database connection.
+// Close stops the background jobs and closes the store, the operations of a closed handler return ErrClosed.
 func (h *Handler) Close() {
-	h.logger.Infof("Closing handler")
-	h.db.Close()
+	h.lifecycle.Lock()
+	defer h.lifecycle.Unlock()
+	if atomic.SwapInt32(&h.state, stateClosed) != stateOpen {
+		return
+	}
+	h.log(LogStore).Infof("Closing handler")
+	h.shutdown()
+	h.removeTemporary()
+}
+
+// Reopen closes the store if it's open and opens it again, eg: to recover from a transient failure of its disk.
+// Closed handlers are opened again as well. Operations return ErrClosed while the store is reopened.
+func (h *Handler) Reopen() error {
+	h.lifecycle.Lock()
+	defer h.lifecycle.Unlock()
+	if atomic.SwapInt32(&h.state, stateClosed) == stateOpen {
+		h.shutdown()
+	}
+	h.log(LogStore).Infof("Reopening handler")
+	return h.open()
+}
+
+// shutdown stops the background jobs, waits for the operations in progress and closes the store.
+// The state is already closed, so no job nor operation starts meanwhile.
+func (h *Handler) shutdown() {
+	h.stopBackground()
+
+	h.inflight.Lock()
+	defer h.inflight.Unlock()
+	h.drain()
+	if err := h.db.Close(); err != nil {
+		h.log(LogStore).Errorf("Couldn't close the store: %s", err)
+	}
+}
+
+// stopBackground stops the background jobs and the goroutines following the store.
+func (h *Handler) stopBackground() {
+	if h.stopReplica != nil {
+		h.stopReplica()
+		h.stopReplica = nil
+	}
+	if h.stopCache != nil {
+		h.stopCache()
+		h.stopCache = nil
+	}
+	if h.stopPurges != nil {
+		h.stopPurges()
+		h.stopPurges = nil
+	}
+	h.stopJobs()
+}
+
+// drain waits for the writes given up by withTimeout and flushes the buffered ones, with the inflight lock held.
+func (h *Handler) drain() {
+	h.detached.Wait()
+	if h.writes != nil {
+		h.flushWrites()
+	}
+	h.releaseSequence()
 }
 
 // ServeHTTP is an http.HandleFunc that will redirect the client to the url linked to the id given in the request url.
-// This id is the last part of request url path. eg: "domain.com/r/{id}"
+// This id is the last part of request url path. eg: "domain.com/r/{id}", a trailing slash being ignored.
+// "domain.com/r/{id}+" serves a page showing the destination instead of redirecting, and "domain.com/r/{id}.json"
+// the stats of the link if enabled with WithPublicStats, "domain.com/r/{id}.png" its thumbnail with WithThumbnails.
+// Only GET and HEAD requests are redirected, DELETE requests delete the link if enabled with WithDeleteMethod().
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if err := h.init(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if err := h.ready(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
 	}
+	defer h.release()
+
+	r = withRequestID(w, r)
+	id, view := parsePath(r.URL.Path)
+	id = h.normalizeID(id)
 
-	// Maybe check only for get methods
-	_, id := path.Split(r.URL.Path)
-	u, err := h.Get(id)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		switch view {
+		case viewInfo:
+			h.serveInfo(w, r, id)
+		case viewStats:
+			h.servePublicStats(w, r, id)
+		case viewThumbnail:
+			h.serveThumbnail(w, r, id)
+		default:
+			h.serveRedirect(w, r, id)
+		}
+	case http.MethodDelete:
+		if h.authorizeDelete != nil && view == viewRedirect {
+			h.serveDelete(w, r, id)
+			return
+		}
+		fallthrough
+	default:
+		w.Header().Set("Allow", h.allowedMethods())
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) allowedMethods() string {
+	if h.authorizeDelete != nil {
+		return "GET, HEAD, DELETE"
+	}
+	return "GET, HEAD"
+}
+
+func (h *Handler) serveRedirect(w http.ResponseWriter, r *http.Request, id string) {
+	rid := RequestIDFrom(r.Context())
+	var e entry
+	var domain, branch string
+	var hit, timedOut bool
+	var err error
+	if len(h.observers) > 0 {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		w = rec
+		defer func() {
+			rd := Redirect{ID: id, Status: rec.status}
+			// the link may still be read in the background
+			if timedOut {
+				h.observeRedirect(r, rd, entry{}, start)
+				return
+			}
+			rd.Domain, rd.CacheHit, rd.Branch = domain, hit, branch
+			h.observeRedirect(r, rd, canaryEntry(e, branch), start)
+		}()
+	}
+
+	// with the circuit open, the link comes from the cache and its click isn't counted
+	allowed, retry := h.breaker.allow(time.Now())
+	if allowed {
+		err = h.withTimeout(func() error {
+			var err error
+			e, domain, err = h.resolve(requestDomain(r), id, &hit)
+			return err
+		})
+		opened, closed := h.breaker.record(err, time.Now())
+		if opened {
+			h.log(LogStore).Errorf("Store failing, open the circuit for %s: %s", h.breaker.cooldown, err)
+		} else if closed {
+			h.log(LogStore).Infof("Store back, close the circuit")
+		}
+	} else {
+		if e, domain, hit = h.resolveCached(requestDomain(r), id); !hit {
+			serveUnavailable(w, r, retry)
+			return
+		}
+	}
+	if errors.Is(err, ErrNotFound) {
+		if expiredAt, ok := h.expiredAt(requestDomain(r), id); ok {
+			serveExpired(w, r, expiredAt)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrTimeout) {
+		timedOut = true
+		h.log(LogRedirects).Warningf("Timeout resolving %s (request %s)", id, rid)
+		serveUnavailable(w, r, h.storeTimeout)
+		return
+	}
 	if err != nil {
-		// TODO: differentiate between not found and other errors
-		w.WriteHeader(http.StatusInternalServerError)
+		h.log(LogRedirects).Errorf("Couldn't resolve %s (request %s): %s", id, rid, err)
+		serveError(w, r, http.StatusInternalServerError)
+		return
+	}
+	if hidden(r, e) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	branch = canaryBranch(r, linkRef{domain: domain, id: id}, e)
+	u := expand(r, canaryEntry(e, branch))
+	if e.Template || len(e.Languages) > 0 {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+
+	// public links are meant to be indexed, unless they ask not to be themselves
+	if e.NoIndex || h.noIndex && !e.Public {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+
+	if e.Disabled {
+		h.serveDisabled(w, r)
+		return
+	}
+	if e.Draft {
+		// the preview token mustn't be cached nor indexed
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Header().Set("X-Robots-Tag", "noindex")
+	} else {
+		h.cdn.writeCacheHeaders(w, linkRef{domain: domain, id: id}, e, time.Now())
+	}
+	if branch != "" {
+		// the branch depends on the visitor
+		w.Header().Set("Cache-Control", "private")
+	}
+	writeHeaders(w, e)
+
+	if e.inGrace(time.Now()) {
+		h.writeGraceHeaders(w, e)
+		if h.writable() == nil {
+			h.notifyGrace(domain, id, e, rid)
+		}
+	}
+
+	if h.sampleRedirectLog() {
+		h.log(LogRedirects).Infof("Redirect from %s to %s (request %s)", id, u, rid)
+	}
+	if h.anomalies != nil {
+		h.anomalies.observe(linkRef{domain: domain, id: id}, r, time.Now())
+	}
+
+	// the visitor cookie is set before the response is written
+	var visitor string
+	if r.Method == http.MethodGet && allowed && h.writable() == nil && h.botKind(r) == NotBot {
+		visitor = h.visitor(w, r, time.Now())
+	}
+
+	if e.Page != nil {
+		if !h.servePage(w, r, linkRef{domain: domain, id: id}, e) {
+			return
+		}
+	} else if h.unfurls(r, id, domain, e) {
+		if err := h.serveUnfurl(w, r, e); err != nil {
+			h.log(LogRedirects).Warningf("Couldn't serve the preview of %s (request %s): %s", id, rid, err)
+		}
+	} else if err := h.redirect(w, r, u, h.redirectStatusOf(e)); err != nil {
+		h.log(LogRedirects).Errorf("Couldn't redirect to %s (request %s)", u, rid)
+		serveError(w, r, http.StatusInternalServerError)
 		return
 	}
 
-	h.logger.Infof("Redirect from %s to %s", id, u)
+	// HEAD requests come from link checkers and previews, not from people following the link.
+	if r.Method == http.MethodHead || e.Draft {
+		return
+	}
+	h.publishRedirect(r, linkRef{domain: domain, id: id}, time.Now())
+	if !allowed || h.writable() != nil {
+		return
+	}
+	err = h.withTimeout(func() error {
+		return h.recordClick(r, linkRef{domain: domain, id: id}, visitor, branch)
+	})
+	if err != nil {
+		h.log(LogRedirects).Warningf("Couldn't count click on %s (request %s): %s", id, rid, err)
+	}
+}
 
-	if err := redirect(w, r, u); err != nil {
-		h.logger.Errorf("Couldn't redirect to %s", u)
-		w.WriteHeader(http.StatusInternalServerError)
+func (h *Handler) serveDelete(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.authorizeDelete(r) {
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
+
+	err := h.delete(id, WithDomain(requestDomain(r)))
+	if errors.Is(err, ErrNotFound) {
+		err = h.delete(id)
+	}
+	if errors.Is(err, ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.log(LogStore).Errorf("Couldn't delete %s (request %s): %s", id, RequestIDFrom(r.Context()), err)
+		serveError(w, r, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveError answers with the status and the request id, so the error can be found in the logs.
+func serveError(w http.ResponseWriter, r *http.Request, status int) {
+	lang := requestLanguage(r)
+	w.Header().Add("Vary", "Accept-Language")
+	http.Error(w, fmt.Sprintf(translate(lang, "%s (request %s)"), translate(lang, http.StatusText(status)), RequestIDFrom(r.Context())), status)
 }
 
-func redirect(w http.ResponseWriter, r *http.Request, s string) error {
+// redirect redirects the request to s, as rewritten by the redirect rewriter.
+func (h *Handler) redirect(w http.ResponseWriter, r *http.Request, s string, status int) error {
+	if h.redirectRewriter != nil {
+		s = h.redirectRewriter(s, r)
+	}
 	u, err := url.Parse(s)
 	if err != nil {
 		return err
 	}
-	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	http.Redirect(w, r, u.String(), status)
 	return nil
 }
 
 // Get search the store for the url linked to the given id.
-func (h *Handler) Get(id string) (string, error) {
-	if err := h.init(); err != nil {
+// Links of a domain or namespace are found using WithDomain() or WithNamespace().
+func (h *Handler) Get(id string, opts ...ReqOptions) (string, error) {
+	if err := h.ready(); err != nil {
 		return "", err // Maybe wrap err with custom error
 	}
-	return h.get(id)
+	defer h.release()
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	e, err := h.get(r, id)
+	if err != nil {
+		return "", err
+	}
+	return e.URL, nil
+}
+
+func (h *Handler) get(r req, id string) (entry, error) {
+	id = h.normalizeID(id)
+	if !validID(id) || !h.verify(id) {
+		return entry{}, ErrNotFound
+	}
+
+	// links of a namespace are cached once the domain of the namespace is resolved
+	cached := h.cache != nil && (r.namespace == "" || r.domain != "")
+	if cached {
+		e, ok := h.cache.get(linkKey(r.domain, id), time.Now())
+		if !ok && h.cache.staleWindow > 0 {
+			var refresh bool
+			if e, refresh, ok = h.cache.getStale(linkKey(r.domain, id), time.Now(), true); refresh {
+				h.refresh(r, id)
+			}
+		}
+		if ok {
+			if r.cacheHit != nil {
+				*r.cacheHit = true
+			}
+			return e, nil
+		}
+	}
+	e, err := h.load(r, id, cached)
+	if err != nil {
+		return entry{}, err
+	}
+
+	h.log(LogRedirects).Debugf("Get entry: %s - %s", id, e.URL)
+
+	return e, nil
 }
 
-func (h *Handler) get(id string) (string, error) {
+// refresh reads the stale cached link id again in the background.
+func (h *Handler) refresh(r req, id string) {
+	r.cacheHit = nil
+	h.detached.Add(1)
+	go func() {
+		defer h.detached.Done()
+		if _, err := h.load(r, id, true); err != nil && !errors.Is(err, ErrNotFound) {
+			h.log(LogStore).Warningf("Couldn't refresh %s: %s", id, err)
+		}
+	}()
+}
 
-	var url string
+// load reads the link id from the store, and caches it if cached.
+func (h *Handler) load(r req, id string, cached bool) (entry, error) {
+	var load *cacheLoad
+	if cached {
+		load = h.cache.load(linkKey(r.domain, id))
+	}
+
+	var e entry
+	var expiresAt uint64
 	err := h.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(id))
-		if err != nil {
+		if err := resolveDomain(txn, &r); err != nil {
 			return err
 		}
 
+		item, err := txn.Get(linkKey(r.domain, id))
+		if err != nil {
+			return err
+		}
+		expiresAt = item.ExpiresAt()
 		b, err := item.ValueCopy(nil)
 		if err != nil {
 			return err
 		}
 
-		url = string(b)
-		return nil
+		e, err = decodeEntry(b)
+		return err
 	})
+	if load != nil {
+		h.cache.loaded(linkKey(r.domain, id), load, e, expiresAt, err == nil)
+	}
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return entry{}, ErrNotFound
+	}
+	return e, err
+}
+
+// resolve finds the link served at the given id on the given domain.
+// Links of the domain key space take precedence over links without domain, which are served on every domain.
+// It returns the domain of the key space the link was found in, and sets hit if not nil when the link is
+// read from the cache.
+func (h *Handler) resolve(domain, id string, hit *bool) (entry, string, error) {
+	if domain != "" {
+		e, err := h.get(req{domain: domain, cacheHit: hit}, id)
+		if !errors.Is(err, ErrNotFound) {
+			return e, domain, err
+		}
+	}
+
+	e, err := h.get(req{cacheHit: hit}, id)
+	return e, "", err
+}
+
+// Delete removes the link with the given id.
+// With WithSoftDelete(), the link is kept until purged and can be restored.
+func (h *Handler) Delete(id string, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	return h.delete(id, opts...)
+}
+
+func (h *Handler) delete(id string, opts ...ReqOptions) error {
+	if err := h.writable(); err != nil {
+		return err
+	}
+	id = h.normalizeID(id)
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err := h.db.Update(func(txn *badger.Txn) error {
+		return h.remove(txn, &r, id)
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	h.logger.Infof("Get entry: %s - %s", id, url)
+	h.sampler.deleted(1)
+	h.countLinks(-1)
+	h.log(LogStore).Infof("Delete entry: %s", id)
+	return nil
+}
 
-	return url, nil
+// remove deletes the link at id in txn along with its indexes, or buries it with WithSoftDelete().
+func (h *Handler) remove(txn *badger.Txn, r *req, id string) error {
+	if err := resolveDomain(txn, r); err != nil {
+		return err
+	}
+
+	l := linkRef{domain: r.domain, id: id}
+	b, err := getValue(txn, l.key())
+	if err != nil {
+		return err
+	}
+	e, err := decodeEntry(b)
+	if err != nil {
+		return err
+	}
+	if err := h.deleteIndexes(txn, l, e); err != nil {
+		return err
+	}
+	if h.expiredRetention > 0 {
+		if err := txn.Delete(expiredKey(l)); err != nil {
+			return err
+		}
+	}
+
+	// a link deleted before its expiry isn't archived
+	if err := txn.Delete(pendingArchiveKey(l.domain, l.id)); err != nil {
+		return err
+	}
+
+	if h.retention > 0 {
+		return bury(txn, l)
+	}
+
+	// the history and stats are dropped with the link, so that a link posted again at its id starts anew
+	keys, err := historyKeys(txn, l)
+	if err != nil {
+		return err
+	}
+	stats, err := statsKeys(txn, l)
+	if err != nil {
+		return err
+	}
+	for _, k := range append(keys, stats...) {
+		if err := txn.Delete(k); err != nil {
+			return err
+		}
+	}
+	return txn.Delete(l.key())
+}
+
+func validID(id string) bool {
+	return id != "" && !strings.HasPrefix(id, metaPrefix)
 }
 
 // Post will take a url, store it and return an id linked to it.
 func (h *Handler) Post(url string, opts ...ReqOptions) (string, error) {
-	if err := h.init(); err != nil {
+	if err := h.ready(); err != nil {
 		return "", err // Maybe wrap err with custom error
 	}
-	return h.post(url)
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return "", err
+	}
+	return h.post(url, opts...)
 }
 
-func (h *Handler) post(s string, opts ...ReqOptions) (string, error) {
-
-	// Check that the given url is valid
-	u, err := url.Parse(s)
-	if err != nil {
+// Validate runs all the checks of Post without storing the link, and returns the id it would get.
+// Generated ids may be taken by the time the link is posted, and in IDSequential mode the returned id is used up.
+func (h *Handler) Validate(url string, opts ...ReqOptions) (string, error) {
+	if err := h.ready(); err != nil {
+		return "", err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
 		return "", err
 	}
+	return h.post(url, append(opts, func(r *req) { r.dryRun = true })...)
+}
 
-	// We set the scheme to http if it's missing to redirect to google.fr and not domain/r/google.fr
-	if u.Scheme == "" {
-		h.logger.Infof("Setting missing scheme to http: %s", u.String())
-		u.Scheme = "http"
+func (h *Handler) post(s string, opts ...ReqOptions) (string, error) {
+	u, broken, err := h.destination(s)
+	if err != nil {
+		return "", err
 	}
 
 	r := req{}
@@ -214,34 +1038,271 @@ func (h *Handler) post(s string, opts ...ReqOptions) (string, error) {
 		opt(&r)
 	}
 
-	// Generate Id
-	id := generateId(u.String(), h.getLength(r))
-	ttl := r.ttl
-	if ttl == 0 {
-		ttl = h.TTL
+	languages, reason, err := h.languageURLs(r)
+	if err != nil {
+		return "", err
+	}
+	if err := checkHeaders(r.headers); err != nil {
+		return "", err
+	}
+	if broken == "" {
+		broken = reason
 	}
 
-	// Put in db
-	err = h.db.Update(func(txn *badger.Txn) error {
-		if ttl != 0 {
-			e := badger.NewEntry([]byte(id), []byte(u.String())).WithTTL(ttl)
-			return txn.SetEntry(e)
+	if r.idempotencyKey != "" && !r.dryRun {
+		if id, err := h.idempotentReplay(&r, u.String()); err != nil || id != "" {
+			return id, err
 		}
-		return txn.Set([]byte(id), []byte(u.String()))
-	})
+	}
+
+	linkTTL := r.ttl
+	if linkTTL == 0 {
+		linkTTL = h.TTL
+	}
+
+	e := entry{
+		URL:       u.String(),
+		Languages: languages,
+		Headers:   r.headers,
+		Tags:      r.tags,
+		Owner:     r.owner,
+		Broken:    broken,
+		NoIndex:   r.noIndex,
+		Public:    r.public,
+		Template:  r.template,
+		CreatedAt: time.Now(),
+	}
+	if err := r.setDraft(&e); err != nil {
+		return "", err
+	}
+
+	id, ttl, err := h.create(u.String(), e, r, linkTTL)
+	if r.idempotencyKey != "" && (errors.Is(err, errIdempotentRace) || errors.Is(err, badger.ErrConflict)) {
+		// another call with the key created its link first
+		return h.idempotentReplay(&r, u.String())
+	}
 	if err != nil {
 		return "", err
 	}
+	if r.dryRun {
+		return id, nil
+	}
 
+	h.sampler.wrote(1)
+	h.countLinks(1)
+	h.refreshPreview(id, r, u.String())
 	if ttl != 0 {
-		h.logger.Infof("New entry: %s - %s (ttl: %s)", id, u.String(), ttl)
+		h.log(LogStore).Infof("New entry: %s - %s (ttl: %s)", id, u.String(), ttl)
 	} else {
-		h.logger.Infof("New entry: %s - %s", id, u.String())
+		h.log(LogStore).Infof("New entry: %s - %s", id, u.String())
 	}
 
 	return id, err
 }
 
+// create stores the new link e at a new id generated from seed, and returns it with the ttl of the link.
+func (h *Handler) create(seed string, e entry, r req, linkTTL time.Duration) (string, time.Duration, error) {
+	if err := h.checkMetadata(e); err != nil {
+		return "", 0, err
+	}
+	if !r.dryRun {
+		if err := h.limitCreation(r.clientIP); err != nil {
+			return "", 0, err
+		}
+	}
+
+	if err := h.namespaceLength(&r); err != nil {
+		return "", 0, err
+	}
+
+	// Generate Id, generated ids are generated again when they are already used
+	var id string
+	var ttl time.Duration
+	var err error
+	for attempt := 1; ; attempt++ {
+		if id, err = h.newID(seed, r); err != nil {
+			return "", 0, err
+		}
+		ttl = linkTTL
+		err = h.put(id, e, r, &ttl)
+		if errors.Is(err, ErrExists) && r.alias == "" && attempt < maxGenerateAttempts {
+			h.log(LogStore).Debugf("Generated id %s already used", id)
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return id, ttl, nil
+}
+
+// put stores a new link, ttl is updated with the ttl of the link as bounded by its campaign.
+// Nothing is written for dry runs, only the checks are done.
+func (h *Handler) put(id string, e entry, r req, ttl *time.Duration) error {
+	if h.writes.buffers(e, r) {
+		return h.putAsync(id, e, r, ttl)
+	}
+	run := h.db.Update
+	if r.dryRun {
+		run = h.db.View
+	}
+	return run(func(txn *badger.Txn) error {
+		return h.store(txn, id, e, &r, ttl)
+	})
+}
+
+// store stores the new link e at id in txn, along with its indexes.
+func (h *Handler) store(txn *badger.Txn, id string, e entry, r *req, ttl *time.Duration) error {
+	if err := h.prepare(txn, id, &e, r, ttl); err != nil {
+		return err
+	}
+	if r.dryRun {
+		return nil
+	}
+
+	// the history of a former link at id, eg: expired, isn't the one of the new link
+	revs, err := historyKeys(txn, linkRef{domain: r.domain, id: id})
+	if err != nil {
+		return err
+	}
+	for _, k := range revs {
+		if err := txn.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	if r.idempotencyKey != "" {
+		if err := h.storeIdempotency(txn, r, id, e.URL); err != nil {
+			return err
+		}
+	}
+	if e.Campaign != "" {
+		if err := txn.Set(campaignLinkKey(e.Campaign, id), []byte(r.domain)); err != nil {
+			return err
+		}
+	}
+
+	// The stored ttl is extended by the grace period, the link expiry is kept in the entry.
+	storedTTL := *ttl
+	if *ttl != 0 {
+		storedTTL += h.grace
+	}
+
+	b, err := h.encodeEntry(e)
+	if err != nil {
+		return err
+	}
+	if err := setValue(txn, linkKey(r.domain, id), b, storedTTL); err != nil {
+		return err
+	}
+	if err := h.setExpired(txn, linkRef{domain: r.domain, id: id}, e.ExpiresAt, storedTTL); err != nil {
+		return err
+	}
+
+	var expiresAt uint64
+	if storedTTL != 0 {
+		expiresAt = uint64(e.CreatedAt.Add(storedTTL).Unix())
+	}
+	if err := h.setIndexes(txn, linkRef{domain: r.domain, id: id}, e, expiresAt); err != nil {
+		return err
+	}
+	return countCreation(txn, e)
+}
+
+// prepare checks that a new link can be stored at id and completes its entry with its domain, campaign and expiry.
+func (h *Handler) prepare(txn *badger.Txn, id string, e *entry, r *req, ttl *time.Duration) error {
+	if err := resolveDomain(txn, r); err != nil {
+		return err
+	}
+	if _, err := txn.Get(linkKey(r.domain, id)); err == nil {
+		return ErrExists
+	} else if !errors.Is(err, badger.ErrKeyNotFound) {
+		return err
+	}
+	e.Domain = r.domain
+	e.Namespace = r.namespace
+	if n, err := r.getNamespace(txn); err != nil {
+		return err
+	} else if n != nil {
+		if err := n.applyDefaults(e, r, ttl); err != nil {
+			return err
+		}
+	}
+
+	if r.campaign != "" {
+		c, err := getCampaign(txn, r.campaign)
+		if err != nil {
+			return err
+		}
+		if *ttl, err = applyCampaign(c, e, *ttl); err != nil {
+			return err
+		}
+	}
+
+	if *ttl != 0 {
+		e.ExpiresAt = e.CreatedAt.Add(*ttl)
+	}
+	return h.checkQuotas(txn, *e)
+}
+
+// destination validates and normalizes the destination of a link.
+// It returns why the destination is broken if it is flagged instead of rejected.
+func (h *Handler) destination(s string) (*url.URL, string, error) {
+	if len(s) > h.getMaxURLLength() {
+		return nil, "", ErrURLTooLong
+	}
+
+	// Check that the given url is valid
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// We set the scheme to http if it's missing to redirect to google.fr and not domain/r/google.fr
+	if u.Scheme == "" {
+		h.log(LogStore).Infof("Setting missing scheme to http: %s", u.String())
+		u.Scheme = "http"
+	}
+
+	// Destinations follow the rewrite rules before being checked
+	if err := h.rewrite(u); err != nil {
+		return nil, "", err
+	}
+
+	if !allowedScheme(h.schemes, u.String()) {
+		return nil, "", ErrSchemeNotAllowed
+	}
+
+	// Internationalized domains are stored in punycode
+	if err := h.toASCII(u); err != nil {
+		return nil, "", err
+	}
+
+	// The url is stored as a string, which must parse back to be redirected to, eg: not "http://host%20"
+	if u, err = url.Parse(u.String()); err != nil {
+		return nil, "", err
+	}
+
+	// Links to the shortener itself could redirect in loops
+	if u, err = h.resolveChain(u); err != nil {
+		return nil, "", err
+	}
+
+	var broken string
+	if h.validator != nil {
+		if err := h.validator.check(context.Background(), u.String()); err != nil {
+			if !h.validator.flagOnly {
+				return nil, "", err
+			}
+			h.log(LogStore).Warningf("Flagging broken destination: %s", err)
+			broken = err.Error()
+		}
+	}
+
+	return u, broken, nil
+}
+
 type ReqOptions func(*req)
 
 func WithTTL(ttl time.Duration) ReqOptions {
@@ -256,19 +1317,176 @@ func WithLength(length int) ReqOptions {
 	}
 }
 
+// WithCampaign adds the link to an existing campaign, sharing its tags and expiry.
+func WithCampaign(name string) ReqOptions {
+	return func(r *req) {
+		r.campaign = name
+	}
+}
+
+func WithTags(tags ...string) ReqOptions {
+	return func(r *req) {
+		r.tags = append(r.tags, tags...)
+	}
+}
+
+// WithDomain stores or looks up the link in the key space of the given domain.
+// Links with a domain are only redirected when the request Host matches it.
+func WithDomain(domain string) ReqOptions {
+	return func(r *req) {
+		r.domain = normalizeDomain(domain)
+	}
+}
+
+// WithNamespace stores or looks up the link in an existing namespace, and its domain.
+func WithNamespace(name string) ReqOptions {
+	return func(r *req) {
+		r.namespace = name
+	}
+}
+
+// WithNoIndex asks search engines not to index the link,
+// by adding a "X-Robots-Tag: noindex" header to its redirect and interstitial pages.
+func WithNoIndex() ReqOptions {
+	return func(r *req) {
+		r.noIndex = true
+	}
+}
+
+// WithOwner attributes the link to the given owner.
+func WithOwner(owner string) ReqOptions {
+	return func(r *req) {
+		r.owner = owner
+	}
+}
+
 type req struct {
-	ttl    time.Duration
-	length int
+	ttl       time.Duration
+	length    int
+	campaign  string
+	tags      []string
+	domain    string
+	namespace string
+	ns        *Namespace // namespace read from the store, see getNamespace
+	owner     string
+	actor     string
+	noIndex   bool
+	public    bool
+	alias     string
+	dryRun    bool
+	template  bool
+	languages map[string]string
+	headers   map[string]string
+	requestID string
+	cacheHit  *bool // set when the link is read from the cache
+
+	idempotencyKey string
+	ifVersion      int // set with IfVersion
+	clientIP       string
+	draft          bool
+}
+
+// RequestOptions are the values set by request options, for implementations of the interfaces such as
+// the fakes of the coopurltest package.
+type RequestOptions struct {
+	TTL       time.Duration
+	Length    int
+	Campaign  string
+	Tags      []string
+	Domain    string
+	Namespace string
+	Owner     string
+	Actor     string
+	NoIndex   bool
+	Public    bool
+	Template  bool
+	Languages map[string]string
+	Headers   map[string]string
+	Alias     string
+	RequestID string
+
+	IdempotencyKey string
+	IfVersion      int
+	ClientIP       string
+	Draft          bool
+}
+
+// ApplyReqOptions returns the values set by the given request options.
+func ApplyReqOptions(opts ...ReqOptions) RequestOptions {
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return RequestOptions{
+		TTL:       r.ttl,
+		Length:    r.length,
+		Campaign:  r.campaign,
+		Tags:      r.tags,
+		Domain:    r.domain,
+		Namespace: r.namespace,
+		Owner:     r.owner,
+		Actor:     r.actor,
+		NoIndex:   r.noIndex,
+		Public:    r.public,
+		Template:  r.template,
+		Languages: r.languages,
+		Headers:   r.headers,
+		Alias:     r.alias,
+		RequestID: r.requestID,
+
+		IdempotencyKey: r.idempotencyKey,
+		IfVersion:      r.ifVersion,
+		ClientIP:       r.clientIP,
+		Draft:          r.draft,
+	}
+}
+
+// maxGenerateAttempts bounds the generation of an id that isn't reserved.
+const maxGenerateAttempts = 10
+
+// newID returns the alias of the request, or generates an id that isn't reserved.
+func (h *Handler) newID(url string, r req) (string, error) {
+	if r.alias != "" {
+		// a signature would change the alias
+		if h.signSecret != nil {
+			return "", ErrInvalidAlias
+		}
+		return h.normalizeID(r.alias), h.checkAlias(r.alias)
+	}
+
+	for i := 0; i < maxGenerateAttempts; i++ {
+		id, err := h.generate(url, h.getLength(r))
+		if err != nil {
+			return "", err
+		}
+		id = h.normalizeID(id)
+		if !h.reserved(id) {
+			return h.normalizeID(h.sign(id)), nil
+		}
+	}
+	return "", ErrReserved
 }
 
-// generateId generates an id from url of size n
-func generateId(url string, n int) string {
-	s := fmt.Sprintf("%s-%s", url, time.Now())
-	sha := fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+// generateId generates an id from url of size n, made of the characters of alphabet or hexadecimal if empty.
+// The url is salted with random bytes then hashed with hash, SHA-256 if nil.
+func generateId(url string, n int, alphabet string, hash func([]byte) []byte) (string, error) {
+	if hash == nil {
+		hash = sha256Sum
+	}
+	b, err := salted(url)
+	if err != nil {
+		return "", err
+	}
+	sum := hash(b)
+	if alphabet != "" {
+		return encodeAlphabet(sum, alphabet, n), nil
+	}
+
+	sha := hex.EncodeToString(sum)
 	if n >= len(sha) {
-		return sha
+		return sha, nil
 	}
-	return sha[:n]
+	return sha[:n], nil
 }
 
 type Logger badger.Logger
@@ -0,0 +1,140 @@
+package coopurltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var update = flag.Bool("coopurltest.update", false, "update the golden files of coopurltest.AssertGolden")
+
+// Do serves a request for target with the given method and returns the recorded response.
+func Do(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+// AssertRedirect checks that a GET of target is redirected to location.
+func AssertRedirect(t testing.TB, h http.Handler, target, location string) {
+	t.Helper()
+	w := Do(h, http.MethodGet, target)
+	if w.Code < 300 || w.Code > 399 {
+		t.Fatalf("GET %s: status %d, expected a redirect to %s", target, w.Code, location)
+	}
+	if got := w.Header().Get("Location"); got != location {
+		t.Fatalf("GET %s: redirected to %q, expected %q", target, got, location)
+	}
+}
+
+// AssertStatus checks that a GET of target is answered with the given status.
+func AssertStatus(t testing.TB, h http.Handler, target string, status int) {
+	t.Helper()
+	if w := Do(h, http.MethodGet, target); w.Code != status {
+		t.Fatalf("GET %s: status %d, expected %d", target, w.Code, status)
+	}
+}
+
+// Redirect is a redirect recorded by a Recorder.
+type Redirect struct {
+	Method   string
+	Path     string
+	Status   int
+	Location string
+}
+
+// Recorder records the redirects served by a handler, eg: a Handler or a Store mounted in the router
+// of the application under test.
+type Recorder struct {
+	Handler http.Handler
+
+	mu        sync.Mutex
+	redirects []Redirect
+}
+
+// NewRecorder returns a recorder of the redirects of h.
+func NewRecorder(h http.Handler) *Recorder {
+	return &Recorder{Handler: h}
+}
+
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	rec.Handler.ServeHTTP(sw, r)
+	if sw.status < 300 || sw.status > 399 {
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.redirects = append(rec.redirects, Redirect{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Status:   sw.status,
+		Location: w.Header().Get("Location"),
+	})
+}
+
+// Redirects returns the redirects recorded so far, oldest first.
+func (rec *Recorder) Redirects() []Redirect {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]Redirect(nil), rec.redirects...)
+}
+
+// Reset forgets the recorded redirects.
+func (rec *Recorder) Reset() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.redirects = nil
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// AssertGolden compares got with the golden file testdata/name.golden.
+// Golden files are written instead when the tests run with -coopurltest.update.
+func AssertGolden(t testing.TB, name string, got []byte) {
+	t.Helper()
+	file := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("%s (run the tests with -coopurltest.update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s differs from the golden file:\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// AssertGoldenJSON compares the indented json encoding of v with the golden file testdata/name.golden,
+// eg: the entries or stats returned by a Store.
+func AssertGoldenJSON(t testing.TB, name string, v interface{}) {
+	t.Helper()
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertGolden(t, name, append(b, '\n'))
+}
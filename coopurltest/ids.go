@@ -0,0 +1,63 @@
+package coopurltest
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// IDGenerator returns the id of each new link, it is called with the store locked.
+type IDGenerator func() string
+
+// SequentialIDs generates the ids prefix+"1", prefix+"2"..., so that tests can expect them.
+func SequentialIDs(prefix string) IDGenerator {
+	var n int
+	return func() string {
+		n++
+		return prefix + strconv.Itoa(n)
+	}
+}
+
+// FixedIDs generates the given ids in order, then panics, eg: to test collisions with taken ids.
+func FixedIDs(ids ...string) IDGenerator {
+	var n int
+	return func() string {
+		if n == len(ids) {
+			panic("coopurltest: no more fixed ids")
+		}
+		n++
+		return ids[n-1]
+	}
+}
+
+// Clock is a manual clock for WithClock, its time only changes with Set and Advance.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock set to now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set sets the time of the clock.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
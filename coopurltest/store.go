@@ -0,0 +1,607 @@
+// Package coopurltest provides fakes and helpers for testing applications embedding coopurl
+// without opening a badger store.
+package coopurltest
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// Store is an in-memory fake of the Shortener and RedirectHandler of coopurl.
+// It keeps the semantics applications rely on: ids, aliases, domains and namespaces, ttls, history, clicks
+// and the error values, but doesn't check destinations nor fetch previews. It is safe for concurrent use.
+type Store struct {
+	mu    sync.Mutex
+	ids   IDGenerator
+	now   func() time.Time
+	links map[linkRef]*link
+
+	posts map[[2]string]idempotentPost // by owner and idempotency key
+}
+
+// idempotentPost is a link created with an idempotency key.
+type idempotentPost struct {
+	id, url string
+	until   time.Time
+}
+
+var (
+	_ coopurl.Shortener       = (*Store)(nil)
+	_ coopurl.RedirectHandler = (*Store)(nil)
+)
+
+type StoreOptions func(*Store)
+
+// WithIDs generates the ids of the links with gen, SequentialIDs("") by default.
+func WithIDs(gen IDGenerator) StoreOptions {
+	return func(s *Store) {
+		s.ids = gen
+	}
+}
+
+// WithClock makes the store use now as the current time, eg: a Clock to expire links without waiting.
+func WithClock(now func() time.Time) StoreOptions {
+	return func(s *Store) {
+		s.now = now
+	}
+}
+
+// NewStore returns an empty store.
+func NewStore(opts ...StoreOptions) *Store {
+	s := &Store{ids: SequentialIDs(""), now: time.Now, links: map[linkRef]*link{}, posts: map[[2]string]idempotentPost{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// linkRef identifies a link, namespaces are kept apart from the domains.
+type linkRef struct {
+	domain    string
+	namespace string
+	id        string
+}
+
+func ref(id string, r coopurl.RequestOptions) linkRef {
+	return linkRef{domain: strings.ToLower(r.Domain), namespace: r.Namespace, id: id}
+}
+
+type link struct {
+	entry   coopurl.Entry
+	history []coopurl.Revision
+	events  []coopurl.ClickEvent
+	reports int
+	buttons map[string]uint64
+}
+
+// get returns the link, nil if it doesn't exist or expired.
+func (s *Store) get(l linkRef) *link {
+	k, ok := s.links[l]
+	if !ok {
+		return nil
+	}
+	if !k.entry.ExpiresAt.IsZero() && !s.now().Before(k.entry.ExpiresAt) {
+		delete(s.links, l)
+		return nil
+	}
+	return k
+}
+
+// export returns the entry of the link with its current ttl.
+func (s *Store) export(k *link) coopurl.Entry {
+	e := k.entry
+	if !e.ExpiresAt.IsZero() {
+		e.TTL = e.ExpiresAt.Sub(s.now())
+	}
+	return e
+}
+
+func destination(u string) (string, error) {
+	if len(u) > coopurl.DefaultMaxURLLength {
+		return "", coopurl.ErrURLTooLong
+	}
+	d, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	if d.Scheme == "" {
+		d.Scheme = "http"
+	}
+	return d.String(), nil
+}
+
+func validID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/?#\x00")
+}
+
+// Post stores the link and returns its id, honoring WithAlias, WithTTL, WithDomain, WithNamespace and the
+// attributes set by WithTags, WithOwner, WithCampaign, WithNoIndex and WithPublic.
+func (s *Store) Post(u string, opts ...coopurl.ReqOptions) (string, error) {
+	dest, err := destination(u)
+	if err != nil {
+		return "", err
+	}
+	return s.create(coopurl.Entry{URL: dest}, opts)
+}
+
+// PostPage stores a page and returns its id.
+func (s *Store) PostPage(p coopurl.Page, opts ...coopurl.ReqOptions) (string, error) {
+	if err := checkPage(p); err != nil {
+		return "", err
+	}
+	return s.create(coopurl.Entry{Page: &p}, opts)
+}
+
+// PostBatch posts the links one by one, and returns the result of each link in the same order.
+func (s *Store) PostBatch(links []coopurl.BatchLink, opts ...coopurl.ReqOptions) ([]coopurl.BatchResult, error) {
+	results := make([]coopurl.BatchResult, len(links))
+	for i, l := range links {
+		o := append([]coopurl.ReqOptions{}, opts...)
+		if l.Alias != "" {
+			o = append(o, coopurl.WithAlias(l.Alias))
+		}
+		if l.TTL != 0 {
+			o = append(o, coopurl.WithTTL(l.TTL))
+		}
+		if l.Draft {
+			o = append(o, coopurl.WithDraft())
+		}
+		results[i].ID, results[i].Err = s.Post(l.URL, o...)
+	}
+	return results, nil
+}
+
+// Clone stores a copy of the link, with its destination, tags, owner, campaign and flags, under a new id.
+func (s *Store) Clone(id string, opts ...coopurl.ReqOptions) (string, error) {
+	e, err := s.GetEntry(id, opts...)
+	if err != nil {
+		return "", err
+	}
+	o := []coopurl.ReqOptions{coopurl.WithDomain(e.Domain), coopurl.WithOwner(e.Owner), coopurl.WithTags(e.Tags...)}
+	if e.Namespace != "" {
+		o = append(o, coopurl.WithNamespace(e.Namespace))
+	}
+	if e.Campaign != "" {
+		o = append(o, coopurl.WithCampaign(e.Campaign))
+	}
+	if e.NoIndex {
+		o = append(o, coopurl.WithNoIndex())
+	}
+	if e.Public {
+		o = append(o, coopurl.WithPublic())
+	}
+	return s.create(coopurl.Entry{URL: e.URL, Languages: e.Languages, Headers: e.Headers, Template: e.Template, Page: e.Page}, append(o, opts...))
+}
+
+func (s *Store) create(e coopurl.Entry, opts []coopurl.ReqOptions) (string, error) {
+	r := coopurl.ApplyReqOptions(opts...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]string{r.Owner, r.IdempotencyKey}
+	if p, ok := s.posts[key]; ok && r.IdempotencyKey != "" && s.now().Before(p.until) {
+		if p.url != e.URL {
+			return "", coopurl.ErrIdempotencyKeyReused
+		}
+		return p.id, nil
+	}
+
+	id := r.Alias
+	if id != "" && !validID(id) {
+		return "", coopurl.ErrInvalidAlias
+	}
+	if id == "" {
+		id = s.ids()
+	}
+	l := ref(id, r)
+	if s.get(l) != nil {
+		return "", coopurl.ErrExists
+	}
+
+	e.ID = id
+	e.Tags = r.Tags
+	e.Campaign = r.Campaign
+	e.Domain = l.domain
+	e.Namespace = r.Namespace
+	e.Owner = r.Owner
+	e.NoIndex = r.NoIndex
+	e.Public = r.Public
+	e.CreatedAt = s.now()
+	e.Version = 1
+	if r.TTL > 0 {
+		e.ExpiresAt = e.CreatedAt.Add(r.TTL)
+	}
+	s.links[l] = &link{entry: e, buttons: map[string]uint64{}}
+	if r.IdempotencyKey != "" {
+		s.posts[key] = idempotentPost{id: id, url: e.URL, until: e.CreatedAt.Add(coopurl.DefaultIdempotencyWindow)}
+	}
+	return id, nil
+}
+
+func checkPage(p coopurl.Page) error {
+	if p.Title == "" || len(p.Buttons) == 0 {
+		return coopurl.ErrInvalidPage
+	}
+	for _, b := range p.Buttons {
+		if b.Label == "" {
+			return coopurl.ErrInvalidPage
+		}
+	}
+	return nil
+}
+
+// UpdatePage replaces the page of a link.
+func (s *Store) UpdatePage(id string, p coopurl.Page, opts ...coopurl.ReqOptions) error {
+	if err := checkPage(p); err != nil {
+		return err
+	}
+	return s.change(id, opts, func(k *link) error {
+		k.entry.URL = ""
+		k.entry.Page = &p
+		return nil
+	})
+}
+
+func (s *Store) update(id string, opts []coopurl.ReqOptions, fn func(k *link) error) error {
+	r := coopurl.ApplyReqOptions(opts...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.get(ref(id, r))
+	if k == nil {
+		return coopurl.ErrNotFound
+	}
+	return fn(k)
+}
+
+// change applies fn to the link like update, if it's at the version of IfVersion, and increments its version.
+func (s *Store) change(id string, opts []coopurl.ReqOptions, fn func(k *link) error) error {
+	v := coopurl.ApplyReqOptions(opts...).IfVersion
+	return s.update(id, opts, func(k *link) error {
+		if v != 0 && v != k.entry.Version {
+			return coopurl.ErrVersionMismatch
+		}
+		if err := fn(k); err != nil {
+			return err
+		}
+		k.entry.Version++
+		return nil
+	})
+}
+
+// Validate returns the id Post would give to the link, without storing it.
+// Generated ids are used up, like in the IDSequential mode of Handler.
+func (s *Store) Validate(u string, opts ...coopurl.ReqOptions) (string, error) {
+	if _, err := destination(u); err != nil {
+		return "", err
+	}
+	r := coopurl.ApplyReqOptions(opts...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Alias == "" {
+		return s.ids(), nil
+	}
+	if !validID(r.Alias) {
+		return "", coopurl.ErrInvalidAlias
+	}
+	if s.get(ref(r.Alias, r)) != nil {
+		return "", coopurl.ErrExists
+	}
+	return r.Alias, nil
+}
+
+// IsAvailable tells if a link can be posted with the given alias.
+func (s *Store) IsAvailable(alias string, opts ...coopurl.ReqOptions) (bool, error) {
+	if !validID(alias) {
+		return false, coopurl.ErrInvalidAlias
+	}
+	r := coopurl.ApplyReqOptions(opts...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(ref(alias, r)) == nil, nil
+}
+
+// Get returns the url of the link, empty for pages.
+func (s *Store) Get(id string, opts ...coopurl.ReqOptions) (string, error) {
+	e, err := s.GetEntry(id, opts...)
+	if err != nil {
+		return "", err
+	}
+	return e.URL, nil
+}
+
+// GetEntry returns the link with its metadata.
+func (s *Store) GetEntry(id string, opts ...coopurl.ReqOptions) (coopurl.Entry, error) {
+	r := coopurl.ApplyReqOptions(opts...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.get(ref(id, r))
+	if k == nil {
+		return coopurl.Entry{}, coopurl.ErrNotFound
+	}
+	return s.export(k), nil
+}
+
+// OwnerLinks returns the links of the owner, newest first.
+func (s *Store) OwnerLinks(owner string) ([]coopurl.Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var links []coopurl.Entry
+	for l := range s.links {
+		if k := s.get(l); k != nil && k.entry.Owner == owner {
+			links = append(links, s.export(k))
+		}
+	}
+	sort.SliceStable(links, func(i, j int) bool {
+		if links[i].CreatedAt.Equal(links[j].CreatedAt) {
+			return links[i].ID > links[j].ID
+		}
+		return links[i].CreatedAt.After(links[j].CreatedAt)
+	})
+	return links, nil
+}
+
+// Preview returns nil, the store doesn't fetch previews.
+func (s *Store) Preview(id string, opts ...coopurl.ReqOptions) (*coopurl.Preview, error) {
+	e, err := s.GetEntry(id, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return e.Preview, nil
+}
+
+// Delete removes the link.
+func (s *Store) Delete(id string, opts ...coopurl.ReqOptions) error {
+	r := coopurl.ApplyReqOptions(opts...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := ref(id, r)
+	if s.get(l) == nil {
+		return coopurl.ErrNotFound
+	}
+	delete(s.links, l)
+	return nil
+}
+
+// Touch sets the ttl of the link to ttl from now, 0 makes it never expire.
+func (s *Store) Touch(id string, ttl time.Duration, opts ...coopurl.ReqOptions) error {
+	return s.change(id, opts, func(k *link) error {
+		k.entry.ExpiresAt = time.Time{}
+		if ttl > 0 {
+			k.entry.ExpiresAt = s.now().Add(ttl)
+		}
+		return nil
+	})
+}
+
+// TouchBatch touches the links one by one, and returns the result of each link in the same order.
+func (s *Store) TouchBatch(ids []string, ttl time.Duration, opts ...coopurl.ReqOptions) ([]coopurl.BatchResult, error) {
+	results := make([]coopurl.BatchResult, len(ids))
+	for i, id := range ids {
+		results[i].ID, results[i].Err = id, s.Touch(id, ttl, opts...)
+	}
+	return results, nil
+}
+
+// GetTTL returns the time left before the link expires, 0 if it doesn't expire.
+func (s *Store) GetTTL(id string, opts ...coopurl.ReqOptions) (time.Duration, error) {
+	e, err := s.GetEntry(id, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return e.TTL, nil
+}
+
+// Update changes the destination of the link and records the change in its history.
+func (s *Store) Update(id, u string, opts ...coopurl.ReqOptions) error {
+	dest, err := destination(u)
+	if err != nil {
+		return err
+	}
+	actor := coopurl.ApplyReqOptions(opts...).Actor
+	return s.change(id, opts, func(k *link) error {
+		k.history = append(k.history, coopurl.Revision{
+			Version: len(k.history) + 1,
+			OldURL:  k.entry.URL,
+			NewURL:  dest,
+			Actor:   actor,
+			At:      s.now(),
+		})
+		k.entry.URL = dest
+		k.entry.Page = nil
+		return nil
+	})
+}
+
+// History returns the changes of the destination of the link, oldest first.
+func (s *Store) History(id string, opts ...coopurl.ReqOptions) ([]coopurl.Revision, error) {
+	var revs []coopurl.Revision
+	err := s.update(id, opts, func(k *link) error {
+		revs = append(revs, k.history...)
+		return nil
+	})
+	return revs, err
+}
+
+// Revert sets the destination of the link back to what it was before the given version.
+func (s *Store) Revert(id string, version int, opts ...coopurl.ReqOptions) error {
+	revs, err := s.History(id, opts...)
+	if err != nil {
+		return err
+	}
+	if version < 1 || version > len(revs) {
+		return coopurl.ErrVersionNotFound
+	}
+	return s.Update(id, revs[version-1].OldURL, opts...)
+}
+
+// Stats returns the clicks of the link served by ServeHTTP. Bots aren't told apart.
+func (s *Store) Stats(id string, opts ...coopurl.ReqOptions) (*coopurl.LinkStats, error) {
+	var stats *coopurl.LinkStats
+	err := s.update(id, opts, func(k *link) error {
+		stats = &coopurl.LinkStats{
+			Clicks:    k.entry.Clicks,
+			Daily:     map[string]uint64{},
+			Referrers: map[string]uint64{},
+		}
+		for _, ev := range k.events {
+			stats.Daily[ev.At.UTC().Format("2006-01-02")]++
+			ref := ""
+			if u, err := url.Parse(ev.Referrer); err == nil {
+				ref = u.Hostname()
+			}
+			stats.Referrers[ref]++
+		}
+		if k.entry.Page != nil {
+			stats.Buttons = map[string]uint64{}
+			for b, n := range k.buttons {
+				stats.Buttons[b] = n
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Events returns the clicks of the link served since the given time, oldest first.
+func (s *Store) Events(id string, since time.Time, opts ...coopurl.ReqOptions) ([]coopurl.ClickEvent, error) {
+	var events []coopurl.ClickEvent
+	err := s.update(id, opts, func(k *link) error {
+		for _, ev := range k.events {
+			if !ev.At.Before(since) {
+				events = append(events, ev)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+// ReportAbuse counts a report of the link.
+func (s *Store) ReportAbuse(id, reason string, opts ...coopurl.ReqOptions) error {
+	return s.update(id, opts, func(k *link) error {
+		k.reports++
+		return nil
+	})
+}
+
+// Reports returns the number of abuse reports of the link.
+func (s *Store) Reports(id string, opts ...coopurl.ReqOptions) int {
+	var n int
+	s.update(id, opts, func(k *link) error {
+		n = k.reports
+		return nil
+	})
+	return n
+}
+
+// SetDisabled disables the link or enables it, disabled links are served with a 410 status.
+func (s *Store) SetDisabled(id string, disabled bool, opts ...coopurl.ReqOptions) error {
+	return s.change(id, opts, func(k *link) error {
+		k.entry.Disabled = disabled
+		return nil
+	})
+}
+
+// Len returns the number of links of the store.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for l := range s.links {
+		if s.get(l) != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// ServeHTTP redirects to the link of the last element of the path, looked up in the domain of the host
+// then in the default domain. Pages are served as plain text listing their buttons.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	_, id := path.Split(r.URL.Path)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := s.get(linkRef{domain: hostname(r.Host), id: id})
+	if k == nil {
+		k = s.get(linkRef{id: id})
+	}
+	if k == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if k.entry.NoIndex {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+	if k.entry.Disabled {
+		http.Error(w, "This link has been disabled", http.StatusGone)
+		return
+	}
+
+	if p := k.entry.Page; p != nil {
+		if b := r.URL.Query().Get("b"); b != "" {
+			n, err := strconv.Atoi(b)
+			if err != nil || n < 0 || n >= len(p.Buttons) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			k.buttons[b]++
+			http.Redirect(w, r, p.Buttons[n].URL, http.StatusMovedPermanently)
+			return
+		}
+		s.click(k, r)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		var b strings.Builder
+		b.WriteString(p.Title + "\n")
+		for i, button := range p.Buttons {
+			b.WriteString(button.Label + ": ?b=" + strconv.Itoa(i) + "\n")
+		}
+		w.Write([]byte(b.String()))
+		return
+	}
+
+	s.click(k, r)
+	http.Redirect(w, r, k.entry.URL, http.StatusMovedPermanently)
+}
+
+func (s *Store) click(k *link, r *http.Request) {
+	if r.Method == http.MethodHead {
+		return
+	}
+	k.entry.Clicks++
+	k.events = append(k.events, coopurl.ClickEvent{
+		At:        s.now(),
+		IP:        r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Referrer:  r.Referer(),
+	})
+}
+
+func hostname(host string) string {
+	if u, err := url.Parse("//" + host); err == nil {
+		host = u.Hostname()
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
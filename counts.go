@@ -0,0 +1,185 @@
+package coopurl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// The links of each index value are counted by day of expiry, so that the counters expire at the end of the day
+// along with the links they count, and the expired links are no longer counted without scanning the index, at
+// the latest a day after their expiry. The changes of a counter are written as deltas under unique keys, so
+// that concurrent writes don't conflict, then merged by the JobCounts job.
+
+// countInterval is the interval between the merges of the deltas of the counters.
+const countInterval = time.Minute
+
+// countKey is the key of a delta of the counter of the links indexed under the term which expire on day,
+// never if empty.
+func countKey(t indexTerm, day, id string) []byte {
+	return metaKey("index-count", t.name, t.value, day, id)
+}
+
+// countDay returns the day of the badger expiry, empty if 0.
+func countDay(expiresAt uint64) string {
+	if expiresAt == 0 {
+		return ""
+	}
+	return time.Unix(int64(expiresAt), 0).UTC().Format(dayFormat)
+}
+
+// dayEnd returns the badger expiry of the counter of the day, 0 if empty.
+func dayEnd(day string) uint64 {
+	t, err := time.Parse(dayFormat, day)
+	if err != nil {
+		return 0
+	}
+	return uint64(t.AddDate(0, 0, 1).Unix())
+}
+
+type countBucket struct {
+	term indexTerm
+	day  string
+}
+
+// counts are the changes of the counters of a transaction.
+type counts map[countBucket]int64
+
+func (c counts) add(t indexTerm, expiresAt uint64, delta int64) {
+	c[countBucket{t, countDay(expiresAt)}] += delta
+}
+
+// uncount removes the link indexed at key from the counters of the term, if it's indexed.
+func (c counts) uncount(txn *badger.Txn, t indexTerm, key []byte) error {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.add(t, item.ExpiresAt(), -1)
+	return nil
+}
+
+// write writes the deltas of the counters in txn.
+func (c counts) write(txn *badger.Txn) error {
+	now := time.Now()
+	for b, delta := range c {
+		if delta == 0 {
+			continue
+		}
+		be := badger.NewEntry(countKey(b.term, b.day, timeID(now)), encodeCounter64(uint64(delta)))
+		be.ExpiresAt = dayEnd(b.day)
+		if err := txn.SetEntry(be); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeCounts merges the deltas of each counter.
+func (h *Handler) mergeCounts() error {
+	type delta struct {
+		key []byte
+		n   int64
+	}
+	buckets := map[countBucket][]delta{}
+	prefix := metaPrefixKey("index-count")
+	err := h.db.View(func(txn *badger.Txn) error {
+		return iteratePrefix(txn, prefix, func(key string, v []byte) error {
+			if parts := splitParts(key); len(parts) == 4 {
+				b := countBucket{indexTerm{parts[0], parts[1]}, parts[2]}
+				buckets[b] = append(buckets[b], delta{append(append([]byte{}, prefix...), key...), int64(decodeCounter(v))})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for b, deltas := range buckets {
+		if len(deltas) < 2 {
+			continue
+		}
+		err := h.updateRetry(func(txn *badger.Txn) error {
+			var sum int64
+			for _, d := range deltas {
+				sum += d.n
+				if err := txn.Delete(d.key); err != nil {
+					return err
+				}
+			}
+			if sum <= 0 {
+				return nil
+			}
+			return counts{b: sum}.write(txn)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countIndexed returns the number of live links indexed under the term.
+func countIndexed(txn *badger.Txn, t indexTerm) (int, error) {
+	n := 0
+	err := iteratePrefix(txn, metaPrefixKey("index-count", t.name, t.value), func(_ string, v []byte) error {
+		n += int(int64(decodeCounter(v)))
+		return nil
+	})
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+// Count returns the number of links indexed under value in the index with the given name, eg:
+// Count(IndexNamespace, "marketing"), read from counters rather than by scanning the index. Expired links are
+// counted until the end of the day of their expiry, UTC, and the links stored by the previous versions once
+// Reindex has run.
+func (h *Handler) Count(name, value string) (int, error) {
+	if err := h.ready(); err != nil {
+		return 0, err
+	}
+	defer h.release()
+
+	var n int
+	err := h.db.View(func(txn *badger.Txn) error {
+		var err error
+		n, err = countIndexed(txn, indexTerm{name, value})
+		return err
+	})
+	return n, err
+}
+
+// Counts returns the number of links under each value of the index with the given name, eg: the links of each
+// tag with Counts(IndexTag).
+func (h *Handler) Counts(name string) (map[string]int, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	return h.counts(name)
+}
+
+func (h *Handler) counts(name string) (map[string]int, error) {
+	counts := map[string]int{}
+	err := h.db.View(func(txn *badger.Txn) error {
+		return iteratePrefix(txn, metaPrefixKey("index-count", name), func(key string, v []byte) error {
+			if parts := splitParts(key); len(parts) == 3 {
+				counts[parts[0]] += int(int64(decodeCounter(v)))
+			}
+			return nil
+		})
+	})
+	for v, n := range counts {
+		if n <= 0 {
+			delete(counts, v)
+		}
+	}
+	return counts, err
+}
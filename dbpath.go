@@ -0,0 +1,56 @@
+package coopurl
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DefaultDbDir returns the store directory used without WithDbPath: "coopurl/badger" in the user config
+// directory, eg: ~/.config/coopurl/badger on Linux, or in the user cache directory if there is none, rather
+// than LegacyDbPath in /tmp, which is wiped on reboot and readable by the other users of the host.
+func DefaultDbDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		if dir, err = os.UserCacheDir(); err != nil {
+			return LegacyDbPath
+		}
+	}
+	return filepath.Join(dir, "coopurl", "badger")
+}
+
+// defaultDbPath returns DefaultDbDir, or LegacyDbPath if a store is there and none in DefaultDbDir, so that
+// the stores created by the previous versions are still opened.
+func defaultDbPath() string {
+	dir := DefaultDbDir()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return dir
+	}
+	if _, err := os.Stat(filepath.Join(LegacyDbPath, "MANIFEST")); err == nil {
+		return LegacyDbPath
+	}
+	return dir
+}
+
+// WithLegacyDbPath stores the links in LegacyDbPath, the default directory of the previous versions.
+func WithLegacyDbPath() Options {
+	return WithDbPath(LegacyDbPath)
+}
+
+// WithTemporaryStore stores the links in a new temporary directory, removed with its links on Close,
+// eg: for tests or demos.
+func WithTemporaryStore() Options {
+	return func(h *Handler) {
+		h.temporary = true
+	}
+}
+
+// removeTemporary removes the store of a temporary handler once closed.
+func (h *Handler) removeTemporary() {
+	if h.tempDir == "" {
+		return
+	}
+	if err := os.RemoveAll(h.tempDir); err != nil {
+		h.log(LogStore).Errorf("Couldn't remove the temporary store %s: %s", h.tempDir, err)
+	}
+	h.tempDir = ""
+}
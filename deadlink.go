@@ -0,0 +1,123 @@
+package coopurl
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// BrokenLink is a link whose destination was found unreachable.
+type BrokenLink struct {
+	ID       string `json:"id"`
+	Domain   string `json:"domain,omitempty"`
+	URL      string `json:"url"`
+	Reason   string `json:"reason"`
+	Disabled bool   `json:"disabled"`
+}
+
+// CheckAutoDisable disables the links found broken by the dead link checker.
+func CheckAutoDisable() CheckOptions {
+	return func(c *checker) {
+		c.autoDisable = true
+	}
+}
+
+// WithDeadLinkChecker checks all the stored destinations at each interval in the background,
+// flagging the unreachable ones as broken. Broken links are listed by ListBroken().
+func WithDeadLinkChecker(interval time.Duration, opts ...CheckOptions) Options {
+	return func(h *Handler) {
+		h.deadLinks = newChecker(opts...)
+		h.deadLinksInterval = interval
+	}
+}
+
+// CheckLinks checks all the stored destinations once, as done by the dead link checker.
+func (h *Handler) CheckLinks(ctx context.Context, opts ...CheckOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	return h.checkLinks(ctx, newChecker(opts...))
+}
+
+func (h *Handler) checkLinks(ctx context.Context, c *checker) error {
+	type target struct {
+		ref    linkRef
+		url    string
+		broken bool
+	}
+
+	var targets []target
+	err := h.db.View(func(txn *badger.Txn) error {
+		return forEachLink(txn, func(l linkRef, e entry) error {
+			if !e.Disabled && e.Page == nil {
+				targets = append(targets, target{ref: l, url: e.URL, broken: e.Broken != ""})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		reason := ""
+		if err := c.check(ctx, t.url); err != nil {
+			reason = err.Error()
+		}
+		if reason == "" && !t.broken {
+			continue
+		}
+
+		err := h.db.Update(func(txn *badger.Txn) error {
+			return h.updateEntry(txn, t.ref.key(), func(e *entry) error {
+				e.Broken = reason
+				if reason != "" && c.autoDisable {
+					e.Disabled = true
+				}
+				return nil
+			})
+		})
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if reason != "" {
+			h.log(LogAdmin).Warningf("Broken link %s: %s", t.ref.id, reason)
+		} else {
+			h.log(LogAdmin).Infof("Link %s isn't broken anymore", t.ref.id)
+		}
+	}
+	return nil
+}
+
+// ListBroken lists the links whose destination was found unreachable.
+func (h *Handler) ListBroken() ([]BrokenLink, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	var links []BrokenLink
+	err := h.db.View(func(txn *badger.Txn) error {
+		return forEachLink(txn, func(l linkRef, e entry) error {
+			if e.Broken != "" {
+				links = append(links, BrokenLink{ID: l.id, Domain: l.domain, URL: e.URL, Reason: e.Broken, Disabled: e.Disabled})
+			}
+			return nil
+		})
+	})
+	return links, err
+}
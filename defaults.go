@@ -0,0 +1,171 @@
+package coopurl
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// redirectStatuses are the statuses links can be redirected with.
+var redirectStatuses = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusSeeOther:          true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+// WithRedirectStatus redirects the links with the given status instead of 301, eg: 302 so that browsers don't
+// keep the destinations of links which may change. Namespaces can set their own with WithNamespaceRedirectStatus.
+func WithRedirectStatus(status int) Options {
+	return Checked(func(h *Handler) error {
+		if !redirectStatuses[status] {
+			return optionError("%d isn't a redirect status", status)
+		}
+		h.redirectStatus = status
+		return nil
+	})
+}
+
+// WithAllowedSchemes rejects the destinations whose scheme isn't one of schemes with ErrSchemeNotAllowed, eg:
+// "https", any scheme being allowed by default. Namespaces can restrict them further with WithNamespaceSchemes.
+func WithAllowedSchemes(schemes ...string) Options {
+	return func(h *Handler) {
+		for _, s := range schemes {
+			h.schemes = append(h.schemes, strings.ToLower(s))
+		}
+	}
+}
+
+// WithNamespaceTTL sets the ttl of the links of the namespace posted without WithTTL, instead of the ttl of
+// WithDefaultTTL.
+func WithNamespaceTTL(ttl time.Duration) NamespaceOptions {
+	return func(n *Namespace) {
+		n.TTL = ttl
+	}
+}
+
+// WithNamespaceLength sets the length of the ids generated for the links of the namespace posted without
+// WithLength, instead of the default length of the handler.
+func WithNamespaceLength(length int) NamespaceOptions {
+	return func(n *Namespace) {
+		n.Length = length
+	}
+}
+
+// WithNamespaceRedirectStatus sets the status the links created in the namespace are redirected with, instead of
+// the one of WithRedirectStatus.
+func WithNamespaceRedirectStatus(status int) NamespaceOptions {
+	return func(n *Namespace) {
+		n.RedirectStatus = status
+	}
+}
+
+// WithNamespaceSchemes only allows the destinations of the given schemes in the namespace, on top of the ones
+// allowed by WithAllowedSchemes.
+func WithNamespaceSchemes(schemes ...string) NamespaceOptions {
+	return func(n *Namespace) {
+		for _, s := range schemes {
+			n.Schemes = append(n.Schemes, strings.ToLower(s))
+		}
+	}
+}
+
+// checkDefaults validates the defaults of the namespace.
+func (n *Namespace) checkDefaults() error {
+	if n.TTL < 0 {
+		return optionError("negative ttl %s of namespace %s", n.TTL, n.Name)
+	}
+	if n.Length < 0 || n.Length > maxLength {
+		return optionError("length %d of namespace %s isn't between 1 and %d", n.Length, n.Name, maxLength)
+	}
+	if n.RedirectStatus != 0 && !redirectStatuses[n.RedirectStatus] {
+		return optionError("%d isn't a redirect status", n.RedirectStatus)
+	}
+	return nil
+}
+
+// allowedScheme tells if the scheme of the destination u is one of schemes, any scheme being allowed if empty.
+func allowedScheme(schemes []string, u string) bool {
+	if len(schemes) == 0 {
+		return true
+	}
+	p, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	for _, s := range schemes {
+		if strings.EqualFold(p.Scheme, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSchemes returns ErrSchemeNotAllowed if a destination of e isn't allowed by the namespace.
+func (n *Namespace) checkSchemes(e entry) error {
+	if e.URL != "" && !allowedScheme(n.Schemes, e.URL) {
+		return ErrSchemeNotAllowed
+	}
+	for _, u := range e.Languages {
+		if !allowedScheme(n.Schemes, u) {
+			return ErrSchemeNotAllowed
+		}
+	}
+	return nil
+}
+
+// applyDefaults completes the new link e and its ttl with the defaults of its namespace, once resolved.
+func (n *Namespace) applyDefaults(e *entry, r *req, ttl *time.Duration) error {
+	if err := n.checkSchemes(*e); err != nil {
+		return err
+	}
+	if r.ttl == 0 && n.TTL != 0 {
+		*ttl = n.TTL
+	}
+	e.RedirectStatus = n.RedirectStatus
+	return nil
+}
+
+// namespaceLength sets the length of the ids generated for the request from its namespace, if it has one and
+// the request gives none.
+func (h *Handler) namespaceLength(r *req) error {
+	if r.namespace == "" || r.length != 0 {
+		return nil
+	}
+	return h.db.View(func(txn *badger.Txn) error {
+		n, err := r.getNamespace(txn)
+		if err != nil {
+			return err
+		}
+		r.length = n.Length
+		return nil
+	})
+}
+
+// getNamespace returns the namespace of the request, nil if none, read once.
+func (r *req) getNamespace(txn *badger.Txn) (*Namespace, error) {
+	if r.namespace == "" || r.ns != nil {
+		return r.ns, nil
+	}
+	n, err := getNamespace(txn, r.namespace)
+	if err != nil {
+		return nil, err
+	}
+	r.ns = n
+	return n, nil
+}
+
+// redirectStatusOf returns the status the link e is redirected with.
+func (h *Handler) redirectStatusOf(e entry) int {
+	if e.RedirectStatus != 0 {
+		return e.RedirectStatus
+	}
+	if h.redirectStatus != 0 {
+		return h.redirectStatus
+	}
+	return http.StatusMovedPermanently
+}
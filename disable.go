@@ -0,0 +1,79 @@
+package coopurl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// WithDisabledHandler serves the given handler instead of the default 410 Gone response
+// on disabled links, eg: an interstitial page explaining why the link was taken offline.
+func WithDisabledHandler(disabled http.Handler) Options {
+	return func(h *Handler) {
+		h.disabledHandler = disabled
+	}
+}
+
+// Disable takes the link offline, without deleting it.
+// Disabled links are answered with 410 Gone, or with the handler set by WithDisabledHandler().
+func (h *Handler) Disable(id string, opts ...ReqOptions) error {
+	return h.setDisabled(id, true, opts...)
+}
+
+// Enable puts a disabled link back online.
+func (h *Handler) Enable(id string, opts ...ReqOptions) error {
+	return h.setDisabled(id, false, opts...)
+}
+
+func (h *Handler) setDisabled(id string, disabled bool, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	id = h.normalizeID(id)
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err := h.db.Update(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		return h.updateEntry(txn, linkKey(r.domain, id), func(e *entry) error {
+			e.Disabled = disabled
+			return r.nextVersion(e)
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if disabled {
+		h.log(LogAdmin).Infof("Disable entry: %s", id)
+	} else {
+		h.log(LogAdmin).Infof("Enable entry: %s", id)
+	}
+	return nil
+}
+
+func (h *Handler) serveDisabled(w http.ResponseWriter, r *http.Request) {
+	if h.disabledHandler != nil {
+		h.disabledHandler.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	http.Error(w, fmt.Sprintf(translate(requestLanguage(r), "This link has been disabled (request %s)"), RequestIDFrom(r.Context())), http.StatusGone)
+}
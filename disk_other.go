@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package coopurl
+
+import "errors"
+
+// diskFree isn't implemented on this platform, disk full forecasts are disabled.
+func diskFree(string) (uint64, error) {
+	return 0, errors.New("coopurl: free disk space not available on this platform")
+}
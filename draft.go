@@ -0,0 +1,95 @@
+package coopurl
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// PreviewParam is the query parameter carrying the preview token of a draft, eg: /abc?preview=token.
+const PreviewParam = "preview"
+
+// previewTokenLength is the length in bytes of the preview tokens.
+const previewTokenLength = 16
+
+// WithDraft creates the link as a draft, which is only redirected for the requests carrying its preview token,
+// in the PreviewToken of its entry, eg: to check it before the link is printed. The other requests get 404 Not
+// Found until the draft is published with Publish. Previews aren't counted as clicks.
+func WithDraft() ReqOptions {
+	return func(r *req) {
+		r.draft = true
+	}
+}
+
+func newPreviewToken() (string, error) {
+	b := make([]byte, previewTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// setDraft makes the new link e a draft if asked with WithDraft.
+func (r req) setDraft(e *entry) error {
+	if !r.draft {
+		return nil
+	}
+	token, err := newPreviewToken()
+	if err != nil {
+		return err
+	}
+	e.Draft, e.PreviewToken = true, token
+	return nil
+}
+
+// hidden tells if the link e is a draft the request doesn't preview.
+func hidden(r *http.Request, e entry) bool {
+	if !e.Draft {
+		return false
+	}
+	token := r.URL.Query().Get(PreviewParam)
+	return token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(e.PreviewToken)) != 1
+}
+
+// Publish makes the draft with the given id live, and revokes its preview token.
+func (h *Handler) Publish(id string, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	id = h.normalizeID(id)
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err := h.db.Update(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		return h.updateEntry(txn, linkKey(r.domain, id), func(e *entry) error {
+			e.Draft, e.PreviewToken = false, ""
+			return r.nextVersion(e)
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	h.log(LogAdmin).Infof("Publish entry: %s", id)
+	return nil
+}
@@ -0,0 +1,261 @@
+package coopurl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// dumpFormat and dumpVersion identify the dumps of ExportDump, the version being raised when a dump can't be
+// read by older handlers.
+const (
+	dumpFormat  = "coopurl-dump"
+	dumpVersion = 1
+)
+
+// dumpHeader is the first line of a dump.
+type dumpHeader struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// dumpRecord is a line of a dump: a link with its entry, or any other record with its value, eg: the clicks,
+// the history or a namespace. The last line is an "end" record with the number of records, so that truncated
+// dumps are refused.
+type dumpRecord struct {
+	Kind      string          `json:"kind"`
+	Parts     []string        `json:"parts,omitempty"`
+	Key       []byte          `json:"key,omitempty"` // keys without parts
+	Entry     json.RawMessage `json:"entry,omitempty"`
+	Value     []byte          `json:"value,omitempty"`
+	ExpiresAt uint64          `json:"expires_at,omitempty"` // unix time
+	Count     int             `json:"count,omitempty"`
+}
+
+// derived tells if the key is computed from the links, and written again by ImportDump: the index keys, their
+// counts and the number of links.
+func derived(kind string, parts []string) bool {
+	switch kind {
+	case "quota":
+		return len(parts) == 4
+	case "index-count":
+		return true
+	case "count":
+		return len(parts) == 1 && parts[0] == "links"
+	}
+	return false
+}
+
+// ExportDump writes the whole store to w as json lines, the links with their entries, expiry and metadata, their
+// stats, history and events, and the other records, eg: namespaces, campaigns and tokens, so that another
+// handler, whatever its compression or key layout, imports them with ImportDump. The index keys aren't written,
+// the importing handler indexes the links with its own indexes.
+func (h *Handler) ExportDump(w io.Writer) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := enc.Encode(dumpHeader{Format: dumpFormat, Version: dumpVersion, ExportedAt: time.Now().UTC()}); err != nil {
+		return err
+	}
+	n := 0
+	err := h.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := item.Key()
+			if bytes.HasPrefix(key, badgerPrefix) {
+				continue
+			}
+			kind, parts := KeyCodec{}.Parse(key)
+			if derived(kind, parts) {
+				continue
+			}
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			rec := dumpRecord{Kind: kind, Parts: parts, ExpiresAt: item.ExpiresAt()}
+			switch {
+			case kind == "link":
+				e, err := decodeEntry(v)
+				if err != nil {
+					return fmt.Errorf("link %s: %w", linkRef{domain: parts[0], id: parts[1]}, err)
+				}
+				if rec.Entry, err = e.encode(); err != nil {
+					return err
+				}
+			case parts == nil:
+				rec.Key, rec.Value = item.KeyCopy(nil), v
+			default:
+				rec.Value = v
+			}
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := enc.Encode(dumpRecord{Kind: "end", Count: n}); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportDump writes the records of a dump of ExportDump to the store, replacing the links and records of the same
+// keys and keeping the others, and indexes the links. It returns ErrInvalidDump if r isn't a complete dump of a
+// supported version, the records read until then being imported.
+func (h *Handler) ImportDump(r io.Reader) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header dumpHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDump, err)
+	}
+	if header.Format != dumpFormat || header.Version < 1 || header.Version > dumpVersion {
+		return fmt.Errorf("%w: format %q version %d", ErrInvalidDump, header.Format, header.Version)
+	}
+
+	var links, records []dumpRecord
+	n, added := 0, 0
+	flush := func() error {
+		a, err := h.importLinks(links)
+		added += a
+		if err == nil {
+			err = h.importRecords(records)
+		}
+		links, records = links[:0], records[:0]
+		return err
+	}
+	for {
+		var rec dumpRecord
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("missing end of dump")
+			}
+			if ferr := flush(); ferr != nil {
+				return ferr
+			}
+			h.countLinks(int64(added))
+			return fmt.Errorf("%w: %s", ErrInvalidDump, err)
+		}
+		if rec.Kind == "end" {
+			if err := flush(); err != nil {
+				return err
+			}
+			h.countLinks(int64(added))
+			if rec.Count != n {
+				return fmt.Errorf("%w: %d records imported, %d expected", ErrInvalidDump, n, rec.Count)
+			}
+			h.log(LogAdmin).Infof("Imported a dump of %s: %d records, %d new links", header.ExportedAt.Format(time.RFC3339), n, added)
+			return nil
+		}
+
+		if rec.Kind == "link" {
+			if len(rec.Parts) != 2 || !validID(rec.Parts[1]) {
+				return fmt.Errorf("%w: invalid link %q", ErrInvalidDump, rec.Parts)
+			}
+			links = append(links, rec)
+		} else if !derived(rec.Kind, rec.Parts) {
+			records = append(records, rec)
+		}
+		n++
+		if len(links)+len(records) >= reindexBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// importLinks writes the links of a dump and indexes them, returning the number of links that weren't in the
+// store.
+func (h *Handler) importLinks(links []dumpRecord) (int, error) {
+	if len(links) == 0 {
+		return 0, nil
+	}
+	added := 0
+	err := h.updateRetry(func(txn *badger.Txn) error {
+		added = 0
+		for _, rec := range links {
+			l := linkRef{domain: rec.Parts[0], id: rec.Parts[1]}
+			e, err := decodeEntry(rec.Entry)
+			if err != nil {
+				return fmt.Errorf("%w: link %s: %s", ErrInvalidDump, l, err)
+			}
+
+			old, err := getValue(txn, l.key())
+			switch {
+			case err == nil:
+				if oe, err := decodeEntry(old); err == nil {
+					if err := h.deleteIndexes(txn, l, oe); err != nil {
+						return err
+					}
+				}
+			case errors.Is(err, badger.ErrKeyNotFound):
+				added++
+			default:
+				return err
+			}
+
+			v, err := h.encodeEntry(e)
+			if err != nil {
+				return err
+			}
+			be := badger.NewEntry(l.key(), v)
+			be.ExpiresAt = rec.ExpiresAt
+			if err := txn.SetEntry(be); err != nil {
+				return err
+			}
+			if err := h.setIndexes(txn, l, e, rec.ExpiresAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+// importRecords writes the records of a dump other than the links.
+func (h *Handler) importRecords(records []dumpRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	wb := h.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, rec := range records {
+		key := rec.Key
+		if key == nil {
+			key = KeyCodec{}.Record(rec.Kind, rec.Parts...)
+		}
+		be := badger.NewEntry(key, rec.Value)
+		be.ExpiresAt = rec.ExpiresAt
+		if err := wb.SetEntry(be); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
@@ -0,0 +1,211 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Entry is a link with its metadata, as returned by GetEntry.
+type Entry struct {
+	ID        string            `json:"id"`
+	URL       string            `json:"url"`
+	Languages map[string]string `json:"languages,omitempty"` // set with WithLanguageURL
+	Headers   map[string]string `json:"headers,omitempty"`   // set with WithHeader
+	Tags      []string          `json:"tags,omitempty"`
+	Campaign  string            `json:"campaign,omitempty"`
+	Domain    string            `json:"domain,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Owner     string            `json:"owner,omitempty"`
+	Broken    string            `json:"broken,omitempty"`
+	Disabled  bool              `json:"disabled"`
+	NoIndex   bool              `json:"noindex,omitempty"`
+	Public    bool              `json:"public,omitempty"`
+	Template  bool              `json:"template,omitempty"` // set with WithTemplate
+	Draft     bool              `json:"draft,omitempty"`    // set with WithDraft until published
+	Preview   *Preview          `json:"preview,omitempty"`  // set with WithPreviews
+	Page      *Page             `json:"page,omitempty"`     // set for pages, which have no url
+	Canary    *Canary           `json:"canary,omitempty"`   // set with StartCanary
+	// RedirectStatus is the status of the redirects set by the namespace at the creation of the link, the one of
+	// the handler if 0
+	RedirectStatus int           `json:"redirect_status,omitempty"`
+	Clicks         uint64        `json:"clicks"`
+	CreatedAt      time.Time     `json:"created_at"`           // zero for links created before entries carried metadata
+	UpdatedAt      time.Time     `json:"updated_at,omitempty"` // zero for links never updated
+	ExpiresAt      time.Time     `json:"expires_at,omitempty"`
+	TTL            time.Duration `json:"ttl,omitempty"` // time left before the link expires, 0 if it doesn't expire
+
+	Version int `json:"version"` // 1 for new links, incremented by each change, see IfVersion
+
+	PreviewToken string `json:"preview_token,omitempty"` // redirects the draft when given in PreviewParam
+}
+
+// entry is the value stored for each link.
+type entry struct {
+	URL       string            `json:"url"`
+	Languages map[string]string `json:"languages,omitempty"` // destinations by language
+	Headers   map[string]string `json:"headers,omitempty"`   // response headers of the redirects
+	Tags      []string          `json:"tags,omitempty"`
+	Campaign  string            `json:"campaign,omitempty"`
+	Domain    string            `json:"domain,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Owner     string            `json:"owner,omitempty"`
+	Broken    string            `json:"broken,omitempty"` // why the destination is unreachable, empty if it isn't
+	Disabled  bool              `json:"disabled,omitempty"`
+	NoIndex   bool              `json:"noindex,omitempty"`
+	Public    bool              `json:"public,omitempty"` // listed by PublicLinks
+	Template  bool              `json:"template,omitempty"`
+	Draft     bool              `json:"draft,omitempty"`
+	Preview   *Preview          `json:"preview,omitempty"`
+	Page      *Page             `json:"page,omitempty"`
+	Canary    *Canary           `json:"canary,omitempty"`
+	// status of the redirects, the one of the handler if 0
+	RedirectStatus int       `json:"redirect_status,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at,omitempty"` // may be before the badger expiry because of the grace period.
+
+	Version int `json:"version,omitempty"` // 0 until the first change, read as 1
+
+	PreviewToken string `json:"preview_token,omitempty"` // of drafts
+}
+
+func (e entry) encode() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// decodeEntry decodes a stored link.
+// Links stored before entries carried metadata are raw urls, which never start with '{' as they always have a scheme.
+func decodeEntry(b []byte) (entry, error) {
+	if len(b) > 0 && b[0] == compressedPrefix {
+		var err error
+		if b, err = decompress(b); err != nil {
+			return entry{}, err
+		}
+	}
+	if len(b) == 0 || b[0] != '{' {
+		return entry{URL: string(b), Version: 1}, nil
+	}
+
+	var e entry
+	err := json.Unmarshal(b, &e)
+	if e.Version == 0 {
+		e.Version = 1
+	}
+	return e, err
+}
+
+func clicksKey(domain, id string) []byte {
+	return metaKey("clicks", domain, id)
+}
+
+// GetEntry returns the link with the given id along with its metadata and click count.
+func (h *Handler) GetEntry(id string, opts ...ReqOptions) (Entry, error) {
+	if err := h.ready(); err != nil {
+		return Entry{}, err
+	}
+	defer h.release()
+	id = h.normalizeID(id)
+	if !validID(id) || !h.verify(id) {
+		return Entry{}, ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	var out Entry
+	err := h.db.View(func(txn *badger.Txn) error {
+		var err error
+		out, err = getEntry(txn, &r, id)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return Entry{}, ErrNotFound
+	}
+	return out, err
+}
+
+// getEntry returns the link at id in txn with its clicks and ttl.
+func getEntry(txn *badger.Txn, r *req, id string) (Entry, error) {
+	if err := resolveDomain(txn, r); err != nil {
+		return Entry{}, err
+	}
+
+	item, err := txn.Get(linkKey(r.domain, id))
+	if err != nil {
+		return Entry{}, err
+	}
+	b, err := item.ValueCopy(nil)
+	if err != nil {
+		return Entry{}, err
+	}
+	e, err := decodeEntry(b)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	clicks, err := getCounter(txn, clicksKey(r.domain, id))
+	if err != nil {
+		return Entry{}, err
+	}
+
+	out := e.export(linkRef{domain: r.domain, id: id}, clicks)
+	// Links created before entries carried their expiry only have the badger one.
+	if out.ExpiresAt.IsZero() && item.ExpiresAt() != 0 {
+		out.ExpiresAt = time.Unix(int64(item.ExpiresAt()), 0)
+	}
+	out.TTL = out.timeLeft(time.Now())
+	return out, nil
+}
+
+// export returns the entry stored for the link l.
+func (e entry) export(l linkRef, clicks uint64) Entry {
+	out := Entry{
+		ID:        l.id,
+		URL:       e.URL,
+		Languages: e.Languages,
+		Headers:   e.Headers,
+		Tags:      e.Tags,
+		Campaign:  e.Campaign,
+		Domain:    l.domain,
+		Namespace: e.Namespace,
+		Owner:     e.Owner,
+		Broken:    e.Broken,
+		Disabled:  e.Disabled,
+		NoIndex:   e.NoIndex,
+		Public:    e.Public,
+		Template:  e.Template,
+		Draft:     e.Draft,
+		Preview:   e.Preview,
+		Page:      e.Page,
+		Canary:    e.Canary,
+		Clicks:    clicks,
+
+		RedirectStatus: e.RedirectStatus,
+		CreatedAt:      e.CreatedAt,
+		UpdatedAt:      e.UpdatedAt,
+		ExpiresAt:      e.ExpiresAt,
+		Version:        e.Version,
+
+		PreviewToken: e.PreviewToken,
+	}
+	out.TTL = out.timeLeft(time.Now())
+	return out
+}
+
+// timeLeft returns the time left before the link expires, 0 if it doesn't expire or already expired.
+func (e Entry) timeLeft(now time.Time) time.Duration {
+	if e.ExpiresAt.IsZero() || !e.ExpiresAt.After(now) {
+		return 0
+	}
+	return e.ExpiresAt.Sub(now)
+}
+
+// OwnerLinks returns the links attributed to the owner with WithOwner, newest first.
+func (h *Handler) OwnerLinks(owner string) ([]Entry, error) {
+	return h.Lookup(IndexOwner, owner)
+}
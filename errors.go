@@ -0,0 +1,130 @@
+package coopurl
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error is the type of the errors returned by coopurl.
+// They are compared with errors.Is, and their Code is part of the compatibility promise
+// so it can be relied on by api clients.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "coopurl: " + e.Message
+}
+
+func newError(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+var (
+	ErrNotFound         = newError("not_found", "link not found")
+	ErrExists           = newError("exists", "link already exists")
+	ErrReserved         = newError("reserved", "id is reserved")
+	ErrRouteConflict    = newError("route_conflict", "id is a route of the server")
+	ErrInvalidAlias     = newError("invalid_alias", "invalid alias")
+	ErrInvalidName      = newError("invalid_name", "invalid name")
+	ErrHomograph        = newError("homograph", "domain mixes scripts")
+	ErrUnreachable      = newError("unreachable", "destination unreachable")
+	ErrLoop             = newError("loop", "destination is a link of the shortener")
+	ErrSchemeNotAllowed = newError("scheme_not_allowed", "destination scheme isn't allowed")
+	ErrQuotaExceeded    = newError("quota_exceeded", "quota exceeded")
+	ErrRateLimited      = newError("rate_limited", "too many links created, try again later")
+	ErrInvalidPage      = newError("invalid_page", "page needs a title and buttons with a label")
+	ErrReadOnly         = newError("read_only", "handler is a read-only replica")
+	ErrNotStandby       = newError("not_standby", "handler isn't a standby")
+	ErrInvalidOption    = newError("invalid_option", "invalid option")
+	ErrClosed           = newError("closed", "handler is closed")
+	ErrTimeout          = newError("timeout", "store didn't answer in time")
+	ErrStoreLocked      = newError("store_locked", "store is used by another process")
+
+	ErrURLTooLong       = newError("url_too_long", "destination url is too long")
+	ErrMetadataTooLarge = newError("metadata_too_large", "link metadata are too large")
+
+	ErrInvalidLanguage = newError("invalid_language", "invalid language")
+	ErrInvalidHeader   = newError("invalid_header", "invalid or reserved header")
+
+	ErrVersionNotFound = newError("version_not_found", "version not found")
+	ErrVersionMismatch = newError("version_mismatch", "link was changed since the given version")
+
+	ErrCampaignExists   = newError("campaign_exists", "campaign already exists")
+	ErrCampaignNotFound = newError("campaign_not_found", "campaign not found")
+	ErrCampaignExpired  = newError("campaign_expired", "campaign expired")
+
+	ErrInvalidToken  = newError("invalid_token", "invalid or revoked token")
+	ErrInvalidScope  = newError("invalid_scope", "token needs known scopes")
+	ErrTokenNotFound = newError("token_not_found", "token not found")
+	ErrInvalidShare  = newError("invalid_share", "invalid or expired share token")
+
+	ErrNamespaceExists   = newError("namespace_exists", "namespace already exists")
+	ErrNamespaceNotFound = newError("namespace_not_found", "namespace not found")
+
+	ErrIdempotencyKeyReused = newError("idempotency_key_reused", "idempotency key was used for another url")
+
+	ErrInvalidQuery = newError("invalid_query", "invalid query")
+	ErrMaintenance  = newError("maintenance", "handler is in maintenance")
+	ErrInvalidDump  = newError("invalid_dump", "invalid or unsupported dump")
+
+	ErrInvalidCanary = newError("invalid_canary", "canary needs a link with a destination and a percent from 1 to 99")
+)
+
+// errorStatus are the http statuses answering the errors.
+var errorStatus = map[*Error]int{
+	ErrNotFound:          http.StatusNotFound,
+	ErrExists:            http.StatusConflict,
+	ErrReserved:          http.StatusConflict,
+	ErrRouteConflict:     http.StatusConflict,
+	ErrInvalidAlias:      http.StatusBadRequest,
+	ErrInvalidName:       http.StatusBadRequest,
+	ErrHomograph:         http.StatusBadRequest,
+	ErrUnreachable:       http.StatusBadRequest,
+	ErrLoop:              http.StatusBadRequest,
+	ErrSchemeNotAllowed:  http.StatusBadRequest,
+	ErrQuotaExceeded:     http.StatusTooManyRequests,
+	ErrRateLimited:       http.StatusTooManyRequests,
+	ErrInvalidPage:       http.StatusBadRequest,
+	ErrReadOnly:          http.StatusServiceUnavailable,
+	ErrNotStandby:        http.StatusConflict,
+	ErrInvalidOption:     http.StatusBadRequest,
+	ErrClosed:            http.StatusServiceUnavailable,
+	ErrTimeout:           http.StatusServiceUnavailable,
+	ErrStoreLocked:       http.StatusServiceUnavailable,
+	ErrURLTooLong:        http.StatusBadRequest,
+	ErrMetadataTooLarge:  http.StatusBadRequest,
+	ErrInvalidLanguage:   http.StatusBadRequest,
+	ErrInvalidHeader:     http.StatusBadRequest,
+	ErrVersionNotFound:   http.StatusNotFound,
+	ErrVersionMismatch:   http.StatusPreconditionFailed,
+	ErrCampaignExists:    http.StatusConflict,
+	ErrCampaignNotFound:  http.StatusNotFound,
+	ErrCampaignExpired:   http.StatusGone,
+	ErrInvalidToken:      http.StatusUnauthorized,
+	ErrInvalidScope:      http.StatusBadRequest,
+	ErrTokenNotFound:     http.StatusNotFound,
+	ErrInvalidShare:      http.StatusNotFound,
+	ErrNamespaceExists:   http.StatusConflict,
+	ErrNamespaceNotFound: http.StatusNotFound,
+
+	ErrIdempotencyKeyReused: http.StatusConflict,
+	ErrInvalidQuery:         http.StatusBadRequest,
+	ErrMaintenance:          http.StatusServiceUnavailable,
+	ErrInvalidDump:          http.StatusBadRequest,
+	ErrInvalidCanary:        http.StatusBadRequest,
+}
+
+// HTTPStatus returns the http status answering err, eg: 404 for ErrNotFound, 500 for the errors that aren't
+// from coopurl.
+func HTTPStatus(err error) int {
+	var e *Error
+	if !errors.As(err, &e) {
+		return http.StatusInternalServerError
+	}
+	if status, ok := errorStatus[e]; ok {
+		return status
+	}
+	return http.StatusBadRequest
+}
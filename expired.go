@@ -0,0 +1,90 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// WithExpiredRetention keeps a tombstone of the expired links for the given duration after they are gone, their
+// grace period included, so that they are answered with 410 Gone and an explanation page instead of 404 as if
+// they never existed. Their stats are kept with the tombstone.
+func WithExpiredRetention(retention time.Duration) Options {
+	return func(h *Handler) {
+		h.expiredRetention = retention
+	}
+}
+
+// expiredTombstone is the record of an expired link kept by WithExpiredRetention.
+type expiredTombstone struct {
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+func expiredKey(l linkRef) []byte {
+	return metaKey("expired", l.domain, l.id)
+}
+
+// expiredEntry returns the tombstone of the link l expiring at expiresAt, storedTTL from now with its grace
+// period, nil if expired links aren't kept or if the link doesn't expire.
+func (h *Handler) expiredEntry(l linkRef, expiresAt time.Time, storedTTL time.Duration) (*badger.Entry, error) {
+	if h.expiredRetention <= 0 || storedTTL == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(expiredTombstone{ExpiredAt: expiresAt})
+	if err != nil {
+		return nil, err
+	}
+	return badger.NewEntry(expiredKey(l), b).WithTTL(storedTTL + h.expiredRetention), nil
+}
+
+// setExpired writes the tombstone of the link l for its new expiry, and deletes the previous one if the link
+// doesn't expire anymore.
+func (h *Handler) setExpired(txn *badger.Txn, l linkRef, expiresAt time.Time, storedTTL time.Duration) error {
+	if h.expiredRetention <= 0 {
+		return nil
+	}
+	e, err := h.expiredEntry(l, expiresAt, storedTTL)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return txn.Delete(expiredKey(l))
+	}
+	return txn.SetEntry(e)
+}
+
+// expiredAt returns when the link id of the domain, or of the default domain, expired if its tombstone is kept.
+func (h *Handler) expiredAt(domain, id string) (time.Time, bool) {
+	if h.expiredRetention <= 0 {
+		return time.Time{}, false
+	}
+	var t expiredTombstone
+	err := h.db.View(func(txn *badger.Txn) error {
+		b, err := getValue(txn, expiredKey(linkRef{domain: domain, id: id}))
+		if errors.Is(err, badger.ErrKeyNotFound) && domain != "" {
+			b, err = getValue(txn, expiredKey(linkRef{id: id}))
+		}
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, &t)
+	})
+	if err != nil {
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			h.log(LogRedirects).Warningf("Couldn't read the tombstone of expired %s: %s", id, err)
+		}
+		return time.Time{}, false
+	}
+	return t.ExpiredAt, true
+}
+
+// serveExpired answers 410 Gone explaining that the link expired.
+func serveExpired(w http.ResponseWriter, r *http.Request, expiredAt time.Time) {
+	w.Header().Add("Vary", "Accept-Language")
+	http.Error(w, fmt.Sprintf(translate(requestLanguage(r), "This link expired on %s (request %s)"),
+		expiredAt.UTC().Format("2006-01-02"), RequestIDFrom(r.Context())), http.StatusGone)
+}
@@ -0,0 +1,174 @@
+package coopurl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// The links are indexed by expiry, so that the links expiring soon are found without going through the store. The
+// keys hold the expiry of the link as zero padded unix nanoseconds, so they are iterated soonest first, and are
+// written in the same transactions as the links, expiring with them.
+
+func expiringKey(at time.Time, l linkRef) []byte {
+	return metaKey("expiring", fmt.Sprintf("%020d", at.UnixNano()), l.domain, l.id)
+}
+
+// expiringIndexedKey is set once the links stored before the expiry index are indexed.
+func expiringIndexedKey() []byte {
+	return metaKey("expiring-indexed")
+}
+
+// setExpiring indexes the link by the expiry of its entry until the given badger expiry.
+func setExpiring(txn *badger.Txn, l linkRef, e entry, expiresAt uint64) error {
+	if e.ExpiresAt.IsZero() {
+		return nil
+	}
+	be := badger.NewEntry(expiringKey(e.ExpiresAt, l), nil)
+	be.ExpiresAt = expiresAt
+	return txn.SetEntry(be)
+}
+
+func deleteExpiring(txn *badger.Txn, l linkRef, e entry) error {
+	if e.ExpiresAt.IsZero() {
+		return nil
+	}
+	return txn.Delete(expiringKey(e.ExpiresAt, l))
+}
+
+// forEachExpiring calls fn for the links expiring before the given time, soonest first, including the expired
+// links still kept by their grace period.
+func forEachExpiring(txn *badger.Txn, before time.Time, fn func(l linkRef, e entry) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	prefix := metaPrefixKey("expiring")
+	limit := fmt.Sprintf("%020d", before.UnixNano())
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		parts := splitParts(string(it.Item().Key()[len(prefix):]))
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] > limit {
+			break
+		}
+		l := linkRef{domain: parts[1], id: parts[2]}
+		b, err := getValue(txn, l.key())
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		e, err := decodeEntry(b)
+		if err != nil {
+			return err
+		}
+		// keys of a former expiry are left by the replicas which didn't know the index
+		if e.ExpiresAt.IsZero() || fmt.Sprintf("%020d", e.ExpiresAt.UnixNano()) != parts[0] {
+			continue
+		}
+		if err := fn(l, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexExpiring indexes the links stored before the expiry index, once.
+func (h *Handler) indexExpiring() error {
+	var links []indexed
+	err := h.db.View(func(txn *badger.Txn) error {
+		if _, err := txn.Get(expiringIndexedKey()); err == nil {
+			return nil
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		return forEachLink(txn, func(l linkRef, e entry) error {
+			if e.ExpiresAt.IsZero() {
+				return nil
+			}
+			item, err := txn.Get(l.key())
+			if err != nil {
+				return err
+			}
+			links = append(links, indexed{l, e, item.ExpiresAt()})
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for len(links) > 0 {
+		n := len(links)
+		if n > reindexBatch {
+			n = reindexBatch
+		}
+		err := h.updateRetry(func(txn *badger.Txn) error {
+			for _, x := range links[:n] {
+				if err := setExpiring(txn, x.l, x.e, x.expiresAt); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		links = links[n:]
+	}
+	return h.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(expiringIndexedKey(), nil)
+	})
+}
+
+// ListExpiring returns the links expiring within the given duration, soonest first,
+// including the expired links still kept by their grace period.
+// Links created before entries carried their expiry aren't listed.
+func (h *Handler) ListExpiring(within time.Duration) ([]Entry, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	limit := time.Now().Add(within)
+	var links []Entry
+	err := h.db.View(func(txn *badger.Txn) error {
+		list := func(l linkRef, e entry) error {
+			clicks, err := getCounter(txn, clicksKey(l.domain, l.id))
+			if err != nil {
+				return err
+			}
+			links = append(links, e.export(l, clicks))
+			return nil
+		}
+		if _, err := txn.Get(expiringIndexedKey()); err == nil {
+			return forEachExpiring(txn, limit, list)
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		// the links aren't all indexed yet
+		err := forEachLink(txn, func(l linkRef, e entry) error {
+			if e.ExpiresAt.IsZero() || e.ExpiresAt.After(limit) {
+				return nil
+			}
+			return list(l, e)
+		})
+		if err != nil {
+			return err
+		}
+		sort.Slice(links, func(i, j int) bool { return links[i].ExpiresAt.Before(links[j].ExpiresAt) })
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
@@ -0,0 +1,127 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ExpiryNotice warns the owner of a link that it will soon expire.
+type ExpiryNotice struct {
+	ID        string    `json:"id"`
+	Domain    string    `json:"domain,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Owner     string    `json:"owner"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// WithExpiryNotifier calls fn once for each link with an owner, the given duration before it expires.
+// Links about to expire are looked for at each interval, fn is called from this background job.
+// Links whose expiry changes with Touch are notified again.
+func WithExpiryNotifier(before, interval time.Duration, fn func(ExpiryNotice)) Options {
+	return func(h *Handler) {
+		h.expiryNoticeBefore = before
+		h.expiryNoticeInterval = interval
+		h.expiryNotifier = fn
+	}
+}
+
+// WithNamespaceExpiryNotice warns the owners of the links of the namespace the given duration before they expire,
+// instead of the duration of WithExpiryNotifier. Negative durations disable the notices of the namespace.
+func WithNamespaceExpiryNotice(before time.Duration) NamespaceOptions {
+	return func(n *Namespace) {
+		n.NotifyBefore = before
+	}
+}
+
+// expiryNotifiedKey records the expiry the owner of the link was warned about.
+func expiryNotifiedKey(l linkRef) []byte {
+	return metaKey("expiry-notified", l.domain, l.id)
+}
+
+// notifyExpiring warns the owners of the links expiring soon that weren't warned yet.
+// Only the links of the expiry index expiring within the longest notice are read.
+func (h *Handler) notifyExpiring() error {
+	if err := h.indexExpiring(); err != nil {
+		return err
+	}
+	now := time.Now()
+
+	var notices []ExpiryNotice
+	err := h.db.View(func(txn *badger.Txn) error {
+		namespaces := map[string]time.Duration{}
+		longest := h.expiryNoticeBefore
+		prefix := metaPrefixKey("namespace")
+		err := iteratePrefix(txn, prefix, func(key string, value []byte) error {
+			var n Namespace
+			if err := json.Unmarshal(value, &n); err != nil {
+				return err
+			}
+			namespaces[unescapePart(key)] = n.NotifyBefore
+			if n.NotifyBefore > longest {
+				longest = n.NotifyBefore
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if longest <= 0 {
+			return nil
+		}
+
+		return forEachExpiring(txn, now.Add(longest), func(l linkRef, e entry) error {
+			if e.Owner == "" || e.Disabled || !now.Before(e.ExpiresAt) {
+				return nil
+			}
+
+			before := h.expiryNoticeBefore
+			if d := namespaces[e.Namespace]; d != 0 {
+				before = d
+			}
+			if before <= 0 || e.ExpiresAt.Sub(now) > before {
+				return nil
+			}
+
+			b, err := getValue(txn, expiryNotifiedKey(l))
+			if err == nil && string(b) == e.ExpiresAt.UTC().Format(time.RFC3339Nano) {
+				return nil
+			}
+			if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+			notices = append(notices, ExpiryNotice{
+				ID:        l.id,
+				Domain:    l.domain,
+				Namespace: e.Namespace,
+				Owner:     e.Owner,
+				URL:       e.URL,
+				ExpiresAt: e.ExpiresAt,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, n := range notices {
+		// the notice is recorded first, owners may miss a notice but aren't warned twice
+		err := h.updateRetry(func(txn *badger.Txn) error {
+			value := []byte(n.ExpiresAt.UTC().Format(time.RFC3339Nano))
+			if err := setValue(txn, expiryNotifiedKey(linkRef{domain: n.Domain, id: n.ID}), value, n.ExpiresAt.Sub(now)+h.grace); err != nil {
+				return err
+			}
+			return h.enqueue(txn, EventExpiryNotice, n)
+		})
+		if err != nil {
+			return err
+		}
+		h.log(LogAdmin).Infof("Warn owner of entry %s of its expiry on %s", n.ID, n.ExpiresAt)
+		h.expiryNotifier(n)
+	}
+	return nil
+}
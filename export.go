@@ -0,0 +1,122 @@
+package coopurl
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// statsColumns is the header of the csv written by ExportStats and ExportCampaignStats.
+var statsColumns = []string{"link", "metric", "value", "clicks"}
+
+// ExportStats writes the stats of a link as csv to w, eg: to open them in a spreadsheet.
+// Each row is a link, a metric ("day", "country" or "referrer"), its value and the clicks, days in order
+// and the other metrics most clicked first. Direct clicks have an empty referrer.
+func (h *Handler) ExportStats(id string, w io.Writer, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	id = h.normalizeID(id)
+	if !validID(id) || !h.verify(id) {
+		return ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	var s *LinkStats
+	err := h.db.View(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		if _, err := txn.Get(linkKey(r.domain, id)); err != nil {
+			return err
+		}
+
+		var err error
+		s, err = linkStats(txn, linkRef{domain: r.domain, id: id})
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(statsColumns)
+	writeStatsRows(cw, id, s)
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportCampaignStats writes the stats of the links of a campaign as csv to w, like ExportStats.
+// Links of a domain are written as "domain/id".
+func (h *Handler) ExportCampaignStats(name string, w io.Writer) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+
+	var links []string
+	stats := map[string]*LinkStats{}
+	err := h.db.View(func(txn *badger.Txn) error {
+		if _, err := getCampaign(txn, name); err != nil {
+			return err
+		}
+
+		return iteratePrefix(txn, campaignLinksPrefix(name), func(id string, domain []byte) error {
+			l := linkRef{domain: string(domain), id: unescapePart(id)}
+			s, err := linkStats(txn, l)
+			if err != nil {
+				return err
+			}
+			key := l.id
+			if l.domain != "" {
+				key = l.domain + "/" + l.id
+			}
+			links = append(links, key)
+			stats[key] = s
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(statsColumns)
+	for _, l := range links {
+		writeStatsRows(cw, l, stats[l])
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeStatsRows(cw *csv.Writer, link string, s *LinkStats) {
+	days := make([]string, 0, len(s.Daily))
+	for d := range s.Daily {
+		days = append(days, d)
+	}
+	sort.Strings(days)
+	for _, d := range days {
+		cw.Write([]string{link, "day", d, strconv.FormatUint(s.Daily[d], 10)})
+	}
+
+	for _, m := range []struct {
+		name   string
+		counts map[string]uint64
+	}{{"country", s.Countries}, {"referrer", s.Referrers}} {
+		for _, k := range rankCounts(m.counts) {
+			cw.Write([]string{link, m.name, k, strconv.FormatUint(m.counts[k], 10)})
+		}
+	}
+}
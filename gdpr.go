@@ -0,0 +1,291 @@
+package coopurl
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ownerData is the document exported by ExportOwnerData.
+type ownerData struct {
+	Owner      string          `json:"owner"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Links      []ownerLink     `json:"links"`
+	Deleted    []ownerLink     `json:"deleted,omitempty"`
+	Revisions  []ownerRevision `json:"revisions,omitempty"` // changes made by the owner on links of others
+}
+
+type ownerLink struct {
+	ID      string       `json:"id"`
+	Entry   entry        `json:"entry"`
+	Clicks  uint64       `json:"clicks"`
+	Events  []ClickEvent `json:"events,omitempty"`
+	History []Revision   `json:"history,omitempty"`
+}
+
+type ownerRevision struct {
+	ID string `json:"id"`
+	Revision
+}
+
+// ownerRecords finds the links, tombstones and revisions attributed to the owner.
+func ownerRecords(txn *badger.Txn, owner string, fn func(kind string, l linkRef, e entry) error, rev func(key []byte, l linkRef, r Revision) error) error {
+	err := forEachLink(txn, func(l linkRef, e entry) error {
+		if e.Owner != owner {
+			return nil
+		}
+		return fn("link", l, e)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = iteratePrefix(txn, metaPrefixKey("tombstone"), func(key string, value []byte) error {
+		var t tombstone
+		if err := json.Unmarshal(value, &t); err != nil {
+			return err
+		}
+		if t.Entry.Owner != owner {
+			return nil
+		}
+		domain, id := splitRef(key)
+		return fn("tombstone", linkRef{domain: domain, id: id}, t.Entry)
+	})
+	if err != nil {
+		return err
+	}
+
+	prefix := metaPrefixKey("history")
+	return iteratePrefix(txn, prefix, func(key string, value []byte) error {
+		var r Revision
+		if err := json.Unmarshal(value, &r); err != nil {
+			return err
+		}
+		if r.Actor != owner {
+			return nil
+		}
+		domain, id := splitRef(key)
+		return rev(append(append([]byte{}, prefix...), key...), linkRef{domain: domain, id: id}, r)
+	})
+}
+
+// ExportOwnerData returns a JSON document with all the data attributed to the owner:
+// its links with their clicks and history, its deleted links and the changes it made to other links.
+func (h *Handler) ExportOwnerData(owner string) (io.Reader, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	d := ownerData{Owner: owner, ExportedAt: time.Now(), Links: []ownerLink{}}
+	owned := map[linkRef]bool{}
+	err := h.db.View(func(txn *badger.Txn) error {
+		return ownerRecords(txn, owner, func(kind string, l linkRef, e entry) error {
+			clicks, err := getCounter(txn, clicksKey(l.domain, l.id))
+			if err != nil {
+				return err
+			}
+			revs, err := history(txn, l)
+			if err != nil {
+				return err
+			}
+			var events []ClickEvent
+			err = iteratePrefix(txn, eventsPrefix(l), func(_ string, value []byte) error {
+				var e ClickEvent
+				if err := json.Unmarshal(value, &e); err != nil {
+					return err
+				}
+				events = append(events, e)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			owned[l] = true
+			ol := ownerLink{ID: l.id, Entry: e, Clicks: clicks, Events: events, History: revs}
+			if kind == "tombstone" {
+				d.Deleted = append(d.Deleted, ol)
+			} else {
+				d.Links = append(d.Links, ol)
+			}
+			return nil
+		}, func(_ []byte, l linkRef, r Revision) error {
+			// the history of its own links is already exported with them
+			if !owned[l] {
+				d.Revisions = append(d.Revisions, ownerRevision{ID: l.id, Revision: r})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// EraseOwner deletes all the data attributed to the owner in a transaction, bypassing soft delete: its links
+// with their analytics, history, notices and scheduled changes, its deleted links, its idempotency keys, its api
+// tokens and the expiry notices waiting in the outbox. The changes it made to other links are kept in their
+// history and schedule but anonymized.
+func (h *Handler) EraseOwner(owner string) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+
+	var links, live int
+	err := h.updateRetry(func(txn *badger.Txn) error {
+		links, live = 0, 0
+		var deletes [][]byte
+		c := counts{}
+		anonymized := map[string][]byte{}
+
+		for _, prefix := range [][]byte{metaPrefixKey("quota-day", "owner", owner), metaPrefixKey("idempotency", owner)} {
+			err := iteratePrefix(txn, prefix, func(key string, _ []byte) error {
+				deletes = append(deletes, append(append([]byte{}, prefix...), key...))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		err := iteratePrefix(txn, metaPrefixKey("token"), func(_ string, value []byte) error {
+			var t storedToken
+			if err := json.Unmarshal(value, &t); err != nil {
+				return err
+			}
+			if t.Owner == owner {
+				deletes = append(deletes, tokenKey(t.ID))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, prefix := range [][]byte{metaPrefixKey("outbox"), metaPrefixKey("outbox-dead")} {
+			err := iteratePrefix(txn, prefix, func(key string, value []byte) error {
+				var d Delivery
+				if err := json.Unmarshal(value, &d); err != nil {
+					return err
+				}
+				if d.Event != EventExpiryNotice {
+					return nil
+				}
+				var n ExpiryNotice
+				if err := json.Unmarshal(d.Payload, &n); err != nil {
+					return err
+				}
+				if n.Owner == owner {
+					deletes = append(deletes, append(append([]byte{}, prefix...), key...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		prefix := metaPrefixKey("schedule")
+		err = iteratePrefix(txn, prefix, func(key string, value []byte) error {
+			var sc ScheduledChange
+			if err := json.Unmarshal(value, &sc); err != nil {
+				return err
+			}
+			if sc.Actor != owner {
+				return nil
+			}
+			sc.Actor = ""
+			b, err := json.Marshal(sc)
+			if err != nil {
+				return err
+			}
+			anonymized[string(prefix)+key] = b
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = ownerRecords(txn, owner, func(kind string, l linkRef, e entry) error {
+			links++
+			if kind == "tombstone" {
+				deletes = append(deletes, tombstoneKey(l.domain, l.id))
+			} else {
+				live++
+				deletes = append(deletes, l.key())
+				for _, t := range h.indexTerms(l, e) {
+					k := indexKey(t.name, t.value, l)
+					if err := c.uncount(txn, t, k); err != nil {
+						return err
+					}
+					deletes = append(deletes, k)
+				}
+				if !e.ExpiresAt.IsZero() {
+					deletes = append(deletes, expiringKey(e.ExpiresAt, l))
+				}
+			}
+			deletes = append(deletes, thumbnailKey(l), expiredKey(l), pendingArchiveKey(l.domain, l.id), scheduleKey(l), graceNotifiedKey(l.domain, l.id), expiryNotifiedKey(l))
+			if e.Campaign != "" {
+				deletes = append(deletes, campaignLinkKey(e.Campaign, l.id))
+			}
+			revs, err := historyKeys(txn, l)
+			if err != nil {
+				return err
+			}
+			stats, err := statsKeys(txn, l)
+			if err != nil {
+				return err
+			}
+			deletes = append(append(deletes, revs...), stats...)
+
+			prefix := metaPrefixKey("visitor", l.domain, l.id)
+			return iteratePrefix(txn, prefix, func(key string, _ []byte) error {
+				deletes = append(deletes, append(append([]byte{}, prefix...), key...))
+				return nil
+			})
+		}, func(key []byte, _ linkRef, r Revision) error {
+			r.Actor = ""
+			b, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			anonymized[string(key)] = b
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range deletes {
+			delete(anonymized, string(k))
+			if err := txn.Delete(k); err != nil {
+				return err
+			}
+		}
+		for k, b := range anonymized {
+			if err := txn.Set([]byte(k), b); err != nil {
+				return err
+			}
+		}
+		return c.write(txn)
+	})
+	if err != nil {
+		return err
+	}
+
+	h.sampler.deleted(uint64(live))
+	h.countLinks(-int64(live))
+	h.log(LogAdmin).Infof("Erased %d links of owner %s", links, owner)
+	return nil
+}
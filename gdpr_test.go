@@ -0,0 +1,55 @@
+package coopurl
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingArchiver struct {
+	mu    sync.Mutex
+	links []ArchivedLink
+}
+
+func (a *recordingArchiver) Archive(l ArchivedLink) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.links = append(a.links, l)
+	return nil
+}
+
+func TestEraseOwnerIsNotArchived(t *testing.T) {
+	a := &recordingArchiver{}
+	h := newTestHandler(t, WithArchiver(a, time.Hour))
+
+	id, err := h.Post("https://example.com/secret", WithOwner("alice"), WithTTL(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Update(id, "https://example.com/other", WithActor("alice")); err != nil {
+		t.Fatal(err)
+	}
+	// keeps the copy of the link expiring before the next run
+	if err := h.archiveExpired(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.EraseOwner("alice"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * time.Second)
+	if err := h.archiveExpired(); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.links) != 0 {
+		t.Fatalf("erased link archived: %+v", a.links)
+	}
+
+	revs, err := h.History(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 0 {
+		t.Fatalf("history kept after erasure: %+v", revs)
+	}
+}
@@ -1,8 +1,17 @@
-module github.com/coopgo/coopurl
+module github.com/coopgo/coopurl/v2
 
-go 1.17
+go 1.18
 
-require github.com/dgraph-io/badger/v3 v3.2103.2
+require (
+	github.com/dgraph-io/badger/v3 v3.2103.2
+	github.com/gorilla/mux v1.8.0
+	github.com/hashicorp/go-hclog v0.9.1
+	github.com/hashicorp/raft v1.3.11
+	github.com/rs/zerolog v1.26.1
+	github.com/sirupsen/logrus v1.8.1
+	go.uber.org/zap v1.21.0
+	golang.org/x/net v0.0.0-20211216030914-fe4d6282115f
+)
 
 require (
 	github.com/cespare/xxhash v1.1.0 // indirect
@@ -13,16 +22,21 @@ require (
 	github.com/golang/glog v1.0.0 // indirect
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/golang/snappy v0.0.4 // indirect
+	github.com/golang/snappy v0.0.4
 	github.com/google/flatbuffers v2.0.5+incompatible // indirect
-	github.com/klauspost/compress v1.13.6 // indirect
+	github.com/klauspost/compress v1.13.6
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/stretchr/testify v1.7.0 // indirect
 	go.opencensus.io v0.23.0 // indirect
-	golang.org/x/net v0.0.0-20211216030914-fe4d6282115f // indirect
 )
 
 require (
+	github.com/armon/go-metrics v0.0.0-20190430140413-ec5e00d3c878 // indirect
+	github.com/hashicorp/go-immutable-radix v1.0.0 // indirect
+	github.com/hashicorp/go-msgpack v0.5.5 // indirect
+	github.com/hashicorp/golang-lru v0.5.0 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
 	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
+	golang.org/x/text v0.3.6 // indirect
 	google.golang.org/protobuf v1.27.1 // indirect
 )
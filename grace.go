@@ -0,0 +1,90 @@
+package coopurl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// GraceNotice describes an expired link used during its grace period.
+type GraceNotice struct {
+	ID        string
+	Domain    string
+	Owner     string
+	URL       string
+	ExpiredAt time.Time
+	GraceEnds time.Time
+	RequestID string // id of the request that used the link
+}
+
+// WithExpiryGrace keeps expired links redirecting for the given duration after their ttl.
+// Redirects during the grace period carry Warning and Sunset headers.
+func WithExpiryGrace(grace time.Duration) Options {
+	return func(h *Handler) {
+		h.grace = grace
+	}
+}
+
+// WithGraceNotifier calls fn the first time an expired link is used during its grace period,
+// so its owner can be warned that the link will soon stop working.
+func WithGraceNotifier(fn func(GraceNotice)) Options {
+	return func(h *Handler) {
+		h.graceNotifier = fn
+	}
+}
+
+func graceNotifiedKey(domain, id string) []byte {
+	return metaKey("grace-notified", domain, id)
+}
+
+// inGrace tells if the entry expired and is only kept by the grace period.
+func (e entry) inGrace(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
+}
+
+// writeGraceHeaders warns the client that the link expired and when it will stop working.
+func (h *Handler) writeGraceHeaders(w http.ResponseWriter, e entry) {
+	end := e.ExpiresAt.Add(h.grace)
+	w.Header().Set("Warning", fmt.Sprintf(`299 - "This link expired on %s and will stop working on %s"`,
+		e.ExpiresAt.UTC().Format(http.TimeFormat), end.UTC().Format(http.TimeFormat)))
+	w.Header().Set("Sunset", end.UTC().Format(http.TimeFormat))
+}
+
+// notifyGrace calls the grace notifier once per link.
+func (h *Handler) notifyGrace(domain, id string, e entry, requestID string) {
+	if h.graceNotifier == nil {
+		return
+	}
+
+	first := false
+	err := h.db.Update(func(txn *badger.Txn) error {
+		key := graceNotifiedKey(domain, id)
+		if _, err := txn.Get(key); err == nil {
+			return nil
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		first = true
+		return setValue(txn, key, nil, h.grace)
+	})
+	if err != nil {
+		h.log(LogRedirects).Warningf("Couldn't record grace notification of %s: %s", id, err)
+		return
+	}
+	if !first {
+		return
+	}
+
+	go h.graceNotifier(GraceNotice{
+		ID:        id,
+		Domain:    domain,
+		Owner:     e.Owner,
+		URL:       e.URL,
+		ExpiredAt: e.ExpiresAt,
+		GraceEnds: e.ExpiresAt.Add(h.grace),
+		RequestID: requestID,
+	})
+}
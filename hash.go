@@ -0,0 +1,89 @@
+package coopurl
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+)
+
+// saltSize is the number of random bytes hashed with the url to generate an id.
+const saltSize = 16
+
+// WithHash sets the hash used to generate ids, SHA-256 by default.
+// The hash must be linked in the binary, eg: by importing golang.org/x/crypto/blake2b, New fails otherwise.
+func WithHash(hash crypto.Hash) Options {
+	return Checked(func(h *Handler) error {
+		if !hash.Available() {
+			return optionError("hash %s isn't linked in the binary", hash)
+		}
+		h.hash = func(b []byte) []byte {
+			hh := hash.New()
+			hh.Write(b)
+			return hh.Sum(nil)
+		}
+		return nil
+	})
+}
+
+// WithHashFunc sets the function used to hash the url and salt of generated ids, eg: BLAKE3 or xxhash.
+// Ids can't be longer than the hexadecimal encoding of the hash.
+func WithHashFunc(fn func([]byte) []byte) Options {
+	return Checked(func(h *Handler) error {
+		if fn == nil {
+			return optionError("nil hash func")
+		}
+		h.hash = fn
+		return nil
+	})
+}
+
+// WithIDSecret mixes the secret into the hash of the generated ids, so that the ids a url may receive can't be
+// computed by anyone not knowing it, even if they could predict the random salt. It only changes the ids of the
+// IDHashed mode, the stored links are kept.
+func WithIDSecret(secret []byte) Options {
+	return Checked(func(h *Handler) error {
+		if len(secret) == 0 {
+			return optionError("empty id secret")
+		}
+		h.idSecret = secret
+		return nil
+	})
+}
+
+// idHash returns the hash of the generated ids, keyed with the id secret if any.
+func (h *Handler) idHash() func([]byte) []byte {
+	hash := h.hash
+	if hash == nil {
+		hash = sha256Sum
+	}
+	if h.idSecret == nil {
+		return hash
+	}
+	if h.hash == nil {
+		return func(b []byte) []byte {
+			mac := hmac.New(sha256.New, h.idSecret)
+			mac.Write(b)
+			return mac.Sum(nil)
+		}
+	}
+	// the hashes set by WithHashFunc can't be used in an hmac, the secret is prepended to their input
+	return func(b []byte) []byte {
+		return hash(append(append([]byte{}, h.idSecret...), b...))
+	}
+}
+
+func sha256Sum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+// salted returns url followed by random bytes, so the same url gets a different id each time.
+func salted(url string) ([]byte, error) {
+	b := make([]byte, len(url)+saltSize)
+	copy(b, url)
+	if _, err := rand.Read(b[len(url):]); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,64 @@
+package coopurl
+
+import (
+	"net/http"
+	"strings"
+)
+
+// reservedHeaders are the headers set by the Handler or by the http server, which links can't set.
+var reservedHeaders = map[string]bool{
+	"Location":          true,
+	"Set-Cookie":        true,
+	"Content-Type":      true,
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Upgrade":           true,
+	"Trailer":           true,
+	"Vary":              true,
+	"Retry-After":       true,
+	"X-Request-Id":      true, // RequestIDHeader
+}
+
+// WithHeader adds a response header to the redirects of the link, eg: a Cache-Control override for a CDN or
+// a tracking header. The headers set by the handler itself, like Location or Set-Cookie, are rejected with
+// ErrInvalidHeader.
+func WithHeader(name, value string) ReqOptions {
+	return func(r *req) {
+		if r.headers == nil {
+			r.headers = map[string]string{}
+		}
+		r.headers[http.CanonicalHeaderKey(name)] = value
+	}
+}
+
+// checkHeaders validates the headers given with WithHeader.
+func checkHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if !validHeaderName(name) || reservedHeaders[name] || strings.ContainsAny(value, "\r\n\x00") {
+			return ErrInvalidHeader
+		}
+	}
+	return nil
+}
+
+// validHeaderName tells if name is an http token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeHeaders sets the headers of the link e.
+func writeHeaders(w http.ResponseWriter, e entry) {
+	for name, value := range e.Headers {
+		w.Header().Set(name, value)
+	}
+}
@@ -0,0 +1,198 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Revision is a change of the destination of a link.
+type Revision struct {
+	Version int       `json:"version"`
+	OldURL  string    `json:"old_url"`
+	NewURL  string    `json:"new_url"`
+	Actor   string    `json:"actor,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// WithActor records who made the change in the link history.
+func WithActor(actor string) ReqOptions {
+	return func(r *req) {
+		r.actor = actor
+	}
+}
+
+func historyPrefix(domain, id string) []byte {
+	return metaPrefixKey("history", domain, id)
+}
+
+func historyKey(domain, id string, version int) []byte {
+	// versions are zero padded so they are iterated in order
+	return append(historyPrefix(domain, id), fmt.Sprintf("%010d", version)...)
+}
+
+// historyKeys returns the keys of the revisions of the link l.
+func historyKeys(txn *badger.Txn, l linkRef) ([][]byte, error) {
+	var keys [][]byte
+	prefix := historyPrefix(l.domain, l.id)
+	err := iteratePrefix(txn, prefix, func(key string, _ []byte) error {
+		keys = append(keys, append(append([]byte{}, prefix...), key...))
+		return nil
+	})
+	return keys, err
+}
+
+func history(txn *badger.Txn, l linkRef) ([]Revision, error) {
+	var revs []Revision
+	err := iteratePrefix(txn, historyPrefix(l.domain, l.id), func(_ string, value []byte) error {
+		var rev Revision
+		if err := json.Unmarshal(value, &rev); err != nil {
+			return err
+		}
+		revs = append(revs, rev)
+		return nil
+	})
+	return revs, err
+}
+
+// IfVersion makes the changes of a link, by Update, UpdatePage, Touch, Disable, Enable or SetPublic, fail with
+// ErrVersionMismatch if the link isn't at the version v anymore, as read in its Entry, so that concurrent
+// changes aren't overwritten. 0 changes the link whatever its version.
+func IfVersion(v int) ReqOptions {
+	return func(r *req) {
+		r.ifVersion = v
+	}
+}
+
+// nextVersion checks the version of the link against IfVersion, and increments it for a change.
+func (r req) nextVersion(e *entry) error {
+	if r.ifVersion != 0 && r.ifVersion != e.Version {
+		return ErrVersionMismatch
+	}
+	e.Version++
+	return nil
+}
+
+// Update changes the destination of a link, keeping its id, and records the change in its history.
+func (h *Handler) Update(id, url string, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	id = h.normalizeID(id)
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	u, broken, err := h.destination(url)
+	if err != nil {
+		return err
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err = h.db.Update(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		l := linkRef{domain: r.domain, id: id}
+
+		revs, err := history(txn, l)
+		if err != nil {
+			return err
+		}
+
+		rev := Revision{Version: len(revs) + 1, NewURL: u.String(), Actor: r.actor, At: time.Now()}
+		err = h.updateEntry(txn, l.key(), func(e *entry) error {
+			if err := r.nextVersion(e); err != nil {
+				return err
+			}
+			if e.Namespace != "" {
+				// the namespace may restrict the schemes
+				n, err := getNamespace(txn, e.Namespace)
+				if err != nil && !errors.Is(err, ErrNamespaceNotFound) {
+					return err
+				}
+				if n != nil && !allowedScheme(n.Schemes, u.String()) {
+					return ErrSchemeNotAllowed
+				}
+			}
+			rev.OldURL = e.URL
+			if e.URL != u.String() {
+				e.Preview = nil
+			}
+			e.URL = u.String()
+			e.Page = nil
+			e.Canary = nil
+			e.Broken = broken
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+		return txn.Set(historyKey(l.domain, l.id, rev.Version), b)
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	h.sampler.wrote(1)
+	h.refreshPreview(id, r, u.String())
+	h.log(LogStore).Infof("Update entry: %s - %s", id, u.String())
+	return nil
+}
+
+// History returns the changes of the destination of a link, oldest first.
+func (h *Handler) History(id string, opts ...ReqOptions) ([]Revision, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	id = h.normalizeID(id)
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	var revs []Revision
+	err := h.db.View(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		var err error
+		revs, err = history(txn, linkRef{domain: r.domain, id: id})
+		return err
+	})
+	return revs, err
+}
+
+// Revert sets the destination of the link back to what it was before the given version.
+// The revert is itself recorded as a new version.
+func (h *Handler) Revert(id string, version int, opts ...ReqOptions) error {
+	revs, err := h.History(id, opts...)
+	if err != nil {
+		return err
+	}
+	if version < 1 || version > len(revs) {
+		return ErrVersionNotFound
+	}
+	return h.Update(id, revs[version-1].OldURL, opts...)
+}
@@ -0,0 +1,86 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// DefaultIdempotencyWindow is how long the idempotency keys are remembered, unless set with WithIdempotencyWindow.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// WithIdempotencyWindow sets how long the idempotency keys of WithIdempotencyKey are remembered.
+func WithIdempotencyWindow(d time.Duration) Options {
+	return func(h *Handler) {
+		h.idempotencyWindow = d
+	}
+}
+
+// WithIdempotencyKey makes Post return the link created by an earlier call with the same key and owner
+// during the idempotency window, rather than creating another, eg: for clients retrying on a network error.
+// A key given again for another url is rejected with ErrIdempotencyKeyReused.
+func WithIdempotencyKey(key string) ReqOptions {
+	return func(r *req) {
+		r.idempotencyKey = key
+	}
+}
+
+// idempotentPost is the link created with an idempotency key.
+type idempotentPost struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// errIdempotentRace is returned when another call with the same idempotency key created its link first.
+var errIdempotentRace = errors.New("idempotency key used concurrently")
+
+func idempotencyKey(r *req) []byte {
+	return metaKey("idempotency", r.owner, r.idempotencyKey)
+}
+
+func (h *Handler) idempotencyTTL() time.Duration {
+	if h.idempotencyWindow > 0 {
+		return h.idempotencyWindow
+	}
+	return DefaultIdempotencyWindow
+}
+
+// idempotentReplay returns the id of the link created earlier with the idempotency key of the request,
+// empty if none.
+func (h *Handler) idempotentReplay(r *req, url string) (string, error) {
+	var p idempotentPost
+	err := h.db.View(func(txn *badger.Txn) error {
+		b, err := getValue(txn, idempotencyKey(r))
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, &p)
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if p.URL != url {
+		return "", ErrIdempotencyKeyReused
+	}
+	return p.ID, nil
+}
+
+// storeIdempotency remembers the link created in txn with the idempotency key of the request.
+func (h *Handler) storeIdempotency(txn *badger.Txn, r *req, id, url string) error {
+	key := idempotencyKey(r)
+	if _, err := txn.Get(key); err == nil {
+		return errIdempotentRace
+	} else if !errors.Is(err, badger.ErrKeyNotFound) {
+		return err
+	}
+	b, err := json.Marshal(idempotentPost{ID: id, URL: url})
+	if err != nil {
+		return err
+	}
+	return setValue(txn, key, b, h.idempotencyTTL())
+}
@@ -0,0 +1,128 @@
+package coopurl
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sync/atomic"
+)
+
+const (
+	hexAlphabet    = "0123456789abcdef"
+	base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// sequenceBandwidth is the number of sequential ids leased at once from the store.
+// Leased ids that aren't used when the handler is closed are skipped.
+const sequenceBandwidth = 100
+
+type IDMode int
+
+const (
+	// IDHashed generates ids from a hash of the url salted with random bytes. It is the default mode.
+	IDHashed IDMode = iota
+	// IDRandom generates ids made of characters drawn uniformly with crypto/rand,
+	// for links whose ids must not be guessable, eg: password reset links.
+	IDRandom
+	// IDSequential generates ids from a counter encoded in base62, or with the alphabet set by WithAlphabet.
+	// It makes the shortest possible ids, which are easy to guess, the id length options are ignored.
+	IDSequential
+)
+
+// WithIDMode sets how ids are generated.
+func WithIDMode(mode IDMode) Options {
+	return func(h *Handler) {
+		h.idMode = mode
+	}
+}
+
+// generate returns a new id of n characters, before any signature.
+func (h *Handler) generate(url string, n int) (string, error) {
+	switch h.idMode {
+	case IDRandom:
+		return randomID(n, h.alphabet)
+	case IDSequential:
+		return h.sequentialID()
+	default:
+		return generateId(url, n, h.alphabet, h.idHash())
+	}
+}
+
+// randomID returns n characters of alphabet, or hexadecimal if empty, drawn with crypto/rand.
+func randomID(n int, alphabet string) (string, error) {
+	if alphabet == "" {
+		alphabet = hexAlphabet
+	}
+	base := big.NewInt(int64(len(alphabet)))
+
+	out := make([]byte, n)
+	for i := range out {
+		c, err := rand.Int(rand.Reader, base)
+		if err != nil {
+			return "", err
+		}
+		out[i] = alphabet[c.Int64()]
+	}
+	return string(out), nil
+}
+
+func sequenceKey() []byte {
+	return metaKey("sequence", "ids")
+}
+
+// openSequence leases the sequential ids when they are enabled.
+func (h *Handler) openSequence() error {
+	// the sequence is kept during a maintenance
+	if h.idMode != IDSequential || h.primaryURL != "" || atomic.LoadInt32(&h.follower) == 1 {
+		return nil
+	}
+	h.sequenceMu.Lock()
+	defer h.sequenceMu.Unlock()
+	if h.sequence != nil {
+		return nil
+	}
+	var err error
+	h.sequence, err = h.db.GetSequence(sequenceKey(), sequenceBandwidth)
+	return err
+}
+
+func (h *Handler) releaseSequence() {
+	h.sequenceMu.Lock()
+	defer h.sequenceMu.Unlock()
+	if h.sequence == nil {
+		return
+	}
+	if err := h.sequence.Release(); err != nil {
+		h.log(LogStore).Errorf("Couldn't release id sequence: %s", err)
+	}
+	h.sequence = nil
+}
+
+// sequentialID returns the next value of the counter, starting at 1.
+func (h *Handler) sequentialID() (string, error) {
+	h.sequenceMu.Lock()
+	if h.sequence == nil {
+		h.sequenceMu.Unlock()
+		return "", ErrReadOnly
+	}
+	n, err := h.sequence.Next()
+	h.sequenceMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	alphabet := h.alphabet
+	if alphabet == "" {
+		alphabet = base62Alphabet
+	}
+	return encodeCounter(n+1, alphabet), nil
+}
+
+// encodeCounter encodes n in base len(alphabet), most significant digit first.
+func encodeCounter(n uint64, alphabet string) string {
+	base := uint64(len(alphabet))
+	var out []byte
+	for ; n > 0; n /= base {
+		out = append([]byte{alphabet[n%base]}, out...)
+	}
+	return string(out)
+}
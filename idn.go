@@ -0,0 +1,153 @@
+package coopurl
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// WithHomographProtection rejects urls whose domain labels mix letters of several scripts,
+// eg: a latin "coopgo.fr" written with a cyrillic "о", commonly used for phishing.
+func WithHomographProtection() Options {
+	return func(h *Handler) {
+		h.rejectHomographs = true
+	}
+}
+
+// toASCII converts the internationalized host of the url to punycode for storage.
+func (h *Handler) toASCII(u *url.URL) error {
+	host := u.Hostname()
+	if host == "" {
+		return nil
+	}
+	if isASCII(host) {
+		// hosts already in punycode are checked in their unicode form
+		host = strings.ToLower(host)
+		if !h.rejectHomographs || !strings.Contains(host, "xn--") {
+			return nil
+		}
+		unicodeHost, err := idna.ToUnicode(host)
+		if err != nil {
+			return err
+		}
+		if mixesScripts(unicodeHost) {
+			return ErrHomograph
+		}
+		return nil
+	}
+
+	unicodeHost, err := idna.Display.ToUnicode(host)
+	if err != nil {
+		return err
+	}
+	if h.rejectHomographs && mixesScripts(unicodeHost) {
+		return ErrHomograph
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return err
+	}
+	if port := u.Port(); port != "" {
+		ascii = net.JoinHostPort(ascii, port)
+	}
+	u.Host = ascii
+	return nil
+}
+
+// DisplayURL returns the url with its host in its unicode form, to be displayed to users.
+// Urls are stored with punycode hosts, "http://xn--caf-dma.fr" is displayed as "http://café.fr".
+func DisplayURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+
+	host, err := idna.Display.ToUnicode(u.Hostname())
+	if err != nil || host == u.Hostname() {
+		return s
+	}
+	if port := u.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	// url.URL.String() would escape the unicode host
+	return strings.Replace(s, u.Host, host, 1)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+// scripts are checked by mixesScripts, scripts used together by a language share the same group.
+var scripts = []struct {
+	table *unicode.RangeTable
+	group string
+}{
+	{unicode.Latin, "latin"},
+	{unicode.Cyrillic, "cyrillic"},
+	{unicode.Greek, "greek"},
+	{unicode.Armenian, "armenian"},
+	{unicode.Georgian, "georgian"},
+	{unicode.Hebrew, "hebrew"},
+	{unicode.Arabic, "arabic"},
+	{unicode.Devanagari, "devanagari"},
+	{unicode.Thai, "thai"},
+	{unicode.Hangul, "hangul"},
+	{unicode.Han, "han"},
+	{unicode.Hiragana, "hiragana"},
+	{unicode.Katakana, "katakana"},
+}
+
+// mixedScripts are the script groups written together, a label may mix the groups of one of them: Korean mixes
+// hangul and han characters, Japanese han, hiragana and katakana ones.
+var mixedScripts = []map[string]bool{
+	{"hangul": true, "han": true},
+	{"han": true, "hiragana": true, "katakana": true},
+}
+
+// mixesScripts tells if a label of the domain contains letters of more than one script group.
+func mixesScripts(domain string) bool {
+	for _, label := range strings.Split(domain, ".") {
+		groups := map[string]bool{}
+		for _, r := range label {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			for _, s := range scripts {
+				if unicode.Is(s.table, r) {
+					groups[s.group] = true
+					break
+				}
+			}
+		}
+		if len(groups) > 1 && !writtenTogether(groups) {
+			return true
+		}
+	}
+	return false
+}
+
+// writtenTogether tells if the script groups all belong to one of mixedScripts.
+func writtenTogether(groups map[string]bool) bool {
+	for _, mixed := range mixedScripts {
+		together := true
+		for g := range groups {
+			if !mixed[g] {
+				together = false
+				break
+			}
+		}
+		if together {
+			return true
+		}
+	}
+	return false
+}
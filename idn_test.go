@@ -0,0 +1,26 @@
+package coopurl
+
+import "testing"
+
+func TestMixesScripts(t *testing.T) {
+	tests := []struct {
+		domain string
+		mixed  bool
+	}{
+		{"example.com", false},
+		{"漢한.com", false},        // han then hangul
+		{"한漢.com", false},        // hangul then han
+		{"漢ひらカタ.jp", false},      // han, hiragana and katakana
+		{"한カ.com", true},         // hangul and katakana
+		{"カ한.com", true},         // katakana and hangul
+		{"한ひ漢.com", true},        // hangul, hiragana and han
+		{"pаypal.com", true},     // latin and cyrillic
+		{"example.рф", false},    // one script per label
+		{"ex-1ample.com", false}, // digits and hyphens aren't letters
+	}
+	for _, test := range tests {
+		if got := mixesScripts(test.domain); got != test.mixed {
+			t.Errorf("mixesScripts(%q) = %v, want %v", test.domain, got, test.mixed)
+		}
+	}
+}
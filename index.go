@@ -0,0 +1,329 @@
+package coopurl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Built-in secondary indexes of the links, looked up with Lookup.
+const (
+	IndexOwner     = "owner"     // owner given with WithOwner
+	IndexNamespace = "namespace" // namespace of the link
+	IndexTag       = "tag"       // each tag given with WithTags
+	IndexDomain    = "domain"    // domain of the destination, eg: example.com
+	IndexURL       = "url"       // URLHash of the destination
+)
+
+// index is a secondary index of the links, extract returns the values the link is indexed under.
+type index struct {
+	name    string
+	extract func(l linkRef, e entry) []string
+}
+
+var builtinIndexes = []index{
+	{IndexOwner, func(_ linkRef, e entry) []string { return nonEmpty(e.Owner) }},
+	{IndexNamespace, func(_ linkRef, e entry) []string { return nonEmpty(e.Namespace) }},
+	{IndexTag, func(_ linkRef, e entry) []string { return e.Tags }},
+	{IndexDomain, func(_ linkRef, e entry) []string {
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			return nil
+		}
+		return nonEmpty(normalizeDomain(u.Host))
+	}},
+	{IndexURL, func(_ linkRef, e entry) []string {
+		if e.URL == "" {
+			return nil
+		}
+		return []string{URLHash(e.URL)}
+	}},
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// URLHash returns the value of the destination u in the IndexURL index.
+func URLHash(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return hex.EncodeToString(sum[:8])
+}
+
+// WithIndex maintains a secondary index of the links under the values returned by extract, written in the same
+// transactions as the links and expiring with them, so that Lookup finds the links by name and value.
+// Links stored before the index was added are only found once Reindex has run.
+func WithIndex(name string, extract func(e Entry) []string) Options {
+	return Checked(func(h *Handler) error {
+		if name == "" {
+			return optionError("index without name")
+		}
+		for _, i := range h.indexes {
+			if i.name == name {
+				return optionError("index %q already exists", name)
+			}
+		}
+		h.indexes = append(h.indexes, index{name, func(l linkRef, e entry) []string { return extract(e.export(l, 0)) }})
+		return nil
+	})
+}
+
+// indexKey is the key of the link in the index under value, kept with the same expiry as the link so expired
+// links aren't found. The keys are those of the quota indexes, which became the owner and namespace indexes.
+func indexKey(name, value string, l linkRef) []byte {
+	return metaKey("quota", name, value, l.domain, l.id)
+}
+
+func indexPrefix(name, value string) []byte {
+	return metaPrefixKey("quota", name, value)
+}
+
+// indexTerm is a value of a link in an index.
+type indexTerm struct {
+	name, value string
+}
+
+// indexTerms returns the values of the link in the indexes of the handler.
+func (h *Handler) indexTerms(l linkRef, e entry) []indexTerm {
+	var terms []indexTerm
+	seen := map[indexTerm]bool{}
+	for _, i := range h.indexes {
+		for _, v := range i.extract(l, e) {
+			t := indexTerm{i.name, v}
+			if v != "" && !seen[t] {
+				seen[t] = true
+				terms = append(terms, t)
+			}
+		}
+	}
+	return terms
+}
+
+// indexKeys returns the keys of the link in the indexes of the handler.
+func (h *Handler) indexKeys(l linkRef, e entry) [][]byte {
+	var keys [][]byte
+	for _, t := range h.indexTerms(l, e) {
+		keys = append(keys, indexKey(t.name, t.value, l))
+	}
+	return keys
+}
+
+// setIndexes indexes and counts the link until the given badger expiry, 0 for no expiry.
+func (h *Handler) setIndexes(txn *badger.Txn, l linkRef, e entry, expiresAt uint64) error {
+	c := counts{}
+	for _, t := range h.indexTerms(l, e) {
+		k := indexKey(t.name, t.value, l)
+		if err := c.uncount(txn, t, k); err != nil {
+			return err
+		}
+		be := badger.NewEntry(k, nil)
+		be.ExpiresAt = expiresAt
+		if err := txn.SetEntry(be); err != nil {
+			return err
+		}
+		c.add(t, expiresAt, 1)
+	}
+	if err := setExpiring(txn, l, e, expiresAt); err != nil {
+		return err
+	}
+	return c.write(txn)
+}
+
+func (h *Handler) deleteIndexes(txn *badger.Txn, l linkRef, e entry) error {
+	c := counts{}
+	for _, t := range h.indexTerms(l, e) {
+		if err := c.uncount(txn, t, indexKey(t.name, t.value, l)); err != nil {
+			return err
+		}
+		if err := txn.Delete(indexKey(t.name, t.value, l)); err != nil {
+			return err
+		}
+	}
+	if err := deleteExpiring(txn, l, e); err != nil {
+		return err
+	}
+	return c.write(txn)
+}
+
+// updateIndexes moves the link from the indexes of its old entry to those of the new one.
+func (h *Handler) updateIndexes(txn *badger.Txn, l linkRef, old, e entry, expiresAt uint64) error {
+	terms := map[indexTerm]bool{}
+	for _, t := range h.indexTerms(l, e) {
+		terms[t] = true
+	}
+	c := counts{}
+	for _, t := range h.indexTerms(l, old) {
+		if terms[t] {
+			continue
+		}
+		if err := c.uncount(txn, t, indexKey(t.name, t.value, l)); err != nil {
+			return err
+		}
+		if err := txn.Delete(indexKey(t.name, t.value, l)); err != nil {
+			return err
+		}
+	}
+	if err := c.write(txn); err != nil {
+		return err
+	}
+	if !old.ExpiresAt.Equal(e.ExpiresAt) {
+		if err := deleteExpiring(txn, l, old); err != nil {
+			return err
+		}
+	}
+	return h.setIndexes(txn, l, e, expiresAt)
+}
+
+// Lookup returns the links indexed under value in the index with the given name, eg: Lookup(IndexTag, "promo"),
+// newest first.
+func (h *Handler) Lookup(name, value string) ([]Entry, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	if value == "" {
+		return nil, nil
+	}
+
+	var links []Entry
+	err := h.db.View(func(txn *badger.Txn) error {
+		return forEachIndexed(txn, name, value, func(l linkRef, e entry) error {
+			clicks, err := getCounter(txn, clicksKey(l.domain, l.id))
+			if err != nil {
+				return err
+			}
+			links = append(links, e.export(l, clicks))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+	return links, nil
+}
+
+// forEachIndexed calls fn for every link indexed under value in the index with the given name.
+func forEachIndexed(txn *badger.Txn, name, value string, fn func(l linkRef, e entry) error) error {
+	return iterateKeys(txn, indexPrefix(name, value), func(key []byte) error {
+		_, parts := KeyCodec{}.Parse(key)
+		if len(parts) != 4 {
+			return nil
+		}
+		l := linkRef{domain: parts[2], id: parts[3]}
+		b, err := getValue(txn, l.key())
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil // removed by a replica which didn't know the index
+		}
+		if err != nil {
+			return err
+		}
+		e, err := decodeEntry(b)
+		if err != nil {
+			return err
+		}
+		return fn(l, e)
+	})
+}
+
+// Reindex writes the index keys of all the links and counts them again, eg: after adding an index with
+// WithIndex. The links shouldn't be changed meanwhile, as their counts could be lost.
+func (h *Handler) Reindex() error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+
+	var links []indexed
+	var stale [][]byte
+	err := h.db.View(func(txn *badger.Txn) error {
+		err := forEachLink(txn, func(l linkRef, e entry) error {
+			item, err := txn.Get(l.key())
+			if err != nil {
+				return err
+			}
+			links = append(links, indexed{l, e, item.ExpiresAt()})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return iterateKeys(txn, metaPrefixKey("index-count"), func(key []byte) error {
+			stale = append(stale, key)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for len(stale) > 0 {
+		n := len(stale)
+		if n > reindexBatch {
+			n = reindexBatch
+		}
+		err := h.updateRetry(func(txn *badger.Txn) error {
+			for _, k := range stale[:n] {
+				if err := txn.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		stale = stale[n:]
+	}
+
+	for len(links) > 0 {
+		n := len(links)
+		if n > reindexBatch {
+			n = reindexBatch
+		}
+		err := h.updateRetry(func(txn *badger.Txn) error {
+			c := counts{}
+			for _, x := range links[:n] {
+				for _, t := range h.indexTerms(x.l, x.e) {
+					be := badger.NewEntry(indexKey(t.name, t.value, x.l), nil)
+					be.ExpiresAt = x.expiresAt
+					if err := txn.SetEntry(be); err != nil {
+						return err
+					}
+					c.add(t, x.expiresAt, 1)
+				}
+				if err := setExpiring(txn, x.l, x.e, x.expiresAt); err != nil {
+					return err
+				}
+			}
+			return c.write(txn)
+		})
+		if err != nil {
+			return err
+		}
+		links = links[n:]
+	}
+	h.log(LogAdmin).Infof("Reindexed the links")
+	return nil
+}
+
+// indexed is a link to index until its badger expiry.
+type indexed struct {
+	l         linkRef
+	e         entry
+	expiresAt uint64
+}
+
+// reindexBatch is the number of links or counters written per transaction.
+const reindexBatch = 500
@@ -0,0 +1,71 @@
+package coopurl
+
+import (
+	"errors"
+	"time"
+)
+
+// StoreBackend is the backend of the stores of the handlers, given in Info.
+const StoreBackend = "badger"
+
+// started is when the process started, the uptime isn't reset when the store is reopened or moved.
+var started = time.Now()
+
+// Info describes a running handler, for the tools keeping an inventory of the instances of a fleet.
+type Info struct {
+	Store         string          `json:"store"`
+	OpenedAt      time.Time       `json:"opened_at"`      // when the store was last opened, see Reopen
+	UptimeSeconds int64           `json:"uptime_seconds"` // since the process started
+	Links         int64           `json:"links"`          // links created and not deleted, including expired ones
+	ReadOnly      bool            `json:"read_only"`
+	Maintenance   string          `json:"maintenance,omitempty"` // message of the maintenance in progress
+	Features      map[string]bool `json:"features"`              // the optional features, enabled or not
+}
+
+// Info returns the state of the handler and the features enabled by its options.
+func (h *Handler) Info() (*Info, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	h.settingsMu.RLock()
+	rewrites := len(h.rewriteRules) > 0
+	h.settingsMu.RUnlock()
+
+	return &Info{
+		Store:         StoreBackend,
+		OpenedAt:      h.openedAt,
+		UptimeSeconds: int64(time.Since(started).Seconds()),
+		Links:         h.links.get(),
+		ReadOnly:      errors.Is(h.writable(), ErrReadOnly),
+		Maintenance:   h.Maintenance(),
+		Features: map[string]bool{
+			"analytics_events":       h.analytics == AnalyticsFull,
+			"unique_visitors":        h.visitors != VisitorsNone,
+			"async_writes":           h.writes != nil,
+			"sync_writes":            h.syncWrites,
+			"compression":            h.compression != CompressionNone,
+			"cache":                  h.cache != nil,
+			"stale_while_revalidate": h.cache != nil && h.staleWindow > 0,
+			"circuit_breaker":        h.breaker != nil,
+			"cdn":                    h.cdn != nil,
+			"soft_delete":            h.retention > 0,
+			"expiry_grace":           h.grace > 0,
+			"expired_retention":      h.expiredRetention > 0,
+			"validate_destination":   h.validator != nil,
+			"dead_links":             h.deadLinks != nil,
+			"rewrite_rules":          rewrites || h.rewriter != nil,
+			"previews":               h.previews != nil,
+			"unfurl":                 h.unfurl,
+			"thumbnails":             h.thumbnails != nil,
+			"public_stats":           h.publicStats,
+			"stats_sharing":          h.shareSecret != nil,
+			"signed_ids":             h.signSecret != nil,
+			"anomalies":              h.anomalies != nil,
+			"archive":                h.archiver != nil,
+			"replica":                h.primaryURL != "" || h.fallbackURL != "",
+			"standby":                h.standbyURL != "",
+		},
+	}, nil
+}
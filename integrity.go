@@ -0,0 +1,186 @@
+package coopurl
+
+import (
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// linkRecords are the kinds of the records kept per link, whose keys start with the domain and the id of the
+// link. The history isn't one of them, it is kept after the link expires until another link takes its id.
+var linkRecords = []string{
+	"clicks", "bot-clicks", "visitors", "reports", "expiry-notified", "grace-notified", "schedule", "thumbnail",
+	"click", "hourly", "daily", "monthly", "daily-visitors", "visitor", "referrer", "country", "button", "branch", "report",
+}
+
+// IntegrityReport is the result of Verify.
+type IntegrityReport struct {
+	Links           int      `json:"links"`
+	Corrupt         []string `json:"corrupt,omitempty"` // links that can't be decoded, prefixed by "{domain}/" for the links of a domain
+	DanglingIndexes int      `json:"dangling_indexes"`  // index keys of missing links
+	OrphanedRecords int      `json:"orphaned_records"`  // stats and records of missing links
+	Repaired        bool     `json:"repaired"`
+}
+
+// OK reports whether the store has no problem.
+func (r *IntegrityReport) OK() bool {
+	return len(r.Corrupt) == 0 && r.DanglingIndexes == 0 && r.OrphanedRecords == 0
+}
+
+// Verify scans the store for links that can't be decoded, index keys of missing links and records of links
+// missing, eg: expired without an archiver, that aren't kept for a tombstone, an expired tombstone, a pending
+// archive or a campaign.
+// With repair, it deletes them, eg: after an unclean shutdown, so that the handler doesn't fail on them later.
+func (h *Handler) Verify(repair bool) (*IntegrityReport, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	if repair {
+		if err := h.writable(); err != nil {
+			return nil, err
+		}
+	}
+
+	var report IntegrityReport
+	var deletes [][]byte
+	err := h.db.View(func(txn *badger.Txn) error {
+		known := map[linkRef]bool{}
+		live := map[linkRef]bool{}
+		err := scanLinks(txn, func(l linkRef, key, value []byte) error {
+			if _, err := decodeEntry(value); err != nil {
+				report.Corrupt = append(report.Corrupt, l.String())
+				deletes = append(deletes, key)
+				return nil
+			}
+			report.Links++
+			known[l], live[l] = true, true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, kind := range []string{"tombstone", "expired", "archive"} {
+			err := iteratePrefix(txn, metaPrefixKey(kind), func(key string, _ []byte) error {
+				domain, id := splitRef(key)
+				known[linkRef{domain: domain, id: id}] = true
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		err = iteratePrefix(txn, metaPrefixKey("campaign-link"), func(key string, value []byte) error {
+			if parts := splitParts(key); len(parts) == 2 {
+				known[linkRef{domain: string(value), id: parts[1]}] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		prefix := metaPrefixKey("quota")
+		err = iterateKeys(txn, prefix, func(key []byte) error {
+			if parts := splitParts(string(key[len(prefix):])); len(parts) == 4 && !live[linkRef{domain: parts[2], id: parts[3]}] {
+				report.DanglingIndexes++
+				deletes = append(deletes, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, kind := range linkRecords {
+			prefix := metaPrefixKey(kind)
+			err := iterateKeys(txn, prefix, func(key []byte) error {
+				if domain, id := splitRef(string(key[len(prefix):])); !known[linkRef{domain: domain, id: id}] {
+					report.OrphanedRecords++
+					deletes = append(deletes, key)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !repair || report.OK() {
+		return &report, nil
+	}
+
+	wb := h.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range deletes {
+		if err := wb.Delete(k); err != nil {
+			return nil, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return nil, err
+	}
+	report.Repaired = true
+
+	h.sampler.deleted(uint64(len(report.Corrupt)))
+	h.countLinks(-int64(len(report.Corrupt)))
+	h.log(LogAdmin).Infof("Repaired the store: deleted %d corrupt links, %d dangling index keys and %d orphaned records",
+		len(report.Corrupt), report.DanglingIndexes, report.OrphanedRecords)
+	return &report, nil
+}
+
+func (l linkRef) String() string {
+	if l.domain == "" {
+		return l.id
+	}
+	return l.domain + "/" + l.id
+}
+
+// scanLinks calls fn with the key and value of every link of the store, decoded or not.
+func scanLinks(txn *badger.Txn, fn func(l linkRef, key, value []byte) error) error {
+	domains := metaPrefixKey("link")
+	err := iteratePrefix(txn, domains, func(key string, value []byte) error {
+		if !strings.Contains(key, metaPrefix) {
+			return nil
+		}
+		domain, id := splitRef(key)
+		return fn(linkRef{domain: domain, id: id}, append(append([]byte{}, domains...), key...), value)
+	})
+	if err != nil {
+		return err
+	}
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek([]byte{metaPrefix[0] + 1}); it.Valid(); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(linkRef{id: string(item.Key())}, item.KeyCopy(nil), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iterateKeys calls fn with every key starting with prefix, without reading the values.
+func iterateKeys(txn *badger.Txn, prefix []byte, fn func(key []byte) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		if err := fn(it.Item().KeyCopy(nil)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package coopurl
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the maintenance jobs, eg: to disable them with WithoutJobs.
+const (
+	JobPurgeTombstones  = "purge-tombstones"
+	JobRollUp           = "rollup"
+	JobPurgeEvents      = "purge-events"
+	JobArchive          = "archive"
+	JobExpiryNotices    = "expiry-notices"
+	JobScheduledUpdates = "scheduled-updates"
+	JobDeadLinks        = "dead-links"
+	JobOutbox           = "outbox"
+	JobCounts           = "counts"
+)
+
+// DefaultJobJitter is the fraction of their interval by which the runs of the maintenance jobs are delayed
+// at random, unless set with WithJobJitter.
+const DefaultJobJitter = 0.1
+
+// WithoutJobs disables the maintenance jobs with the given names, eg: JobDeadLinks to check the links from
+// another instance only, or JobRollUp to run RollUp from a cron job.
+func WithoutJobs(names ...string) Options {
+	return func(h *Handler) {
+		if h.disabledJobs == nil {
+			h.disabledJobs = map[string]bool{}
+		}
+		for _, n := range names {
+			h.disabledJobs[n] = true
+		}
+	}
+}
+
+// WithJobJitter delays each run of the maintenance jobs by up to the fraction of their interval, drawn at
+// random, so that the instances started together don't all hit their stores at once. 0 disables the jitter.
+func WithJobJitter(fraction float64) Options {
+	return func(h *Handler) {
+		h.jobJitter = fraction
+	}
+}
+
+// JobState is the state of a maintenance job, as returned by Jobs.
+type JobState struct {
+	Name         string        `json:"name"`
+	Interval     time.Duration `json:"interval"`
+	Runs         uint64        `json:"runs"`
+	Failures     uint64        `json:"failures"`
+	LastRun      time.Time     `json:"last_run,omitempty"`
+	LastDuration time.Duration `json:"last_duration,omitempty"`
+	LastError    string        `json:"last_error,omitempty"`
+	NextRun      time.Time     `json:"next_run,omitempty"`
+}
+
+// scheduler keeps the state of the maintenance jobs of the open store.
+type scheduler struct {
+	mu   sync.Mutex
+	jobs map[string]*JobState
+}
+
+func (s *scheduler) reset() {
+	s.mu.Lock()
+	s.jobs = map[string]*JobState{}
+	s.mu.Unlock()
+}
+
+func (s *scheduler) update(name string, fn func(j *JobState)) {
+	s.mu.Lock()
+	if j := s.jobs[name]; j != nil {
+		fn(j)
+	}
+	s.mu.Unlock()
+}
+
+// schedule runs the maintenance job fn at each interval, delayed by the jitter, in a background goroutine
+// until the handler is closed. The runs are skipped while the handler is read-only, as the jobs write to
+// the store. Nothing runs if the job is disabled or the handler is closed.
+func (h *Handler) schedule(name string, interval time.Duration, fn func() error) {
+	if h.disabledJobs[name] {
+		return
+	}
+	h.mu.Lock()
+	done := h.done
+	if done == nil {
+		h.mu.Unlock()
+		return
+	}
+	h.jobs.Add(1)
+	h.mu.Unlock()
+
+	h.scheduler.mu.Lock()
+	h.scheduler.jobs[name] = &JobState{Name: name, Interval: interval}
+	h.scheduler.mu.Unlock()
+
+	go func() {
+		defer h.jobs.Done()
+
+		for {
+			wait := interval
+			if h.jobJitter > 0 {
+				wait += time.Duration(rand.Int63n(int64(float64(interval)*h.jobJitter) + 1))
+			}
+			h.scheduler.update(name, func(j *JobState) { j.NextRun = time.Now().Add(wait) })
+
+			t := time.NewTimer(wait)
+			select {
+			case <-done:
+				t.Stop()
+				return
+			case <-t.C:
+			}
+			if h.writable() != nil {
+				continue
+			}
+
+			start := time.Now()
+			err := fn()
+			h.scheduler.update(name, func(j *JobState) {
+				j.Runs++
+				j.LastRun, j.LastDuration, j.LastError = start, time.Since(start), ""
+				if err != nil {
+					j.Failures++
+					j.LastError = err.Error()
+				}
+			})
+			if err != nil {
+				h.log(LogAdmin).Errorf("The %s job failed: %s", name, err)
+			}
+		}
+	}()
+}
+
+// Jobs returns the state of the maintenance jobs running on the open store, by name. A replica runs none.
+func (h *Handler) Jobs() []JobState {
+	h.scheduler.mu.Lock()
+	jobs := make([]JobState, 0, len(h.scheduler.jobs))
+	for _, j := range h.scheduler.jobs {
+		jobs = append(jobs, *j)
+	}
+	h.scheduler.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs
+}
+
+// WriteJobMetrics writes the runs, failures and last run of the maintenance jobs to w in Prometheus text format.
+func (h *Handler) WriteJobMetrics(w io.Writer) error {
+	jobs := h.Jobs()
+
+	var b strings.Builder
+	b.WriteString("# HELP coopurl_job_runs_total Runs of the maintenance jobs.\n")
+	b.WriteString("# TYPE coopurl_job_runs_total counter\n")
+	for _, j := range jobs {
+		fmt.Fprintf(&b, "coopurl_job_runs_total{job=%q} %d\n", j.Name, j.Runs)
+	}
+	b.WriteString("# HELP coopurl_job_failures_total Failed runs of the maintenance jobs.\n")
+	b.WriteString("# TYPE coopurl_job_failures_total counter\n")
+	for _, j := range jobs {
+		fmt.Fprintf(&b, "coopurl_job_failures_total{job=%q} %d\n", j.Name, j.Failures)
+	}
+	b.WriteString("# HELP coopurl_job_last_run_timestamp_seconds Start of the last run of the maintenance jobs.\n")
+	b.WriteString("# TYPE coopurl_job_last_run_timestamp_seconds gauge\n")
+	for _, j := range jobs {
+		if !j.LastRun.IsZero() {
+			fmt.Fprintf(&b, "coopurl_job_last_run_timestamp_seconds{job=%q} %.3f\n", j.Name, float64(j.LastRun.UnixNano())/1e9)
+		}
+	}
+	b.WriteString("# HELP coopurl_job_last_duration_seconds Duration of the last run of the maintenance jobs.\n")
+	b.WriteString("# TYPE coopurl_job_last_duration_seconds gauge\n")
+	for _, j := range jobs {
+		if !j.LastRun.IsZero() {
+			fmt.Fprintf(&b, "coopurl_job_last_duration_seconds{job=%q} %g\n", j.Name, j.LastDuration.Seconds())
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
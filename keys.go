@@ -0,0 +1,101 @@
+package coopurl
+
+import "strings"
+
+// keyEscape escapes the separators and itself in the parts of the keys.
+const keyEscape = "\x01"
+
+var (
+	partEscaper   = strings.NewReplacer(keyEscape, keyEscape+"\x02", metaPrefix, keyEscape+"\x01")
+	partUnescaper = strings.NewReplacer(keyEscape+"\x02", keyEscape, keyEscape+"\x01", metaPrefix)
+)
+
+// KeyCodec builds and parses the keys of the store, eg: for tools reading a backup.
+//
+// Links without domain are stored with their id as key. The other keys are made of metaPrefix, the kind of
+// the record, then its parts each following metaPrefix, eg: "\x00clicks\x00example.org\x00abcd" for the clicks
+// of the link abcd of example.org. Parts are escaped so that they never contain metaPrefix: the strings chosen
+// by users, aliases, domains, owners or tags, can't end a part early and collide with the keys of another
+// record or index. Parts without "\x00" nor "\x01" are kept as is.
+type KeyCodec struct{}
+
+// Link returns the key of a link in the key space of the given domain.
+func (c KeyCodec) Link(domain, id string) []byte {
+	if domain == "" {
+		return []byte(id)
+	}
+	return c.Record("link", domain, id)
+}
+
+// Record returns the key of a record of the given kind.
+func (KeyCodec) Record(kind string, parts ...string) []byte {
+	n := len(metaPrefix) + len(kind)
+	for _, p := range parts {
+		n += len(metaPrefix) + len(p)
+	}
+	b := make([]byte, 0, n)
+	b = append(b, metaPrefix...)
+	b = append(b, kind...)
+	b = append(b, metaPrefix...)
+	for i, p := range parts {
+		if i > 0 {
+			b = append(b, metaPrefix...)
+		}
+		b = append(b, escapePart(p)...)
+	}
+	return b
+}
+
+// Prefix returns the prefix shared by all the records of the given kind and parts.
+func (c KeyCodec) Prefix(kind string, parts ...string) []byte {
+	if len(parts) == 0 {
+		return c.Record(kind)
+	}
+	return append(c.Record(kind, parts...), metaPrefix...)
+}
+
+// Parse returns the kind and the parts of a key. Links are of the "link" kind with their domain and id as parts.
+func (KeyCodec) Parse(key []byte) (string, []string) {
+	s := string(key)
+	if !strings.HasPrefix(s, metaPrefix) {
+		return "link", []string{"", s}
+	}
+	s = s[len(metaPrefix):]
+	i := strings.Index(s, metaPrefix)
+	if i < 0 {
+		return s, nil
+	}
+	return s[:i], splitParts(s[i+len(metaPrefix):])
+}
+
+func escapePart(p string) string {
+	if !strings.ContainsAny(p, metaPrefix+keyEscape) {
+		return p
+	}
+	return partEscaper.Replace(p)
+}
+
+func unescapePart(p string) string {
+	if !strings.Contains(p, keyEscape) {
+		return p
+	}
+	return partUnescaper.Replace(p)
+}
+
+// splitParts returns the parts of a key suffix following a prefix.
+func splitParts(suffix string) []string {
+	parts := strings.Split(suffix, metaPrefix)
+	for i, p := range parts {
+		parts[i] = unescapePart(p)
+	}
+	return parts
+}
+
+// splitRef returns the domain and the id of the link starting a key suffix.
+func splitRef(suffix string) (string, string) {
+	parts := splitParts(suffix)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,66 @@
+package coopurl
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// WithLanguageURL redirects the clients preferring the given language to url instead of the destination of the
+// link, eg: WithLanguageURL("fr", "https://fr.example.com") for a multilingual campaign. The language is the
+// primary subtag of the Accept-Language header, fr for fr-CA. It can be given for several languages, the
+// destination is the default for the other ones.
+func WithLanguageURL(lang, url string) ReqOptions {
+	return func(r *req) {
+		if r.languages == nil {
+			r.languages = map[string]string{}
+		}
+		r.languages[primaryLanguage(lang)] = url
+	}
+}
+
+// primaryLanguage returns the lowercased primary subtag of a language tag.
+func primaryLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexByte(tag, '-'); i >= 0 {
+		tag = tag[:i]
+	}
+	return tag
+}
+
+// languageURLs validates and normalizes the destinations given with WithLanguageURL.
+// It returns why a destination is broken if it is flagged instead of rejected.
+func (h *Handler) languageURLs(r req) (map[string]string, string, error) {
+	if len(r.languages) == 0 {
+		return nil, "", nil
+	}
+	var broken string
+	urls := make(map[string]string, len(r.languages))
+	for lang, s := range r.languages {
+		if lang == "" || lang == "*" {
+			return nil, "", ErrInvalidLanguage
+		}
+		u, reason, err := h.destination(s)
+		if err != nil {
+			return nil, "", err
+		}
+		if reason != "" && broken == "" {
+			broken = fmt.Sprintf("language %s: %s", lang, reason)
+		}
+		urls[lang] = u.String()
+	}
+	return urls, broken, nil
+}
+
+// localized returns the destination of the link e for the languages accepted by the request.
+func localized(r *http.Request, e entry) string {
+	if len(e.Languages) == 0 {
+		return e.URL
+	}
+	for _, lang := range acceptLanguages(r.Header.Get("Accept-Language")) {
+		if u, ok := e.Languages[lang]; ok {
+			return u
+		}
+	}
+	return e.URL
+}
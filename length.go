@@ -0,0 +1,82 @@
+package coopurl
+
+import (
+	"math"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// WithAdaptiveLength grows the length of the generated ids as the store fills up,
+// keeping the number of links below maxDensity of the possible ids, eg: 0.001 for 0.1%.
+// The length is never shorter than the default length.
+func WithAdaptiveLength(maxDensity float64) Options {
+	return func(h *Handler) {
+		h.maxDensity = maxDensity
+	}
+}
+
+func linksCountKey() []byte {
+	return metaKey("count", "links")
+}
+
+// linkCounter counts the links created and not deleted, persisted in the store.
+// Expired links are still counted, as their ids may be reused by clients that kept them.
+type linkCounter struct {
+	mu sync.Mutex
+	n  int64
+}
+
+func (c *linkCounter) get() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+func (h *Handler) loadLinkCount() error {
+	return h.db.View(func(txn *badger.Txn) error {
+		n, err := getCounter(txn, linksCountKey())
+		h.links.mu.Lock()
+		h.links.n = int64(n)
+		h.links.mu.Unlock()
+		return err
+	})
+}
+
+// countLinks adds delta to the number of links.
+func (h *Handler) countLinks(delta int64) {
+	err := h.updateRetry(func(txn *badger.Txn) error {
+		return add(txn, linksCountKey(), delta)
+	})
+	if err != nil {
+		h.log(LogStore).Warningf("Couldn't count links: %s", err)
+		return
+	}
+
+	h.links.mu.Lock()
+	h.links.n += delta
+	if h.links.n < 0 {
+		h.links.n = 0
+	}
+	h.links.mu.Unlock()
+}
+
+// adaptiveLength returns the shortest length from min keeping the links density below the maximum.
+func (h *Handler) adaptiveLength(min int) int {
+	if h.maxDensity <= 0 {
+		return min
+	}
+
+	size := 16.0
+	if h.alphabet != "" {
+		size = float64(len(h.alphabet))
+	}
+
+	// the link being created is counted
+	n := float64(h.links.get() + 1)
+	length := min
+	for n/math.Pow(size, float64(length)) > h.maxDensity && length < 64 {
+		length++
+	}
+	return length
+}
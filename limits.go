@@ -0,0 +1,53 @@
+package coopurl
+
+import "encoding/json"
+
+const (
+	// DefaultMaxURLLength bounds the destination urls, unless set with WithMaxURLLength.
+	DefaultMaxURLLength = 8 << 10
+	// DefaultMaxMetadataSize bounds the metadata of links, unless set with WithMaxMetadataSize.
+	DefaultMaxMetadataSize = 8 << 10
+)
+
+// WithMaxURLLength sets the maximum length in bytes of the destination urls, DefaultMaxURLLength if 0.
+// Longer urls are rejected with ErrURLTooLong.
+func WithMaxURLLength(n int) Options {
+	return func(h *Handler) {
+		h.maxURLLength = n
+	}
+}
+
+// WithMaxMetadataSize sets the maximum size in bytes of the metadata of a link, DefaultMaxMetadataSize if 0.
+// The metadata are the tags, owner, campaign and page of the link as stored, larger ones are rejected
+// with ErrMetadataTooLarge.
+func WithMaxMetadataSize(n int) Options {
+	return func(h *Handler) {
+		h.maxMetadataSize = n
+	}
+}
+
+func (h *Handler) getMaxURLLength() int {
+	if h.maxURLLength == 0 {
+		return DefaultMaxURLLength
+	}
+	return h.maxURLLength
+}
+
+func (h *Handler) getMaxMetadataSize() int {
+	if h.maxMetadataSize == 0 {
+		return DefaultMaxMetadataSize
+	}
+	return h.maxMetadataSize
+}
+
+// checkMetadata checks the size of the metadata of a new or updated link, the fields the server sets aren't counted.
+func (h *Handler) checkMetadata(e entry) error {
+	b, err := json.Marshal(entry{Languages: e.Languages, Headers: e.Headers, Tags: e.Tags, Campaign: e.Campaign, Owner: e.Owner, Page: e.Page})
+	if err != nil {
+		return err
+	}
+	if len(b) > h.getMaxMetadataSize() {
+		return ErrMetadataTooLarge
+	}
+	return nil
+}
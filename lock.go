@@ -0,0 +1,93 @@
+package coopurl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// maxLockBackoff bounds the wait between two attempts to open a locked store.
+const maxLockBackoff = 5 * time.Second
+
+// WithLockWait retries opening the store while another process holds its lock, for up to d, eg: while the
+// previous server of a rolling restart exits. The lock is released by the system when its process ends, so a
+// LOCK file left by a crash doesn't block the store: only a process still running does.
+func WithLockWait(d time.Duration) Options {
+	return func(h *Handler) {
+		h.lockWait = d
+	}
+}
+
+// lockedStore reports whether badger failed to open the store because another process holds its lock.
+func lockedStore(err error) bool {
+	return errors.Is(err, syscall.EWOULDBLOCK) ||
+		strings.Contains(err.Error(), "Another process is using this Badger database")
+}
+
+// openStore opens the badger store, waiting for its lock with WithLockWait.
+// It returns ErrStoreLocked if another process still holds it.
+func (h *Handler) openStore(opt badger.Options) (*badger.DB, error) {
+	deadline := time.Now().Add(h.lockWait)
+	backoff := 100 * time.Millisecond
+	for {
+		db, err := badger.Open(opt)
+		if err == nil || !lockedStore(err) {
+			return db, err
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("%w: %s", ErrStoreLocked, opt.Dir)
+		}
+		h.log(LogStore).Warningf("Store %s is locked by another process, retrying in %s", opt.Dir, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxLockBackoff {
+			backoff = maxLockBackoff
+		}
+	}
+}
+
+// fallbackSuffix suffixes the directory of the store of a replica fallback.
+const fallbackSuffix = "-replica"
+
+// WithReplicaFallback makes the handler a read-only replica, as WithReplicaOf, when its store is locked by
+// another process, eg: the previous server of a blue/green deploy on the same host, which serves
+// ServeReplication at url. The replica keeps its copy in the store directory suffixed with "-replica".
+// Once the replication stops, eg: as the other process exited, the handler opens the store again and
+// becomes writable if its lock is free.
+func WithReplicaFallback(url, token string) Options {
+	return func(h *Handler) {
+		h.fallbackURL = url
+		h.fallbackToken = token
+	}
+}
+
+// openFallback opens the store of the replica fallback, its primary being the process holding the lock.
+func (h *Handler) openFallback(opt badger.Options) (*badger.DB, error) {
+	h.log(LogStore).Warningf("Store %s is locked by another process, following it at %s", opt.Dir, h.fallbackURL)
+	opt.Dir = opt.Dir + fallbackSuffix
+	opt.ValueDir = opt.Dir
+	db, err := h.openStore(opt)
+	if err != nil {
+		return nil, err
+	}
+	h.primaryURL, h.primaryToken = h.fallbackURL, h.fallbackToken
+	return db, nil
+}
+
+// takeOver opens the store again once a replica fallback stopped following the process holding its lock.
+// The handler stays closed if it can't be opened, as with Reopen.
+func (h *Handler) takeOver() {
+	h.lifecycle.Lock()
+	defer h.lifecycle.Unlock()
+	if atomic.SwapInt32(&h.state, stateClosed) != stateOpen {
+		return // closed meanwhile
+	}
+	h.shutdown()
+	if err := h.open(); err != nil {
+		h.log(LogStore).Errorf("Couldn't open the store after following %s: %s", h.fallbackURL, err)
+	}
+}
@@ -0,0 +1,128 @@
+package coopurl
+
+import (
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	"go.uber.org/zap"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+// ZapLogger adapts a zap logger to the Logger interface.
+func ZapLogger(l *zap.Logger) Logger {
+	return zapLogger{l.WithOptions(zap.AddCallerSkip(1)).Sugar()}
+}
+
+type zapLogger struct {
+	l *zap.SugaredLogger
+}
+
+func (z zapLogger) Errorf(format string, v ...interface{})   { z.l.Errorf(format, v...) }
+func (z zapLogger) Warningf(format string, v ...interface{}) { z.l.Warnf(format, v...) }
+func (z zapLogger) Infof(format string, v ...interface{})    { z.l.Infof(format, v...) }
+func (z zapLogger) Debugf(format string, v ...interface{})   { z.l.Debugf(format, v...) }
+
+// ZerologLogger adapts a zerolog logger to the Logger interface.
+func ZerologLogger(l zerolog.Logger) Logger {
+	return zerologLogger{l}
+}
+
+type zerologLogger struct {
+	l zerolog.Logger
+}
+
+func (z zerologLogger) Errorf(format string, v ...interface{})   { z.l.Error().Msgf(format, v...) }
+func (z zerologLogger) Warningf(format string, v ...interface{}) { z.l.Warn().Msgf(format, v...) }
+func (z zerologLogger) Infof(format string, v ...interface{})    { z.l.Info().Msgf(format, v...) }
+func (z zerologLogger) Debugf(format string, v ...interface{})   { z.l.Debug().Msgf(format, v...) }
+
+// LevelFilter drops the messages of l below the given level.
+func LevelFilter(l Logger, level LogLevel) Logger {
+	return levelFilter{l: l, level: level}
+}
+
+type levelFilter struct {
+	l     Logger
+	level LogLevel
+}
+
+func (f levelFilter) Errorf(format string, v ...interface{}) {
+	if f.level <= LevelError {
+		f.l.Errorf(format, v...)
+	}
+}
+
+func (f levelFilter) Warningf(format string, v ...interface{}) {
+	if f.level <= LevelWarning {
+		f.l.Warningf(format, v...)
+	}
+}
+
+func (f levelFilter) Infof(format string, v ...interface{}) {
+	if f.level <= LevelInfo {
+		f.l.Infof(format, v...)
+	}
+}
+
+func (f levelFilter) Debugf(format string, v ...interface{}) {
+	if f.level <= LevelDebug {
+		f.l.Debugf(format, v...)
+	}
+}
+
+type LogCategory int
+
+const (
+	// LogStore are the messages about links storage: creations, updates, deletions and the badger logs.
+	LogStore LogCategory = iota
+	// LogRedirects are the messages about redirects and lookups, the most numerous ones.
+	LogRedirects
+	// LogAdmin are the messages about moderation, campaigns, namespaces and maintenance jobs.
+	LogAdmin
+
+	logCategories
+)
+
+// WithLogLevel drops the messages of the category below the given level.
+func WithLogLevel(category LogCategory, level LogLevel) Options {
+	return func(h *Handler) {
+		if category >= 0 && category < logCategories {
+			h.logLevels[category] = level
+		}
+	}
+}
+
+// WithRedirectLogSampling only logs one successful redirect out of n, errors are always logged.
+func WithRedirectLogSampling(n int) Options {
+	return func(h *Handler) {
+		h.redirectLogSampling = uint64(n)
+	}
+}
+
+// log returns the logger of the category.
+func (h *Handler) log(category LogCategory) Logger {
+	h.settingsMu.RLock()
+	level := h.logLevels[category]
+	h.settingsMu.RUnlock()
+	if level == LevelDebug {
+		return h.logger
+	}
+	return levelFilter{l: h.logger, level: level}
+}
+
+// sampleRedirectLog tells if the current redirect is logged.
+func (h *Handler) sampleRedirectLog() bool {
+	n := atomic.LoadUint64(&h.redirectLogSampling)
+	if n <= 1 {
+		return true
+	}
+	return atomic.AddUint64(&h.redirectLogCount, 1)%n == 1
+}
@@ -0,0 +1,84 @@
+package coopurl
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// WithBaseURL sets the urls the links are served at, eg: "https://coopgo.fr/r/".
+// Links to these urls are refused, unless chains are resolved with WithChainDepth().
+// Urls without a host are rejected by New.
+func WithBaseURL(urls ...string) Options {
+	return Checked(func(h *Handler) error {
+		for _, s := range urls {
+			u, err := url.Parse(s)
+			if err != nil || u.Host == "" {
+				return optionError("base url %q has no host", s)
+			}
+			u.Host = normalizeDomain(u.Host)
+			h.baseURLs = append(h.baseURLs, u)
+		}
+		return nil
+	})
+}
+
+// WithChainDepth resolves links to other links of the handler up to depth hops,
+// storing the final destination instead of the link. Loops and longer chains are refused with ErrLoop.
+func WithChainDepth(depth int) Options {
+	return func(h *Handler) {
+		h.chainDepth = depth
+	}
+}
+
+// ownID returns the id of the link if u points at one of the handler base urls.
+func (h *Handler) ownID(u *url.URL) (string, bool) {
+	host := normalizeDomain(u.Host)
+	for _, base := range h.baseURLs {
+		if host != base.Host || !strings.HasPrefix(u.Path, base.Path) {
+			continue
+		}
+		_, id := path.Split(strings.TrimSuffix(u.Path, "/"))
+		return id, id != ""
+	}
+	return "", false
+}
+
+// resolveChain follows the links to the handler itself, returning the final destination.
+func (h *Handler) resolveChain(u *url.URL) (*url.URL, error) {
+	visited := map[string]bool{}
+	for hops := 0; ; hops++ {
+		id, ok := h.ownID(u)
+		if !ok {
+			return u, nil
+		}
+		if hops >= h.chainDepth {
+			return nil, ErrLoop
+		}
+
+		key := normalizeDomain(u.Host) + "/" + id
+		if visited[key] {
+			return nil, ErrLoop
+		}
+		visited[key] = true
+
+		e, _, err := h.resolve(normalizeDomain(u.Host), id, nil)
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrLoop
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// pages are served by the shortener, the link can't be skipped
+		if e.Page != nil {
+			return u, nil
+		}
+
+		h.log(LogStore).Infof("Resolving chained link %s to %s", u, e.URL)
+		if u, err = url.Parse(e.URL); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package coopurl
+
+// DefaultMaintenanceMessage is the message of the maintenance mode when none is given.
+const DefaultMaintenanceMessage = "The service is under maintenance, please try again later"
+
+// StartMaintenance puts the handler in maintenance with the message shown to people, DefaultMaintenanceMessage if
+// empty: the links are still served and their clicks counted, but the other writes fail with ErrMaintenance and
+// the maintenance jobs are paused, eg: while the store is backed up or migrated.
+func (h *Handler) StartMaintenance(message string) {
+	if message == "" {
+		message = DefaultMaintenanceMessage
+	}
+	h.maintenance.Store(message)
+	h.log(LogAdmin).Infof("Start maintenance: %s", message)
+}
+
+// EndMaintenance ends the maintenance started with StartMaintenance.
+func (h *Handler) EndMaintenance() {
+	h.maintenance.Store("")
+	h.log(LogAdmin).Infof("End maintenance")
+}
+
+// Maintenance returns the message of the maintenance, empty if the handler isn't in maintenance.
+func (h *Handler) Maintenance() string {
+	message, _ := h.maintenance.Load().(string)
+	return message
+}
@@ -0,0 +1,58 @@
+package coopurl
+
+import (
+	"net/http"
+	"sort"
+)
+
+// messages are the translations of the text of the built-in pages, the info page and the error pages, keyed by
+// their English text, by language. The language of a page is selected by the Accept-Language header.
+var messages = map[string]map[string]string{
+	"fr": {
+		"This link goes to:":                       "Ce lien mène à :",
+		"This link has been disabled (request %s)": "Ce lien a été désactivé (requête %s)",
+		"This link expired on %s (request %s)":     "Ce lien a expiré le %s (requête %s)",
+		"%s (request %s)":                          "%s (requête %s)",
+		"Internal Server Error":                    "Erreur interne du serveur",
+		"Service Unavailable":                      "Service indisponible",
+	},
+}
+
+// languages are the languages of the built-in pages, English, their default, first.
+var languages = func() []string {
+	langs := []string{"en"}
+	for lang := range messages {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs[1:])
+	return langs
+}()
+
+// PreferredLanguage returns the language most preferred by the Accept-Language header of the request among
+// the given ones, eg: "fr" for "fr-CA,en;q=0.8" among en and fr, the first of them if it accepts none.
+func PreferredLanguage(r *http.Request, languages ...string) string {
+	for _, lang := range acceptLanguages(r.Header.Get("Accept-Language")) {
+		for _, l := range languages {
+			if l == lang {
+				return l
+			}
+		}
+	}
+	if len(languages) == 0 {
+		return ""
+	}
+	return languages[0]
+}
+
+// translate returns the text s of a built-in page in the language lang, s itself if it isn't translated.
+func translate(lang, s string) string {
+	if t, ok := messages[lang][s]; ok {
+		return t
+	}
+	return s
+}
+
+// requestLanguage returns the language of the built-in pages answered to the request.
+func requestLanguage(r *http.Request) string {
+	return PreferredLanguage(r, languages...)
+}
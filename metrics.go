@@ -0,0 +1,168 @@
+package coopurl
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of the redirect latency histogram.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// otherDomain is the label of the destinations outside the allowlist of RedirectMetrics.
+const otherDomain = "other"
+
+// exemplar is the last redirect observed in a bucket, with the trace it belongs to.
+type exemplar struct {
+	traceID string
+	value   float64
+	at      time.Time
+}
+
+type redirectCount struct {
+	domain string
+	status int
+}
+
+// RedirectMetrics is a RedirectObserver counting the redirects per destination domain and status, and their
+// latency in a histogram whose buckets hold the trace id of their last redirect as exemplar. The domains are
+// the ones of the allowlist, and their subdomains, the others are counted as "other" so that the cardinality
+// stays bounded. It serves the metrics in Prometheus text format, with the exemplars if OpenMetrics is accepted.
+type RedirectMetrics struct {
+	domains []string
+
+	mu        sync.Mutex
+	counts    map[redirectCount]uint64
+	buckets   []uint64 // cumulated, the last one is +Inf
+	exemplars []exemplar
+	sum       float64
+	count     uint64
+}
+
+// NewRedirectMetrics returns metrics counting the redirects to the given domains, eg: "example.org".
+func NewRedirectMetrics(domains ...string) *RedirectMetrics {
+	m := &RedirectMetrics{
+		counts:    map[redirectCount]uint64{},
+		buckets:   make([]uint64, len(latencyBuckets)+1),
+		exemplars: make([]exemplar, len(latencyBuckets)+1),
+	}
+	for _, d := range domains {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			m.domains = append(m.domains, d)
+		}
+	}
+	return m
+}
+
+// domain returns the label of the host, the allowlisted domain it belongs to or "other".
+func (m *RedirectMetrics) domain(host string) string {
+	host = strings.ToLower(host)
+	for _, d := range m.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return d
+		}
+	}
+	return otherDomain
+}
+
+// traceID returns the trace of the request from its W3C traceparent header, its request id otherwise.
+func traceID(r *http.Request, rd Redirect) string {
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) == 4 && len(parts[1]) == 32 {
+		return parts[1]
+	}
+	return rd.RequestID
+}
+
+func (m *RedirectMetrics) ObserveRedirect(r *http.Request, rd Redirect) {
+	domain := "" // pages have no destination
+	if rd.Host != "" {
+		domain = m.domain(rd.Host)
+	}
+	v := rd.Latency.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, v)
+	trace := traceID(r, rd)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[redirectCount{domain: domain, status: rd.Status}]++
+	for j := i; j < len(m.buckets); j++ {
+		m.buckets[j]++
+	}
+	if trace != "" {
+		m.exemplars[i] = exemplar{traceID: trace, value: v, at: time.Now()}
+	}
+	m.sum += v
+	m.count++
+}
+
+// ServeHTTP writes the metrics, eg: for Prometheus to scrape them at /metrics.
+func (m *RedirectMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	m.Write(w, openMetrics)
+}
+
+// Write writes the metrics to w in Prometheus text format, or OpenMetrics with the exemplars.
+func (m *RedirectMetrics) Write(w io.Writer, openMetrics bool) error {
+	m.mu.Lock()
+	counts := make([]redirectCount, 0, len(m.counts))
+	values := make(map[redirectCount]uint64, len(m.counts))
+	for c, n := range m.counts {
+		counts = append(counts, c)
+		values[c] = n
+	}
+	buckets := append([]uint64(nil), m.buckets...)
+	exemplars := append([]exemplar(nil), m.exemplars...)
+	sum, count := m.sum, m.count
+	m.mu.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].domain != counts[j].domain {
+			return counts[i].domain < counts[j].domain
+		}
+		return counts[i].status < counts[j].status
+	})
+
+	var b strings.Builder
+	family := "coopurl_redirects_total"
+	if openMetrics {
+		family = "coopurl_redirects"
+	}
+	fmt.Fprintf(&b, "# HELP %s Redirects served per destination domain, empty for pages, and status.\n", family)
+	fmt.Fprintf(&b, "# TYPE %s counter\n", family)
+	for _, c := range counts {
+		fmt.Fprintf(&b, "coopurl_redirects_total{domain=%q,status=\"%d\"} %d\n", c.domain, c.status, values[c])
+	}
+
+	b.WriteString("# HELP coopurl_redirect_duration_seconds Latency of the redirects.\n")
+	b.WriteString("# TYPE coopurl_redirect_duration_seconds histogram\n")
+	for i, n := range buckets {
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = strconv.FormatFloat(latencyBuckets[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(&b, "coopurl_redirect_duration_seconds_bucket{le=%q} %d", le, n)
+		if e := exemplars[i]; openMetrics && e.traceID != "" {
+			fmt.Fprintf(&b, " # {trace_id=%q} %g %.3f", e.traceID, e.value, float64(e.at.UnixNano())/1e9)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "coopurl_redirect_duration_seconds_sum %g\n", sum)
+	fmt.Fprintf(&b, "coopurl_redirect_duration_seconds_count %d\n", count)
+	if openMetrics {
+		b.WriteString("# EOF\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,120 @@
+package coopurl
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// movePendingWrites bounds the writes in flight while a store is copied by MoveTo.
+const movePendingWrites = 256
+
+// MoveTo moves the store to the directory at path, which must be empty or not exist, eg: off the default
+// /tmp/badger. The store is copied while the handler serves as usual, then the operations wait while the
+// writes made during the copy are copied too and the handler switches to the new store, it keeps the old one
+// if the copy fails. The old directory is left as is, to be removed once the move is checked.
+func (h *Handler) MoveTo(path string) error {
+	if err := checkEmptyDir(path); err != nil {
+		return err
+	}
+	if abs, _ := filepath.Abs(path); abs == h.absPath() {
+		return optionError("the store is already at %s", path)
+	}
+
+	if err := h.ready(); err != nil {
+		return err
+	}
+	opt := badger.DefaultOptions(path).WithLogger(h.log(LogStore)).WithSyncWrites(h.syncWrites)
+	db, err := badger.Open(opt)
+	if err != nil {
+		h.release()
+		return err
+	}
+	since, err := copyStore(h.db, db, 0)
+	h.release()
+	if err != nil {
+		db.Close()
+		return err
+	}
+	h.log(LogStore).Infof("Copied the store to %s, switching to it", path)
+
+	// The operations wait on the inflight lock while the handler switches.
+	h.lifecycle.Lock()
+	defer h.lifecycle.Unlock()
+	if atomic.LoadInt32(&h.state) != stateOpen {
+		db.Close()
+		return ErrClosed
+	}
+	h.stopBackground()
+	h.inflight.Lock()
+	defer h.inflight.Unlock()
+	h.drain()
+
+	_, err = copyStore(h.db, db, since)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := h.db.Close(); cerr != nil {
+		h.log(LogStore).Errorf("Couldn't close the store: %s", cerr)
+	}
+	old := h.getPath()
+	if err == nil {
+		h.path = path
+	}
+	if oerr := h.open(); oerr != nil {
+		atomic.StoreInt32(&h.state, stateClosed)
+		return oerr
+	}
+	if err != nil {
+		return err
+	}
+	h.log(LogStore).Infof("Moved the store from %s to %s", old, path)
+	return nil
+}
+
+func (h *Handler) absPath() string {
+	abs, _ := filepath.Abs(h.getPath())
+	return abs
+}
+
+// checkEmptyDir returns ErrInvalidOption if there is anything at path but an empty directory.
+func checkEmptyDir(path string) error {
+	entries, err := os.ReadDir(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return optionError("%s isn't empty", path)
+	}
+	return nil
+}
+
+// copyStore copies the keys of src written after the version since to dst, and returns the version to copy
+// the keys written next from.
+func copyStore(src, dst *badger.DB, since uint64) (uint64, error) {
+	r, w := io.Pipe()
+	var version uint64
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		version, err = src.Backup(w, since)
+		w.CloseWithError(err)
+		done <- err
+	}()
+	err := dst.Load(r, movePendingWrites)
+	r.CloseWithError(err) // stops the backup if the load failed
+	if berr := <-done; err == nil {
+		err = berr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
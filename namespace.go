@@ -0,0 +1,152 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Namespace is a group of links served on their own domain.
+// Links created with WithNamespace() are stored in the key space of the namespace domain,
+// so the same id can be used by several namespaces without conflicts.
+type Namespace struct {
+	Name      string    `json:"name"`
+	Domain    string    `json:"domain,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// NotifyBefore is how long before their expiry the owners of the links are warned, set with WithNamespaceExpiryNotice.
+	NotifyBefore time.Duration `json:"notify_before,omitempty"`
+
+	// Defaults of the links of the namespace, overriding the ones of the handler
+	TTL            time.Duration `json:"ttl,omitempty"`             // set with WithNamespaceTTL
+	Length         int           `json:"length,omitempty"`          // set with WithNamespaceLength
+	RedirectStatus int           `json:"redirect_status,omitempty"` // set with WithNamespaceRedirectStatus
+	Schemes        []string      `json:"schemes,omitempty"`         // set with WithNamespaceSchemes
+}
+
+type NamespaceOptions func(*Namespace)
+
+// WithNamespaceDomain sets the domain the namespace links are served on, eg: "go.coopgo.fr".
+func WithNamespaceDomain(domain string) NamespaceOptions {
+	return func(n *Namespace) {
+		n.Domain = normalizeDomain(domain)
+	}
+}
+
+func namespaceKey(name string) []byte {
+	return metaKey("namespace", name)
+}
+
+// linkKey is the key of a link in the key space of the given domain.
+// Links without domain are stored with their id as key.
+func linkKey(domain, id string) []byte {
+	return KeyCodec{}.Link(domain, id)
+}
+
+// normalizeDomain lowercases a domain or host and removes its port.
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+	return strings.TrimSuffix(domain, ".")
+}
+
+func requestDomain(r *http.Request) string {
+	return normalizeDomain(r.Host)
+}
+
+// CreateNamespace creates a new namespace.
+func (h *Handler) CreateNamespace(name string, opts ...NamespaceOptions) (*Namespace, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return nil, err
+	}
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+
+	n := Namespace{Name: name, CreatedAt: time.Now()}
+	for _, opt := range opts {
+		opt(&n)
+	}
+	if strings.Contains(n.Domain, metaPrefix) {
+		return nil, ErrInvalidName
+	}
+	if err := n.checkDefaults(); err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(namespaceKey(name)); err == nil {
+			return ErrNamespaceExists
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		return txn.Set(namespaceKey(name), b)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.log(LogAdmin).Infof("New namespace: %s (domain: %s)", name, n.Domain)
+	return &n, nil
+}
+
+// GetNamespace returns the namespace with the given name.
+func (h *Handler) GetNamespace(name string) (*Namespace, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	var n *Namespace
+	err := h.db.View(func(txn *badger.Txn) error {
+		var err error
+		n, err = getNamespace(txn, name)
+		return err
+	})
+	return n, err
+}
+
+func getNamespace(txn *badger.Txn, name string) (*Namespace, error) {
+	b, err := getValue(txn, namespaceKey(name))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNamespaceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var n Namespace
+	if err := json.Unmarshal(b, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// resolveDomain sets the domain of the request from its namespace when it isn't given explicitly.
+func resolveDomain(txn *badger.Txn, r *req) error {
+	if r.namespace == "" || r.domain != "" {
+		return nil
+	}
+
+	n, err := r.getNamespace(txn)
+	if err != nil {
+		return err
+	}
+	r.domain = n.Domain
+	return nil
+}
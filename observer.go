@@ -0,0 +1,61 @@
+package coopurl
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Redirect is a redirect request served by the Handler, as given to the RedirectObserver set with
+// WithRedirectObserver. Requests for links that don't exist or can't be read are observed too.
+type Redirect struct {
+	ID        string
+	Domain    string
+	RequestID string
+	Host      string // host of the destination, empty for pages
+	Status    int
+	Latency   time.Duration
+	CacheHit  bool   // the link was read from the cache set with WithCache
+	Branch    string // BranchStable or BranchCanary for the links with a canary, see StartCanary
+}
+
+// RedirectObserver receives a Redirect per redirect request, eg: to feed metrics or an analytics pipeline.
+// ObserveRedirect is called once the response is written, in the goroutine serving the request, so it should
+// hand the redirect over rather than block.
+type RedirectObserver interface {
+	ObserveRedirect(r *http.Request, rd Redirect)
+}
+
+// RedirectObserverFunc is a function used as a RedirectObserver.
+type RedirectObserverFunc func(r *http.Request, rd Redirect)
+
+func (f RedirectObserverFunc) ObserveRedirect(r *http.Request, rd Redirect) { f(r, rd) }
+
+// WithRedirectObserver adds an observer of the redirects.
+func WithRedirectObserver(o RedirectObserver) Options {
+	return func(h *Handler) {
+		h.observers = append(h.observers, o)
+	}
+}
+
+// RedirectLogger is a RedirectObserver logging a line per redirect request with l.
+func RedirectLogger(l Logger) RedirectObserver {
+	return RedirectObserverFunc(func(r *http.Request, rd Redirect) {
+		l.Infof("Redirect id=%s domain=%s host=%s status=%d latency=%s cache_hit=%t request=%s",
+			rd.ID, rd.Domain, rd.Host, rd.Status, rd.Latency, rd.CacheHit, rd.RequestID)
+	})
+}
+
+// observeRedirect sends the redirect to the observers.
+func (h *Handler) observeRedirect(r *http.Request, rd Redirect, e entry, start time.Time) {
+	rd.RequestID = RequestIDFrom(r.Context())
+	rd.Latency = time.Since(start)
+	if e.URL != "" {
+		if u, err := url.Parse(e.URL); err == nil {
+			rd.Host = u.Hostname()
+		}
+	}
+	for _, o := range h.observers {
+		o.ObserveRedirect(r, rd)
+	}
+}
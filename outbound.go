@@ -0,0 +1,70 @@
+package coopurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// errPrivateAddress is returned when a destination resolves to an address that isn't public.
+var errPrivateAddress = errors.New("destination resolves to a private address")
+
+// publicClient fetches the destinations of links, which are chosen by users. It only connects to public
+// addresses, checked once resolved, so that links can't be used to read the services of the local network or
+// the metadata endpoints of cloud providers. Proxies aren't used, as they would connect in its place.
+var publicClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   dialPublic,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	},
+}
+
+// dialPublic is the Control of the dialer of publicClient, called with the resolved address.
+func dialPublic(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, host)
+	}
+	return nil
+}
+
+// publicIP tells if ip is neither loopback, private, link-local, multicast nor unspecified.
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// checkPublicHost resolves the host of the url u and fails if one of its addresses isn't public, for the
+// destinations fetched by another service, eg: a ThumbnailProvider.
+func checkPublicHost(ctx context.Context, u string) error {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, pu.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			return fmt.Errorf("%w: %s", errPrivateAddress, a.IP)
+		}
+	}
+	return nil
+}
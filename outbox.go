@@ -0,0 +1,260 @@
+package coopurl
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Events queued in the outbox.
+const (
+	EventAbuseReport  = "abuse_report"  // an AbuseReport
+	EventExpiryNotice = "expiry_notice" // an ExpiryNotice
+	EventPurge        = "purge"         // the surrogate keys of the changed links, with WithCDN
+)
+
+const (
+	DefaultOutboxAttempts = 10
+	DefaultOutboxBackoff  = 30 * time.Second
+)
+
+const (
+	outboxInterval   = 10 * time.Second
+	outboxBatch      = 100
+	outboxMaxBackoff = time.Hour
+	outboxTimeout    = 30 * time.Second
+)
+
+// Delivery is an event of the outbox, waiting to be delivered or given up as a dead letter.
+type Delivery struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`   // eg: EventAbuseReport
+	Payload   json.RawMessage `json:"payload"` // the event as JSON
+	CreatedAt time.Time       `json:"created_at"`
+	Attempts  int             `json:"attempts"`
+	NextAt    time.Time       `json:"next_at"` // next attempt
+	LastError string          `json:"last_error,omitempty"`
+}
+
+// Outbox delivers the events of the handler, eg: to webhooks or a broker. The events are stored with the changes
+// causing them, then delivered by a background job, retried with an exponential backoff, until Deliver succeeds
+// or they have been tried MaxAttempts times, then they are kept as dead letters.
+type Outbox struct {
+	Deliver     func(ctx context.Context, d Delivery) error
+	Events      []string      // events queued, all of them if empty
+	MaxAttempts int           // DefaultOutboxAttempts if 0
+	Backoff     time.Duration // delay before the first retry, doubled at each attempt up to an hour, DefaultOutboxBackoff if 0
+}
+
+// WithOutbox queues the abuse reports, expiry notices and cdn purges of the handler in the outbox of its store,
+// delivered with o, so that they aren't lost while the receiver is down. The notifiers and the purge hook are
+// still called.
+func WithOutbox(o Outbox) Options {
+	if o.MaxAttempts == 0 {
+		o.MaxAttempts = DefaultOutboxAttempts
+	}
+	if o.Backoff == 0 {
+		o.Backoff = DefaultOutboxBackoff
+	}
+	return func(h *Handler) {
+		h.outbox = &o
+	}
+}
+
+// queues tells if the outbox queues the event.
+func (o *Outbox) queues(event string) bool {
+	if o == nil {
+		return false
+	}
+	if len(o.Events) == 0 {
+		return true
+	}
+	for _, e := range o.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func outboxKey(id string) []byte {
+	return metaKey("outbox", id)
+}
+
+func deadLetterKey(id string) []byte {
+	return metaKey("outbox-dead", id)
+}
+
+// timeID returns a new unique id, ordered by creation time, eg: of a delivery.
+func timeID(now time.Time) string {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint64(b, uint64(now.UnixNano()))
+	binary.BigEndian.PutUint32(b[8:], rand.Uint32())
+	return hex.EncodeToString(b)
+}
+
+// enqueue stores the event in txn if the outbox queues it.
+func (h *Handler) enqueue(txn *badger.Txn, event string, v interface{}) error {
+	if !h.outbox.queues(event) {
+		return nil
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	d := Delivery{ID: timeID(now), Event: event, Payload: payload, CreatedAt: now, NextAt: now}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return txn.Set(outboxKey(d.ID), b)
+}
+
+// deliverOutbox delivers the events of the outbox due for an attempt.
+func (h *Handler) deliverOutbox() error {
+	now := time.Now()
+	var due []Delivery
+	err := h.db.View(func(txn *badger.Txn) error {
+		return iteratePrefix(txn, metaPrefixKey("outbox"), func(_ string, v []byte) error {
+			var d Delivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			if !d.NextAt.After(now) && len(due) < outboxBatch {
+				due = append(due, d)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		ctx, cancel := context.WithTimeout(context.Background(), outboxTimeout)
+		err := h.outbox.Deliver(ctx, d)
+		cancel()
+		if err == nil {
+			if err := h.updateRetry(func(txn *badger.Txn) error { return txn.Delete(outboxKey(d.ID)) }); err != nil {
+				return err
+			}
+			continue
+		}
+
+		d.Attempts++
+		d.LastError = err.Error()
+		backoff := h.outbox.Backoff << (d.Attempts - 1)
+		if backoff > outboxMaxBackoff || backoff <= 0 {
+			backoff = outboxMaxBackoff
+		}
+		d.NextAt = time.Now().Add(backoff).UTC()
+		key := outboxKey(d.ID)
+		if d.Attempts >= h.outbox.MaxAttempts {
+			h.log(LogAdmin).Errorf("Give up the delivery of %s event %s after %d attempts: %s", d.Event, d.ID, d.Attempts, err)
+			key = deadLetterKey(d.ID)
+		} else {
+			h.log(LogAdmin).Warningf("Couldn't deliver %s event %s, retrying at %s: %s", d.Event, d.ID, d.NextAt.Format(time.RFC3339), err)
+		}
+		b, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		err = h.updateRetry(func(txn *badger.Txn) error {
+			if err := txn.Delete(outboxKey(d.ID)); err != nil {
+				return err
+			}
+			return txn.Set(key, b)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listDeliveries returns the deliveries of the records of the given kind, oldest first.
+func (h *Handler) listDeliveries(kind string) ([]Delivery, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	var ds []Delivery
+	err := h.db.View(func(txn *badger.Txn) error {
+		return iteratePrefix(txn, metaPrefixKey(kind), func(_ string, v []byte) error {
+			var d Delivery
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			ds = append(ds, d)
+			return nil
+		})
+	})
+	sort.Slice(ds, func(i, j int) bool { return ds[i].ID < ds[j].ID })
+	return ds, err
+}
+
+// PendingDeliveries returns the events of the outbox waiting to be delivered, oldest first.
+func (h *Handler) PendingDeliveries() ([]Delivery, error) {
+	return h.listDeliveries("outbox")
+}
+
+// DeadLetters returns the events of the outbox given up after their last attempt, oldest first.
+func (h *Handler) DeadLetters() ([]Delivery, error) {
+	return h.listDeliveries("outbox-dead")
+}
+
+// RetryDeadLetter queues the dead letter with the given id again, for MaxAttempts more attempts.
+func (h *Handler) RetryDeadLetter(id string) error {
+	return h.updateDeadLetter(id, func(txn *badger.Txn, d Delivery) error {
+		d.Attempts, d.NextAt = 0, time.Now().UTC()
+		b, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		return txn.Set(outboxKey(d.ID), b)
+	})
+}
+
+// DeleteDeadLetter deletes the dead letter with the given id.
+func (h *Handler) DeleteDeadLetter(id string) error {
+	return h.updateDeadLetter(id, func(*badger.Txn, Delivery) error { return nil })
+}
+
+// updateDeadLetter removes the dead letter with the given id, calling fn with it in the same transaction.
+func (h *Handler) updateDeadLetter(id string, fn func(txn *badger.Txn, d Delivery) error) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+
+	err := h.db.Update(func(txn *badger.Txn) error {
+		b, err := getValue(txn, deadLetterKey(id))
+		if err != nil {
+			return err
+		}
+		var d Delivery
+		if err := json.Unmarshal(b, &d); err != nil {
+			return err
+		}
+		if err := txn.Delete(deadLetterKey(id)); err != nil {
+			return err
+		}
+		return fn(txn, d)
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
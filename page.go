@@ -0,0 +1,270 @@
+package coopurl
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// maxButtons bounds the buttons of a page.
+const maxButtons = 50
+
+// Page is a landing page listing links, served in place of a redirect, eg: a link in a bio.
+type Page struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Buttons     []Button `json:"buttons"`
+}
+
+// Button is a link of a page.
+type Button struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// PageData is the data page templates are executed with.
+type PageData struct {
+	ID          string
+	Title       string
+	Description string
+	Buttons     []PageButton
+}
+
+// PageButton is a button as rendered, Href counts the click before redirecting to the button url.
+type PageButton struct {
+	Label string
+	URL   string
+	Href  string
+}
+
+var defaultPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<meta property="og:title" content="{{.Title}}">
+{{with .Description}}<meta property="og:description" content="{{.}}">
+<meta name="description" content="{{.}}">
+{{end}}<style>
+body{font-family:sans-serif;max-width:32em;margin:2em auto;padding:0 1em;text-align:center}
+a{display:block;margin:1em 0;padding:1em;border:1px solid #333;border-radius:.5em;color:inherit;text-decoration:none}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Description}}<p>{{.}}</p>
+{{end}}{{range .Buttons}}<a href="{{.Href}}">{{.Label}}</a>
+{{end}}</body>
+</html>
+`))
+
+// WithPageTemplate renders pages with t instead of the default template, t is executed with a PageData.
+func WithPageTemplate(t *template.Template) Options {
+	return func(h *Handler) {
+		h.pageTemplate = t
+	}
+}
+
+func buttonsPrefix(l linkRef) []byte {
+	return metaPrefixKey("button", l.domain, l.id)
+}
+
+func buttonKey(l linkRef, n int) []byte {
+	return append(buttonsPrefix(l), strconv.Itoa(n)...)
+}
+
+// PostPage stores a page and returns its id, with the same options as Post.
+// Get returns an empty url for pages, their buttons are returned by GetEntry.
+func (h *Handler) PostPage(p Page, opts ...ReqOptions) (string, error) {
+	if err := h.ready(); err != nil {
+		return "", err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return "", err
+	}
+
+	p, broken, err := h.page(p)
+	if err != nil {
+		return "", err
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	linkTTL := r.ttl
+	if linkTTL == 0 {
+		linkTTL = h.TTL
+	}
+
+	e := entry{
+		Page:      &p,
+		Tags:      r.tags,
+		Owner:     r.owner,
+		Broken:    broken,
+		NoIndex:   r.noIndex,
+		Public:    r.public,
+		CreatedAt: time.Now(),
+	}
+	if err := r.setDraft(&e); err != nil {
+		return "", err
+	}
+
+	id, ttl, err := h.create(p.Title, e, r, linkTTL)
+	if err != nil {
+		return "", err
+	}
+	if r.dryRun {
+		return id, nil
+	}
+
+	h.sampler.wrote(1)
+	h.countLinks(1)
+	if ttl != 0 {
+		h.log(LogStore).Infof("New page: %s - %d buttons (ttl: %s)", id, len(p.Buttons), ttl)
+	} else {
+		h.log(LogStore).Infof("New page: %s - %d buttons", id, len(p.Buttons))
+	}
+	return id, nil
+}
+
+// UpdatePage replaces the page of a link, links redirecting somewhere become pages.
+// The click counts of the buttons are kept by position.
+func (h *Handler) UpdatePage(id string, p Page, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	id = h.normalizeID(id)
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	p, broken, err := h.page(p)
+	if err != nil {
+		return err
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err = h.db.Update(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		return h.updateEntry(txn, linkKey(r.domain, id), func(e *entry) error {
+			if err := r.nextVersion(e); err != nil {
+				return err
+			}
+			e.URL = ""
+			e.Page = &p
+			e.Preview = nil
+			e.Broken = broken
+			return h.checkMetadata(*e)
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	h.sampler.wrote(1)
+	h.log(LogStore).Infof("Update page: %s - %d buttons", id, len(p.Buttons))
+	return nil
+}
+
+// page validates a page and normalizes the urls of its buttons like the destinations of links.
+// It returns why a button is broken if its destination is flagged instead of rejected.
+func (h *Handler) page(p Page) (Page, string, error) {
+	if p.Title == "" || len(p.Buttons) == 0 || len(p.Buttons) > maxButtons {
+		return Page{}, "", ErrInvalidPage
+	}
+	p.Title = clip(p.Title)
+	p.Description = clip(p.Description)
+
+	var broken string
+	buttons := make([]Button, len(p.Buttons))
+	for i, b := range p.Buttons {
+		if b.Label == "" {
+			return Page{}, "", ErrInvalidPage
+		}
+		u, reason, err := h.destination(b.URL)
+		if err != nil {
+			return Page{}, "", err
+		}
+		if reason != "" && broken == "" {
+			broken = fmt.Sprintf("button %d: %s", i, reason)
+		}
+		buttons[i] = Button{Label: clip(b.Label), URL: u.String()}
+	}
+	p.Buttons = buttons
+	return p, broken, nil
+}
+
+// servePage renders the page of a link, or redirects to the button given by the b query parameter.
+// It tells if the request is a view of the page, to be counted as a click of the link.
+func (h *Handler) servePage(w http.ResponseWriter, r *http.Request, l linkRef, e entry) bool {
+	rid := RequestIDFrom(r.Context())
+
+	if b := r.URL.Query().Get("b"); b != "" {
+		n, err := strconv.Atoi(b)
+		if err != nil || n < 0 || n >= len(e.Page.Buttons) {
+			w.WriteHeader(http.StatusNotFound)
+			return false
+		}
+		u := e.Page.Buttons[n].URL
+		if err := h.redirect(w, r, u, h.redirectStatusOf(e)); err != nil {
+			h.log(LogRedirects).Errorf("Couldn't redirect to %s (request %s)", u, rid)
+			serveError(w, r, http.StatusInternalServerError)
+			return false
+		}
+		if r.Method == http.MethodHead || h.writable() != nil || h.botKind(r) != NotBot {
+			return false
+		}
+		err = h.withTimeout(func() error {
+			return h.updateRetry(func(txn *badger.Txn) error {
+				return incr(txn, buttonKey(l, n), 1)
+			})
+		})
+		if err != nil {
+			h.log(LogRedirects).Warningf("Couldn't count click on button %d of %s (request %s): %s", n, l.id, rid, err)
+		}
+		return false
+	}
+
+	data := PageData{ID: l.id, Title: e.Page.Title, Description: e.Page.Description}
+	for i, b := range e.Page.Buttons {
+		data.Buttons = append(data.Buttons, PageButton{Label: b.Label, URL: b.URL, Href: "?b=" + strconv.Itoa(i)})
+	}
+
+	if NotModified(w, r, etag(data), e.modified()) {
+		return true
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return true
+	}
+	t := h.pageTemplate
+	if t == nil {
+		t = defaultPageTemplate
+	}
+	if err := t.Execute(w, data); err != nil {
+		h.log(LogRedirects).Warningf("Couldn't render the page %s (request %s): %s", l.id, rid, err)
+	}
+	return true
+}
@@ -0,0 +1,236 @@
+package coopurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"golang.org/x/net/html"
+)
+
+const (
+	DefaultPreviewTimeout = 5 * time.Second
+	DefaultPreviewMaxSize = 512 << 10
+)
+
+// maxPreviewText bounds the stored title and description of previews.
+const maxPreviewText = 500
+
+// Preview is the metadata of a destination page, to show rich previews of a link.
+type Preview struct {
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Image       string    `json:"image,omitempty"` // absolute url of the og:image
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// previewer fetches the previews of destinations.
+type previewer struct {
+	client  *http.Client
+	timeout time.Duration
+	maxSize int64
+}
+
+type PreviewOptions func(*previewer)
+
+// PreviewTimeout sets the timeout of a preview fetch, DefaultPreviewTimeout by default.
+func PreviewTimeout(timeout time.Duration) PreviewOptions {
+	return func(p *previewer) {
+		p.timeout = timeout
+	}
+}
+
+// PreviewMaxSize sets the number of bytes of a page read to find its metadata, DefaultPreviewMaxSize by default.
+func PreviewMaxSize(n int64) PreviewOptions {
+	return func(p *previewer) {
+		p.maxSize = n
+	}
+}
+
+// PreviewClient sets the http client used to fetch previews, instead of a client connecting only to public
+// addresses.
+func PreviewClient(client *http.Client) PreviewOptions {
+	return func(p *previewer) {
+		p.client = client
+	}
+}
+
+// WithPreviews fetches the title, description and og:image of destinations in the background
+// when links are posted or updated, and stores them in the links.
+// Links without preview get one on their first call to Preview.
+func WithPreviews(opts ...PreviewOptions) Options {
+	p := previewer{client: publicClient, timeout: DefaultPreviewTimeout, maxSize: DefaultPreviewMaxSize}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return func(h *Handler) {
+		h.previews = &p
+	}
+}
+
+// Preview returns the preview of the link with the given id, nil if it has none.
+// With WithPreviews, a missing preview is fetched and stored.
+func (h *Handler) Preview(id string, opts ...ReqOptions) (*Preview, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	e, err := h.get(r, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.Page != nil {
+		return &Preview{Title: e.Page.Title, Description: e.Page.Description}, nil
+	}
+	if e.Preview != nil || h.previews == nil {
+		return e.Preview, nil
+	}
+
+	p, err := h.previews.fetch(context.Background(), e.URL)
+	if err != nil {
+		return nil, err
+	}
+	if h.writable() == nil {
+		if err := h.storePreview(h.normalizeID(id), r, e.URL, p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// refreshPreview fetches the preview of a new destination in the background.
+func (h *Handler) refreshPreview(id string, r req, u string) {
+	if h.previews == nil {
+		return
+	}
+	h.async(func(ctx context.Context) {
+		p, err := h.previews.fetch(ctx, u)
+		if err == nil {
+			err = h.storePreview(id, r, u, p)
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			h.log(LogStore).Warningf("Couldn't fetch the preview of %s: %s", id, err)
+		}
+	})
+}
+
+// storePreview stores the preview of the link, unless its destination changed meanwhile.
+func (h *Handler) storePreview(id string, r req, u string, p *Preview) error {
+	err := h.updateRetry(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		return h.updateEntry(txn, linkKey(r.domain, id), func(e *entry) error {
+			if e.URL == u {
+				e.Preview = p
+			}
+			return nil
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil // deleted meanwhile
+	}
+	return err
+}
+
+func (p *previewer) fetch(ctx context.Context, u string) (*Preview, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s returned %d", u, resp.StatusCode)
+	}
+
+	// destinations which aren't pages have an empty preview, so they aren't fetched again
+	preview := &Preview{FetchedAt: time.Now()}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "text/html" && mt != "application/xhtml+xml" {
+		return preview, nil
+	}
+	parsePreview(io.LimitReader(resp.Body, p.maxSize), resp.Request.URL, preview)
+	return preview, nil
+}
+
+// parsePreview reads the metadata of the head of the page, og: properties take precedence.
+func parsePreview(r io.Reader, base *url.URL, p *Preview) {
+	var og, page Preview
+	z := html.NewTokenizer(r)
+loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break loop
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				break loop
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				break loop
+			case "title":
+				if z.Next() == html.TextToken {
+					page.Title = clip(string(z.Text()))
+				}
+			case "meta":
+				attrs := map[string]string{}
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					attrs[string(k)] = string(v)
+				}
+				switch {
+				case attrs["property"] == "og:title":
+					og.Title = clip(attrs["content"])
+				case attrs["property"] == "og:description":
+					og.Description = clip(attrs["content"])
+				case attrs["property"] == "og:image":
+					if img, err := base.Parse(strings.TrimSpace(attrs["content"])); err == nil && (img.Scheme == "http" || img.Scheme == "https") {
+						og.Image = img.String()
+					}
+				case attrs["name"] == "description":
+					page.Description = clip(attrs["content"])
+				}
+			}
+		}
+	}
+
+	p.Title, p.Description, p.Image = og.Title, og.Description, og.Image
+	if p.Title == "" {
+		p.Title = page.Title
+	}
+	if p.Description == "" {
+		p.Description = page.Description
+	}
+}
+
+// clip trims s and bounds it to maxPreviewText runes.
+func clip(s string) string {
+	s = strings.TrimSpace(s)
+	if r := []rune(s); len(r) > maxPreviewText {
+		return string(r[:maxPreviewText])
+	}
+	return s
+}
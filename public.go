@@ -0,0 +1,96 @@
+package coopurl
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// WithPublic lists the link in the public index returned by PublicLinks, eg: for a curated link directory.
+func WithPublic() ReqOptions {
+	return func(r *req) {
+		r.public = true
+	}
+}
+
+// SetPublic adds the link to the public index or removes it.
+func (h *Handler) SetPublic(id string, public bool, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	id = h.normalizeID(id)
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err := h.db.Update(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		return h.updateEntry(txn, linkKey(r.domain, id), func(e *entry) error {
+			e.Public = public
+			return r.nextVersion(e)
+		})
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if public {
+		h.log(LogAdmin).Infof("Make entry public: %s", id)
+	} else {
+		h.log(LogAdmin).Infof("Make entry private: %s", id)
+	}
+	return nil
+}
+
+// PublicLinks returns limit public links from offset, newest first, along with the number of public links.
+// Disabled links, drafts and links asking not to be indexed aren't listed. All the links are returned if limit is 0.
+func (h *Handler) PublicLinks(offset, limit int) ([]Entry, int, error) {
+	if err := h.ready(); err != nil {
+		return nil, 0, err
+	}
+	defer h.release()
+
+	var links []Entry
+	err := h.db.View(func(txn *badger.Txn) error {
+		return forEachLink(txn, func(l linkRef, e entry) error {
+			if !e.Public || e.Disabled || e.NoIndex || e.Draft {
+				return nil
+			}
+			clicks, err := getCounter(txn, clicksKey(l.domain, l.id))
+			if err != nil {
+				return err
+			}
+			links = append(links, e.export(l, clicks))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.SliceStable(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+	total := len(links)
+	if offset >= total {
+		return nil, total, nil
+	}
+	links = links[offset:]
+	if limit > 0 && limit < len(links) {
+		links = links[:limit]
+	}
+	return links, total, nil
+}
@@ -0,0 +1,291 @@
+package coopurl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Fields of the links filtered by a Query besides the indexes.
+const (
+	FieldCreated  = "created"  // creation time, eg: created>2024-01-01
+	FieldUpdated  = "updated"  // last update time
+	FieldExpires  = "expires"  // expiry time, links without expiry expire after any time
+	FieldClicks   = "clicks"   // number of clicks, eg: clicks>=100
+	FieldDisabled = "disabled" // eg: disabled=true
+	FieldPublic   = "public"
+	FieldDraft    = "draft"
+)
+
+// Filter is a condition of a Query on a field of the links: an index, eg: IndexTag, or one of the Field*
+// constants.
+type Filter struct {
+	Field string
+	Op    string // =, !=, <, <=, > or >=
+	Value string
+}
+
+// Query selects the links matching all its filters, parsed by ParseQuery.
+type Query []Filter
+
+var queryOps = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+// ParseQuery parses filters joined by AND, eg: `tag=q3 AND domain=example.com AND created>2024-01-01`.
+// Values with spaces are quoted, eg: tag="year end". Times are dates, whole days in UTC, or RFC 3339 times.
+func ParseQuery(s string) (Query, error) {
+	var q Query
+	s = strings.TrimSpace(s)
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool { return strings.ContainsRune("!=<>", r) || unicode.IsSpace(r) })
+		if i < 0 {
+			i = len(s)
+		}
+		if i == 0 {
+			return nil, fmt.Errorf("%w: expected a field at %q", ErrInvalidQuery, s)
+		}
+		f := Filter{Field: s[:i]}
+		s = strings.TrimLeftFunc(s[i:], unicode.IsSpace)
+		for _, op := range queryOps {
+			if strings.HasPrefix(s, op) {
+				f.Op = op
+				break
+			}
+		}
+		if f.Op == "" {
+			return nil, fmt.Errorf("%w: expected an operator after %q", ErrInvalidQuery, f.Field)
+		}
+		s = strings.TrimLeftFunc(s[len(f.Op):], unicode.IsSpace)
+
+		if strings.HasPrefix(s, `"`) {
+			quoted, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("%w: unterminated value of %q", ErrInvalidQuery, f.Field)
+			}
+			f.Value, _ = strconv.Unquote(quoted)
+			s = s[len(quoted):]
+		} else {
+			i := strings.IndexFunc(s, unicode.IsSpace)
+			if i < 0 {
+				i = len(s)
+			}
+			f.Value, s = s[:i], s[i:]
+		}
+		q = append(q, f)
+
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			break
+		}
+		if len(s) < 4 || !strings.EqualFold(s[:3], "and") || !unicode.IsSpace(rune(s[3])) {
+			return nil, fmt.Errorf("%w: expected AND at %q", ErrInvalidQuery, s)
+		}
+		s = strings.TrimLeftFunc(s[3:], unicode.IsSpace)
+	}
+	return q, nil
+}
+
+func (q Query) String() string {
+	terms := make([]string, len(q))
+	for i, f := range q {
+		v := f.Value
+		if v == "" || strings.IndexFunc(v, unicode.IsSpace) >= 0 || strings.HasPrefix(v, `"`) {
+			v = strconv.Quote(v)
+		}
+		terms[i] = f.Field + f.Op + v
+	}
+	return strings.Join(terms, " AND ")
+}
+
+// condition is a compiled filter.
+type condition func(l linkRef, e entry, clicks uint64) bool
+
+// compile returns the conditions of the query, and the index and value of its first equality on an index,
+// which gives the links to filter rather than scanning the store.
+func (h *Handler) compile(q Query) (conds []condition, index, value string, err error) {
+	for _, f := range q {
+		c, err := h.condition(f)
+		if err != nil {
+			return nil, "", "", err
+		}
+		conds = append(conds, c)
+		if index == "" && f.Op == "=" && h.indexNamed(f.Field) != nil {
+			index, value = f.Field, indexValue(f)
+		}
+	}
+	return conds, index, value, nil
+}
+
+func (h *Handler) indexNamed(name string) *index {
+	for i := range h.indexes {
+		if h.indexes[i].name == name {
+			return &h.indexes[i]
+		}
+	}
+	return nil
+}
+
+// indexValue returns the value of the filter in its index, the urls being hashed.
+func indexValue(f Filter) string {
+	if f.Field == IndexURL {
+		return URLHash(f.Value)
+	}
+	return f.Value
+}
+
+func (h *Handler) condition(f Filter) (condition, error) {
+	if i := h.indexNamed(f.Field); i != nil {
+		if f.Op != "=" && f.Op != "!=" {
+			return nil, fmt.Errorf("%w: %s can only be compared with = or !=", ErrInvalidQuery, f.Field)
+		}
+		value := indexValue(f)
+		return func(l linkRef, e entry, _ uint64) bool {
+			found := false
+			for _, v := range i.extract(l, e) {
+				found = found || v == value
+			}
+			return found == (f.Op == "=")
+		}, nil
+	}
+
+	switch f.Field {
+	case FieldCreated, FieldUpdated, FieldExpires:
+		from, to, err := queryTime(f.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s isn't a date nor a time", ErrInvalidQuery, f.Value)
+		}
+		return func(_ linkRef, e entry, _ uint64) bool {
+			t := e.CreatedAt
+			switch f.Field {
+			case FieldUpdated:
+				t = e.UpdatedAt
+			case FieldExpires:
+				t = e.ExpiresAt
+				if t.IsZero() {
+					t = time.Unix(1<<62, 0) // never
+				}
+			}
+			return compareTime(t, f.Op, from, to)
+		}, nil
+
+	case FieldClicks:
+		n, err := strconv.ParseUint(f.Value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s isn't a number of clicks", ErrInvalidQuery, f.Value)
+		}
+		return func(_ linkRef, _ entry, clicks uint64) bool {
+			switch f.Op {
+			case "=":
+				return clicks == n
+			case "!=":
+				return clicks != n
+			case "<":
+				return clicks < n
+			case "<=":
+				return clicks <= n
+			case ">":
+				return clicks > n
+			}
+			return clicks >= n
+		}, nil
+
+	case FieldDisabled, FieldPublic, FieldDraft:
+		b, err := strconv.ParseBool(f.Value)
+		if err != nil || (f.Op != "=" && f.Op != "!=") {
+			return nil, fmt.Errorf("%w: %s can only be compared with = or != to true or false", ErrInvalidQuery, f.Field)
+		}
+		return func(_ linkRef, e entry, _ uint64) bool {
+			v := e.Disabled
+			switch f.Field {
+			case FieldPublic:
+				v = e.Public
+			case FieldDraft:
+				v = e.Draft
+			}
+			return (v == b) == (f.Op == "=")
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidQuery, f.Field)
+}
+
+// queryTime returns the interval [from, to) of a date, or the time at from.
+func queryTime(s string) (from, to time.Time, err error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	return t, t.Add(time.Nanosecond), err
+}
+
+func compareTime(t time.Time, op string, from, to time.Time) bool {
+	switch op {
+	case "=":
+		return !t.Before(from) && t.Before(to)
+	case "!=":
+		return t.Before(from) || !t.Before(to)
+	case "<":
+		return t.Before(from)
+	case "<=":
+		return t.Before(to)
+	case ">":
+		return !t.Before(to)
+	}
+	return !t.Before(from)
+}
+
+// Links returns limit links matching the query from offset, newest first, along with the number of matching
+// links. The links are read from the index of the first equality on an index, eg: tag=q3, the whole store is
+// scanned otherwise. All the links are returned if limit is 0, a negative offset is an ErrInvalidQuery.
+func (h *Handler) Links(q Query, offset, limit int) ([]Entry, int, error) {
+	if offset < 0 {
+		return nil, 0, fmt.Errorf("%w: negative offset %d", ErrInvalidQuery, offset)
+	}
+	if err := h.ready(); err != nil {
+		return nil, 0, err
+	}
+	defer h.release()
+
+	conds, index, value, err := h.compile(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var links []Entry
+	err = h.db.View(func(txn *badger.Txn) error {
+		fn := func(l linkRef, e entry) error {
+			clicks, err := getCounter(txn, clicksKey(l.domain, l.id))
+			if err != nil {
+				return err
+			}
+			for _, c := range conds {
+				if !c(l, e, clicks) {
+					return nil
+				}
+			}
+			links = append(links, e.export(l, clicks))
+			return nil
+		}
+		if index != "" {
+			return forEachIndexed(txn, index, value, fn)
+		}
+		return forEachLink(txn, fn)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.SliceStable(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+	total := len(links)
+	if offset >= total {
+		return nil, total, nil
+	}
+	links = links[offset:]
+	if limit > 0 && limit < len(links) {
+		links = links[:limit]
+	}
+	return links, total, nil
+}
@@ -0,0 +1,87 @@
+package coopurl
+
+import (
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Quota limits the links of an owner or a namespace, 0 means no limit.
+type Quota struct {
+	MaxLinks  int `json:"max_links,omitempty" yaml:"max_links,omitempty"`     // links that didn't expire nor were deleted
+	MaxPerDay int `json:"max_per_day,omitempty" yaml:"max_per_day,omitempty"` // links created since midnight UTC
+}
+
+// WithOwnerQuota sets the quota of each owner, links without owner aren't limited.
+// Expired links are counted in MaxLinks until the end of the day of their expiry, UTC.
+func WithOwnerQuota(q Quota) Options {
+	return func(h *Handler) {
+		h.ownerQuota = q
+	}
+}
+
+// WithNamespaceQuota sets the quota of each namespace, links without namespace aren't limited.
+// Expired links are counted in MaxLinks until the end of the day of their expiry, UTC.
+func WithNamespaceQuota(q Quota) Options {
+	return func(h *Handler) {
+		h.namespaceQuota = q
+	}
+}
+
+func quotaDayKey(kind, name string, day time.Time) []byte {
+	return metaKey("quota-day", kind, name, day.UTC().Format(dayFormat))
+}
+
+// checkQuotas returns ErrQuotaExceeded if the new link e goes over the quota of its owner or namespace.
+func (h *Handler) checkQuotas(txn *badger.Txn, e entry) error {
+	if e.Owner != "" {
+		if err := checkQuota(txn, h.ownerQuota, "owner", e.Owner, e.CreatedAt); err != nil {
+			return err
+		}
+	}
+	if e.Namespace != "" {
+		return checkQuota(txn, h.namespaceQuota, "namespace", e.Namespace, e.CreatedAt)
+	}
+	return nil
+}
+
+func checkQuota(txn *badger.Txn, q Quota, kind, name string, now time.Time) error {
+	if q.MaxPerDay > 0 {
+		n, err := getCounter(txn, quotaDayKey(kind, name, now))
+		if err != nil {
+			return err
+		}
+		if n >= uint64(q.MaxPerDay) {
+			return ErrQuotaExceeded
+		}
+	}
+
+	if q.MaxLinks > 0 {
+		n, err := countIndexed(txn, indexTerm{kind, name})
+		if err != nil {
+			return err
+		}
+		if n >= q.MaxLinks {
+			return ErrQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// countCreation counts the new link e in the daily quotas of its owner and namespace.
+func countCreation(txn *badger.Txn, e entry) error {
+	for kind, name := range map[string]string{"owner": e.Owner, "namespace": e.Namespace} {
+		if name == "" {
+			continue
+		}
+		key := quotaDayKey(kind, name, e.CreatedAt)
+		n, err := getCounter(txn, key)
+		if err != nil {
+			return err
+		}
+		if err := setValue(txn, key, encodeCounter64(n+1), 48*time.Hour); err != nil {
+			return err
+		}
+	}
+	return nil
+}
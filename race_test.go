@@ -0,0 +1,143 @@
+package coopurl
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// The tests of this file are meant to be run with the race detector: go test -race
+
+func newTestHandler(t testing.TB, opts ...Options) *Handler {
+	t.Helper()
+	h, err := New(append([]Options{WithDbPath(t.TempDir())}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func TestConcurrentPostGet(t *testing.T) {
+	h := newTestHandler(t, WithCache(16))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				id, err := h.Post("https://coopgo.fr")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := h.Get(id); err != nil {
+					t.Error(err)
+					return
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+				if w.Code != http.StatusFound && w.Code != http.StatusMovedPermanently {
+					t.Errorf("redirect of %s: status %d", id, w.Code)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConcurrentClose(t *testing.T) {
+	h := newTestHandler(t)
+	id, err := h.Post("https://coopgo.fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := h.Post("https://coopgo.fr"); err != nil && !errors.Is(err, ErrClosed) {
+					t.Error(err)
+					return
+				}
+				if _, err := h.Get(id); err != nil && !errors.Is(err, ErrClosed) {
+					t.Error(err)
+					return
+				}
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/"+id, nil))
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Close()
+		}()
+	}
+	wg.Wait()
+
+	if _, err := h.Get(id); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get after Close: %v, expected ErrClosed", err)
+	}
+}
+
+func TestConcurrentReopen(t *testing.T) {
+	h := newTestHandler(t)
+	id, err := h.Post("https://coopgo.fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := h.Get(id); err != nil && !errors.Is(err, ErrClosed) {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 3; j++ {
+			if err := h.Reopen(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if _, err := h.Get(id); err != nil {
+		t.Errorf("Get after Reopen: %v", err)
+	}
+}
+
+func TestConcurrentLazyOpen(t *testing.T) {
+	h := newTestHandler(t, WithLazyOpen())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := h.Post("https://coopgo.fr"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
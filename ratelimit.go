@@ -0,0 +1,147 @@
+package coopurl
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// CreationLimit limits the links created from each client ip given with WithClientIP: Burst links at once, then
+// one more per Interval. Its state is kept in the store, so that restarting doesn't reset it.
+type CreationLimit struct {
+	Burst    int
+	Interval time.Duration
+}
+
+// CreationLimitState is the state of the creation limit of a client ip.
+type CreationLimitState struct {
+	IP         string        `json:"ip"`
+	Remaining  int           `json:"remaining"`   // links it can create now
+	RetryAfter time.Duration `json:"retry_after"` // time before it can create a link, 0 if Remaining isn't
+	ResetAt    time.Time     `json:"reset_at"`    // time it can create Burst links again
+}
+
+// WithCreationLimit limits the links created from each client ip, links posted without WithClientIP aren't
+// limited. Links over the limit are rejected with ErrRateLimited.
+func WithCreationLimit(l CreationLimit) Options {
+	return func(h *Handler) {
+		h.creationLimit = &l
+	}
+}
+
+// WithClientIP gives the ip of the client creating the link, for WithCreationLimit.
+func WithClientIP(ip string) ReqOptions {
+	return func(r *req) {
+		r.clientIP = ip
+	}
+}
+
+// limit returns the creation limit, nil if none, which Reload can change.
+func (h *Handler) limit() *CreationLimit {
+	h.settingsMu.RLock()
+	defer h.settingsMu.RUnlock()
+	return h.creationLimit
+}
+
+func creationLimitPrefix() []byte {
+	return metaPrefixKey("ratelimit")
+}
+
+func creationLimitKey(ip string) []byte {
+	return metaKey("ratelimit", ip)
+}
+
+// The limit is a generic cell rate algorithm: each ip has a theoretical arrival time, moved forward by Interval
+// on each link, and a link is allowed while it is at most Burst-1 intervals ahead of now.
+
+// state returns the state of the ip whose theoretical arrival time is tat.
+func (l CreationLimit) state(ip string, tat, now time.Time) CreationLimitState {
+	if tat.Before(now) {
+		tat = now
+	}
+	s := CreationLimitState{IP: ip, ResetAt: tat}
+	tolerance := time.Duration(l.Burst-1) * l.Interval
+	if ahead := tat.Sub(now); ahead > tolerance {
+		s.RetryAfter = ahead - tolerance
+	} else {
+		s.Remaining = int((tolerance-ahead)/l.Interval) + 1
+	}
+	return s
+}
+
+func decodeArrival(b []byte) time.Time {
+	if len(b) != 8 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
+
+// limitCreation counts a link created from the ip, or returns ErrRateLimited if it is over the limit.
+func (h *Handler) limitCreation(ip string) error {
+	limit := h.limit()
+	if limit == nil || ip == "" {
+		return nil
+	}
+	l := *limit
+	return h.updateRetry(func(txn *badger.Txn) error {
+		var tat time.Time
+		b, err := getValue(txn, creationLimitKey(ip))
+		if err == nil {
+			tat = decodeArrival(b)
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		now := time.Now()
+		if l.state(ip, tat, now).Remaining == 0 {
+			return ErrRateLimited
+		}
+		if tat.Before(now) {
+			tat = now
+		}
+		tat = tat.Add(l.Interval)
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, uint64(tat.UnixNano()))
+		// once tat has passed, the ip is as new
+		return setValue(txn, creationLimitKey(ip), v, tat.Sub(now))
+	})
+}
+
+// CreationLimits returns the state of the client ips limited by WithCreationLimit, the ones which created links
+// recently, eg: to find the ones abusing the shortener.
+func (h *Handler) CreationLimits() ([]CreationLimitState, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	limit := h.limit()
+	if limit == nil {
+		return nil, ErrInvalidOption
+	}
+
+	now := time.Now()
+	var states []CreationLimitState
+	err := h.db.View(func(txn *badger.Txn) error {
+		return iteratePrefix(txn, creationLimitPrefix(), func(ip string, v []byte) error {
+			states = append(states, limit.state(unescapePart(ip), decodeArrival(v), now))
+			return nil
+		})
+	})
+	return states, err
+}
+
+// ResetCreationLimit lets the client ip create Burst links again.
+func (h *Handler) ResetCreationLimit(ip string) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	return h.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(creationLimitKey(ip))
+	})
+}
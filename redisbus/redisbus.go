@@ -0,0 +1,210 @@
+// Package redisbus is a coopurl.InvalidationBus over a Redis pub/sub channel, for several coopurl instances with
+// their own cache over a shared store, eg:
+//
+//	h, err := coopurl.New(coopurl.WithCache(10000), coopurl.WithInvalidationBus(redisbus.New("redis:6379", "")))
+//
+// The keys of the changed links are published on the channel as a JSON array of base64 strings.
+package redisbus
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// DefaultChannel is the channel of the invalidations, unless set with Bus.Channel.
+const DefaultChannel = "coopurl:invalidations"
+
+const dialTimeout = 5 * time.Second
+
+// Bus publishes and receives the invalidations on a Redis channel.
+type Bus struct {
+	Addr     string // address of the Redis server, eg: redis:6379
+	Password string // password of the AUTH command, not sent if empty
+	Channel  string // DefaultChannel if empty
+
+	mu   sync.Mutex
+	conn *conn // connection of Publish, dialed again after an error
+}
+
+var _ coopurl.InvalidationBus = (*Bus)(nil)
+
+// New returns a bus over the Redis server at addr, authenticated with password if not empty.
+func New(addr, password string) *Bus {
+	return &Bus{Addr: addr, Password: password}
+}
+
+func (b *Bus) channel() string {
+	if b.Channel == "" {
+		return DefaultChannel
+	}
+	return b.Channel
+}
+
+func (b *Bus) Publish(ctx context.Context, keys [][]byte) error {
+	msg, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		if b.conn, err = b.dial(ctx); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		b.conn.SetDeadline(deadline)
+	} else {
+		b.conn.SetDeadline(time.Time{})
+	}
+	if _, err = b.conn.do("PUBLISH", b.channel(), string(msg)); err != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+	return err
+}
+
+func (b *Bus) Subscribe(ctx context.Context, fn func(keys [][]byte)) error {
+	c, err := b.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-stop:
+		}
+	}()
+
+	if _, err := c.do("SUBSCRIBE", b.channel()); err != nil {
+		return err
+	}
+	for {
+		v, err := c.read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		// messages are ["message", channel, payload]
+		msg, ok := v.([]interface{})
+		if !ok || len(msg) != 3 || msg[0] != "message" {
+			continue
+		}
+		payload, _ := msg[2].(string)
+		var keys [][]byte
+		if err := json.Unmarshal([]byte(payload), &keys); err != nil {
+			continue
+		}
+		fn(keys)
+	}
+}
+
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn = nil
+	return err
+}
+
+// dial connects to the server and authenticates.
+func (b *Bus) dial(ctx context.Context) (*conn, error) {
+	d := net.Dialer{Timeout: dialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", b.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if b.Password != "" {
+		c.SetDeadline(time.Now().Add(dialTimeout))
+		if _, err := c.do("AUTH", b.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.SetDeadline(time.Time{})
+	}
+	return c, nil
+}
+
+// conn speaks the RESP protocol of Redis.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// do sends a command and reads its reply.
+func (c *conn) do(args ...string) (interface{}, error) {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(a))+"\r\n"...)
+		buf = append(buf, a...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.Write(buf); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read reads a reply, strings and bulk strings as string, integers as int64 and arrays as []interface{}.
+func (c *conn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redisbus: malformed reply")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, fmt.Errorf("redisbus: %s", line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		a := make([]interface{}, n)
+		for i := range a {
+			if a[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("redisbus: unknown reply %q", kind)
+}
@@ -0,0 +1,49 @@
+package coopurl
+
+import "sync/atomic"
+
+// Reload applies the runtime settings of c without reopening the store: the blocked words, the protected brands,
+// the reserved prefixes and ids, the rewrite rules, the creation limit, the log levels and the redirect log
+// sampling. They replace the ones given to New, eg: an empty list of blocked words keeps only DefaultBlockedWords,
+// and a nil creation limit removes the limit. The screening lists of SetScreening are read again from the store.
+// The other settings of c are ignored, they need a new handler. Invalid settings are rejected as by New.
+func (h *Handler) Reload(c Config) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+
+	opts, err := Config{
+		BlockedWords:        c.BlockedWords,
+		ProtectedBrands:     c.ProtectedBrands,
+		ReservedPrefixes:    c.ReservedPrefixes,
+		ReservedIDs:         c.ReservedIDs,
+		RewriteRules:        c.RewriteRules,
+		CreationLimit:       c.CreationLimit,
+		LogLevels:           c.LogLevels,
+		RedirectLogSampling: c.RedirectLogSampling,
+	}.Options()
+	if err != nil {
+		return err
+	}
+	n, err := configure(opts)
+	if err != nil {
+		return err
+	}
+	// the screening lists may have been set on the primary
+	if err := h.loadScreening(); err != nil {
+		return err
+	}
+
+	h.settingsMu.Lock()
+	h.blockedWords, h.reservedPrefixes, h.reservedIDs = n.blockedWords, n.reservedPrefixes, n.reservedIDs
+	h.protectedBrands = n.protectedBrands
+	h.rewriteRules = n.rewriteRules
+	h.creationLimit = n.creationLimit
+	h.logLevels = n.logLevels
+	h.settingsMu.Unlock()
+	atomic.StoreUint64(&h.redirectLogSampling, n.redirectLogSampling)
+
+	h.log(LogAdmin).Infof("Reloaded the settings")
+	return nil
+}
@@ -0,0 +1,440 @@
+package coopurl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/pb"
+)
+
+// Replication frames, each one is its kind, the length of its payload then a KVList.
+const (
+	frameSnapshot byte = 's' // keys of the snapshot
+	frameSynced   byte = 'e' // end of the snapshot, the payload holds its version
+	frameUpdate   byte = 'u' // keys changed since the snapshot
+)
+
+// deletedMeta marks the deleted keys in the update frames.
+const deletedMeta = 1
+
+// badgerPrefix prefixes the internal keys of badger, which aren't replicated.
+var badgerPrefix = []byte("!badger!")
+
+// snapshotBatch is the number of keys per snapshot frame.
+const snapshotBatch = 1000
+
+// maxFrameSize bounds the frames read by replicas.
+const maxFrameSize = 256 << 20
+
+// WithReplicaOf makes the handler a read-only replica of the primary serving ServeReplication at url,
+// authenticated with the given bearer token if not empty.
+// Replicas serve redirects from their copy of the store and reject writes with ErrReadOnly.
+// Clicks on replicas aren't counted, and background jobs only run on the primary.
+func WithReplicaOf(url, token string) Options {
+	return func(h *Handler) {
+		h.primaryURL = url
+		h.primaryToken = token
+	}
+}
+
+// writable returns ErrReadOnly on replicas, and ErrMaintenance during a maintenance.
+func (h *Handler) writable() error {
+	if h.primaryURL != "" || atomic.LoadInt32(&h.follower) == 1 {
+		return ErrReadOnly
+	}
+	if h.Maintenance() != "" {
+		return ErrMaintenance
+	}
+	return nil
+}
+
+func replicationPingKey() []byte {
+	return metaKey("replication", "ping")
+}
+
+// ServeReplication streams a snapshot of the store then its changes, to replicas set up with WithReplicaOf.
+// It must be served behind authentication, as it exposes all the data of the store.
+func (h *Handler) ServeReplication(w http.ResponseWriter, r *http.Request) {
+	if err := h.ready(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer h.release()
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the stream holds the store open, it ends when the handler is closed
+	ctx, cancel := h.untilClosed(r.Context())
+	defer cancel()
+
+	sub, err := h.subscribe(ctx)
+	if err != nil {
+		h.log(LogAdmin).Errorf("Couldn't start replication: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	bw := bufio.NewWriter(w)
+
+	version, err := h.writeSnapshot(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	flusher.Flush()
+	if err == nil {
+		h.log(LogAdmin).Infof("Replica %s synced at version %d", r.RemoteAddr, version)
+	}
+
+	for err == nil {
+		var kvs *pb.KVList
+		if kvs, err = sub.next(ctx); err != nil {
+			break
+		}
+		if kvs, err = h.changesAfter(kvs, version); err == nil && len(kvs.Kv) > 0 {
+			if err = writeFrame(bw, frameUpdate, kvs); err == nil {
+				err = bw.Flush()
+			}
+			flusher.Flush()
+		}
+	}
+	if !errors.Is(err, context.Canceled) {
+		h.log(LogAdmin).Warningf("Replication to %s stopped: %s", r.RemoteAddr, err)
+	}
+}
+
+// subscription receives the changes committed to the store.
+type subscription struct {
+	pending []*pb.KVList
+	updates chan *pb.KVList
+	err     chan error
+}
+
+// subscribe returns the changes committed to the store from its return on, until ctx is done.
+func (h *Handler) subscribe(ctx context.Context) (*subscription, error) {
+	sub := &subscription{updates: make(chan *pb.KVList, 64), err: make(chan error, 1)}
+	db := h.db
+	go func() {
+		sub.err <- db.Subscribe(ctx, func(kvs *badger.KVList) error {
+			select {
+			case sub.updates <- kvs:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, []pb.Match{{Prefix: []byte{}}})
+	}()
+
+	// The subscription starts in the background, a ping tells when it receives the changes.
+	ping := []byte(newRequestID())
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for {
+		if err := h.db.Update(func(txn *badger.Txn) error {
+			return txn.Set(replicationPingKey(), ping)
+		}); err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case err := <-sub.err:
+			return nil, err
+		case kvs := <-sub.updates:
+			for i, kv := range kvs.Kv {
+				if bytes.Equal(kv.Key, replicationPingKey()) && bytes.Equal(kv.Value, ping) {
+					// keep the changes committed after the ping in the same batch
+					if rest := kvs.Kv[i+1:]; len(rest) > 0 {
+						sub.pending = append(sub.pending, &pb.KVList{Kv: rest})
+					}
+					return sub, nil
+				}
+			}
+		case <-t.C:
+		}
+	}
+}
+
+// next waits for the next changes.
+func (s *subscription) next(ctx context.Context) (*pb.KVList, error) {
+	if len(s.pending) > 0 {
+		kvs := s.pending[0]
+		s.pending = s.pending[1:]
+		return kvs, nil
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err := <-s.err:
+		return nil, err
+	case kvs := <-s.updates:
+		return kvs, nil
+	}
+}
+
+// writeSnapshot writes all the keys of the store followed by a synced frame, it returns the version of the snapshot.
+func (h *Handler) writeSnapshot(w io.Writer) (uint64, error) {
+	var version uint64
+	err := h.db.View(func(txn *badger.Txn) error {
+		version = txn.ReadTs()
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		batch := &pb.KVList{}
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			v, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			batch.Kv = append(batch.Kv, &pb.KV{
+				Key:       item.KeyCopy(nil),
+				Value:     v,
+				ExpiresAt: item.ExpiresAt(),
+				Version:   item.Version(),
+			})
+			if len(batch.Kv) == snapshotBatch {
+				if err := writeFrame(w, frameSnapshot, batch); err != nil {
+					return err
+				}
+				batch = &pb.KVList{}
+			}
+		}
+		if len(batch.Kv) == 0 {
+			return nil
+		}
+		return writeFrame(w, frameSnapshot, batch)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, writeFrame(w, frameSynced, &pb.KVList{Kv: []*pb.KV{{Version: version}}})
+}
+
+// changesAfter returns the changes made after the given version.
+// Subscriptions don't tell deletions from empty values, so keys with an empty value are looked up
+// and marked with deletedMeta when not found: if the key was set again since then, the later change follows.
+func (h *Handler) changesAfter(kvs *pb.KVList, version uint64) (*pb.KVList, error) {
+	out := &pb.KVList{}
+	for _, kv := range kvs.Kv {
+		if kv.Version <= version || bytes.HasPrefix(kv.Key, badgerPrefix) {
+			continue
+		}
+		if len(kv.Value) == 0 {
+			err := h.db.View(func(txn *badger.Txn) error {
+				_, err := txn.Get(kv.Key)
+				return err
+			})
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				kv.Meta = []byte{deletedMeta}
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		out.Kv = append(out.Kv, kv)
+	}
+	return out, nil
+}
+
+func writeFrame(w io.Writer, kind byte, kvs *pb.KVList) error {
+	b, err := kvs.Marshal()
+	if err != nil {
+		return err
+	}
+	head := make([]byte, 5)
+	head[0] = kind
+	binary.BigEndian.PutUint32(head[1:], uint32(len(b)))
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readFrame(r io.Reader) (byte, *pb.KVList, error) {
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(head[1:])
+	if n > maxFrameSize {
+		return 0, nil, fmt.Errorf("replication frame of %d bytes", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	kvs := &pb.KVList{}
+	return head[0], kvs, kvs.Unmarshal(b)
+}
+
+// replicate follows the primary until the handler is closed, reconnecting when the stream breaks.
+func (h *Handler) replicate(ctx context.Context) {
+	defer h.jobs.Done()
+
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := h.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		h.log(LogAdmin).Warningf("Replication from %s stopped: %s", h.primaryURL, err)
+		if h.fallbackURL != "" {
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+				go h.takeOver()
+			}
+			return
+		}
+
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// follow loads a snapshot of the primary, then applies its changes.
+func (h *Handler) follow(ctx context.Context) error {
+	resp, err := h.connect(ctx, h.primaryURL, h.primaryToken)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	r := bufio.NewReader(resp.Body)
+	if err := h.loadSnapshot(r); err != nil {
+		return err
+	}
+	h.log(LogAdmin).Infof("Replica synced with %s", h.primaryURL)
+
+	for {
+		kind, kvs, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+		if kind != frameUpdate {
+			return fmt.Errorf("unexpected replication frame %q", kind)
+		}
+		if err := h.apply(kvs); err != nil {
+			return err
+		}
+	}
+}
+
+// connect opens the replication stream served at url.
+func (h *Handler) connect(ctx context.Context, url, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("primary answered %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// loadSnapshot replaces the content of the store by the snapshot read from r, up to its synced frame.
+func (h *Handler) loadSnapshot(r io.Reader) error {
+	seen := map[string]struct{}{}
+	for {
+		kind, kvs, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case frameSnapshot:
+			for _, kv := range kvs.Kv {
+				seen[string(kv.Key)] = struct{}{}
+			}
+			if err := h.apply(kvs); err != nil {
+				return err
+			}
+		case frameSynced:
+			return h.dropUnseen(seen)
+		default:
+			return fmt.Errorf("unexpected replication frame %q", kind)
+		}
+	}
+}
+
+// apply writes the keys received from the primary.
+func (h *Handler) apply(kvs *pb.KVList) error {
+	wb := h.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, kv := range kvs.Kv {
+		if len(kv.Meta) > 0 && kv.Meta[0] == deletedMeta {
+			if err := wb.Delete(kv.Key); err != nil {
+				return err
+			}
+			continue
+		}
+		e := badger.NewEntry(kv.Key, kv.Value)
+		e.ExpiresAt = kv.ExpiresAt
+		if err := wb.SetEntry(e); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// dropUnseen deletes the local keys that aren't in the snapshot of the primary.
+func (h *Handler) dropUnseen(seen map[string]struct{}) error {
+	var keys [][]byte
+	err := h.db.View(func(txn *badger.Txn) error {
+		opt := badger.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if _, ok := seen[string(it.Item().Key())]; !ok {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	wb := h.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range keys {
+		if err := wb.Delete(k); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
@@ -0,0 +1,72 @@
+package coopurl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader is the header carrying the id of a request, honored when set by a proxy.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the honored request ids.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID is a middleware giving an id to every request, the one of the X-Request-ID header if valid.
+// The id is sent back in the X-Request-ID header and is read from the request context with RequestIDFrom.
+// The Handler does it itself, the middleware is for the other routes of an application.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withRequestID(w, r))
+	})
+}
+
+// RequestIDFrom returns the id given to the request by RequestID or the Handler, empty if none.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID gives an id to the request if it doesn't have one yet.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if RequestIDFrom(r.Context()) != "" {
+		return r
+	}
+
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID attaches the id of the http request a call is made for, eg: to the abuse reports.
+func WithRequestID(id string) ReqOptions {
+	return func(r *req) {
+		r.requestID = id
+	}
+}
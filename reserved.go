@@ -0,0 +1,170 @@
+package coopurl
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// DefaultReservedPrefixes are the routes of a server that ids can't start with.
+var DefaultReservedPrefixes = []string{"api", "admin", "static", "health", "metrics", "robots", "favicon", "sitemap", "login", "logout"}
+
+// DefaultBlockedWords are the offensive words that ids can't contain, unless WithoutDefaultBlockedWords.
+// The words shorter than 5 letters only block the ids holding them as a whole token, see WithBlockedWords.
+var DefaultBlockedWords = []string{"fuck", "shit", "cunt", "bitch", "nazi", "porn", "merde", "pute", "salope", "connard", "encule"}
+
+const maxAliasLength = 64
+
+// WithReservedPrefixes adds prefixes that generated ids and aliases can't start with, eg: the routes of the server.
+func WithReservedPrefixes(prefixes ...string) Options {
+	return func(h *Handler) {
+		for _, p := range prefixes {
+			h.reservedPrefixes = append(h.reservedPrefixes, strings.ToLower(p))
+		}
+	}
+}
+
+// WithReservedIDs adds ids that generated ids and aliases can't be, eg: the routes of a server serving the
+// links at the root of its paths, which would hide them.
+func WithReservedIDs(ids ...string) Options {
+	return func(h *Handler) {
+		for _, id := range ids {
+			h.reservedIDs = append(h.reservedIDs, strings.ToLower(id))
+		}
+	}
+}
+
+// WithRoutes refuses the aliases for which conflicts returns true with ErrRouteConflict, and doesn't generate such
+// ids, eg: the paths of a server serving the links at its root, whose pages they would hide.
+func WithRoutes(conflicts func(id string) bool) Options {
+	return func(h *Handler) {
+		h.routeConflicts = conflicts
+	}
+}
+
+// WithBlockedWords adds words that generated ids and aliases can't contain. The words shorter than 5 letters
+// only block the ids holding them between separators, eg: "pute" blocks "la-pute" but not "computer".
+func WithBlockedWords(words ...string) Options {
+	return func(h *Handler) {
+		for _, w := range words {
+			h.blockedWords = append(h.blockedWords, strings.ToLower(w))
+		}
+	}
+}
+
+// WithoutDefaultBlockedWords doesn't block DefaultBlockedWords, eg: to replace them with WithBlockedWords.
+func WithoutDefaultBlockedWords() Options {
+	return func(h *Handler) {
+		h.noDefaultWords = true
+	}
+}
+
+// WithAlias uses the given alias as id of the link instead of generating one.
+// Aliases are letters, digits, '-' and '_', and can't be reserved.
+func WithAlias(alias string) ReqOptions {
+	return func(r *req) {
+		r.alias = alias
+	}
+}
+
+// reserved tells if the id is reserved, a route, starts with a reserved prefix, contains a blocked word or resembles
+// a protected brand.
+func (h *Handler) reserved(id string) bool {
+	if h.routeConflicts != nil && h.routeConflicts(id) {
+		return true
+	}
+	id = strings.ToLower(id)
+	h.settingsMu.RLock()
+	defer h.settingsMu.RUnlock()
+	for _, r := range h.reservedIDs {
+		if id == r {
+			return true
+		}
+	}
+	for _, list := range [][]string{DefaultReservedPrefixes, h.reservedPrefixes} {
+		for _, p := range list {
+			if strings.HasPrefix(id, p) {
+				return true
+			}
+		}
+	}
+	form := screenForm(id)
+	lists := [][]string{DefaultBlockedWords, h.blockedWords, h.screening.BlockedWords}
+	if h.noDefaultWords {
+		lists = lists[1:]
+	}
+	for _, list := range lists {
+		if blocked(id, form, list) {
+			return true
+		}
+	}
+	return typosquats(form, h.protectedBrands) || typosquats(form, h.screening.ProtectedBrands)
+}
+
+func validAlias(alias string) bool {
+	if alias == "" || len(alias) > maxAliasLength {
+		return false
+	}
+	for _, c := range alias {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAlias validates the format of an alias and that it isn't reserved nor a route.
+func (h *Handler) checkAlias(alias string) error {
+	if !validAlias(alias) {
+		return ErrInvalidAlias
+	}
+	if h.routeConflicts != nil && h.routeConflicts(alias) {
+		return ErrRouteConflict
+	}
+	if h.reserved(alias) {
+		return ErrReserved
+	}
+	return nil
+}
+
+// IsAvailable tells if a link can be posted with the given alias, eg: to check a vanity id as it's typed.
+// Aliases breaking the format rules, reserved or taking a route return ErrInvalidAlias, ErrReserved or
+// ErrRouteConflict, so that the reason can be
+// shown. Aliases of a domain or namespace are checked using WithDomain() or WithNamespace().
+// The alias may be taken by the time the link is posted.
+func (h *Handler) IsAvailable(alias string, opts ...ReqOptions) (bool, error) {
+	if err := h.ready(); err != nil {
+		return false, err
+	}
+	defer h.release()
+
+	// a signature would change the alias
+	if h.signSecret != nil {
+		return false, ErrInvalidAlias
+	}
+	if err := h.checkAlias(alias); err != nil {
+		return false, err
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	id := h.normalizeID(alias)
+
+	err := h.db.View(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		_, err := txn.Get(linkKey(r.domain, id))
+		return err
+	})
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, badger.ErrKeyNotFound) {
+		return false, err
+	}
+	return !h.writes.holds(linkKey(r.domain, id)), nil
+}
@@ -0,0 +1,138 @@
+package coopurl
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RewriteRule rewrites the destinations of the links before they are checked and stored, so that they follow the
+// policy of the organization, eg: {Hosts: ["m.example.com"], MapHost: "example.com", ForceHTTPS: true}.
+type RewriteRule struct {
+	// Hosts are the hosts the rule applies to, "*.example.com" matching the subdomains of example.com, all the
+	// hosts if empty.
+	Hosts []string `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	// ForceHTTPS replaces the http scheme by https.
+	ForceHTTPS bool `json:"force_https,omitempty" yaml:"force_https,omitempty"`
+	// StripParams are the query parameters removed, "utm_*" removing all the ones starting with "utm_".
+	StripParams []string `json:"strip_params,omitempty" yaml:"strip_params,omitempty"`
+	// MapHost replaces the host, its port kept, eg: to drop the mobile subdomain of a site.
+	MapHost string `json:"map_host,omitempty" yaml:"map_host,omitempty"`
+}
+
+// TrackingParams are the usual tracking parameters, to be stripped with a RewriteRule.
+var TrackingParams = []string{"utm_*", "gclid", "dclid", "fbclid", "msclkid", "mc_eid", "igshid", "yclid", "_hsenc", "_hsmi"}
+
+// WithRewriteRules rewrites the destinations posted, updated and scheduled with the rules, in order, each one
+// applying on the result of the previous ones.
+func WithRewriteRules(rules ...RewriteRule) Options {
+	return Checked(func(h *Handler) error {
+		for i, r := range rules {
+			if !r.ForceHTTPS && len(r.StripParams) == 0 && r.MapHost == "" {
+				return optionError("rewrite rule %d does nothing", i)
+			}
+			if strings.ContainsAny(r.MapHost, "/:?#@") {
+				return optionError("rewrite rule %d maps to invalid host %q", i, r.MapHost)
+			}
+			h.rewriteRules = append(h.rewriteRules, r)
+		}
+		return nil
+	})
+}
+
+// WithRewriter rewrites the destinations with fn after the rules of WithRewriteRules, fn returning an error to
+// reject the destination, eg: ErrSchemeNotAllowed.
+func WithRewriter(fn func(u *url.URL) error) Options {
+	return func(h *Handler) {
+		h.rewriter = fn
+	}
+}
+
+// WithRedirectRewriter rewrites the destination of each redirect with fn just before it is issued, without
+// changing the stored link, eg: to append a session token or to switch to another CDN. The redirects depending
+// on the request shouldn't be cached by a CDN of WithCDN.
+func WithRedirectRewriter(fn func(dest string, r *http.Request) string) Options {
+	return func(h *Handler) {
+		h.redirectRewriter = fn
+	}
+}
+
+// matches tells if the rule applies to the host.
+func (r RewriteRule) matches(host string) bool {
+	if len(r.Hosts) == 0 {
+		return true
+	}
+	for _, h := range r.Hosts {
+		if strings.HasPrefix(h, "*.") {
+			if strings.HasSuffix(host, strings.ToLower(h[1:])) {
+				return true
+			}
+		} else if strings.EqualFold(host, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply rewrites u with the rule.
+func (r RewriteRule) apply(u *url.URL) {
+	if r.ForceHTTPS && strings.EqualFold(u.Scheme, "http") {
+		u.Scheme = "https"
+	}
+	if r.MapHost != "" {
+		port := u.Port()
+		u.Host = r.MapHost
+		if port != "" {
+			u.Host += ":" + port
+		}
+	}
+	if len(r.StripParams) > 0 && u.RawQuery != "" {
+		// the query is only encoded again if a parameter is removed, keeping the order of the others otherwise
+		q, changed := u.Query(), false
+		for name := range q {
+			if stripped(r.StripParams, name) {
+				q.Del(name)
+				changed = true
+			}
+		}
+		if changed {
+			u.RawQuery = q.Encode()
+		}
+	}
+}
+
+// stripped tells if the query parameter name is one of params.
+func stripped(params []string, name string) bool {
+	for _, p := range params {
+		if strings.HasSuffix(p, "*") && strings.HasPrefix(name, p[:len(p)-1]) || p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite applies the rewrite rules and the rewriter to the destination u.
+func (h *Handler) rewrite(u *url.URL) error {
+	h.settingsMu.RLock()
+	rules := h.rewriteRules
+	h.settingsMu.RUnlock()
+	if len(rules) == 0 && h.rewriter == nil {
+		return nil
+	}
+
+	before := u.String()
+	for _, r := range rules {
+		if r.matches(strings.ToLower(u.Hostname())) {
+			r.apply(u)
+		}
+	}
+	if h.rewriter != nil {
+		if err := h.rewriter(u); err != nil {
+			return err
+		}
+	}
+	if after := u.String(); after != before {
+		h.log(LogStore).Debugf("Rewrote %s to %s", before, after)
+	}
+	return nil
+}
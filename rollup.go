@@ -0,0 +1,185 @@
+package coopurl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// The clicks are counted per hour and per day, formatted like dayFormat.
+// The days of the ended months are rolled up into monthly counters by RollUp.
+const (
+	hourFormat  = "2006-01-02T15"
+	monthFormat = "2006-01"
+)
+
+// hourlyRetention is how long the hourly counters are kept, their clicks stay in the daily counters.
+const hourlyRetention = 7 * 24 * time.Hour
+
+func hourlyPrefix(l linkRef) []byte {
+	return metaPrefixKey("hourly", l.domain, l.id)
+}
+
+func monthlyPrefix(l linkRef) []byte {
+	return metaPrefixKey("monthly", l.domain, l.id)
+}
+
+// rolledUpKey holds the last month whose days were rolled up.
+func rolledUpKey() []byte {
+	return metaKey("rolled-up")
+}
+
+// RollUp removes the hourly counters older than a week, and sums the daily counters of the months ended since
+// the last roll up into monthly counters, so that the clicks over long ranges are read without going through
+// every day. It runs every hour on writable handlers.
+func (h *Handler) RollUp() error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if err := h.pruneHours(now.Add(-hourlyRetention)); err != nil {
+		return err
+	}
+	return h.rollUpMonths(now)
+}
+
+// pruneHours removes the hourly counters before the given time.
+func (h *Handler) pruneHours(before time.Time) error {
+	wb := h.db.NewWriteBatch()
+	defer wb.Cancel()
+	err := h.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := metaPrefixKey("hourly")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			k := it.Item().KeyCopy(nil)
+			if len(k) < len(hourFormat) {
+				continue
+			}
+			at, err := time.Parse(hourFormat, string(k[len(k)-len(hourFormat):]))
+			if err != nil || !at.Before(before) {
+				continue
+			}
+			if err := wb.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return wb.Flush()
+}
+
+// rollUpMonths writes the monthly counters of the months ended since the last roll up.
+func (h *Handler) rollUpMonths(now time.Time) error {
+	month := now.Format(monthFormat)
+	ended := time.Date(now.Year(), now.Month(), 0, 0, 0, 0, 0, time.UTC).Format(monthFormat)
+
+	var last string
+	months := map[string]uint64{}
+	err := h.db.View(func(txn *badger.Txn) error {
+		b, err := getValue(txn, rolledUpKey())
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		if last = string(b); last >= ended {
+			return nil
+		}
+
+		// daily keys are the parts of the link then the day, the monthly keys the same parts then the month
+		daily, monthly := metaPrefixKey("daily"), string(metaPrefixKey("monthly"))
+		return iteratePrefix(txn, daily, func(key string, value []byte) error {
+			if len(key) < len(dayFormat) {
+				return nil
+			}
+			link, day := key[:len(key)-len(dayFormat)], key[len(key)-len(dayFormat):]
+			if m := day[:len(monthFormat)]; m > last && m < month {
+				months[monthly+link+m] += decodeCounter(value)
+			}
+			return nil
+		})
+	})
+	if err != nil || last >= ended {
+		return err
+	}
+
+	wb := h.db.NewWriteBatch()
+	defer wb.Cancel()
+	for k, n := range months {
+		if err := wb.Set([]byte(k), encodeCounter64(n)); err != nil {
+			return err
+		}
+	}
+	if err := wb.Set(rolledUpKey(), []byte(ended)); err != nil {
+		return err
+	}
+	if err := wb.Flush(); err != nil {
+		return err
+	}
+	h.log(LogAdmin).Infof("Rolled up the clicks of %d link months until %s", len(months), ended)
+	return nil
+}
+
+// ClicksBetween returns the clicks on a link from the day of from until the day of to, excluded, in UTC.
+// The months rolled up are read from their monthly counter, the other days from their daily counter.
+func (h *Handler) ClicksBetween(id string, from, to time.Time, opts ...ReqOptions) (uint64, error) {
+	if err := h.ready(); err != nil {
+		return 0, err
+	}
+	defer h.release()
+	id = h.normalizeID(id)
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	var clicks uint64
+	err := h.db.View(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		l := linkRef{domain: r.domain, id: id}
+
+		b, err := getValue(txn, rolledUpKey())
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		last := string(b)
+
+		from := from.UTC().Truncate(24 * time.Hour)
+		to := to.UTC().Truncate(24 * time.Hour)
+		for d := from; d.Before(to); {
+			next := d.AddDate(0, 1, 0)
+			if m := d.Format(monthFormat); d.Day() == 1 && m <= last && !next.After(to) {
+				n, err := getCounter(txn, append(monthlyPrefix(l), m...))
+				if err != nil {
+					return err
+				}
+				clicks += n
+				d = next
+				continue
+			}
+
+			n, err := getCounter(txn, append(dailyPrefix(l), d.Format(dayFormat)...))
+			if err != nil {
+				return err
+			}
+			clicks += n
+			d = d.AddDate(0, 0, 1)
+		}
+		return nil
+	})
+	return clicks, err
+}
@@ -0,0 +1,31 @@
+package coopurl
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The adapters below mount the redirects of a handler, "{id}", "{id}+" and "{id}.json", on the usual routers
+// without the library depending on them. Echo needs none: e.Any("/r/:id", echo.WrapHandler(h)).
+
+// ChiRouter is the method of chi.Router used by ChiRoutes.
+type ChiRouter interface {
+	Method(method, pattern string, h http.Handler)
+}
+
+// ChiRoutes registers the redirects of h on r, eg: r.Route("/r", func(r chi.Router) { coopurl.ChiRoutes(r, h) }).
+// The ids are also routed with a trailing slash, which chi doesn't strip.
+func ChiRoutes(r ChiRouter, h *Handler) {
+	for _, m := range strings.Split(h.allowedMethods(), ", ") {
+		r.Method(m, "/{id}", h)
+		r.Method(m, "/{id}/", h)
+	}
+}
+
+// Mount registers the redirects of h under prefix on mux, eg: Mount(http.DefaultServeMux, "/r/", h).
+func Mount(mux *http.ServeMux, prefix string, h *Handler) {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	mux.Handle(prefix, h)
+}
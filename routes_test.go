@@ -0,0 +1,52 @@
+package coopurl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// chiFake routes like chi, "{id}" matching a single path segment and trailing slashes being kept.
+type chiFake map[string]http.Handler
+
+func (c chiFake) Method(method, pattern string, h http.Handler) {
+	c[method+" "+pattern] = h
+}
+
+func (c chiFake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pattern := "/{id}"
+	if strings.HasSuffix(r.URL.Path, "/") {
+		pattern += "/"
+	}
+	if strings.Count(strings.TrimSuffix(r.URL.Path, "/"), "/") != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	h, ok := c[r.Method+" "+pattern]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+func TestChiRoutes(t *testing.T) {
+	h := newTestHandler(t)
+	id, err := h.Post("https://coopgo.fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := chiFake{}
+	ChiRoutes(r, h)
+
+	for _, path := range []string{"/" + id, "/" + id + "/"} {
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+			if w.Code != http.StatusFound && w.Code != http.StatusMovedPermanently {
+				t.Errorf("%s %s: status %d", method, path, w.Code)
+			}
+		}
+	}
+}
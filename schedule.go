@@ -0,0 +1,161 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// scheduleInterval is the interval between the runs applying the scheduled changes.
+const scheduleInterval = time.Minute
+
+// ScheduledChange is a change of the destination of a link scheduled with ScheduleUpdate.
+type ScheduledChange struct {
+	ID     string    `json:"id"`
+	Domain string    `json:"domain,omitempty"`
+	URL    string    `json:"url"`
+	At     time.Time `json:"at"`
+	Actor  string    `json:"actor,omitempty"`
+}
+
+func scheduleKey(l linkRef) []byte {
+	return metaKey("schedule", l.domain, l.id)
+}
+
+// ScheduleUpdate changes the destination of the link with the given id to url at the given time, eg: for a
+// registration link to go to the recording after the event. The change is applied by a background job within
+// a minute, like Update, and replaces the change already scheduled for the link, if any.
+func (h *Handler) ScheduleUpdate(id, url string, at time.Time, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	if at.IsZero() {
+		return ErrInvalidOption
+	}
+	id = h.normalizeID(id)
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	u, _, err := h.destination(url)
+	if err != nil {
+		return err
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err = h.db.Update(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		l := linkRef{domain: r.domain, id: id}
+		if _, err := txn.Get(l.key()); err != nil {
+			return err
+		}
+		b, err := json.Marshal(ScheduledChange{ID: id, Domain: r.domain, URL: u.String(), At: at.UTC(), Actor: r.actor})
+		if err != nil {
+			return err
+		}
+		return txn.Set(scheduleKey(l), b)
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	h.log(LogAdmin).Infof("Schedule update of %s to %s at %s", id, u, at)
+	return nil
+}
+
+// CancelScheduledUpdate cancels the change scheduled for the link with the given id, if any.
+func (h *Handler) CancelScheduledUpdate(id string, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	id = h.normalizeID(id)
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	return h.db.Update(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		return txn.Delete(scheduleKey(linkRef{domain: r.domain, id: id}))
+	})
+}
+
+// ScheduledUpdates returns the changes scheduled for the links, the next one first.
+func (h *Handler) ScheduledUpdates() ([]ScheduledChange, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	return h.scheduledUpdates()
+}
+
+func (h *Handler) scheduledUpdates() ([]ScheduledChange, error) {
+	var changes []ScheduledChange
+	err := h.db.View(func(txn *badger.Txn) error {
+		return iteratePrefix(txn, metaPrefixKey("schedule"), func(_ string, v []byte) error {
+			var c ScheduledChange
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			changes = append(changes, c)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].At.Before(changes[j].At) })
+	return changes, nil
+}
+
+// applySchedule applies the changes whose time has come.
+func (h *Handler) applySchedule() error {
+	changes, err := h.scheduledUpdates()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, c := range changes {
+		if c.At.After(now) {
+			break
+		}
+		err := h.Update(c.ID, c.URL, WithDomain(c.Domain), WithActor(c.Actor))
+		if errors.Is(err, ErrClosed) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			h.log(LogAdmin).Errorf("Couldn't apply the scheduled update of %s: %s", c.ID, err)
+			continue
+		}
+		err = h.db.Update(func(txn *badger.Txn) error {
+			return txn.Delete(scheduleKey(linkRef{domain: c.Domain, id: c.ID}))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Screening are the lists screening the generated ids and the aliases, kept in the store by SetScreening on top of
+// the ones of the options: the blocked words, that ids can't contain, and the protected brands, that ids can't
+// contain nor resemble, eg: "paypa1" or "gogle" for "paypal" and "google".
+type Screening struct {
+	BlockedWords    []string `json:"blocked_words,omitempty" yaml:"blocked_words,omitempty"`
+	ProtectedBrands []string `json:"protected_brands,omitempty" yaml:"protected_brands,omitempty"`
+}
+
+// minTyposquatLength is the length of the brands whose misspellings are screened, shorter ones being only
+// screened when written as is, so that short ids aren't too often refused.
+const minTyposquatLength = 5
+
+// WithProtectedBrands adds brands that generated ids and aliases can't contain, even written with look-alike
+// digits or separators, eg: "g00-gle", nor resemble by one letter missing, added, changed or swapped.
+func WithProtectedBrands(brands ...string) Options {
+	return func(h *Handler) {
+		for _, b := range brands {
+			h.protectedBrands = append(h.protectedBrands, screenForm(b))
+		}
+	}
+}
+
+func screeningKey() []byte {
+	return metaKey("screening", "lists")
+}
+
+// Screening returns the lists stored by SetScreening.
+func (h *Handler) Screening() (Screening, error) {
+	if err := h.ready(); err != nil {
+		return Screening{}, err
+	}
+	defer h.release()
+	var s Screening
+	err := h.db.View(func(txn *badger.Txn) error {
+		var err error
+		s, err = getScreening(txn)
+		return err
+	})
+	return s, err
+}
+
+// SetScreening replaces the lists kept in the store, which screen the ids from now on along with the ones of the
+// options, and after the handler is opened again. Replicas apply them when opened or reloaded, see Reload.
+func (h *Handler) SetScreening(s Screening) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	s = s.normalized()
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := h.updateRetry(func(txn *badger.Txn) error { return txn.Set(screeningKey(), b) }); err != nil {
+		return err
+	}
+	h.settingsMu.Lock()
+	h.screening = s
+	h.settingsMu.Unlock()
+	h.log(LogAdmin).Infof("Set the screening lists: %d blocked words, %d protected brands", len(s.BlockedWords), len(s.ProtectedBrands))
+	return nil
+}
+
+func getScreening(txn *badger.Txn) (Screening, error) {
+	var s Screening
+	b, err := getValue(txn, screeningKey())
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	return s, json.Unmarshal(b, &s)
+}
+
+// loadScreening reads the lists kept in the store.
+func (h *Handler) loadScreening() error {
+	var s Screening
+	err := h.db.View(func(txn *badger.Txn) error {
+		var err error
+		s, err = getScreening(txn)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	h.settingsMu.Lock()
+	h.screening = s.normalized()
+	h.settingsMu.Unlock()
+	return nil
+}
+
+func (s Screening) normalized() Screening {
+	var n Screening
+	for _, w := range s.BlockedWords {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			n.BlockedWords = append(n.BlockedWords, w)
+		}
+	}
+	for _, b := range s.ProtectedBrands {
+		if b = screenForm(b); b != "" {
+			n.ProtectedBrands = append(n.ProtectedBrands, b)
+		}
+	}
+	return n
+}
+
+// lookAlikes are the characters read as letters in ids, eg: "sh1t", and the separators ignored, eg: "s-h-i-t".
+// The other readings, eg: "1" for "l", are one edit away for the brands.
+var lookAlikes = strings.NewReplacer("0", "o", "1", "i", "!", "i", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b", "$", "s", "@", "a", "-", "", "_", "", ".", "", " ", "")
+
+// screenForm returns the id as it reads, lower case without separators and with the look-alike digits replaced.
+func screenForm(id string) string {
+	return lookAlikes.Replace(strings.ToLower(id))
+}
+
+// minSubstringWord is the length from which the blocked words are looked for inside the ids, the shorter ones
+// being found in too many words, eg: "pute" in "computer" or "reputation".
+const minSubstringWord = 5
+
+// blocked tells if the id, lower case, or its screen form contains one of the words. The short words must be a
+// token of the id, between its separators, or the whole id as it reads, eg: "s-h-i-t".
+func blocked(id, form string, words []string) bool {
+	var tokens []string
+	for _, w := range words {
+		if len(w) >= minSubstringWord {
+			if strings.Contains(id, w) || strings.Contains(form, lookAlikes.Replace(w)) {
+				return true
+			}
+			continue
+		}
+		if form == lookAlikes.Replace(w) {
+			return true
+		}
+		if tokens == nil {
+			tokens = strings.FieldsFunc(id, func(c rune) bool { return c == '-' || c == '_' || c == '~' })
+		}
+		for _, t := range tokens {
+			if t == w || screenForm(t) == lookAlikes.Replace(w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typosquats tells if the screen form of an id contains one of the brands, or a misspelling of the long ones.
+func typosquats(form string, brands []string) bool {
+	for _, b := range brands {
+		if b == "" {
+			continue
+		}
+		if strings.Contains(form, b) {
+			return true
+		}
+		if len(b) < minTyposquatLength {
+			continue
+		}
+		for n := len(b) - 1; n <= len(b)+1; n++ {
+			for i := 0; i+n <= len(form); i++ {
+				if editDistance(form[i:i+n], b) <= 1 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// editDistance returns the Damerau-Levenshtein distance of a and b, counting the swaps of two letters as one
+// edit.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,69 @@
+package coopurl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ClickPoint is the clicks on a link during an hour or a day.
+type ClickPoint struct {
+	Time   time.Time `json:"time"`
+	Clicks uint64    `json:"clicks"`
+}
+
+// maxClickPoints bounds the points returned by ClickSeries.
+const maxClickPoints = 10000
+
+// ClickSeries returns the clicks on a link per hour if hourly, per day otherwise, from from until to, excluded,
+// in UTC, eg: to graph them. Periods without clicks have a point too. As the hourly counters are kept a week,
+// hourly series start a week ago at most. With AnalyticsPrivate, clicks aren't counted per hour and the series
+// are per day.
+func (h *Handler) ClickSeries(id string, from, to time.Time, hourly bool, opts ...ReqOptions) ([]ClickPoint, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	id = h.normalizeID(id)
+	if !validID(id) || !h.verify(id) {
+		return nil, ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	step, format, prefix := 24*time.Hour, dayFormat, dailyPrefix
+	if hourly && h.analytics != AnalyticsPrivate {
+		step, format, prefix = time.Hour, hourFormat, hourlyPrefix
+		if oldest := time.Now().Add(-hourlyRetention); from.Before(oldest) {
+			from = oldest
+		}
+	}
+	from, to = from.UTC().Truncate(step), to.UTC()
+
+	counts := map[string]uint64{}
+	err := h.db.View(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		if _, err := txn.Get(linkKey(r.domain, id)); err != nil {
+			return err
+		}
+		return iterateCounters(txn, prefix(linkRef{domain: r.domain, id: id}), counts)
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var points []ClickPoint
+	for t := from; t.Before(to) && len(points) < maxClickPoints; t = t.Add(step) {
+		points = append(points, ClickPoint{Time: t, Clicks: counts[t.Format(format)]})
+	}
+	return points, nil
+}
@@ -0,0 +1,574 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+
+	"github.com/gorilla/mux"
+)
+
+// RequireToken only lets through requests with the given bearer token.
+func RequireToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) != 1 {
+				writeAPIError(w, r, http.StatusUnauthorized, codeUnauthorized, "missing or invalid token", nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ServeBroken lists the links whose destination was found unreachable.
+func ServeBroken(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		links, err := h.ListBroken()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, links)
+	}
+}
+
+// Bounds of the pages of links listed by ServeLinks.
+const (
+	defaultLinksLimit = 100
+	maxLinksLimit     = 1000
+)
+
+// LinksPage is a page of the links listed by ServeLinks.
+type LinksPage struct {
+	Links []coopurl.Entry `json:"links"`
+	Total int             `json:"total"` // links matching the query
+}
+
+// ServeLinks lists the links matching the "q" query value, eg: `tag=q3 AND created>2024-01-01`, all of them if
+// empty, newest first, by pages given by the "offset" and "limit" query values.
+func ServeLinks(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query()
+		q, err := coopurl.ParseQuery(v.Get("q"))
+		if err != nil {
+			writeAPIError(w, r, http.StatusBadRequest, coopurl.ErrInvalidQuery.Code, err.Error(), nil)
+			return
+		}
+		offset, limit := 0, defaultLinksLimit
+		if s := v.Get("offset"); s != "" {
+			if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+				badRequest(w, r, "invalid offset")
+				return
+			}
+		}
+		if s := v.Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxLinksLimit {
+				badRequest(w, r, "limit must be between 1 and "+strconv.Itoa(maxLinksLimit))
+				return
+			}
+		}
+
+		links, total, err := h.Links(q, offset, limit)
+		if err != nil {
+			if errors.Is(err, coopurl.ErrInvalidQuery) {
+				writeAPIError(w, r, http.StatusBadRequest, coopurl.ErrInvalidQuery.Code, err.Error(), nil)
+				return
+			}
+			writeError(w, r, err)
+			return
+		}
+		if links == nil {
+			links = []coopurl.Entry{}
+		}
+		writeJSON(w, http.StatusOK, LinksPage{Links: links, Total: total})
+	}
+}
+
+// ServeDisable disables or enables the link given in the url.
+func ServeDisable(h *coopurl.Handler, disable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		var err error
+		if disable {
+			err = h.Disable(id)
+		} else {
+			err = h.Enable(id)
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServePublic adds the link given in the url to the public index or removes it.
+func ServePublic(h *coopurl.Handler, public bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := h.SetPublic(mux.Vars(r)["id"], public)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeRestore restores the soft deleted link given in the url.
+func ServeRestore(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := h.Restore(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeEntry serves the link given in the url with its metadata, answering conditional requests on its ETag.
+func ServeEntry(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, err := h.GetEntry(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		// no Last-Modified, the clicks change without the link being modified
+		if coopurl.NotModified(w, r, e.ETag(), time.Time{}) {
+			return
+		}
+		writeJSON(w, http.StatusOK, e)
+	}
+}
+
+// ServePublish publishes the draft given in the url.
+func ServePublish(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.Publish(mux.Vars(r)["id"]); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeSchedule schedules the change of the destination of the link given in the url to the "url" form value
+// at the "at" one, in RFC 3339, eg: "2024-06-01T18:00:00+02:00".
+func ServeSchedule(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		at, err := time.Parse(time.RFC3339, r.FormValue("at"))
+		if err != nil {
+			badRequest(w, r, "at must be a time in RFC 3339, eg: 2024-06-01T18:00:00Z")
+			return
+		}
+
+		err = h.ScheduleUpdate(mux.Vars(r)["id"], r.FormValue("url"), at)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeCancelSchedule cancels the change scheduled for the link given in the url.
+func ServeCancelSchedule(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.CancelScheduledUpdate(mux.Vars(r)["id"]); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeStartCanary sends the percent of the visitors given in the form to the url given in the form, for the link
+// given in the url.
+func ServeStartCanary(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		percent, err := strconv.Atoi(r.FormValue("percent"))
+		if err != nil {
+			badRequest(w, r, "percent must be a number from 1 to 99")
+			return
+		}
+		if err := h.StartCanary(mux.Vars(r)["id"], r.FormValue("url"), percent); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeEndCanary ends the canary of the link given in the url, promoting it if promote is true.
+func ServeEndCanary(h *coopurl.Handler, promote bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		end := h.EndCanary
+		if promote {
+			end = h.PromoteCanary
+		}
+		if err := end(mux.Vars(r)["id"]); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeSchedules serves the scheduled changes, the next one first.
+func ServeSchedules(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		changes, err := h.ScheduledUpdates()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if changes == nil {
+			changes = []coopurl.ScheduledChange{}
+		}
+		writeJSON(w, http.StatusOK, changes)
+	}
+}
+
+// ServeTouch sets the ttl of the link given in the url to the "ttl" form value, eg: "72h", "0" for no expiry.
+func ServeTouch(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ttl, err := time.ParseDuration(r.FormValue("ttl"))
+		if err != nil || ttl < 0 {
+			badRequest(w, r, "ttl must be a duration, eg: 72h")
+			return
+		}
+
+		err = h.Touch(mux.Vars(r)["id"], ttl)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ifMatch returns the IfVersion of the If-Match header of the request, which carries the version of the link
+// as an entity tag, eg: "3". Requests without If-Match change the link whatever its version.
+func ifMatch(r *http.Request) (coopurl.ReqOptions, bool) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return coopurl.IfVersion(0), true
+	}
+	version, err := strconv.Atoi(strings.Trim(v, `"`))
+	if err != nil || version <= 0 {
+		return nil, false
+	}
+	return coopurl.IfVersion(version), true
+}
+
+// ServeUpdate changes the destination of the link given in the url to the "u" form value, if the link is still
+// at the version of the If-Match header.
+func ServeUpdate(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := r.FormValue("u")
+		if u == "" {
+			badRequest(w, r, "u must be the destination url")
+			return
+		}
+		version, ok := ifMatch(r)
+		if !ok {
+			badRequest(w, r, `If-Match must be the version of the link, eg: "3"`)
+			return
+		}
+
+		err := h.Update(mux.Vars(r)["id"], u, coopurl.WithActor("admin"), version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeHistory lists the changes of the destination of the link given in the url.
+func ServeHistory(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		revs, err := h.History(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, revs)
+	}
+}
+
+// ServeJobs serves the state of the maintenance jobs.
+func ServeJobs(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, h.Jobs())
+	}
+}
+
+// ServeExpiring lists the links expiring within the "within" query value, eg: "72h", 24h by default.
+func ServeExpiring(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		within := 24 * time.Hour
+		if v := r.URL.Query().Get("within"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				badRequest(w, r, "within must be a duration, eg: 72h")
+				return
+			}
+			within = d
+		}
+
+		links, err := h.ListExpiring(within)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, links)
+	}
+}
+
+type StoreStatsData struct {
+	*coopurl.StoreStats
+	Forecast string `json:"forecast"`
+}
+
+// ServeStoreStats serves the store size, growth rates and disk usage forecast.
+func ServeStoreStats(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := h.StoreStats()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, StoreStatsData{StoreStats: s, Forecast: s.Forecast()})
+	}
+}
+
+// ServeCounts serves the number of links under each value of the index given in the url, eg: /admin/counts/tag,
+// or under the value given by the "value" query value.
+func ServeCounts(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		index := mux.Vars(r)["index"]
+		if v := r.URL.Query().Get("value"); v != "" {
+			n, err := h.Count(index, v)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]int{v: n})
+			return
+		}
+		counts, err := h.Counts(index)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, counts)
+	}
+}
+
+// ServeMoveStore moves the store to the directory given in the "path" form value, eg: off /tmp/badger.
+func ServeMoveStore(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := r.FormValue("path")
+		if path == "" {
+			badRequest(w, r, "path must be the directory to move the store to")
+			return
+		}
+		if err := h.MoveTo(path); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServePromote makes a standby writable, once its primary is down.
+func ServePromote(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.Promote(); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// maxPageSize bounds the JSON documents of pages.
+const maxPageSize = 1 << 20
+
+// decodePage decodes the page given as JSON in the request body, answering bad requests itself.
+func decodePage(w http.ResponseWriter, r *http.Request) (coopurl.Page, bool) {
+	var p coopurl.Page
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPageSize)).Decode(&p); err != nil {
+		badRequest(w, r, "the body must be a json page")
+		return p, false
+	}
+	return p, true
+}
+
+// ServePostPage creates the page given as JSON, eg: {"title": "...", "buttons": [{"label": "...", "url": "..."}]}.
+func ServePostPage(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := decodePage(w, r)
+		if !ok {
+			return
+		}
+		id, err := h.PostPage(p)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+	}
+}
+
+// ServeUpdatePage replaces the page of the link given in the url by the page given as JSON, if the link is still
+// at the version of the If-Match header.
+func ServeUpdatePage(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version, ok := ifMatch(r)
+		if !ok {
+			badRequest(w, r, `If-Match must be the version of the link, eg: "3"`)
+			return
+		}
+		p, ok := decodePage(w, r)
+		if !ok {
+			return
+		}
+		if err := h.UpdatePage(mux.Vars(r)["id"], p, version); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeClone copies the link given in the url to a new link, and answers its id. The "alias", "ttl", "campaign"
+// and comma separated "tags" form values are optional, eg: to reuse a link for a new campaign.
+func ServeClone(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var opts []coopurl.ReqOptions
+		if alias := r.FormValue("alias"); alias != "" {
+			opts = append(opts, coopurl.WithAlias(alias))
+		}
+		if v := r.FormValue("ttl"); v != "" {
+			ttl, err := time.ParseDuration(v)
+			if err != nil || ttl < 0 {
+				badRequest(w, r, "ttl must be a duration, eg: 72h")
+				return
+			}
+			opts = append(opts, coopurl.WithTTL(ttl))
+		}
+		if campaign := r.FormValue("campaign"); campaign != "" {
+			opts = append(opts, coopurl.WithCampaign(campaign))
+		}
+		for _, tag := range strings.Split(r.FormValue("tags"), ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				opts = append(opts, coopurl.WithTags(tag))
+			}
+		}
+
+		id, err := h.Clone(mux.Vars(r)["id"], opts...)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+	}
+}
+
+// ServeCreationLimits lists the state of the creation limit of the ips which created links recently.
+func ServeCreationLimits(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		states, err := h.CreationLimits()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, states)
+	}
+}
+
+// ServeResetCreationLimit lets the ip given in the url create links again.
+func ServeResetCreationLimit(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.ResetCreationLimit(mux.Vars(r)["ip"]); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeExportDump streams a dump of the whole store, see coopurl.Handler.ExportDump. A dump failing midway is
+// cut short, without its end record, so that it can't be imported.
+func ServeExportDump(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="coopurl.dump"`)
+		if err := h.ExportDump(w); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// ServeImportDump imports the dump posted, see coopurl.Handler.ImportDump.
+func ServeImportDump(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := h.ImportDump(r.Body)
+		switch {
+		case errors.Is(err, coopurl.ErrInvalidDump):
+			writeAPIError(w, r, http.StatusBadRequest, "invalid_dump", err.Error(), nil)
+			return
+		case err != nil:
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeScreening serves the screening lists kept in the store.
+func ServeScreening(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := h.Screening()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	}
+}
+
+// ServeSetScreening replaces the screening lists kept in the store by the ones given as JSON, eg:
+// {"blocked_words": ["spam"], "protected_brands": ["paypal"]}.
+func ServeSetScreening(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var s coopurl.Screening
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPageSize)).Decode(&s); err != nil {
+			badRequest(w, r, "the body must be json screening lists")
+			return
+		}
+		if err := h.SetScreening(s); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs parses a comma separated list of CIDR ranges, eg: "10.0.0.0/8,192.168.1.0/24".
+// Plain ip addresses are accepted as single address ranges.
+func ParseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// AllowCIDRs only lets through requests coming from the given ranges, all requests pass if there are none.
+// The client address is the remote address of the connection, so proxies must be in the ranges.
+// Requests with one of the public methods, eg: GET for redirects, always pass.
+func AllowCIDRs(nets []*net.IPNet, public ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(nets) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, m := range public {
+				if r.Method == m {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			if !allowed(nets, r.RemoteAddr) {
+				writeAPIError(w, r, http.StatusForbidden, codeForbidden, "the address isn't allowed", nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func allowed(nets []*net.IPNet, addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// maxBatchLinks bounds the links posted in a single batch.
+const maxBatchLinks = 1000
+
+// maxBatchSize bounds the JSON documents of batches.
+const maxBatchSize = 1 << 22
+
+// batchItem is a link of a batch, either a bare url or {"url": "...", "alias": "...", "ttl": "72h", "draft": true}.
+type batchItem struct {
+	URL   string `json:"url"`
+	Alias string `json:"alias"`
+	TTL   string `json:"ttl"`
+	Draft bool   `json:"draft"`
+}
+
+func (i *batchItem) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(b, []byte(`"`)) {
+		return json.Unmarshal(b, &i.URL)
+	}
+	type item batchItem
+	return json.Unmarshal(b, (*item)(i))
+}
+
+// batchResult is the outcome of a link of a batch, at the same index.
+type batchResult struct {
+	ID      string `json:"id,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ServeBatch creates the links given as a JSON array, and answers the result of each link, eg: to load the
+// links of a campaign from a spreadsheet. A link failing doesn't stop the other ones.
+func ServeBatch(h *coopurl.Handler, ownerHeader string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var items []batchItem
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchSize)).Decode(&items); err != nil {
+			badRequest(w, r, "the batch must be a json array of links")
+			return
+		}
+		if len(items) > maxBatchLinks {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, codeTooLarge, "the batch has too many links", map[string]int{"max_links": maxBatchLinks})
+			return
+		}
+
+		links := make([]coopurl.BatchLink, len(items))
+		for i, item := range items {
+			links[i] = coopurl.BatchLink{URL: item.URL, Alias: item.Alias, Draft: item.Draft}
+			if item.TTL == "" {
+				continue
+			}
+			ttl, err := time.ParseDuration(item.TTL)
+			if err != nil || ttl < 0 {
+				writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, "invalid ttl "+item.TTL, map[string]int{"index": i})
+				return
+			}
+			links[i].TTL = ttl
+		}
+
+		var opts []coopurl.ReqOptions
+		if owner := requestOwner(r, ownerHeader); owner != "" {
+			opts = append(opts, coopurl.WithOwner(owner))
+		}
+
+		results, err := h.PostBatch(links, opts...)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, batchResults(results))
+	}
+}
+
+// batchResults returns the outcome of each link of a batch.
+func batchResults(results []coopurl.BatchResult) []batchResult {
+	out := make([]batchResult, len(results))
+	for i, res := range results {
+		var e *coopurl.Error
+		var ue *url.Error
+		switch {
+		case res.Err == nil:
+			out[i].ID = res.ID
+		case errors.As(res.Err, &e):
+			out[i].Error, out[i].Message = e.Code, e.Message
+		case errors.As(res.Err, &ue):
+			out[i].Error, out[i].Message = "invalid_url", ue.Err.Error()
+		default:
+			out[i].Error = codeInternal
+		}
+	}
+	return out
+}
+
+// touchBatch is the body of the batch ttl requests, eg: {"ids": ["abc", "def"], "ttl": "72h"}.
+type touchBatch struct {
+	IDs []string `json:"ids"`
+	TTL string   `json:"ttl"`
+}
+
+// ServeTouchBatch sets the ttl of the links given as JSON, and answers the result of each link, eg: to extend
+// the links of a campaign when its event is prolonged. A link failing doesn't stop the other ones.
+func ServeTouchBatch(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var b touchBatch
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchSize)).Decode(&b); err != nil {
+			badRequest(w, r, "the batch must be a json object of ids and ttl")
+			return
+		}
+		if len(b.IDs) > maxBatchLinks {
+			writeAPIError(w, r, http.StatusRequestEntityTooLarge, codeTooLarge, "the batch has too many links", map[string]int{"max_links": maxBatchLinks})
+			return
+		}
+		ttl, err := time.ParseDuration(b.TTL)
+		if err != nil || ttl < 0 {
+			badRequest(w, r, "ttl must be a duration, eg: 72h")
+			return
+		}
+
+		results, err := h.TouchBatch(b.IDs, ttl)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, batchResults(results))
+	}
+}
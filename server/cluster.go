@@ -0,0 +1,62 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coopgo/coopurl/v2/cluster"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ServeJoin adds the node given by the "id" and "addr" form values to the cluster.
+func ServeJoin(n *cluster.Node) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, addr := r.FormValue("id"), r.FormValue("addr")
+		if id == "" || addr == "" {
+			badRequest(w, r, "id and addr are required")
+			return
+		}
+
+		err := n.Join(id, addr)
+		if errors.Is(err, cluster.ErrNotLeader) {
+			writeAPIError(w, r, http.StatusServiceUnavailable, codeNotLeader, "the node isn't the leader of the cluster", nil)
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// JoinCluster asks a member of the cluster serving its api at apiURL to add this node, until it succeeds.
+func JoinCluster(apiURL, token, id, addr string, logger logrus.FieldLogger) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	form := url.Values{"id": {id}, "addr": {addr}}
+	for {
+		req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(apiURL, "/")+"/admin/cluster/join", strings.NewReader(form.Encode()))
+		if err != nil {
+			logger.Errorf("Couldn't join the cluster: %s", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusNoContent {
+				logger.Infof("Joined the cluster through %s", apiURL)
+				return
+			}
+			err = errors.New(resp.Status)
+		}
+		logger.Warningf("Couldn't join the cluster through %s: %s", apiURL, err)
+		time.Sleep(5 * time.Second)
+	}
+}
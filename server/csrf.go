@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+const (
+	CSRFHeader = "X-CSRF-Token" // header of the csrf token, answered on the safe requests for the scripts
+	csrfField  = "csrf_token"   // form field of the csrf token
+	csrfCookie = "coopurl_csrf"
+)
+
+type csrfKey struct{}
+
+// CSRF protects the forms of the pages and the admin actions from cross-site submissions, with a signed
+// double-submit cookie: the browser gets a random cookie, and the forms carry its hmac, which other sites can
+// neither read nor compute. Requests with a bearer token, which browsers don't add on their own, and those
+// without Origin nor Sec-Fetch-Site header, which aren't sent by browsers, aren't checked.
+type CSRF struct {
+	key   []byte
+	pages *Pages // renders the rejections of the forms, api errors are answered if nil
+}
+
+// NewCSRF returns the csrf protection signing its tokens with key, random if empty so that the forms loaded
+// before a restart have to be loaded again.
+func NewCSRF(key []byte, pages *Pages) (*CSRF, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &CSRF{key: key, pages: pages}, nil
+}
+
+func (c *CSRF) token(nonce string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte("csrf." + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Protect gives the token of the browser to the templates of next, setting its cookie if missing, and rejects
+// the unsafe requests without it.
+func (c *CSRF) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var nonce string
+		if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+			nonce = cookie.Value
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if c.exempt(r) {
+				break
+			}
+			t := r.Header.Get(CSRFHeader)
+			if t == "" {
+				t = r.PostFormValue(csrfField)
+			}
+			if nonce == "" || !hmac.Equal([]byte(t), []byte(c.token(nonce))) {
+				c.reject(w, r)
+				return
+			}
+		}
+
+		if nonce == "" {
+			nonce = randomString()
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    nonce,
+				Path:     "/",
+				Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		token := c.token(nonce)
+		w.Header().Set(CSRFHeader, token)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, token)))
+	})
+}
+
+// exempt tells if the request can't be a cross-site submission of a browser.
+func (c *CSRF) exempt(r *http.Request) bool {
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return true
+	}
+	return r.Header.Get("Origin") == "" && r.Header.Get("Sec-Fetch-Site") == ""
+}
+
+func (c *CSRF) reject(w http.ResponseWriter, r *http.Request) {
+	if c.pages != nil && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		c.pages.Error(w, r, http.StatusForbidden, "The form expired, please reload the page and try again")
+		return
+	}
+	writeAPIError(w, r, http.StatusForbidden, codeForbidden, "missing or invalid csrf token", nil)
+}
+
+// CSRFToken returns the csrf token of the request, empty if it isn't protected.
+func CSRFToken(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+// csrfInput returns the hidden field of the csrf token of the request, added to the forms by {{csrfField}}.
+func csrfInput(r *http.Request) template.HTML {
+	token := CSRFToken(r)
+	if token == "" {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + csrfField + `" value="` + token + `">`)
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// DebugHandler serves the pprof profiles under /debug/pprof/ and the expvar variables under /debug/vars,
+// including the badger metrics and the store stats of h, the redirect metrics at /metrics and the metrics of
+// the maintenance jobs at /metrics/jobs.
+// It must only be served on a private address, as profiles expose the internals of the process.
+func DebugHandler(h *coopurl.Handler, metrics *coopurl.RedirectMetrics) http.Handler {
+	expvar.Publish("coopurl_store", expvar.Func(func() interface{} {
+		s, err := h.StoreStats()
+		if err != nil {
+			return err.Error()
+		}
+		return s
+	}))
+
+	m := http.NewServeMux()
+	m.HandleFunc("/debug/pprof/", pprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	m.Handle("/debug/vars", expvar.Handler())
+	m.Handle("/metrics", metrics)
+	m.HandleFunc("/metrics/jobs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		h.WriteJobMetrics(w)
+	})
+	return m
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// APIError is the body of the error responses of the api. Clients branch on its code, the ones of the
+// coopurl errors or the ones below, as the message is meant for humans.
+type APIError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// Codes of the errors that aren't from coopurl.
+const (
+	codeBadRequest   = "bad_request"
+	codeUnauthorized = "unauthorized"
+	codeForbidden    = "forbidden"
+	codeTooLarge     = "too_large"
+	codeNotLeader    = "not_leader"
+	codeInternal     = "internal"
+)
+
+// writeAPIError answers the request with an APIError.
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, code, message string, details interface{}) {
+	writeJSON(w, status, APIError{Code: code, Message: message, Details: details, RequestID: coopurl.RequestIDFrom(r.Context())})
+}
+
+// writeError answers err with the status and code of the coopurl errors, the others are internal errors
+// whose message isn't disclosed.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *coopurl.Error
+	if !errors.As(err, &e) {
+		writeAPIError(w, r, http.StatusInternalServerError, codeInternal, "internal error", nil)
+		return
+	}
+	writeAPIError(w, r, coopurl.HTTPStatus(err), e.Code, e.Message, nil)
+}
+
+// badRequest answers a request whose parameters are invalid.
+func badRequest(w http.ResponseWriter, r *http.Request, message string) {
+	writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, message, nil)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+
+	"github.com/coopgo/coopurl/v2"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SMTPConfig is the mail server sending the expiry notices.
+type SMTPConfig struct {
+	Addr     string // host:port
+	From     string
+	Username string // no authentication if empty
+	Password string
+}
+
+// ExpiryMailer emails the expiry notices to the owners of the links, owners which aren't email addresses are skipped.
+// Links are shown under base, eg: https://coopgo.fr/r/.
+func ExpiryMailer(cfg SMTPConfig, base string, logger logrus.FieldLogger) func(coopurl.ExpiryNotice) {
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return func(n coopurl.ExpiryNotice) {
+		to, err := mail.ParseAddress(n.Owner)
+		if err != nil {
+			logger.Debugf("Skipping expiry notice of %s, its owner isn't an email address", n.ID)
+			return
+		}
+
+		link := n.ID
+		if base != "" {
+			link = strings.TrimSuffix(base, "/") + "/" + n.ID
+		}
+		msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: Your link %s expires soon\r\n"+
+			"Content-Type: text/plain; charset=utf-8\r\n\r\n"+
+			"Your short link %s to %s will stop working on %s.\r\n",
+			cfg.From, to.String(), n.ID, link, n.URL, n.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"))
+		if err := smtp.SendMail(cfg.Addr, auth, cfg.From, []string{to.Address}, []byte(msg)); err != nil {
+			logger.Errorf("Couldn't email expiry notice of %s: %s", n.ID, err)
+		}
+	}
+}
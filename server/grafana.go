@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// The Grafana JSON datasource, eg: the simpod-json-datasource plugin, queries the clicks of the links whose
+// targets are their ids, "domain/id" for the links of a domain. Points are hourly for ranges of up to two days.
+const hourlySeriesRange = 48 * time.Hour
+
+// grafanaQuery is the body of the query requests of the Grafana JSON datasource.
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+	} `json:"targets"`
+}
+
+// grafanaSeries is a time series answered to Grafana, its datapoints are [value, unix milliseconds].
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	RefID      string       `json:"refId,omitempty"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+func splitTarget(target string) (string, string) {
+	if i := strings.LastIndex(target, "/"); i >= 0 {
+		return target[i+1:], target[:i]
+	}
+	return target, ""
+}
+
+// ServeGrafanaHealth answers the test of the datasource.
+func ServeGrafanaHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// ServeGrafanaSearch answers the targets matching the one typed, the link it names if it exists.
+func ServeGrafanaSearch(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Target string `json:"target"`
+		}
+		json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req)
+
+		targets := []string{}
+		if req.Target != "" {
+			id, domain := splitTarget(req.Target)
+			if _, err := h.GetEntry(id, coopurl.WithDomain(domain)); err == nil {
+				targets = append(targets, req.Target)
+			}
+		}
+		writeJSON(w, http.StatusOK, targets)
+	}
+}
+
+// ServeGrafanaQuery answers the clicks of the targets during the range of the query.
+func ServeGrafanaQuery(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var q grafanaQuery
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&q); err != nil {
+			badRequest(w, r, "the body must be a json query")
+			return
+		}
+		hourly := q.Range.To.Sub(q.Range.From) <= hourlySeriesRange
+
+		series := []grafanaSeries{}
+		for _, t := range q.Targets {
+			if t.Target == "" {
+				continue
+			}
+			id, domain := splitTarget(t.Target)
+			points, err := h.ClickSeries(id, q.Range.From, q.Range.To, hourly, coopurl.WithDomain(domain))
+			if errors.Is(err, coopurl.ErrNotFound) {
+				writeAPIError(w, r, http.StatusBadRequest, "not_found", "no link "+t.Target, map[string]string{"target": t.Target})
+				return
+			}
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+
+			s := grafanaSeries{Target: t.Target, RefID: t.RefID, Datapoints: make([][2]float64, len(points))}
+			for i, p := range points {
+				s.Datapoints[i] = [2]float64{float64(p.Clicks), float64(p.Time.UnixNano() / int64(time.Millisecond))}
+			}
+			series = append(series, s)
+		}
+		writeJSON(w, http.StatusOK, series)
+	}
+}
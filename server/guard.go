@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// honeypotField is the field of the creation form hidden to people, only bots filling every field fill it.
+const honeypotField = "website"
+
+// errBot is returned by the guards for the submissions they reject.
+var errBot = errors.New("submission rejected as sent by a bot")
+
+// FormGuard checks the submissions of the creation form of the home page, eg: to keep the spam bots creating
+// phishing links out of public instances. Check returns errBot for the submissions of bots, another error if
+// it couldn't tell.
+type FormGuard interface {
+	Check(r *http.Request) error
+}
+
+// Honeypot rejects the submissions filling the hidden honeypot field.
+type Honeypot struct{}
+
+func (Honeypot) Check(r *http.Request) error {
+	if r.FormValue(honeypotField) != "" {
+		return errBot
+	}
+	return nil
+}
+
+// CaptchaProvider is a captcha service whose widget is added to the creation form, and whose responses are
+// checked with its siteverify api.
+type CaptchaProvider struct {
+	Script    string // url of the script of the widget
+	Class     string // class of the element of the widget
+	Field     string // form field of the response of the widget
+	VerifyURL string
+}
+
+var (
+	HCaptcha = CaptchaProvider{
+		Script:    "https://js.hcaptcha.com/1/api.js",
+		Class:     "h-captcha",
+		Field:     "h-captcha-response",
+		VerifyURL: "https://api.hcaptcha.com/siteverify",
+	}
+	Turnstile = CaptchaProvider{
+		Script:    "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		Class:     "cf-turnstile",
+		Field:     "cf-turnstile-response",
+		VerifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	}
+)
+
+// Captcha requires the submissions of the creation form to solve the captcha of the provider.
+type Captcha struct {
+	CaptchaProvider
+	SiteKey string
+	secret  string
+	client  *http.Client
+}
+
+// NewCaptcha returns the captcha of the provider, with the site key of the widget and the secret of the api.
+func NewCaptcha(p CaptchaProvider, siteKey, secret string) *Captcha {
+	return &Captcha{CaptchaProvider: p, SiteKey: siteKey, secret: secret, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Widget returns the html of the widget, added to the creation form.
+func (c *Captcha) Widget() template.HTML {
+	return template.HTML(`<script src="` + template.HTMLEscapeString(c.Script) + `" async defer></script>` +
+		`<div class="` + template.HTMLEscapeString(c.Class) + `" data-sitekey="` + template.HTMLEscapeString(c.SiteKey) + `"></div>`)
+}
+
+func (c *Captcha) Check(r *http.Request) error {
+	response := r.FormValue(c.Field)
+	if response == "" {
+		return errBot
+	}
+	form := url.Values{"secret": {c.secret}, "response": {response}}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		form.Set("remoteip", ip)
+	}
+	resp, err := c.client.PostForm(c.VerifyURL, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var v struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return err
+	}
+	if !v.Success {
+		return errBot
+	}
+	return nil
+}
+
+// ParseCaptchaProvider returns the provider of the given name: hcaptcha or turnstile.
+func ParseCaptchaProvider(name string) (CaptchaProvider, error) {
+	switch strings.ToLower(name) {
+	case "hcaptcha":
+		return HCaptcha, nil
+	case "turnstile":
+		return Turnstile, nil
+	}
+	return CaptchaProvider{}, errors.New("unknown captcha provider " + name + ", expected hcaptcha or turnstile")
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// defaultLanguage is the language of the text of the templates, used when the request accepts no translation.
+const defaultLanguage = "en"
+
+// translations are the translations of the text of the pages, keyed by their English text, by language.
+var translations = map[string]map[string]string{
+	"fr": {
+		"URL Shortener":                                "Raccourcisseur d'URL",
+		"Paste the URL to be shortened":                "Collez l'URL à raccourcir",
+		"Enter the link here":                          "Saisissez le lien ici",
+		"Shorten URL":                                  "Raccourcir",
+		"CoopURL is a library to shorten a given URL.": "CoopURL est une bibliothèque pour raccourcir les URL.",
+		"Made with":                                    "Fait avec",
+		"by":                                           "par",
+		"Your shortened URL":                           "Votre URL raccourcie",
+		"Copy the shortened link and share it in messages, texts, posts, websites and other locations.": "Copiez le lien raccourci et partagez-le dans vos messages, publications, sites web et ailleurs.",
+		"Copy URL":              "Copier l'URL",
+		"Copied!":               "Copié !",
+		"Long URL:":             "URL longue :",
+		"Back to the home page": "Retour à l'accueil",
+
+		"The form couldn't be verified, please try again":                       "Le formulaire n'a pas pu être vérifié, veuillez réessayer",
+		"The form expired, please reload the page and try again":                "Le formulaire a expiré, veuillez recharger la page et réessayer",
+		coopurl.DefaultMaintenanceMessage:                                       "Le service est en maintenance, veuillez réessayer plus tard",
+		"Too many links were created from your address, please try again later": "Trop de liens ont été créés depuis votre adresse, veuillez réessayer plus tard",
+
+		"The destination url is unreachable":                           "L'url de destination est injoignable",
+		"The destination url is already a short link":                  "L'url de destination est déjà un lien court",
+		"The destination url is too long":                              "L'url de destination est trop longue",
+		"The Idempotency-Key was used for another url":                 "L'Idempotency-Key a déjà servi pour une autre url",
+		"The link was changed meanwhile, please reload the page":       "Le lien a été modifié entre-temps, veuillez recharger la page",
+		"The link was reported, it can only be enabled by a moderator": "Le lien a été signalé, seul un modérateur peut le réactiver",
+		"The link is invalid or expired":                               "Le lien est invalide ou a expiré",
+	},
+}
+
+// languages are the languages of the pages, the default first.
+var languages = []string{defaultLanguage, "fr"}
+
+// translate returns the text s in the language lang, s itself if it isn't translated.
+func translate(lang, s string) string {
+	if t, ok := translations[lang][s]; ok {
+		return t
+	}
+	return s
+}
+
+// pageLanguage returns the language of the page answered to the request, and tells caches it depends on it.
+func pageLanguage(w http.ResponseWriter, r *http.Request) string {
+	lang := coopurl.PreferredLanguage(r, languages...)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", lang)
+	return lang
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// InfoData is the build and state of the server served at /api/v1/info.
+type InfoData struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"go_version"`
+	*coopurl.Info
+}
+
+// buildVersion returns the version of the module of the binary when it isn't given at build time, "(devel)" when
+// built from a checkout.
+func buildVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		return bi.Main.Version
+	}
+	return "unknown"
+}
+
+// buildCommit returns the revision the binary was built from when it isn't given at build time, recorded by go
+// build in a checkout, with "-dirty" when the checkout was modified.
+func buildCommit() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision string
+	var modified bool
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	return revision
+}
+
+// ServeInfo serves the version and commit of the build, the store backend, the uptime, the number of links and
+// the features enabled, so that the tools of a fleet inventory the instances and check their deployments.
+func ServeInfo(h *coopurl.Handler, version, commit string) http.HandlerFunc {
+	if version == "" {
+		version = buildVersion()
+	}
+	if commit == "" {
+		commit = buildCommit()
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, err := h.Info()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, InfoData{Version: version, Commit: commit, GoVersion: runtime.Version(), Info: info})
+	}
+}
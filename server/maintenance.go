@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// maintenanceRetry is the delay after which the clients are asked to retry during a maintenance, in seconds.
+const maintenanceRetry = "300"
+
+// DuringMaintenance answers the forms of the pages with the message of the maintenance of the handler while in
+// maintenance, rather than the errors of the writes, eg: on the creation form.
+func DuringMaintenance(h *coopurl.Handler, pages *Pages) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if message := h.Maintenance(); message != "" && r.Method != http.MethodGet && r.Method != http.MethodHead {
+				w.Header().Set("Retry-After", maintenanceRetry)
+				pages.Error(w, r, http.StatusServiceUnavailable, message)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MaintenanceData is the state of the maintenance mode.
+type MaintenanceData struct {
+	Maintenance bool   `json:"maintenance"`
+	Message     string `json:"message,omitempty"`
+}
+
+// ServeMaintenance serves the state of the maintenance mode.
+func ServeMaintenance(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		message := h.Maintenance()
+		writeJSON(w, http.StatusOK, MaintenanceData{Maintenance: message != "", Message: message})
+	}
+}
+
+// ServeStartMaintenance puts the handler in maintenance, with the message of the "message" form value if any.
+func ServeStartMaintenance(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.StartMaintenance(r.FormValue("message"))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeEndMaintenance ends the maintenance of the handler.
+func ServeEndMaintenance(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.EndMaintenance()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/coopgo/coopurl/v2"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// redirectPrefixes returns the paths the links are served under, starting and ending with a slash, "/r/" if none.
+func redirectPrefixes(prefixes []string) []string {
+	var paths []string
+	for _, p := range prefixes {
+		p = "/" + strings.Trim(strings.TrimSpace(p), "/")
+		if p != "/" {
+			p += "/"
+		}
+		paths = append(paths, p)
+	}
+	if len(paths) == 0 {
+		paths = []string{"/r/"}
+	}
+	return paths
+}
+
+// RootRoutes detects the conflicts of the root-level codes with the other routes of a router: the first segments
+// of their paths, eg: /admin/* reserves "admin", and the extra ones, eg: served by a proxy in front of the router.
+// Its Conflicts refuses the ids taking them once Mount was called, with coopurl.WithRoutes.
+type RootRoutes struct {
+	extra    []string
+	reserved atomic.Value // map[string]bool set by Mount
+}
+
+// NewRootRoutes returns the conflicts detection of the root-level codes with the routes of a router, and with the
+// first path segments extra.
+func NewRootRoutes(extra []string) *RootRoutes {
+	rr := &RootRoutes{}
+	for _, r := range extra {
+		if r = strings.Trim(strings.TrimSpace(r), "/"); r != "" {
+			rr.extra = append(rr.extra, r)
+		}
+	}
+	return rr
+}
+
+// Conflicts tells if /id is taken by a route, case insensitive like the routes served by the router of Mount.
+func (rr *RootRoutes) Conflicts(id string) bool {
+	reserved, _ := rr.reserved.Load().(map[string]bool)
+	return reserved[strings.ToLower(id)]
+}
+
+// Mount serves the root-level codes on r with next, after all the other routes of r so that they aren't hidden,
+// and answers 404 on the reserved routes that r doesn't serve, eg: /admin without the admin api. The links taking
+// a route, created before it was, are logged as they can't be reached at the root anymore.
+func (rr *RootRoutes) Mount(r *mux.Router, h *coopurl.Handler, logger logrus.FieldLogger, next http.Handler) {
+	reserved := map[string]bool{}
+	for _, route := range rr.extra {
+		reserved[strings.ToLower(route)] = true
+	}
+	r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil // no path, eg: a host matcher
+		}
+		segment := strings.SplitN(strings.TrimPrefix(tpl, "/"), "/", 2)[0]
+		if segment != "" && !strings.Contains(segment, "{") {
+			reserved[strings.ToLower(segment)] = true
+		}
+		return nil
+	})
+	rr.reserved.Store(reserved)
+
+	for route := range reserved {
+		if e, err := h.GetEntry(route); err == nil {
+			logger.Warnf("The link %s is hidden by the route /%s, it's only served under the other prefixes", e.ID, route)
+		}
+	}
+
+	var routes []string
+	for route := range reserved {
+		routes = append(routes, route)
+	}
+	root := ExcludeRoutes(routes, next)
+	r.Handle("/{key}", root)
+	r.Handle("/{key}/", root)
+}
+
+// servesRoot tells if the links are served at the root among the prefixes.
+func servesRoot(prefixes []string) bool {
+	for _, p := range prefixes {
+		if p == "/" {
+			return true
+		}
+	}
+	return false
+}
+
+// mountRedirects serves the links with next under each prefix but the root.
+func mountRedirects(r *mux.Router, prefixes []string, next http.Handler) {
+	for _, p := range prefixes {
+		if p != "/" {
+			r.Handle(p+"{key}", next)
+			r.Handle(p+"{key}/", next)
+		}
+	}
+}
+
+// ExcludeRoutes answers 404 to the requests whose first path segment is one of routes, case insensitive, and
+// passes the others to next.
+func ExcludeRoutes(routes []string, next http.Handler) http.Handler {
+	excluded := map[string]bool{}
+	for _, r := range routes {
+		excluded[strings.ToLower(r)] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		segment := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+		if excluded[strings.ToLower(segment)] {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/coopgo/coopurl/v2"
+
+	"github.com/gorilla/mux"
+)
+
+type ownerKey struct{}
+
+// RequireOwner only lets through the requests authenticated by a proxy, which sets the user in the given header.
+// The header must be removed from the requests of clients by the proxy, or anyone could claim any link.
+func RequireOwner(header string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			owner := r.Header.Get(header)
+			if owner == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			// forms of other sites could act on behalf of the user, as the proxy authenticates them anyway
+			if r.Method != http.MethodGet && !sameOrigin(r) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ownerKey{}, owner)))
+		})
+	}
+}
+
+func ownerFrom(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerKey{}).(string)
+	return owner
+}
+
+// sameOrigin tells if the request comes from a page of the server, according to its Origin or Referer header.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Referer()
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// MyLinkData is the link shown to its owner.
+type MyLinkData struct {
+	coopurl.Entry
+	Stats *coopurl.LinkStats
+	Path  string // path of the link page, with its domain
+
+	Thumbnail string // path of the thumbnail of the destination, empty without thumbnails
+
+	Shareable bool // the stats can be shared with ServeMyShare
+}
+
+func myLinkPath(e coopurl.Entry) string {
+	return linkPath(e, "")
+}
+
+// linkPath returns the path of the page of the link under /my/links followed by suffix, with its domain.
+func linkPath(e coopurl.Entry, suffix string) string {
+	p := "/my/links/" + url.PathEscape(e.ID) + suffix
+	if e.Domain != "" {
+		p += "?" + url.Values{"domain": {e.Domain}}.Encode()
+	}
+	return p
+}
+
+// ownLink returns the link given in the url if it belongs to the user, answering the request otherwise.
+func ownLink(h *coopurl.Handler, w http.ResponseWriter, r *http.Request) (coopurl.Entry, bool) {
+	e, err := h.GetEntry(mux.Vars(r)["id"], coopurl.WithDomain(r.FormValue("domain")))
+	// links of others are hidden as missing ones
+	if err == nil && e.Owner != ownerFrom(r.Context()) {
+		err = coopurl.ErrNotFound
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return e, false
+	}
+	return e, true
+}
+
+// ServeMyLinks lists the links of the user, with the thumbnails of their destinations if enabled.
+func ServeMyLinks(h *coopurl.Handler, pages *Pages, thumbnails bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		links, err := h.OwnerLinks(ownerFrom(r.Context()))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		data := make([]MyLinkData, len(links))
+		for i, e := range links {
+			data[i] = MyLinkData{Entry: e, Path: myLinkPath(e)}
+			if thumbnails && e.Page == nil {
+				data[i].Thumbnail = linkPath(e, "/thumbnail")
+			}
+		}
+		pages.Render(w, r, "mylinks", data)
+	}
+}
+
+// ServeMyLink shows a link of the user with its stats, and a button to share them if shareable.
+func ServeMyLink(h *coopurl.Handler, pages *Pages, shareable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, ok := ownLink(h, w, r)
+		if !ok {
+			return
+		}
+		s, err := h.Stats(e.ID, coopurl.WithDomain(e.Domain))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		pages.Render(w, r, "mylink", MyLinkData{Entry: e, Stats: s, Path: myLinkPath(e), Shareable: shareable})
+	}
+}
+
+// ServeMyThumbnail serves the thumbnail of the destination of a link of the user.
+func ServeMyThumbnail(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, ok := ownLink(h, w, r)
+		if !ok {
+			return
+		}
+		t, err := h.Thumbnail(e.ID, coopurl.WithDomain(e.Domain))
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if t == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", t.ContentType)
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		w.Write(t.Data)
+	}
+}
+
+// ServeMyUpdate changes the destination of a link of the user to the "u" form value, unless it was changed since
+// the "version" form value of the page.
+func ServeMyUpdate(h *coopurl.Handler, pages *Pages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, ok := ownLink(h, w, r)
+		if !ok {
+			return
+		}
+		u := r.FormValue("u")
+		if u == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		version, _ := strconv.Atoi(r.FormValue("version"))
+
+		err := h.Update(e.ID, u, coopurl.WithDomain(e.Domain), coopurl.WithActor(e.Owner), coopurl.IfVersion(version))
+		if errors.Is(err, coopurl.ErrVersionMismatch) {
+			pages.Error(w, r, http.StatusConflict, "The link was changed meanwhile, please reload the page")
+			return
+		}
+		if errors.Is(err, coopurl.ErrUnreachable) {
+			pages.Error(w, r, http.StatusBadRequest, "The destination url is unreachable")
+			return
+		}
+		if errors.Is(err, coopurl.ErrLoop) {
+			pages.Error(w, r, http.StatusBadRequest, "The destination url is already a short link")
+			return
+		}
+		if errors.Is(err, coopurl.ErrURLTooLong) {
+			pages.Error(w, r, http.StatusBadRequest, "The destination url is too long")
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, myLinkPath(e), http.StatusSeeOther)
+	}
+}
+
+// ServeMyDisable disables or enables a link of the user, reported links can only be enabled by moderators.
+func ServeMyDisable(h *coopurl.Handler, pages *Pages, disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, ok := ownLink(h, w, r)
+		if !ok {
+			return
+		}
+
+		set := h.Disable
+		if !disabled {
+			// links reported for abuse stay disabled until a moderator enables them
+			reports, err := h.AbuseReports(e.ID, coopurl.WithDomain(e.Domain))
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if len(reports) > 0 && e.Disabled {
+				pages.Error(w, r, http.StatusForbidden, "The link was reported, it can only be enabled by a moderator")
+				return
+			}
+			set = h.Enable
+		}
+		if err := set(e.ID, coopurl.WithDomain(e.Domain)); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, myLinkPath(e), http.StatusSeeOther)
+	}
+}
+
+// ServeMyPublish publishes a draft of the user.
+func ServeMyPublish(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, ok := ownLink(h, w, r)
+		if !ok {
+			return
+		}
+		if err := h.Publish(e.ID, coopurl.WithDomain(e.Domain)); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, myLinkPath(e), http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,467 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie = "coopurl_session"
+	loginCookie   = "coopurl_login" // state, nonce and page to go back to during a login
+)
+
+const (
+	sessionDuration = 12 * time.Hour
+	loginDuration   = 10 * time.Minute
+)
+
+// jwksRefresh bounds how often the keys of the provider are fetched for an unknown key id.
+const jwksRefresh = time.Minute
+
+// OIDC logs the users of the dashboards in with an OpenID Connect provider, eg: the SSO of a company.
+// The users are the owners of their links, identified by a claim of their id token, the subject by default.
+// Sessions are kept in a cookie signed with the session key, they last 12 hours.
+type OIDC struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // url of the callback, eg: https://short.example.org/auth/callback
+	OwnerClaim   string   // claim naming the owner of the links, eg: "email", "sub" if empty
+	Admins       []string // owners allowed on the admin api
+
+	issuer   string
+	authURL  string
+	tokenURL string
+	jwksURL  string
+	key      []byte
+	client   *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewOIDC reads the configuration of the provider of issuer. Sessions are signed with key.
+func NewOIDC(issuer, clientID, clientSecret, redirectURL string, key []byte) (*OIDC, error) {
+	o := &OIDC{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		key:          key,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+
+	var config struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURL  string `json:"jwks_uri"`
+	}
+	if err := o.getJSON(o.issuer+"/.well-known/openid-configuration", &config); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(config.Issuer, "/") != o.issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q doesn't match %q", config.Issuer, issuer)
+	}
+	if config.AuthURL == "" || config.TokenURL == "" || config.JWKSURL == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+	o.issuer, o.authURL, o.tokenURL, o.jwksURL = config.Issuer, config.AuthURL, config.TokenURL, config.JWKSURL
+	return o, nil
+}
+
+func (o *OIDC) getJSON(u string, v interface{}) error {
+	res, err := o.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// sign returns value with its expiry and signature, to be stored in a cookie.
+func (o *OIDC) sign(value string, expiry time.Time) string {
+	payload := strconv.FormatInt(expiry.Unix(), 10) + "|" + value
+	mac := hmac.New(sha256.New, o.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the value of a cookie made by sign, if it's untouched and not expired.
+func (o *OIDC) verify(cookie string) (string, bool) {
+	i := strings.Index(cookie, ".")
+	if i < 0 {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(cookie[:i])
+	if err != nil {
+		return "", false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(cookie[i+1:])
+	if err != nil {
+		return "", false
+	}
+	mac := hmac.New(sha256.New, o.key)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", false
+	}
+
+	s := string(payload)
+	j := strings.Index(s, "|")
+	if j < 0 {
+		return "", false
+	}
+	exp, err := strconv.ParseInt(s[:j], 10, 64)
+	if err != nil || time.Now().Unix() >= exp {
+		return "", false
+	}
+	return s[j+1:], true
+}
+
+func (o *OIDC) setCookie(w http.ResponseWriter, name, value string, d time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    o.sign(value, time.Now().Add(d)),
+		Path:     "/",
+		MaxAge:   int(d / time.Second),
+		Secure:   strings.HasPrefix(o.RedirectURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1})
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// localPath returns p if it's a path of the server, "/" otherwise, so logins don't redirect to other sites.
+func localPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return "/"
+	}
+	return p
+}
+
+// ServeLogin sends the user to the provider, to come back to the "next" path once logged in.
+func (o *OIDC) ServeLogin(w http.ResponseWriter, r *http.Request) {
+	state, nonce := randomString(), randomString()
+	o.setCookie(w, loginCookie, state+"|"+nonce+"|"+localPath(r.FormValue("next")), loginDuration)
+
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.ClientID},
+		"redirect_uri":  {o.RedirectURL},
+		"scope":         {"openid profile email"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	sep := "?"
+	if strings.Contains(o.authURL, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, o.authURL+sep+q.Encode(), http.StatusFound)
+}
+
+// ServeCallback opens the session of the user coming back from the provider.
+func (o *OIDC) ServeCallback(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(loginCookie)
+	if err != nil {
+		http.Error(w, "The login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	login, ok := o.verify(c.Value)
+	parts := strings.SplitN(login, "|", 3)
+	if !ok || len(parts) != 3 || r.FormValue("state") != parts[0] {
+		http.Error(w, "The login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	clearCookie(w, loginCookie)
+	if e := r.FormValue("error"); e != "" {
+		http.Error(w, "The login was refused: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := o.exchange(r.Context(), r.FormValue("code"), parts[1])
+	if err != nil {
+		http.Error(w, "The login failed", http.StatusUnauthorized)
+		return
+	}
+	claim := o.OwnerClaim
+	if claim == "" {
+		claim = "sub"
+	}
+	owner, _ := claims[claim].(string)
+	if owner == "" {
+		http.Error(w, "The identity has no "+claim, http.StatusUnauthorized)
+		return
+	}
+
+	o.setCookie(w, sessionCookie, owner, sessionDuration)
+	http.Redirect(w, r, parts[2], http.StatusFound)
+}
+
+// ServeLogout closes the session of the user.
+func (o *OIDC) ServeLogout(w http.ResponseWriter, r *http.Request) {
+	clearCookie(w, sessionCookie)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// owner returns the owner of the session of the request, empty if it has none.
+// Sessions are ignored on other sites' forms, which the browser would send the cookie with.
+func (o *OIDC) owner(r *http.Request) string {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+		return ""
+	}
+	owner, _ := o.verify(c.Value)
+	return owner
+}
+
+// Sessions attributes the requests with a session to their owner, eg: the links they create.
+func (o *OIDC) Sessions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if owner := o.owner(r); owner != "" && ownerFrom(r.Context()) == "" {
+			r = r.WithContext(context.WithValue(r.Context(), ownerKey{}, owner))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireSession sends the users without session to the login, and attributes the requests to their owner.
+func (o *OIDC) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		owner := o.owner(r)
+		if owner == "" {
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "/auth/login?"+url.Values{"next": {r.URL.RequestURI()}}.Encode(), http.StatusFound)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ownerKey{}, owner)))
+	})
+}
+
+// admin tells if the request has the session of an admin.
+func (o *OIDC) admin(r *http.Request) bool {
+	owner := o.owner(r)
+	for _, a := range o.Admins {
+		if owner != "" && owner == a {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange trades the code for an id token, and returns its verified claims.
+func (o *OIDC) exchange(ctx context.Context, code, nonce string) (map[string]interface{}, error) {
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {o.RedirectURL}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	res, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint answered %s", res.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	return o.verifyIDToken(tokens.IDToken, nonce)
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of an id token.
+func (o *OIDC) verifyIDToken(token, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	key, err := o.publicKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return nil, errors.New("invalid id token signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return nil, errors.New("invalid id token signature")
+		}
+	default:
+		return nil, errors.New("unsupported id token key")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != o.issuer {
+		return nil, errors.New("id token of another issuer")
+	}
+	if !audience(claims["aud"], o.ClientID) {
+		return nil, errors.New("id token of another client")
+	}
+	if exp, _ := claims["exp"].(float64); time.Now().Unix() >= int64(exp) {
+		return nil, errors.New("expired id token")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("id token of another login")
+	}
+	return claims, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// audience tells if the aud claim, a string or an array, holds the client id.
+func audience(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if v == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// publicKey returns the key of the provider with the given id, fetching the keys again when it's unknown,
+// as providers rotate them.
+func (o *OIDC) publicKey(kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(o.fetched) < jwksRefresh {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	o.fetched = time.Now()
+	if err := o.getJSON(o.jwksURL, &set); err != nil {
+		return nil, err
+	}
+
+	o.keys = map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			o.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			o.keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// RequireAdmin lets in the requests bearing the admin token, if not empty, and those of the sessions of the
+// admins of o, if not nil.
+func RequireAdmin(token string, o *OIDC) func(http.Handler) http.Handler {
+	withToken := RequireToken(token)
+	return func(next http.Handler) http.Handler {
+		checked := withToken(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if o != nil && r.Header.Get("Authorization") == "" && o.admin(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if token == "" {
+				writeAPIError(w, r, http.StatusUnauthorized, codeUnauthorized, "an admin session is required", nil)
+				return
+			}
+			checked.ServeHTTP(w, r)
+		})
+	}
+}
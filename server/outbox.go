@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+	"github.com/coopgo/coopurl/v2/webhook"
+
+	"github.com/gorilla/mux"
+)
+
+// Webhooks delivers the events of the outbox to the webhooks of their type, eg: coopurl.EventAbuseReport, posting
+// their payload as JSON signed with secret unless it is empty.
+func Webhooks(urls map[string]string, secret []byte) func(context.Context, coopurl.Delivery) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(ctx context.Context, d coopurl.Delivery) error {
+		url, ok := urls[d.Event]
+		if !ok {
+			return fmt.Errorf("no webhook for %s events", d.Event)
+		}
+		return webhook.Post(ctx, client, url, d.Event, secret, d.Payload)
+	}
+}
+
+// ServeDeliveries serves the events of the outbox waiting to be delivered, or the dead letters if dead.
+func ServeDeliveries(h *coopurl.Handler, dead bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list := h.PendingDeliveries
+		if dead {
+			list = h.DeadLetters
+		}
+		ds, err := list()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if ds == nil {
+			ds = []coopurl.Delivery{}
+		}
+		writeJSON(w, http.StatusOK, ds)
+	}
+}
+
+// ServeRetryDeadLetter queues the dead letter given in the url again.
+func ServeRetryDeadLetter(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.RetryDeadLetter(mux.Vars(r)["id"]); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeDeleteDeadLetter deletes the dead letter given in the url.
+func ServeDeleteDeadLetter(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.DeleteDeadLetter(mux.Vars(r)["id"]); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
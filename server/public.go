@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// publicPageSize is the number of links of a page of the public index.
+const publicPageSize = 50
+
+// maxSitemapURLs is the limit of urls of a sitemap.
+const maxSitemapURLs = 50000
+
+// shortLink returns the url of the link served under base, a path at the host of the request if it isn't an
+// absolute url, "/r/" if empty.
+func shortLink(r *http.Request, base, id string) string {
+	if strings.Contains(base, "://") {
+		return strings.TrimSuffix(base, "/") + "/" + url.PathEscape(id)
+	}
+	if base == "" {
+		base = "/r/"
+	}
+	return (&url.URL{Scheme: "https", Host: r.Host, Path: strings.TrimSuffix(base, "/") + "/" + id}).String()
+}
+
+// PublicLink is a link of the public index.
+type PublicLink struct {
+	coopurl.Entry
+	ShortURL string
+	Title    string // title of the page or of the destination preview, the destination if none
+}
+
+func publicLink(r *http.Request, base string, e coopurl.Entry) PublicLink {
+	l := PublicLink{Entry: e, ShortURL: shortLink(r, base, e.ID), Title: e.URL}
+	if e.Page != nil {
+		l.Title = e.Page.Title
+	} else if e.Preview != nil && e.Preview.Title != "" {
+		l.Title = e.Preview.Title
+	}
+	return l
+}
+
+// PublicIndexData is a page of the public index.
+type PublicIndexData struct {
+	Links []PublicLink
+	Page  int
+	Prev  int // previous page, 0 if none
+	Next  int // next page, 0 if none
+}
+
+// ServePublicIndex lists the public links, newest first, by pages given by the "page" query value.
+func ServePublicIndex(h *coopurl.Handler, pages *Pages, base string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page := 1
+		if v := r.URL.Query().Get("page"); v != "" {
+			var err error
+			if page, err = strconv.Atoi(v); err != nil || page < 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		links, total, err := h.PublicLinks((page-1)*publicPageSize, publicPageSize)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(links) == 0 && page > 1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		data := PublicIndexData{Page: page}
+		for _, e := range links {
+			data.Links = append(data.Links, publicLink(r, base, e))
+		}
+		if page > 1 {
+			data.Prev = page - 1
+		}
+		if page*publicPageSize < total {
+			data.Next = page + 1
+		}
+
+		pages.Render(w, r, "public", data)
+	}
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemap struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// ServeSitemap lists the pages of the public index and the public links.
+func ServeSitemap(h *coopurl.Handler, base string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		links, total, err := h.PublicLinks(0, 0)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		index := url.URL{Scheme: "https", Host: r.Host, Path: "/links"}
+		var s sitemap
+		for page := 1; page == 1 || (page-1)*publicPageSize < total; page++ {
+			u := index
+			if page > 1 {
+				u.RawQuery = url.Values{"page": {strconv.Itoa(page)}}.Encode()
+			}
+			s.URLs = append(s.URLs, sitemapURL{Loc: u.String()})
+		}
+		for _, e := range links {
+			if len(s.URLs) == maxSitemapURLs {
+				break
+			}
+			u := sitemapURL{Loc: shortLink(r, base, e.ID)}
+			if !e.CreatedAt.IsZero() {
+				u.LastMod = e.CreatedAt.UTC().Format("2006-01-02")
+			}
+			s.URLs = append(s.URLs, u)
+		}
+
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(s)
+	}
+}
+
+// ServePublicRobots serves DefaultRobots letting crawlers follow the public links, and pointing at the sitemap.
+func ServePublicRobots(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		links, _, err := h.PublicLinks(0, 0)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var b strings.Builder
+		b.WriteString("User-agent: *\n")
+		// the longest matching rule applies, so the links are allowed despite the /r/ rule
+		for _, e := range links {
+			fmt.Fprintf(&b, "Allow: /r/%s$\n", url.PathEscape(e.ID))
+		}
+		b.WriteString(strings.TrimPrefix(DefaultRobots, "User-agent: *\n"))
+		fmt.Fprintf(&b, "\nSitemap: %s\n", (&url.URL{Scheme: "https", Host: r.Host, Path: "/sitemap.xml"}).String())
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(b.String()))
+	}
+}
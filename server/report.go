@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/coopgo/coopurl/v2"
+
+	"github.com/gorilla/mux"
+)
+
+// ServeReport records an abuse report of the link given in the url, with the "reason" form value.
+func ServeReport(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reason := r.FormValue("reason")
+		if reason == "" {
+			http.Error(w, "A reason is required", http.StatusBadRequest)
+			return
+		}
+
+		err := h.ReportAbuse(mux.Vars(r)["id"], reason, coopurl.WithRequestID(coopurl.RequestIDFrom(r.Context())))
+		if errors.Is(err, coopurl.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("Thank you, the link will be reviewed"))
+	}
+}
+
+// ServeReports lists the abuse reports of the link given in the url.
+func ServeReports(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reports, err := h.AbuseReports(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, reports)
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+	"os"
+)
+
+// DefaultRobots keeps crawlers away from the links and the admin api.
+const DefaultRobots = `User-agent: *
+Disallow: /r/
+Disallow: /admin/
+`
+
+// ServeRobots serves the robots.txt read from path, or DefaultRobots if path is empty.
+func ServeRobots(path string) (http.HandlerFunc, error) {
+	robots := []byte(DefaultRobots)
+	if path != "" {
+		var err error
+		if robots, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(robots)
+	}, nil
+}
@@ -0,0 +1,802 @@
+// Package server is the url shortener served by cmd/server: the creation form and pages, the api, the admin
+// api and the redirects, so that applications can embed it, eg:
+//
+//	srv, err := server.New(server.Config{Addr: ":8080", DBPath: "/var/lib/coopurl"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(srv.ListenAndServe())
+//
+// The zero Config serves a shortener without admin api, storing its links in coopurl.DefaultDbDir.
+package server
+
+import (
+	"crypto/rand"
+	"embed"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+	"github.com/coopgo/coopurl/v2/cluster"
+	"github.com/coopgo/coopurl/v2/redisbus"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const writeTimeout = 15 * time.Second
+
+//go:embed templates
+var templateFiles embed.FS
+
+// Config configures the server returned by New, its fields are the flags of cmd/server.
+type Config struct {
+	Addr string // address the server listens on, 0.0.0.0:8080 if empty
+
+	AdminToken          string        // bearer token of the admin api, the admin api is disabled if empty
+	DBPath              string        // badger store directory, coopurl.DefaultDbDir if empty
+	ValidateDestination bool          // reject urls whose destination is unreachable
+	BaseURL             string        // url the links are served at, eg: https://coopgo.fr/r/, links to it are refused
+	RedirectPrefixes    []string      // paths the links are served under, "/" for root-level codes, "/r/" if empty
+	ReservedRoutes      []string      // first path segments served in front of the server, which root-level codes can't take
+	SoftDelete          time.Duration // how long deleted links can be restored, links are deleted immediately if 0
+	Analytics           string        // how clicks are recorded: counts, full or private, counts if empty
+	EventRetention      time.Duration // how long the click events of full analytics are kept, forever if 0
+	UniqueVisitors      string        // how unique visitors are counted: cookie or hash, not counted if empty
+	LockWait            time.Duration // how long to wait for the store while another process holds its lock
+	Verify              bool          // check and repair the store at startup
+	TemplatesDir        string        // directory of templates replacing the default page templates of the same name
+	RobotsPath          string        // robots.txt file to serve, crawlers are kept away from links by default
+	PublicIndex         bool          // serve the public links at /links and /sitemap.xml
+	NoIndex             bool          // ask search engines not to index any link
+	CheckInterval       time.Duration // interval between two checks of all the stored destinations, disabled if 0
+	DisabledJobs        []string      // maintenance jobs not run by this instance, eg: coopurl.JobDeadLinks
+	JobJitter           float64       // jitter of the maintenance jobs, coopurl.DefaultJobJitter if 0, none if negative
+	ArchivePath         string        // file the expired links and their stats are appended to, disabled if empty
+	AbuseWebhook        string        // url the abuse reports are posted to as JSON
+	AbuseDisable        int           // number of abuse reports disabling a link, never disabled if 0
+	ExpiryNotice        time.Duration // how long before their expiry the owners of links are warned, disabled if 0
+	ExpiryWebhook       string        // url the expiry notices are posted to as JSON
+	WebhookSecret       string        // secret signing the webhooks, checked by the receivers with webhook.Verify
+	SMTP                SMTPConfig    // mail server emailing the expiry notices, disabled if its address is empty
+	Anomalies           bool          // log the links with an unusual traffic
+	AccessLog           string        // format of the access log written to stdout: combined or json, disabled if empty
+	DebugAddr           string        // private address serving pprof, expvar and the prometheus metrics, disabled if empty
+	MetricsDomains      []string      // destination domains counted apart in the redirect metrics
+	Previews            bool          // fetch the title, description and image of destinations
+	Unfurl              bool          // answer link preview bots with the preview of the destination, with Previews
+	ScreenshotURL       string        // endpoint of a headless browser service capturing thumbnails, disabled if empty
+	PublicStats         bool          // serve the clicks and top referrers of each link to anyone
+	StoreTimeout        time.Duration // time the redirects wait for the store before answering 503, unbounded if 0
+	CircuitFailures     int           // consecutive store failures opening the circuit, disabled if 0
+	CircuitCooldown     time.Duration // time the circuit stays open, 10s if 0
+	SyncWrites          bool          // sync every write to disk before answering
+	Compression         string        // compression of the stored links: snappy or zstd, uncompressed if empty
+	CacheSize           int           // number of links kept in memory, disabled if 0
+	StaleWindow         time.Duration // time the cached links are served while read again after they changed, with CacheSize
+	CacheRedis          string        // address of a Redis server evicting the links changed by the other instances from the cache, with CacheSize
+	CacheRedisPassword  string        // password of CacheRedis
+	CDNMaxAge           time.Duration // time the redirects are cached by browsers when served through a cdn
+	CDNSharedMaxAge     time.Duration // time the redirects are cached by a cdn in front of the server, disabled if 0
+	CDNPurgeURL         string        // url the surrogate keys of the changed links are posted to as JSON
+	WarmUp              int           // number of the most clicked links read at startup, disabled if 0
+	RedirectLogSampling int           // log one redirect out of n, every redirect if 0
+	SettingsPath        string        // json file of the runtime settings of the store, reloaded on SIGHUP and POST /admin/reload
+
+	ReplicationAddr string        // address serving the changes of the store to replicas and standbys, disabled if empty
+	ReplicaOf       string        // replication url of the primary to follow
+	ReplicaFallback string        // replication url of the process holding the store, followed while it is locked
+	StandbyOf       string        // deltas url of the primary to pull
+	StandbyInterval time.Duration // interval between two pulls of the primary changes, 10s if 0
+
+	ClusterID             string // id of the node in a raft cluster of servers, clustering is disabled if empty
+	ClusterAddr           string // address of the raft transport reachable by the other nodes
+	ClusterDir            string // directory of the raft log and snapshots, "raft" if empty
+	ClusterBootstrap      bool   // start a new cluster made of this node
+	ClusterJoin           string // api url of a member of the cluster to join
+	ClusterAPIURL         string // api url of this node
+	ClusterReplicationURL string // url of the replication address of this node
+
+	OwnerHeader      string   // header carrying the user authenticated by a proxy, enables the /my/links pages
+	OIDCIssuer       string   // url of the OpenID Connect provider logging the users in, disabled if empty
+	OIDCClientID     string   // client id of the server at the OpenID Connect provider
+	OIDCClientSecret string   // client secret of the server at the OpenID Connect provider
+	OIDCRedirectURL  string   // public url of /auth/callback
+	OIDCOwnerClaim   string   // claim of the id tokens naming the owner of the links, "sub" if empty
+	OIDCAdmins       []string // owners allowed on the admin api with their session
+	SessionKey       string   // key signing the sessions, random if empty so the sessions end with the server
+
+	SlackSigningSecret string // signing secret of the Slack app whose slash command is served, disabled if empty
+	CreateToken        string // static bearer token required to create links, in place of the api tokens
+	Htpasswd           string // htpasswd file of the users allowed to create links with basic auth
+	ShareSecret        string // secret signing the temporary links to the stats of a link, disabled if empty
+	IDSecret           string // secret mixed into the generated ids, so that they can't be predicted
+
+	Captcha          string        // captcha required by the creation form: hcaptcha or turnstile, disabled if empty
+	CaptchaSiteKey   string        // site key of the captcha widget
+	CaptchaSecret    string        // secret of the captcha verification api
+	CreationBurst    int           // links an ip can create from the home page at once, not limited if 0
+	CreationInterval time.Duration // time for an ip to create one more link, a minute if 0
+	AllowCIDRs       []string      // ip ranges allowed to create links and use the admin api, everyone if empty
+
+	Version string // version of the build served at /api/v1/info, the version of the module if empty
+	Commit  string // commit of the build served at /api/v1/info
+
+	Logger *logrus.Logger // logger of the server and its store, a new logrus logger if nil
+}
+
+// New opens the store and returns the server configured by cfg, not yet listening. Its Shutdown closes the
+// store, and the debug and replication servers listening on their own addresses.
+func New(cfg Config) (*http.Server, error) {
+	var closers []func()
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	srv, err := build(cfg, &closers)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	srv.RegisterOnShutdown(closeAll)
+	return srv, nil
+}
+
+func build(cfg Config, closers *[]func()) (*http.Server, error) {
+	onClose := func(fn func()) { *closers = append(*closers, fn) }
+
+	allowlist, err := ParseCIDRs(strings.Join(cfg.AllowCIDRs, ","))
+	if err != nil {
+		return nil, err
+	}
+	restricted := AllowCIDRs(allowlist)
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logrus.New()
+	}
+	if cfg.RedirectLogSampling == 0 {
+		cfg.RedirectLogSampling = 1
+	}
+	opts := []coopurl.Options{
+		coopurl.WithLogger(logger),
+		coopurl.WithRedirectLogSampling(cfg.RedirectLogSampling),
+		coopurl.WithStoreSampling(time.Hour, 24*7),
+	}
+	switch cfg.Analytics {
+	case "full":
+		opts = append(opts, coopurl.WithAnalytics(coopurl.AnalyticsFull))
+	case "private":
+		opts = append(opts, coopurl.WithAnalytics(coopurl.AnalyticsPrivate))
+	}
+	if cfg.EventRetention > 0 {
+		opts = append(opts, coopurl.WithEventRetention(cfg.EventRetention))
+	}
+	switch cfg.UniqueVisitors {
+	case "cookie":
+		opts = append(opts, coopurl.WithUniqueVisitors(coopurl.VisitorsCookie))
+	case "hash":
+		opts = append(opts, coopurl.WithUniqueVisitors(coopurl.VisitorsDailyHash))
+	}
+	if cfg.StoreTimeout > 0 {
+		opts = append(opts, coopurl.WithStoreTimeout(cfg.StoreTimeout))
+	}
+	if cfg.CircuitFailures > 0 {
+		cooldown := cfg.CircuitCooldown
+		if cooldown == 0 {
+			cooldown = 10 * time.Second
+		}
+		opts = append(opts, coopurl.WithCircuitBreaker(cfg.CircuitFailures, cooldown))
+	}
+	if cfg.DBPath != "" {
+		opts = append(opts, coopurl.WithDbPath(cfg.DBPath))
+	}
+	if cfg.SyncWrites {
+		opts = append(opts, coopurl.WithSyncWrites(true))
+	}
+	if cfg.LockWait > 0 {
+		opts = append(opts, coopurl.WithLockWait(cfg.LockWait))
+	}
+	switch cfg.Compression {
+	case "snappy":
+		opts = append(opts, coopurl.WithCompression(coopurl.CompressionSnappy))
+	case "zstd":
+		opts = append(opts, coopurl.WithCompression(coopurl.CompressionZstd))
+	}
+	if cfg.CreationBurst > 0 {
+		interval := cfg.CreationInterval
+		if interval == 0 {
+			interval = time.Minute
+		}
+		opts = append(opts, coopurl.WithCreationLimit(coopurl.CreationLimit{Burst: cfg.CreationBurst, Interval: interval}))
+	}
+	if cfg.Previews {
+		opts = append(opts, coopurl.WithPreviews())
+	}
+	if cfg.Unfurl {
+		opts = append(opts, coopurl.WithUnfurl())
+	}
+	if cfg.PublicStats {
+		opts = append(opts, coopurl.WithPublicStats())
+	}
+	if cfg.ScreenshotURL != "" {
+		opts = append(opts, coopurl.WithThumbnails(coopurl.ScreenshotService{URL: cfg.ScreenshotURL}, 0))
+	}
+	if cfg.CacheSize > 0 {
+		opts = append(opts, coopurl.WithCache(cfg.CacheSize))
+		if cfg.StaleWindow > 0 {
+			opts = append(opts, coopurl.WithStaleWhileRevalidate(cfg.StaleWindow))
+		}
+		if cfg.CacheRedis != "" {
+			opts = append(opts, coopurl.WithInvalidationBus(redisbus.New(cfg.CacheRedis, cfg.CacheRedisPassword)))
+		}
+	}
+	if cfg.CDNSharedMaxAge > 0 {
+		opts = append(opts, coopurl.WithCDN(coopurl.CDN{MaxAge: cfg.CDNMaxAge, SharedMaxAge: cfg.CDNSharedMaxAge}))
+	}
+	if cfg.WarmUp > 0 {
+		opts = append(opts, coopurl.WithWarmUp(cfg.WarmUp))
+	}
+	if cfg.NoIndex {
+		opts = append(opts, coopurl.WithDefaultNoIndex())
+	}
+	if cfg.BaseURL != "" {
+		opts = append(opts, coopurl.WithBaseURL(cfg.BaseURL))
+	}
+	prefixes := redirectPrefixes(cfg.RedirectPrefixes)
+	rootRoutes := NewRootRoutes(cfg.ReservedRoutes)
+	if servesRoot(prefixes) {
+		opts = append(opts, coopurl.WithRoutes(rootRoutes.Conflicts))
+	}
+	if cfg.ValidateDestination {
+		opts = append(opts, coopurl.WithValidateDestination())
+	}
+	if cfg.SoftDelete > 0 {
+		opts = append(opts, coopurl.WithSoftDelete(cfg.SoftDelete))
+	}
+	if cfg.CheckInterval > 0 {
+		opts = append(opts, coopurl.WithDeadLinkChecker(cfg.CheckInterval))
+	}
+	// the webhooks are delivered through the outbox, retried while they are down
+	webhooks := map[string]string{}
+	if cfg.AbuseWebhook != "" {
+		webhooks[coopurl.EventAbuseReport] = cfg.AbuseWebhook
+	}
+	if cfg.ExpiryWebhook != "" && cfg.ExpiryNotice > 0 {
+		webhooks[coopurl.EventExpiryNotice] = cfg.ExpiryWebhook
+	}
+	if cfg.CDNPurgeURL != "" && cfg.CDNSharedMaxAge > 0 {
+		webhooks[coopurl.EventPurge] = cfg.CDNPurgeURL
+	}
+	if len(webhooks) > 0 {
+		outbox := coopurl.Outbox{Deliver: Webhooks(webhooks, []byte(cfg.WebhookSecret))}
+		for event := range webhooks {
+			outbox.Events = append(outbox.Events, event)
+		}
+		opts = append(opts, coopurl.WithOutbox(outbox))
+	}
+	if cfg.ExpiryNotice > 0 {
+		if cfg.ExpiryWebhook == "" && cfg.SMTP.Addr == "" {
+			return nil, errors.New("expiry notices need an expiry webhook or a mail server")
+		}
+		notify := func(coopurl.ExpiryNotice) {}
+		if cfg.SMTP.Addr != "" {
+			notify = ExpiryMailer(cfg.SMTP, cfg.BaseURL, logger)
+		}
+		opts = append(opts, coopurl.WithExpiryNotifier(cfg.ExpiryNotice, time.Hour, notify))
+	}
+	if cfg.AbuseDisable > 0 {
+		opts = append(opts, coopurl.WithAbuseAutoDisable(cfg.AbuseDisable))
+	}
+	if cfg.Anomalies {
+		opts = append(opts, coopurl.WithAnomalyDetection(coopurl.DefaultAnomalyConfig, func(a coopurl.Anomaly) {
+			logger.WithFields(logrus.Fields{"id": a.ID, "domain": a.Domain, "requests": a.Requests, "ip": a.IP}).Warnf("Unusual traffic: %s", a.Kind)
+		}))
+	}
+	if cfg.ClusterID != "" {
+		if cfg.AdminToken == "" {
+			return nil, errors.New("clustering needs an admin token")
+		}
+		opts = append(opts, coopurl.WithFollower())
+	}
+	standbyInterval := cfg.StandbyInterval
+	if standbyInterval == 0 {
+		standbyInterval = 10 * time.Second
+	}
+	if cfg.StandbyOf != "" {
+		opts = append(opts, coopurl.WithStandbyOf(cfg.StandbyOf, cfg.AdminToken, standbyInterval))
+	}
+	if cfg.ReplicaOf != "" {
+		opts = append(opts, coopurl.WithReplicaOf(cfg.ReplicaOf, cfg.AdminToken))
+	}
+	if cfg.ReplicaFallback != "" {
+		opts = append(opts, coopurl.WithReplicaFallback(cfg.ReplicaFallback, cfg.AdminToken))
+	}
+	if len(cfg.DisabledJobs) > 0 {
+		opts = append(opts, coopurl.WithoutJobs(cfg.DisabledJobs...))
+	}
+	switch {
+	case cfg.JobJitter > 0:
+		opts = append(opts, coopurl.WithJobJitter(cfg.JobJitter))
+	case cfg.JobJitter < 0:
+		opts = append(opts, coopurl.WithJobJitter(0))
+	}
+	if cfg.ArchivePath != "" {
+		a, err := coopurl.NewFileArchiver(cfg.ArchivePath)
+		if err != nil {
+			return nil, err
+		}
+		onClose(func() { a.Close() })
+		opts = append(opts, coopurl.WithArchiver(a, time.Hour))
+	}
+
+	if cfg.ShareSecret != "" {
+		opts = append(opts, coopurl.WithStatsSharing([]byte(cfg.ShareSecret)))
+	}
+	if cfg.IDSecret != "" {
+		opts = append(opts, coopurl.WithIDSecret([]byte(cfg.IDSecret)))
+	}
+
+	var metrics *coopurl.RedirectMetrics
+	if cfg.DebugAddr != "" {
+		metrics = coopurl.NewRedirectMetrics(cfg.MetricsDomains...)
+		opts = append(opts, coopurl.WithRedirectObserver(metrics))
+	}
+
+	templates, err := fs.Sub(templateFiles, "templates")
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TemplatesDir != "" {
+		templates = overlayFS{fsys: os.DirFS(cfg.TemplatesDir), defaults: templates}
+	}
+	static, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		return nil, err
+	}
+	assets, err := NewAssets(static, "/static/")
+	if err != nil {
+		return nil, err
+	}
+	// links are shown under the base url, or under the first prefix at the host of the request
+	base := cfg.BaseURL
+	if base == "" {
+		base = prefixes[0]
+	}
+	pages := NewPages(templates, base, assets, logger)
+	// the forms and the admin actions are signed with the session key, so that they still work after a restart
+	csrf, err := NewCSRF([]byte(cfg.SessionKey), pages)
+	if err != nil {
+		return nil, err
+	}
+
+	// the hidden honeypot field is filled by the bots only
+	guards := []FormGuard{Honeypot{}}
+	var captcha *Captcha
+	if cfg.Captcha != "" {
+		p, err := ParseCaptchaProvider(cfg.Captcha)
+		if err != nil {
+			return nil, err
+		}
+		captcha = NewCaptcha(p, cfg.CaptchaSiteKey, cfg.CaptchaSecret)
+		guards = append(guards, captcha)
+	}
+	if pages.Has("preview") {
+		t, err := pages.Layout("preview")
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, coopurl.WithInfoTemplate(t))
+	}
+
+	h, err := coopurl.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	onClose(h.Close)
+	var settings *Settings
+	if cfg.SettingsPath != "" {
+		defaults := coopurl.Config{RedirectLogSampling: cfg.RedirectLogSampling}
+		if cfg.CreationBurst > 0 {
+			defaults.CreationLimit = &coopurl.CreationLimitConfig{Burst: cfg.CreationBurst, Interval: coopurl.Duration(time.Minute)}
+			if cfg.CreationInterval > 0 {
+				defaults.CreationLimit.Interval = coopurl.Duration(cfg.CreationInterval)
+			}
+		}
+		settings = NewSettings(h, cfg.SettingsPath, defaults, logger)
+		if err := settings.Load(); err != nil {
+			return nil, err
+		}
+		onClose(settings.Watch())
+	}
+	if cfg.Verify {
+		report, err := h.Verify(true)
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("Verified the store: %d links, %d corrupt, %d dangling index keys, %d orphaned records",
+			report.Links, len(report.Corrupt), report.DanglingIndexes, report.OrphanedRecords)
+	}
+
+	var node *cluster.Node
+	if cfg.ClusterID != "" {
+		clusterDir := cfg.ClusterDir
+		if clusterDir == "" {
+			clusterDir = "raft"
+		}
+		node, err = cluster.New(h, cluster.Config{
+			ID:             cfg.ClusterID,
+			RaftAddr:       cfg.ClusterAddr,
+			Dir:            clusterDir,
+			Bootstrap:      cfg.ClusterBootstrap,
+			APIURL:         cfg.ClusterAPIURL,
+			ReplicationURL: cfg.ClusterReplicationURL,
+			Token:          cfg.AdminToken,
+			Logger:         logger,
+		})
+		if err != nil {
+			return nil, err
+		}
+		onClose(func() { node.Close() })
+		if cfg.ClusterJoin != "" {
+			go JoinCluster(cfg.ClusterJoin, cfg.AdminToken, cfg.ClusterID, cfg.ClusterAddr, logger)
+		}
+	}
+
+	// links are created with an api token, or by the allowed ips, with the static token or a user of htpasswd if set
+	create := RequireScope(h, coopurl.ScopeCreate, restricted)
+	if cfg.CreateToken != "" {
+		create = func(next http.Handler) http.Handler { return restricted(coopurl.StaticToken(cfg.CreateToken)(next)) }
+	} else if cfg.Htpasswd != "" {
+		f, err := os.Open(cfg.Htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		users, err := coopurl.ReadHtpasswd(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		basic := coopurl.BasicAuth("coopurl", users)
+		create = RequireScope(h, coopurl.ScopeCreate, func(next http.Handler) http.Handler { return restricted(basic(next)) })
+	}
+
+	var oidc *OIDC
+	if cfg.OIDCIssuer != "" {
+		key := []byte(cfg.SessionKey)
+		if len(key) == 0 {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+			logger.Warn("No session key, the sessions end when the server stops")
+		}
+		oidc, err = NewOIDC(cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL, key)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.OIDCOwnerClaim != "" {
+			oidc.OwnerClaim = cfg.OIDCOwnerClaim
+		}
+		oidc.Admins = cfg.OIDCAdmins
+	}
+
+	r := mux.NewRouter()
+	r.Use(coopurl.RequestID)
+	switch cfg.AccessLog {
+	case "combined":
+		r.Use(coopurl.AccessLog(os.Stdout, coopurl.LogCombined))
+	case "json":
+		r.Use(coopurl.AccessLog(os.Stdout, coopurl.LogJSON))
+	}
+	if node != nil {
+		r.Use(node.ForwardWrites)
+	}
+	if oidc != nil {
+		r.Use(oidc.Sessions)
+		r.HandleFunc("/auth/login", oidc.ServeLogin).Methods("GET")
+		r.HandleFunc("/auth/callback", oidc.ServeCallback).Methods("GET")
+		r.HandleFunc("/auth/logout", oidc.ServeLogout).Methods("POST")
+	}
+
+	// Stylesheets and scripts of the pages
+	r.PathPrefix("/static/").Handler(assets).Methods("GET", "HEAD")
+
+	// HomePage
+	r.Handle("/", csrf.Protect(ServeHome(pages, captcha))).Methods("GET")
+	maintenance := DuringMaintenance(h, pages)
+	r.Handle("/", csrf.Protect(maintenance(create(ServeShort(h, pages, cfg.OwnerHeader, guards, logger))))).Methods("POST")
+
+	// Build and state of the instance, for the inventory of a fleet
+	r.HandleFunc("/api/v1/info", ServeInfo(h, cfg.Version, cfg.Commit)).Methods("GET")
+
+	// Bulk creation
+	r.Handle("/api/v1/links:batch", create(ServeBatch(h, cfg.OwnerHeader))).Methods("POST")
+
+	// Links, answering conditional requests for dashboards polling them
+	r.Handle("/api/v1/links/{id}", restricted(ServeEntry(h))).Methods("GET", "HEAD")
+	r.Handle("/api/v1/links/{id}", RequireScope(h, coopurl.ScopeDelete, nil)(ServeOwnerDelete(h))).Methods("DELETE")
+	// the owners of the links read all their stats with a token, anyone else the public ones if enabled
+	anyone := func(next http.Handler) http.Handler { return next }
+	r.Handle("/api/v1/links/{id}/stats", RequireScope(h, coopurl.ScopeReadStats, anyone)(ServeLinkStats(h, cfg.PublicStats))).Methods("GET")
+	if cfg.ShareSecret != "" {
+		r.HandleFunc("/shared/{token}", ServeSharedStats(h, pages)).Methods("GET")
+	}
+
+	robots, err := ServeRobots(cfg.RobotsPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PublicIndex && cfg.RobotsPath == "" {
+		robots = ServePublicRobots(h)
+	}
+	r.HandleFunc("/robots.txt", robots).Methods("GET")
+
+	// Directory of the public links
+	if cfg.PublicIndex {
+		r.HandleFunc("/links", ServePublicIndex(h, pages, base)).Methods("GET")
+		r.HandleFunc("/sitemap.xml", ServeSitemap(h, base)).Methods("GET")
+	}
+
+	// Redirect
+	redirects := AllowCIDRs(allowlist, http.MethodGet, http.MethodHead)(h)
+	mountRedirects(r, prefixes, redirects)
+
+	// Abuse reports
+	r.HandleFunc("/report/{id}", ServeReport(h)).Methods("POST")
+
+	// Slack slash command, authenticated by its signature
+	if cfg.SlackSigningSecret != "" {
+		r.HandleFunc("/slack/command", ServeSlack(h, cfg.SlackSigningSecret, base)).Methods("POST")
+	}
+
+	// Links of the user
+	if cfg.OwnerHeader != "" || oidc != nil {
+		my := r.PathPrefix("/my").Subrouter()
+		if oidc != nil {
+			my.Use(oidc.RequireSession)
+		} else {
+			my.Use(RequireOwner(cfg.OwnerHeader))
+		}
+		my.Use(csrf.Protect, maintenance)
+		my.HandleFunc("/links", ServeMyLinks(h, pages, cfg.ScreenshotURL != "")).Methods("GET")
+		my.HandleFunc("/links/{id}", ServeMyLink(h, pages, cfg.ShareSecret != "")).Methods("GET")
+		if cfg.ScreenshotURL != "" {
+			my.HandleFunc("/links/{id}/thumbnail", ServeMyThumbnail(h)).Methods("GET")
+		}
+		my.HandleFunc("/links/{id}", ServeMyUpdate(h, pages)).Methods("POST")
+		my.HandleFunc("/links/{id}/publish", ServeMyPublish(h)).Methods("POST")
+		my.HandleFunc("/links/{id}/disable", ServeMyDisable(h, pages, true)).Methods("POST")
+		my.HandleFunc("/links/{id}/enable", ServeMyDisable(h, pages, false)).Methods("POST")
+		if cfg.ShareSecret != "" {
+			my.HandleFunc("/links/{id}/share", ServeMyShare(h)).Methods("POST")
+		}
+	}
+
+	// Admin
+	if cfg.AdminToken != "" || (oidc != nil && len(oidc.Admins) > 0) {
+		// the links matching a query, eg: ?q=tag=q3 AND created>2024-01-01
+		r.Handle("/api/v1/links", restricted(RequireAdmin(cfg.AdminToken, oidc)(ServeLinks(h)))).Methods("GET")
+
+		admin := r.PathPrefix("/admin").Subrouter()
+		admin.Use(restricted, RequireAdmin(cfg.AdminToken, oidc), csrf.Protect)
+		admin.HandleFunc("/store", ServeStoreStats(h)).Methods("GET")
+		admin.HandleFunc("/store/move", ServeMoveStore(h)).Methods("POST")
+		admin.HandleFunc("/counts/{index}", ServeCounts(h)).Methods("GET")
+		admin.HandleFunc("/broken", ServeBroken(h)).Methods("GET")
+		admin.HandleFunc("/expiring", ServeExpiring(h)).Methods("GET")
+		admin.HandleFunc("/jobs", ServeJobs(h)).Methods("GET")
+		admin.HandleFunc("/maintenance", ServeMaintenance(h)).Methods("GET")
+		admin.HandleFunc("/maintenance", ServeStartMaintenance(h)).Methods("POST")
+		admin.HandleFunc("/maintenance", ServeEndMaintenance(h)).Methods("DELETE")
+		if settings != nil {
+			admin.HandleFunc("/reload", ServeReload(settings)).Methods("POST")
+		}
+		admin.HandleFunc("/outbox", ServeDeliveries(h, false)).Methods("GET")
+		admin.HandleFunc("/outbox/dead", ServeDeliveries(h, true)).Methods("GET")
+		admin.HandleFunc("/outbox/dead/{id}/retry", ServeRetryDeadLetter(h)).Methods("POST")
+		admin.HandleFunc("/outbox/dead/{id}", ServeDeleteDeadLetter(h)).Methods("DELETE")
+		if cfg.CreationBurst > 0 {
+			admin.HandleFunc("/limits", ServeCreationLimits(h)).Methods("GET")
+			admin.HandleFunc("/limits/{ip}", ServeResetCreationLimit(h)).Methods("DELETE")
+		}
+		admin.HandleFunc("/stream", ServeStream(h, writeTimeout-time.Second)).Methods("GET")
+		admin.HandleFunc("/dump", ServeExportDump(h)).Methods("GET")
+		admin.HandleFunc("/dump", ServeImportDump(h)).Methods("POST")
+		admin.HandleFunc("/screening", ServeScreening(h)).Methods("GET")
+		admin.HandleFunc("/screening", ServeSetScreening(h)).Methods("PUT")
+		admin.HandleFunc("/links/{id}/disable", ServeDisable(h, true)).Methods("POST")
+		admin.HandleFunc("/links/{id}/enable", ServeDisable(h, false)).Methods("POST")
+		admin.HandleFunc("/links/{id}/restore", ServeRestore(h)).Methods("POST")
+		admin.HandleFunc("/links/{id}/public", ServePublic(h, true)).Methods("POST")
+		admin.HandleFunc("/links/{id}/private", ServePublic(h, false)).Methods("POST")
+		admin.HandleFunc("/links/{id}/publish", ServePublish(h)).Methods("POST")
+		admin.HandleFunc("/links/{id}/touch", ServeTouch(h)).Methods("POST")
+		admin.HandleFunc("/links/{id}/schedule", ServeSchedule(h)).Methods("POST")
+		admin.HandleFunc("/links/{id}/schedule", ServeCancelSchedule(h)).Methods("DELETE")
+		admin.HandleFunc("/schedule", ServeSchedules(h)).Methods("GET")
+		admin.HandleFunc("/links/{id}/canary", ServeStartCanary(h)).Methods("POST")
+		admin.HandleFunc("/links/{id}/canary", ServeEndCanary(h, false)).Methods("DELETE")
+		admin.HandleFunc("/links/{id}/canary/promote", ServeEndCanary(h, true)).Methods("POST")
+		admin.HandleFunc("/links/{id}/clone", ServeClone(h)).Methods("POST")
+		admin.HandleFunc("/links:touch", ServeTouchBatch(h)).Methods("POST")
+		admin.HandleFunc("/links/{id}", ServeEntry(h)).Methods("GET")
+		admin.HandleFunc("/links/{id}", ServeUpdate(h)).Methods("PUT")
+		admin.HandleFunc("/links/{id}/history", ServeHistory(h)).Methods("GET")
+		admin.HandleFunc("/links/{id}/reports", ServeReports(h)).Methods("GET")
+		admin.HandleFunc("/links/{id}/stats.csv", ServeExportStats(h)).Methods("GET")
+		if cfg.ShareSecret != "" {
+			admin.HandleFunc("/links/{id}/share", ServeShareStats(h)).Methods("POST")
+		}
+		admin.HandleFunc("/campaigns/{name}/stats.csv", ServeExportCampaignStats(h)).Methods("GET")
+		admin.HandleFunc("/grafana/", ServeGrafanaHealth).Methods("GET")
+		admin.HandleFunc("/grafana/search", ServeGrafanaSearch(h)).Methods("POST")
+		admin.HandleFunc("/grafana/query", ServeGrafanaQuery(h)).Methods("POST")
+		admin.HandleFunc("/pages", ServePostPage(h)).Methods("POST")
+		admin.HandleFunc("/tokens", ServeTokens(h)).Methods("GET")
+		admin.HandleFunc("/tokens", ServeCreateToken(h)).Methods("POST")
+		admin.HandleFunc("/tokens/{id}", ServeRevokeToken(h)).Methods("DELETE")
+		admin.HandleFunc("/links/{id}/page", ServeUpdatePage(h)).Methods("PUT")
+		if node != nil {
+			admin.HandleFunc("/cluster/join", ServeJoin(node)).Methods("POST")
+		}
+		if cfg.StandbyOf != "" {
+			admin.HandleFunc("/promote", ServePromote(h)).Methods("POST")
+		}
+	}
+
+	// Root-level codes, which any other route wins over
+	if servesRoot(prefixes) {
+		rootRoutes.Mount(r, h, logger, redirects)
+	}
+
+	if cfg.DebugAddr != "" {
+		listen(&http.Server{Addr: cfg.DebugAddr, Handler: DebugHandler(h, metrics)}, logger, onClose)
+	}
+
+	if cfg.ReplicationAddr != "" {
+		if cfg.AdminToken == "" {
+			return nil, errors.New("replication needs an admin token")
+		}
+		replication := http.NewServeMux()
+		replication.HandleFunc("/", h.ServeReplication)
+		replication.HandleFunc("/deltas", h.ServeDeltas)
+		// no write timeout, replicas stay connected
+		listen(&http.Server{Addr: cfg.ReplicationAddr, Handler: RequireToken(cfg.AdminToken)(replication)}, logger, onClose)
+	}
+
+	addr := cfg.Addr
+	if addr == "" {
+		addr = "0.0.0.0:8080"
+	}
+	return &http.Server{
+		Handler:      r,
+		Addr:         addr,
+		WriteTimeout: writeTimeout,
+		ReadTimeout:  15 * time.Second,
+	}, nil
+}
+
+// listen serves srv in the background until the server returned by New shuts down.
+func listen(srv *http.Server, logger logrus.FieldLogger, onClose func(func())) {
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Couldn't serve %s: %s", srv.Addr, err)
+		}
+	}()
+	onClose(func() { srv.Close() })
+}
+
+// HomeData is the data of the home page, with the creation form, and the widget of its captcha if required.
+type HomeData struct {
+	Captcha *Captcha
+}
+
+func ServeHome(pages *Pages, captcha *Captcha) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pages.Render(w, r, "home", HomeData{Captcha: captcha})
+	}
+}
+
+// ShortData is the data of the short page, showing a link just created.
+type ShortData struct {
+	ShortURL    string
+	PrevURL     string
+	PrevURLLink string
+}
+
+// ServeShort creates the link of the "u" form value, if the guards let the submission through. The errors of the
+// guards are logged to logger.
+func ServeShort(h *coopurl.Handler, pages *Pages, ownerHeader string, guards []FormGuard, logger logrus.FieldLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for _, g := range guards {
+			if err := g.Check(r); err != nil {
+				if !errors.Is(err, errBot) {
+					logger.Errorf("Couldn't check the creation form (request %s): %s", coopurl.RequestIDFrom(r.Context()), err)
+				}
+				pages.Error(w, r, http.StatusForbidden, "The form couldn't be verified, please try again")
+				return
+			}
+		}
+
+		us := r.Form["u"]
+		if len(us) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u := us[0]
+
+		var opts []coopurl.ReqOptions
+		if owner := requestOwner(r, ownerHeader); owner != "" {
+			opts = append(opts, coopurl.WithOwner(owner))
+		}
+		if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			opts = append(opts, coopurl.WithClientIP(ip))
+		}
+		// retried requests get the link created by the first one
+		if key := r.Header.Get("Idempotency-Key"); key != "" {
+			opts = append(opts, coopurl.WithIdempotencyKey(key))
+		}
+
+		id, err := h.Post(u, opts...)
+		if errors.Is(err, coopurl.ErrUnreachable) {
+			pages.Error(w, r, http.StatusBadRequest, "The destination url is unreachable")
+			return
+		}
+		if errors.Is(err, coopurl.ErrLoop) {
+			pages.Error(w, r, http.StatusBadRequest, "The destination url is already a short link")
+			return
+		}
+		if errors.Is(err, coopurl.ErrURLTooLong) {
+			pages.Error(w, r, http.StatusBadRequest, "The destination url is too long")
+			return
+		}
+		if errors.Is(err, coopurl.ErrRateLimited) {
+			pages.Error(w, r, http.StatusTooManyRequests, "Too many links were created from your address, please try again later")
+			return
+		}
+		if errors.Is(err, coopurl.ErrIdempotencyKeyReused) {
+			pages.Error(w, r, http.StatusConflict, "The Idempotency-Key was used for another url")
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		ur, err := url.Parse(u)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if ur.Scheme == "" {
+			ur.Scheme = "http"
+		}
+
+		path := "/r/"
+		if strings.HasPrefix(pages.base, "/") {
+			path = pages.base
+		}
+		surl := url.URL{Host: r.Host, Path: path + id}
+
+		data := ShortData{
+			ShortURL:    strings.TrimLeft(surl.String(), "/"),
+			PrevURL:     coopurl.DisplayURL(u),
+			PrevURLLink: ur.String(),
+		}
+
+		pages.Render(w, r, "short", data)
+	}
+}
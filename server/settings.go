@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/coopgo/coopurl/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// Settings reloads the runtime settings of a handler from a json file of coopurl.Config, eg:
+// {"blocked_words": ["spam"], "log_levels": {"redirects": "warning"}}, see coopurl.Handler.Reload. The creation
+// limit and the redirect log sampling of the server are kept if the file has none.
+type Settings struct {
+	h        *coopurl.Handler
+	path     string
+	defaults coopurl.Config
+	logger   logrus.FieldLogger
+}
+
+// NewSettings returns the settings of h read from path, defaults being the creation limit and the redirect log
+// sampling of the server.
+func NewSettings(h *coopurl.Handler, path string, defaults coopurl.Config, logger logrus.FieldLogger) *Settings {
+	return &Settings{h: h, path: path, defaults: defaults, logger: logger}
+}
+
+// Load reads the settings file and applies it to the handler.
+func (s *Settings) Load() error {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var c coopurl.Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return fmt.Errorf("%s: %w", s.path, err)
+	}
+	if c.CreationLimit == nil {
+		c.CreationLimit = s.defaults.CreationLimit
+	}
+	if c.RedirectLogSampling == 0 {
+		c.RedirectLogSampling = s.defaults.RedirectLogSampling
+	}
+	return s.h.Reload(c)
+}
+
+// Watch loads the settings again on each SIGHUP, until stop is called.
+func (s *Settings) Watch() (stop func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hup:
+				if err := s.Load(); err != nil {
+					s.logger.Errorf("Couldn't reload the settings: %s", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(hup)
+		close(done)
+	}
+}
+
+// ServeReload loads the settings again, like SIGHUP, and answers why they were rejected if they were.
+func ServeReload(s *Settings) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := s.Load()
+		var e *coopurl.Error
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errors.As(err, &e):
+			writeAPIError(w, r, coopurl.HTTPStatus(err), e.Code, err.Error(), nil)
+		default:
+			s.logger.Errorf("Couldn't reload the settings: %s", err)
+			writeAPIError(w, r, http.StatusInternalServerError, codeInternal, "couldn't read the settings", nil)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultShareTTL is how long the stats shared from the /my/links pages can be read.
+const defaultShareTTL = 7 * 24 * time.Hour
+
+func sharedPath(token string) string {
+	return "/shared/" + url.PathEscape(token)
+}
+
+// SharedData is a link shown with a share token.
+type SharedData struct {
+	coopurl.Entry
+	Stats *coopurl.LinkStats
+}
+
+// ServeSharedStats shows the stats of the link of the share token given in the url, to anyone having it.
+func ServeSharedStats(h *coopurl.Handler, pages *Pages) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, s, err := h.SharedStats(mux.Vars(r)["token"])
+		if errors.Is(err, coopurl.ErrInvalidShare) || errors.Is(err, coopurl.ErrNotFound) {
+			pages.Error(w, r, http.StatusNotFound, "The link is invalid or expired")
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// the token is the credential, keep it out of caches, search engines and the referrers of the page
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Header().Set("X-Robots-Tag", "noindex")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		pages.Render(w, r, "shared", SharedData{Entry: e, Stats: s})
+	}
+}
+
+// ServeShareStats issues a share token of the stats of the link given in the url, valid for the "ttl" form
+// value, eg: "72h".
+func ServeShareStats(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ttl, err := time.ParseDuration(r.FormValue("ttl"))
+		if err != nil || ttl <= 0 {
+			badRequest(w, r, "ttl must be a positive duration, eg: 72h")
+			return
+		}
+
+		token, expiry, err := h.ShareStats(mux.Vars(r)["id"], ttl, coopurl.WithDomain(r.FormValue("domain")))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, struct {
+			Token     string    `json:"token"`
+			Path      string    `json:"path"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}{token, sharedPath(token), expiry})
+	}
+}
+
+// ServeMyShare shares the stats of a link of the user for a week, sending them to the shared page to copy its url.
+func ServeMyShare(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, ok := ownLink(h, w, r)
+		if !ok {
+			return
+		}
+		token, _, err := h.ShareStats(e.ID, defaultShareTTL, coopurl.WithDomain(e.Domain))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, sharedPath(token), http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// slackMaxAge bounds the age of the signed requests of Slack, older ones may be replayed.
+const slackMaxAge = 5 * time.Minute
+
+// maxSlackSize bounds the body of slash commands.
+const maxSlackSize = 1 << 16
+
+// slackMessage is the response to a slash command.
+type slackMessage struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// verifySlack checks the signature of a request of Slack, computed with the signing secret of the app.
+func verifySlack(secret string, r *http.Request, body []byte, now time.Time) bool {
+	ts := r.Header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > slackMaxAge || age < -slackMaxAge {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature")))
+}
+
+// ServeSlack implements a Slack slash command, eg: "/shorten https://coopgo.fr", answered with an ephemeral
+// message holding the short url. Links are served under base, or under the host of the request if empty,
+// and are attributed to the Slack user.
+func ServeSlack(h *coopurl.Handler, secret, base string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSlackSize))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !verifySlack(secret, r, body, time.Now()) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Slack shows the errors of the command only with a successful status
+		u := strings.TrimSpace(r.PostForm.Get("text"))
+		if u == "" || strings.ContainsAny(u, " \t\n") {
+			writeJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: "Usage: " + r.PostForm.Get("command") + " <url>"})
+			return
+		}
+		// Slack wraps the urls it recognizes in angle brackets, eg: <https://coopgo.fr|coopgo.fr>
+		u = strings.TrimPrefix(strings.TrimSuffix(u, ">"), "<")
+		if i := strings.Index(u, "|"); i >= 0 {
+			u = u[:i]
+		}
+
+		var opts []coopurl.ReqOptions
+		if user := r.PostForm.Get("user_id"); user != "" {
+			opts = append(opts, coopurl.WithOwner("slack:"+r.PostForm.Get("team_id")+":"+user))
+		}
+		id, err := h.Post(u, opts...)
+		if errors.Is(err, coopurl.ErrUnreachable) {
+			writeJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: "The destination url is unreachable"})
+			return
+		}
+		if errors.Is(err, coopurl.ErrLoop) {
+			writeJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: "The destination url is already a short link"})
+			return
+		}
+		if errors.Is(err, coopurl.ErrURLTooLong) {
+			writeJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: "The destination url is too long"})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: "Couldn't shorten the url, please try again later"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: shortLink(r, base, id)})
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// assetHashLength is the length of the content hashes in the names of the assets.
+const assetHashLength = 8
+
+// Assets serves the stylesheets and scripts of the pages, embedded in the server. Each asset is also served at
+// a name carrying the hash of its content, eg: style.3f2a1b9c.css for style.css, which is cached for a year:
+// a new version of the asset gets a new name. The pages link to them with the asset template function.
+type Assets struct {
+	prefix string
+	files  map[string][]byte // by name, hashed name or not
+	hashed map[string]string // hashed name by name
+}
+
+// NewAssets returns the assets of fsys, served under prefix, eg: "/static/".
+func NewAssets(fsys fs.FS, prefix string) (*Assets, error) {
+	a := &Assets{prefix: prefix, files: map[string][]byte{}, hashed: map[string]string{}}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		ext := path.Ext(name)
+		hashed := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:])[:assetHashLength] + ext
+		a.files[name], a.files[hashed] = b, b
+		a.hashed[name] = hashed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// URL returns the path of the hashed name of the asset, its path as given if it is unknown.
+func (a *Assets) URL(name string) string {
+	if hashed, ok := a.hashed[name]; ok {
+		return a.prefix + hashed
+	}
+	return a.prefix + name
+}
+
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, a.prefix)
+	b, ok := a.files[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if _, plain := a.hashed[name]; plain {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(b))
+}
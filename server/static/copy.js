@@ -0,0 +1,15 @@
+function copy() {
+    /* Get the text field */
+    var copyText = document.getElementById("shortenurl");
+
+    /* Select the text field */
+    copyText.select();
+    copyText.setSelectionRange(0, 99999); /* For mobile devices */
+
+    /* Copy the text inside the text field */
+    navigator.clipboard.writeText(copyText.value);
+
+    /* Change button text */
+    var copybutton = document.getElementById("copybutton");
+    copybutton.value = copybutton.dataset.copied;
+}
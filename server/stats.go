@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+
+	"github.com/coopgo/coopurl/v2"
+
+	"github.com/gorilla/mux"
+)
+
+// ServePublicStats serves the clicks, dates and top referrers of the link given in the url, like /r/{id}.json.
+func ServePublicStats(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := h.PublicStats(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		writeJSON(w, http.StatusOK, s)
+	}
+}
+
+// ServeExportStats serves the stats of the link given in the url as csv.
+func ServeExportStats(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		var b bytes.Buffer
+		err := h.ExportStats(id, &b)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeCSV(w, id+".csv", b.Bytes())
+	}
+}
+
+// ServeExportCampaignStats serves the stats of the links of the campaign given in the url as csv.
+func ServeExportCampaignStats(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		var b bytes.Buffer
+		err := h.ExportCampaignStats(name, &b)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeCSV(w, name+".csv", b.Bytes())
+	}
+}
+
+func writeCSV(w http.ResponseWriter, filename string, b []byte) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Write(b)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+// ServeStream pushes the redirects as server-sent events.
+// The stream is closed after maxDuration, to stay within the server write timeout,
+// and browsers EventSource reconnect automatically.
+func ServeStream(h *coopurl.Handler, maxDuration time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		events, stop := h.Subscribe(64)
+		defer stop()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "retry: 1000\n\n")
+		flusher.Flush()
+
+		end := time.NewTimer(maxDuration)
+		defer end.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-end.C:
+				return
+			case e := <-events:
+				b, err := json.Marshal(e)
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(w, "event: redirect\ndata: %s\n\n", b)
+				flusher.Flush()
+			}
+		}
+	}
+}
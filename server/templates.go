@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Pages renders the html pages of the server, each from layout.html and the template of the page:
+//
+//	home.html     executed with a HomeData
+//	short.html    executed with a ShortData
+//	mylinks.html  executed with a []MyLinkData
+//	mylink.html   executed with a MyLinkData
+//	public.html   executed with a PublicIndexData
+//	shared.html   executed with a SharedData
+//	error.html    executed with an ErrorData
+//	preview.html  executed with a coopurl.InfoData, optional, replaces the info page of the links
+//
+// The templates can call shortURL, which returns the short url of an id, humanizeTTL, which writes a ttl for
+// people, eg: "3 days", asset, which returns the url of an asset, eg: {{asset "style.css"}}, t, which translates
+// a text in the language of the page, eg: {{t "Shorten URL"}}, lang, which returns it, and csrfField, which
+// writes the hidden field of the csrf token in the forms protected by CSRF. The language is the
+// one of the Accept-Language header among the translations of the server. The text of preview.html is
+// translated with the T method of coopurl.InfoData instead. Deployments brand the pages with -templates, a directory of templates replacing
+// the default ones of the same name.
+type Pages struct {
+	fsys   fs.FS
+	base   string
+	assets *Assets
+	logger logrus.FieldLogger
+}
+
+// NewPages returns the pages rendered from the templates of fsys, linking to the short links at base, "/r/" if
+// empty, and to the assets. The errors of the templates are logged to logger.
+func NewPages(fsys fs.FS, base string, assets *Assets, logger logrus.FieldLogger) *Pages {
+	if base == "" {
+		base = "/r/"
+	}
+	return &Pages{fsys: fsys, base: base, assets: assets, logger: logger}
+}
+
+// ErrorData is the data of the error page.
+type ErrorData struct {
+	Status  int
+	Message string
+}
+
+// overlayFS reads the files of fsys, and the ones of defaults it doesn't have.
+type overlayFS struct {
+	fsys, defaults fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.defaults.Open(name)
+	}
+	return f, err
+}
+
+func (p *Pages) shortURL(id string) string {
+	return p.base + id
+}
+
+// humanizeTTL returns the ttl in its largest unit, eg: "3 days", "never" for 0, the ttl of links that don't expire.
+func humanizeTTL(ttl time.Duration) string {
+	plural := func(n int64, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return strconv.FormatInt(n, 10) + " " + unit + "s"
+	}
+	switch {
+	case ttl == 0:
+		return "never"
+	case ttl < time.Minute:
+		return "less than a minute"
+	case ttl < time.Hour:
+		return plural(int64(ttl/time.Minute), "minute")
+	case ttl < 24*time.Hour:
+		return plural(int64(ttl/time.Hour), "hour")
+	}
+	return plural(int64(ttl/(24*time.Hour)), "day")
+}
+
+// template parses the layout and the template of the page in lang for r, nil outside of a request, the layout is
+// its "layout" template.
+func (p *Pages) template(name, lang string, r *http.Request) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"shortURL":    p.shortURL,
+		"humanizeTTL": humanizeTTL,
+		"asset":       p.assets.URL,
+		"t":           func(s string) string { return translate(lang, s) },
+		"lang":        func() string { return lang },
+		"csrfField":   func() template.HTML { return csrfInput(r) },
+	}
+	return template.New(name).Funcs(funcs).ParseFS(p.fsys, "layout.html", name+".html")
+}
+
+// Has reports whether there is a template for the page.
+func (p *Pages) Has(name string) bool {
+	_, err := fs.Stat(p.fsys, name+".html")
+	return err == nil
+}
+
+// Layout returns the page laid out, eg: for coopurl.WithInfoTemplate.
+func (p *Pages) Layout(name string) (*template.Template, error) {
+	t, err := p.template(name, defaultLanguage, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t.Lookup("layout"), nil
+}
+
+// Render writes the page executed with data in the language of the request. The templates are parsed on each
+// call, so that they can be edited without restarting the server.
+func (p *Pages) Render(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	lang := pageLanguage(w, r)
+	t, err := p.template(name, lang, r)
+	if err != nil {
+		p.logger.Errorf("Couldn't parse the %s template: %s", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := t.ExecuteTemplate(w, "layout", data); err != nil {
+		p.logger.Errorf("Couldn't render the %s template: %s", name, err)
+	}
+}
+
+// Error writes the error page with the status and the message translated in the language of the request, or the
+// message as text if the page can't be parsed.
+func (p *Pages) Error(w http.ResponseWriter, r *http.Request, status int, message string) {
+	lang := pageLanguage(w, r)
+	message = translate(lang, message)
+	t, err := p.template("error", lang, r)
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := t.ExecuteTemplate(w, "layout", ErrorData{Status: status, Message: message}); err != nil {
+		p.logger.Errorf("Couldn't render the error template: %s", err)
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/coopgo/coopurl/v2"
+
+	"github.com/gorilla/mux"
+)
+
+// RequireScope authenticates the requests bearing an api token, which must have the scope, and attributes them
+// to the owner of the token. Requests without token go through fallback, eg: the ip allowlist, or are
+// rejected if fallback is nil.
+func RequireScope(h *coopurl.Handler, scope coopurl.Scope, fallback func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		anonymous := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeAPIError(w, r, http.StatusUnauthorized, codeUnauthorized, "an api token is required", nil)
+		}))
+		if fallback != nil {
+			anonymous = fallback(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") {
+				anonymous.ServeHTTP(w, r)
+				return
+			}
+			t, err := h.Authenticate(strings.TrimPrefix(auth, "Bearer "))
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if !t.Allows(scope) {
+				writeAPIError(w, r, http.StatusForbidden, codeForbidden, "the token lacks the scope "+string(scope), nil)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ownerKey{}, t.Owner)))
+		})
+	}
+}
+
+// requestOwner returns the owner of the token of the request, or the user authenticated by the proxy.
+func requestOwner(r *http.Request, ownerHeader string) string {
+	if owner := ownerFrom(r.Context()); owner != "" {
+		return owner
+	}
+	if ownerHeader == "" {
+		return ""
+	}
+	return r.Header.Get(ownerHeader)
+}
+
+// ServeLinkStats serves the stats of the link given in the url: all of them to its owner, authenticated by a
+// token, and its public stats to anyone else if public.
+func ServeLinkStats(h *coopurl.Handler, public bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ownerFrom(r.Context()) == "" {
+			if !public {
+				writeAPIError(w, r, http.StatusUnauthorized, codeUnauthorized, "an api token is required", nil)
+				return
+			}
+			ServePublicStats(h)(w, r)
+			return
+		}
+
+		e, ok := ownLink(h, w, r)
+		if !ok {
+			return
+		}
+		s, err := h.Stats(e.ID, coopurl.WithDomain(e.Domain))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	}
+}
+
+// ServeOwnerDelete deletes the link given in the url, if it belongs to the owner of the token.
+func ServeOwnerDelete(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, ok := ownLink(h, w, r)
+		if !ok {
+			return
+		}
+		err := h.Delete(e.ID, coopurl.WithDomain(e.Domain), coopurl.WithActor(e.Owner))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// tokenRequest is the body of the requests creating tokens.
+type tokenRequest struct {
+	Owner  string          `json:"owner"`
+	Name   string          `json:"name"`
+	Scopes []coopurl.Scope `json:"scopes"`
+}
+
+// ServeCreateToken issues a token to the owner given in the json body, the token is only returned once.
+func ServeCreateToken(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req tokenRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			badRequest(w, r, "the body must be a json object")
+			return
+		}
+
+		token, t, err := h.CreateToken(req.Owner, req.Name, req.Scopes...)
+		if errors.Is(err, coopurl.ErrInvalidName) {
+			badRequest(w, r, "the token needs an owner")
+			return
+		}
+		if errors.Is(err, coopurl.ErrInvalidScope) {
+			writeAPIError(w, r, http.StatusBadRequest, "invalid_scope", "the scopes must be create, read-stats or delete", nil)
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, struct {
+			Token string `json:"token"`
+			*coopurl.APIToken
+		}{Token: token, APIToken: t})
+	}
+}
+
+// ServeTokens lists the tokens of the "owner" query parameter, all of them if it's empty.
+func ServeTokens(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens, err := h.Tokens(r.URL.Query().Get("owner"))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, tokens)
+	}
+}
+
+// ServeRevokeToken revokes the token given in the url.
+func ServeRevokeToken(h *coopurl.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := h.RevokeToken(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
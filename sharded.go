@@ -0,0 +1,272 @@
+package coopurl
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// shardPoints is the number of points of each shard on the hash ring, spreading the ids evenly.
+const shardPoints = 128
+
+// maxShardAttempts bounds the generation of an id that isn't taken in its shard.
+const maxShardAttempts = 10
+
+// Sharded spreads the links over several handlers, each with its own store, eg: on separate disks for very
+// large numbers of links. Links are placed by a consistent hash of their id, so a store made of n shards
+// keeps its links in the same shards as long as it's opened with the same paths in the same order.
+// Campaigns and namespaces live in each shard, they are created on every shard with Shards.
+type Sharded struct {
+	shards []*Handler
+	ring   []shardPoint
+}
+
+type shardPoint struct {
+	hash  uint32
+	shard int
+}
+
+var (
+	_ Shortener       = (*Sharded)(nil)
+	_ RedirectHandler = (*Sharded)(nil)
+)
+
+func hashID(s string) uint32 {
+	f := fnv.New32a()
+	f.Write([]byte(s))
+	// fnv alone puts close strings at close points
+	x := f.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// NewSharded opens a handler in each of the paths with the given options, which must not include WithDbPath.
+// Signed ids aren't supported, as the id of a link is chosen before its shard.
+func NewSharded(paths []string, opts ...Options) (*Sharded, error) {
+	if len(paths) == 0 {
+		return nil, optionError("no shard paths")
+	}
+
+	s := &Sharded{}
+	for i, p := range paths {
+		h, err := New(append(append([]Options{}, opts...), WithDbPath(p))...)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("shard %s: %w", p, err)
+		}
+		s.shards = append(s.shards, h)
+		if h.signSecret != nil {
+			s.Close()
+			return nil, optionError("signed ids aren't supported by sharded stores")
+		}
+		for j := 0; j < shardPoints; j++ {
+			s.ring = append(s.ring, shardPoint{hash: hashID(strconv.Itoa(i) + "-" + strconv.Itoa(j)), shard: i})
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	return s, nil
+}
+
+// Shards returns the handlers of the shards, eg: to create a campaign on each of them.
+func (s *Sharded) Shards() []*Handler {
+	return append([]*Handler(nil), s.shards...)
+}
+
+// Close closes all the shards.
+func (s *Sharded) Close() {
+	var wg sync.WaitGroup
+	for _, h := range s.shards {
+		wg.Add(1)
+		go func(h *Handler) {
+			defer wg.Done()
+			h.Close()
+		}(h)
+	}
+	wg.Wait()
+}
+
+// shard returns the shard of the link with the given id.
+func (s *Sharded) shard(id string) *Handler {
+	h := hashID(s.shards[0].normalizeID(id))
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.shards[s.ring[i].shard]
+}
+
+// create stores a new link with post, at the id given with WithAlias or at a generated one.
+func (s *Sharded) create(seed string, opts []ReqOptions, post func(h *Handler, opts []ReqOptions) (string, error)) (string, error) {
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	if r.alias != "" {
+		return post(s.shard(r.alias), opts)
+	}
+
+	for attempt := 1; ; attempt++ {
+		// the first shard generates the ids, from its sequence in IDSequential mode
+		id, err := s.shards[0].generateID(seed, r)
+		if err != nil {
+			return "", err
+		}
+		id, err = post(s.shard(id), append(opts, WithAlias(id)))
+		if err == ErrExists && attempt < maxShardAttempts {
+			continue
+		}
+		return id, err
+	}
+}
+
+// generateID returns a new id for a link, like the ids generated by Post.
+func (h *Handler) generateID(seed string, r req) (string, error) {
+	if err := h.ready(); err != nil {
+		return "", err
+	}
+	defer h.release()
+	return h.newID(seed, r)
+}
+
+// Post stores the link in its shard and returns its id.
+func (s *Sharded) Post(url string, opts ...ReqOptions) (string, error) {
+	return s.create(url, opts, func(h *Handler, opts []ReqOptions) (string, error) {
+		return h.Post(url, opts...)
+	})
+}
+
+// PostPage stores the page in its shard and returns its id.
+func (s *Sharded) PostPage(p Page, opts ...ReqOptions) (string, error) {
+	return s.create(p.Title, opts, func(h *Handler, opts []ReqOptions) (string, error) {
+		return h.PostPage(p, opts...)
+	})
+}
+
+// PostBatch posts each link in its shard, and returns the result of each link in the same order.
+func (s *Sharded) PostBatch(links []BatchLink, opts ...ReqOptions) ([]BatchResult, error) {
+	results := make([]BatchResult, len(links))
+	for i, l := range links {
+		results[i].ID, results[i].Err = s.Post(l.URL, l.options(opts)...)
+	}
+	return results, nil
+}
+
+// Clone copies the link of its shard to a new link, stored in the shard of its own id.
+func (s *Sharded) Clone(id string, opts ...ReqOptions) (string, error) {
+	e, err := s.GetEntry(id, opts...)
+	if err != nil {
+		return "", err
+	}
+	if e.Page != nil {
+		return s.PostPage(*e.Page, e.cloneOptions(opts)...)
+	}
+	return s.Post(e.URL, e.cloneOptions(opts)...)
+}
+
+// Validate runs all the checks of Post without storing the link, and returns the id it would get.
+func (s *Sharded) Validate(url string, opts ...ReqOptions) (string, error) {
+	return s.create(url, opts, func(h *Handler, opts []ReqOptions) (string, error) {
+		return h.Validate(url, opts...)
+	})
+}
+
+func (s *Sharded) IsAvailable(alias string, opts ...ReqOptions) (bool, error) {
+	return s.shard(alias).IsAvailable(alias, opts...)
+}
+
+func (s *Sharded) UpdatePage(id string, p Page, opts ...ReqOptions) error {
+	return s.shard(id).UpdatePage(id, p, opts...)
+}
+
+func (s *Sharded) Get(id string, opts ...ReqOptions) (string, error) {
+	return s.shard(id).Get(id, opts...)
+}
+
+func (s *Sharded) GetEntry(id string, opts ...ReqOptions) (Entry, error) {
+	return s.shard(id).GetEntry(id, opts...)
+}
+
+// OwnerLinks returns the links of the owner in all the shards, newest first.
+func (s *Sharded) OwnerLinks(owner string) ([]Entry, error) {
+	var links []Entry
+	for _, h := range s.shards {
+		l, err := h.OwnerLinks(owner)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l...)
+	}
+	sort.SliceStable(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+	return links, nil
+}
+
+func (s *Sharded) Preview(id string, opts ...ReqOptions) (*Preview, error) {
+	return s.shard(id).Preview(id, opts...)
+}
+
+func (s *Sharded) Delete(id string, opts ...ReqOptions) error {
+	return s.shard(id).Delete(id, opts...)
+}
+
+func (s *Sharded) Touch(id string, ttl time.Duration, opts ...ReqOptions) error {
+	return s.shard(id).Touch(id, ttl, opts...)
+}
+
+// TouchBatch touches each link in its shard, and returns the result of each link in the same order.
+func (s *Sharded) TouchBatch(ids []string, ttl time.Duration, opts ...ReqOptions) ([]BatchResult, error) {
+	results := make([]BatchResult, len(ids))
+	for i, id := range ids {
+		results[i].ID, results[i].Err = id, s.Touch(id, ttl, opts...)
+	}
+	return results, nil
+}
+
+func (s *Sharded) GetTTL(id string, opts ...ReqOptions) (time.Duration, error) {
+	return s.shard(id).GetTTL(id, opts...)
+}
+
+func (s *Sharded) Update(id, url string, opts ...ReqOptions) error {
+	return s.shard(id).Update(id, url, opts...)
+}
+
+func (s *Sharded) History(id string, opts ...ReqOptions) ([]Revision, error) {
+	return s.shard(id).History(id, opts...)
+}
+
+func (s *Sharded) Revert(id string, version int, opts ...ReqOptions) error {
+	return s.shard(id).Revert(id, version, opts...)
+}
+
+func (s *Sharded) Stats(id string, opts ...ReqOptions) (*LinkStats, error) {
+	return s.shard(id).Stats(id, opts...)
+}
+
+func (s *Sharded) ClicksBetween(id string, from, to time.Time, opts ...ReqOptions) (uint64, error) {
+	return s.shard(id).ClicksBetween(id, from, to, opts...)
+}
+
+func (s *Sharded) PublicStats(id string, opts ...ReqOptions) (*PublicStats, error) {
+	return s.shard(id).PublicStats(id, opts...)
+}
+
+func (s *Sharded) Events(id string, since time.Time, opts ...ReqOptions) ([]ClickEvent, error) {
+	return s.shard(id).Events(id, since, opts...)
+}
+
+func (s *Sharded) ReportAbuse(id, reason string, opts ...ReqOptions) error {
+	return s.shard(id).ReportAbuse(id, reason, opts...)
+}
+
+// ServeHTTP serves the request with the shard of the link in its path.
+func (s *Sharded) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, _ := parsePath(r.URL.Path)
+	s.shard(id).ServeHTTP(w, r)
+}
@@ -0,0 +1,84 @@
+package coopurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shareSignatureLength is the length in bytes of the signatures of the share tokens.
+const shareSignatureLength = 16
+
+// WithStatsSharing enables ShareStats, signing the share tokens with the secret.
+// Changing the secret revokes all the tokens.
+func WithStatsSharing(secret []byte) Options {
+	return func(h *Handler) {
+		h.shareSecret = secret
+	}
+}
+
+func (h *Handler) shareSignature(payload string) []byte {
+	mac := hmac.New(sha256.New, h.shareSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)[:shareSignatureLength]
+}
+
+// ShareStats returns a token granting read-only access to the stats of a link until ttl has passed, and its
+// expiry, eg: for the owner to show them to a client without an account. The token is read by SharedStats.
+func (h *Handler) ShareStats(id string, ttl time.Duration, opts ...ReqOptions) (string, time.Time, error) {
+	if h.shareSecret == nil || ttl <= 0 {
+		return "", time.Time{}, ErrInvalidOption
+	}
+	e, err := h.GetEntry(id, opts...)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiry := time.Now().Add(ttl).Truncate(time.Second)
+	payload := strconv.FormatInt(expiry.Unix(), 10) + "|" + e.Domain + "|" + e.ID
+	token := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(h.shareSignature(payload))
+	return token, expiry, nil
+}
+
+// SharedStats returns the link and stats granted by a token of ShareStats, or ErrInvalidShare if it
+// wasn't issued with the secret of the handler or expired.
+func (h *Handler) SharedStats(token string) (Entry, *LinkStats, error) {
+	if h.shareSecret == nil {
+		return Entry{}, nil, ErrInvalidShare
+	}
+	i := strings.Index(token, ".")
+	if i < 0 {
+		return Entry{}, nil, ErrInvalidShare
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return Entry{}, nil, ErrInvalidShare
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(sig, h.shareSignature(string(payload))) {
+		return Entry{}, nil, ErrInvalidShare
+	}
+
+	parts := strings.SplitN(string(payload), "|", 3)
+	if len(parts) != 3 {
+		return Entry{}, nil, ErrInvalidShare
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() >= expiry {
+		return Entry{}, nil, ErrInvalidShare
+	}
+
+	e, err := h.GetEntry(parts[2], WithDomain(parts[1]))
+	if err != nil {
+		return Entry{}, nil, err
+	}
+	s, err := h.Stats(e.ID, WithDomain(e.Domain))
+	if err != nil {
+		return Entry{}, nil, err
+	}
+	return e, s, nil
+}
@@ -0,0 +1,56 @@
+package coopurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const DefaultSignatureLength = 4
+
+// WithSignedIDs appends to the generated ids an hmac signature of length characters,
+// checked before looking up the store so forged or guessed ids are rejected cheaply.
+// Links created before enabling signed ids can't be resolved anymore.
+func WithSignedIDs(secret []byte, length int) Options {
+	return func(h *Handler) {
+		if length <= 0 {
+			length = DefaultSignatureLength
+		}
+		h.signSecret = secret
+		h.signLength = length
+	}
+}
+
+func (h *Handler) signature(id string) string {
+	mac := hmac.New(sha256.New, h.signSecret)
+	mac.Write([]byte(id))
+	if h.alphabet != "" {
+		return encodeAlphabet(mac.Sum(nil), h.alphabet, h.signLength)
+	}
+
+	sig := hex.EncodeToString(mac.Sum(nil))
+	if h.signLength < len(sig) {
+		sig = sig[:h.signLength]
+	}
+	return sig
+}
+
+// sign appends its signature to the id when signed ids are enabled.
+func (h *Handler) sign(id string) string {
+	if h.signSecret == nil {
+		return id
+	}
+	return id + h.signature(id)
+}
+
+// verify checks the signature of the id when signed ids are enabled.
+func (h *Handler) verify(id string) bool {
+	if h.signSecret == nil {
+		return true
+	}
+	if len(id) <= h.signLength {
+		return false
+	}
+	base, sig := id[:len(id)-h.signLength], id[len(id)-h.signLength:]
+	return hmac.Equal([]byte(sig), []byte(h.normalizeID(h.signature(base))))
+}
@@ -0,0 +1,194 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// tombstone keeps a soft deleted link until it is purged.
+type tombstone struct {
+	Entry     entry     `json:"entry"`
+	DeletedAt time.Time `json:"deleted_at"`
+	ExpiresAt uint64    `json:"expires_at,omitempty"` // badger expiry of the link, 0 if it doesn't expire
+}
+
+// WithSoftDelete makes Delete keep the deleted links for the retention duration,
+// during which they can be brought back with Restore. Older tombstones are purged every hour.
+func WithSoftDelete(retention time.Duration) Options {
+	return func(h *Handler) {
+		h.retention = retention
+	}
+}
+
+func tombstoneKey(domain, id string) []byte {
+	return metaKey("tombstone", domain, id)
+}
+
+// bury replaces the link by its tombstone.
+func bury(txn *badger.Txn, l linkRef) error {
+	item, err := txn.Get(l.key())
+	if err != nil {
+		return err
+	}
+	b, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	e, err := decodeEntry(b)
+	if err != nil {
+		return err
+	}
+
+	t, err := json.Marshal(tombstone{Entry: e, DeletedAt: time.Now(), ExpiresAt: item.ExpiresAt()})
+	if err != nil {
+		return err
+	}
+	if err := txn.Set(tombstoneKey(l.domain, l.id), t); err != nil {
+		return err
+	}
+	return txn.Delete(l.key())
+}
+
+// Restore brings back a soft deleted link.
+func (h *Handler) Restore(id string, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	id = h.normalizeID(id)
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err := h.db.Update(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		l := linkRef{domain: r.domain, id: id}
+
+		b, err := getValue(txn, tombstoneKey(l.domain, l.id))
+		if err != nil {
+			return err
+		}
+		var t tombstone
+		if err := json.Unmarshal(b, &t); err != nil {
+			return err
+		}
+
+		// The link expired while it was deleted, its tombstone will be purged
+		if t.ExpiresAt != 0 && t.ExpiresAt <= uint64(time.Now().Unix()) {
+			return ErrNotFound
+		}
+
+		if _, err := txn.Get(l.key()); err == nil {
+			return ErrExists
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		v, err := h.encodeEntry(t.Entry)
+		if err != nil {
+			return err
+		}
+		be := badger.NewEntry(l.key(), v)
+		be.ExpiresAt = t.ExpiresAt
+		if err := txn.SetEntry(be); err != nil {
+			return err
+		}
+		if err := h.setIndexes(txn, l, t.Entry, t.ExpiresAt); err != nil {
+			return err
+		}
+		return txn.Delete(tombstoneKey(l.domain, l.id))
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	h.countLinks(1)
+	h.log(LogAdmin).Infof("Restore entry: %s", id)
+	return nil
+}
+
+// PurgeTombstones removes the soft deleted links older than the retention duration,
+// and reclaims their space in the value log. It returns the number of purged links.
+func (h *Handler) PurgeTombstones() (int, error) {
+	if err := h.ready(); err != nil {
+		return 0, err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return 0, err
+	}
+
+	var keys [][]byte
+	var n int
+	err := h.db.View(func(txn *badger.Txn) error {
+		prefix := metaPrefixKey("tombstone")
+		return iteratePrefix(txn, prefix, func(key string, value []byte) error {
+			var t tombstone
+			if err := json.Unmarshal(value, &t); err != nil {
+				return err
+			}
+			if time.Since(t.DeletedAt) < h.retention {
+				return nil
+			}
+			n++
+			keys = append(keys, append(append([]byte{}, prefix...), key...))
+
+			// the history and stats go with the link, unless another link was posted at its id since
+			domain, id := splitRef(key)
+			l := linkRef{domain: domain, id: id}
+			if _, err := txn.Get(l.key()); err == nil {
+				return nil
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+			revs, err := historyKeys(txn, l)
+			if err != nil {
+				return err
+			}
+			stats, err := statsKeys(txn, l)
+			if err != nil {
+				return err
+			}
+			keys = append(append(keys, revs...), stats...)
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	wb := h.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range keys {
+		if err := wb.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+
+	if n > 0 {
+		h.log(LogAdmin).Infof("Purged %d tombstones", n)
+		// RunValueLogGC returns an error when there was nothing to reclaim
+		for h.db.RunValueLogGC(0.5) == nil {
+		}
+	}
+	return n, nil
+}
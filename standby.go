@@ -0,0 +1,166 @@
+package coopurl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// SinceTrailer is the trailer of ServeDeltas holding the since parameter of the next request.
+const SinceTrailer = "X-Coopurl-Since"
+
+// maxPendingLoads bounds the pending writes while loading deltas.
+const maxPendingLoads = 256
+
+// WithStandbyOf makes the handler a warm standby of the primary serving ServeDeltas at url,
+// authenticated with the given bearer token if not empty.
+// The standby pulls the changes of the primary at every interval and rejects writes with ErrReadOnly
+// until promoted. Deltas are loaded while no other transaction runs, so a standby shouldn't serve
+// requests before being promoted.
+func WithStandbyOf(url, token string, interval time.Duration) Options {
+	return func(h *Handler) {
+		h.standbyURL = url
+		h.standbyToken = token
+		h.standbyInterval = interval
+		h.follower = 1
+	}
+}
+
+func standbySinceKey() []byte {
+	return metaKey("standby", "since")
+}
+
+// StreamSince writes the keys changed since the version ts, deletions included, as a badger backup.
+// It returns the ts to give to the next call, 0 the first time dumps the whole store.
+func (h *Handler) StreamSince(ts uint64, w io.Writer) (uint64, error) {
+	if err := h.ready(); err != nil {
+		return 0, err
+	}
+	defer h.release()
+	return h.streamSince(ts, w)
+}
+
+func (h *Handler) streamSince(ts uint64, w io.Writer) (uint64, error) {
+	last, err := h.db.Backup(w, ts)
+	if err != nil {
+		return 0, err
+	}
+	if last < ts {
+		return ts, nil // nothing changed
+	}
+	// the backup holds the versions from ts up to last, the next one starts after last
+	return last + 1, nil
+}
+
+// LoadDeltas writes the keys streamed by StreamSince.
+func (h *Handler) LoadDeltas(r io.Reader) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	return h.db.Load(r, maxPendingLoads)
+}
+
+// ServeDeltas streams the keys changed since the "since" query parameter to standbys set up with WithStandbyOf,
+// then the since parameter of the next request in the SinceTrailer trailer.
+// It must be served behind authentication, as it exposes all the data of the store.
+func (h *Handler) ServeDeltas(w http.ResponseWriter, r *http.Request) {
+	if err := h.ready(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer h.release()
+	var since uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Trailer", SinceTrailer)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	next, err := h.streamSince(since, w)
+	if err != nil {
+		// the status is already sent, the missing trailer tells the standby the stream failed
+		h.log(LogAdmin).Errorf("Couldn't stream the changes since %d to %s: %s", since, r.RemoteAddr, err)
+		return
+	}
+	w.Header().Set(SinceTrailer, strconv.FormatUint(next, 10))
+}
+
+// pullDeltas loads the changes of the primary since the last pull.
+func (h *Handler) pullDeltas() {
+	h.standbyMu.Lock()
+	defer h.standbyMu.Unlock()
+	if atomic.LoadInt32(&h.follower) == 0 {
+		return // promoted
+	}
+
+	since, err := h.standbySince()
+	if err == nil {
+		var next uint64
+		if next, err = h.fetchDeltas(since); err == nil && next != since {
+			err = h.db.Update(func(txn *badger.Txn) error {
+				return txn.Set(standbySinceKey(), encodeCounter64(next))
+			})
+		}
+	}
+	if err != nil {
+		h.log(LogAdmin).Warningf("Couldn't pull the changes of %s: %s", h.standbyURL, err)
+	}
+}
+
+func (h *Handler) standbySince() (uint64, error) {
+	var since uint64
+	err := h.db.View(func(txn *badger.Txn) error {
+		var err error
+		since, err = getCounter(txn, standbySinceKey())
+		return err
+	})
+	return since, err
+}
+
+func (h *Handler) fetchDeltas(since uint64) (uint64, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s?since=%d", h.standbyURL, since), nil)
+	if err != nil {
+		return 0, err
+	}
+	if h.standbyToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.standbyToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("primary answered %s", resp.Status)
+	}
+
+	if err := h.LoadDeltas(resp.Body); err != nil {
+		return 0, err
+	}
+	next, err := strconv.ParseUint(resp.Trailer.Get(SinceTrailer), 10, 64)
+	if err != nil {
+		return 0, errors.New("incomplete changes stream")
+	}
+	return next, nil
+}
+
+// Promote stops pulling the changes of the primary and makes a standby writable.
+func (h *Handler) Promote() error {
+	if h.standbyURL == "" {
+		return ErrNotStandby
+	}
+	h.standbyMu.Lock()
+	defer h.standbyMu.Unlock()
+	return h.Lead()
+}
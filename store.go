@@ -0,0 +1,200 @@
+package coopurl
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// metaPrefix prefixes every key that isn't a link id (campaigns, counters, indexes...).
+// It can't be part of a generated id nor of a request path, so it never collides with links.
+const metaPrefix = "\x00"
+
+// metaKey builds the key of a record of the given kind.
+func metaKey(kind string, parts ...string) []byte {
+	return KeyCodec{}.Record(kind, parts...)
+}
+
+// metaPrefixKey builds the prefix shared by all the records of the given kind and parts.
+func metaPrefixKey(kind string, parts ...string) []byte {
+	return KeyCodec{}.Prefix(kind, parts...)
+}
+
+func getValue(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func setValue(txn *badger.Txn, key, value []byte, ttl time.Duration) error {
+	e := badger.NewEntry(key, value)
+	if ttl > 0 {
+		e = e.WithTTL(ttl)
+	}
+	return txn.SetEntry(e)
+}
+
+// getCounter returns the value of a counter, missing counters are 0.
+func getCounter(txn *badger.Txn, key []byte) (uint64, error) {
+	b, err := getValue(txn, key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return decodeCounter(b), nil
+}
+
+func encodeCounter64(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func decodeCounter(b []byte) uint64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// incr adds n to a counter.
+func incr(txn *badger.Txn, key []byte, n uint64) error {
+	c, err := getCounter(txn, key)
+	if err != nil {
+		return err
+	}
+	return txn.Set(key, encodeCounter64(c+n))
+}
+
+// add adds delta to a counter, counters don't go below zero.
+func add(txn *badger.Txn, key []byte, delta int64) error {
+	c, err := getCounter(txn, key)
+	if err != nil {
+		return err
+	}
+	n := int64(c) + delta
+	if n < 0 {
+		n = 0
+	}
+	return txn.Set(key, encodeCounter64(uint64(n)))
+}
+
+// updateRetry runs fn in an update transaction, retrying when concurrent transactions conflict.
+// It is used by the writes done on every redirect, like counters.
+func (h *Handler) updateRetry(fn func(txn *badger.Txn) error) error {
+	for {
+		err := h.db.Update(fn)
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+}
+
+// iteratePrefix calls fn with the key suffix and value of every key starting with prefix.
+func iteratePrefix(txn *badger.Txn, prefix []byte, fn func(key string, value []byte) error) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(string(item.Key()[len(prefix):]), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkRef identifies a link by its domain key space and its id.
+type linkRef struct {
+	domain string
+	id     string
+}
+
+func (l linkRef) key() []byte {
+	return linkKey(l.domain, l.id)
+}
+
+// forEachLink calls fn for every link of the store, links of domains first.
+func forEachLink(txn *badger.Txn, fn func(l linkRef, e entry) error) error {
+	domains := metaPrefixKey("link")
+	err := iteratePrefix(txn, domains, func(key string, value []byte) error {
+		if !strings.Contains(key, metaPrefix) {
+			return nil
+		}
+		e, err := decodeEntry(value)
+		if err != nil {
+			return err
+		}
+		domain, id := splitRef(key)
+		return fn(linkRef{domain: domain, id: id}, e)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Links without domain are stored with their id as key, after all the meta keys.
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek([]byte{metaPrefix[0] + 1}); it.Valid(); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		e, err := decodeEntry(v)
+		if err != nil {
+			return err
+		}
+		if err := fn(linkRef{id: string(item.Key())}, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateEntry applies fn to the link stored at key, keeping its expiry and setting its update time.
+func (h *Handler) updateEntry(txn *badger.Txn, key []byte, fn func(e *entry) error) error {
+	item, err := txn.Get(key)
+	if err != nil {
+		return err
+	}
+	b, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	e, err := decodeEntry(b)
+	if err != nil {
+		return err
+	}
+	old := e
+
+	if err := fn(&e); err != nil {
+		return err
+	}
+	e.UpdatedAt = time.Now()
+
+	b, err = h.encodeEntry(e)
+	if err != nil {
+		return err
+	}
+	be := badger.NewEntry(key, b)
+	be.ExpiresAt = item.ExpiresAt()
+	if err := txn.SetEntry(be); err != nil {
+		return err
+	}
+	if kind, parts := (KeyCodec{}).Parse(key); kind == "link" && len(parts) == 2 {
+		return h.updateIndexes(txn, linkRef{domain: parts[0], id: parts[1]}, old, e, item.ExpiresAt())
+	}
+	return nil
+}
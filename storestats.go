@@ -0,0 +1,172 @@
+package coopurl
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// StoreStats describes the size of the store, its growth and when the disk will be full at the current rate.
+type StoreStats struct {
+	LSMSize  int64        `json:"lsm_size"`
+	VlogSize int64        `json:"vlog_size"`
+	Levels   []LevelStats `json:"levels"`
+
+	Writes  uint64 `json:"writes"`  // links written since the process started
+	Deletes uint64 `json:"deletes"` // links deleted since the process started
+
+	// Rates are computed over the samples, they are zero until two samples were taken.
+	WritesPerHour  float64 `json:"writes_per_hour"`
+	DeletesPerHour float64 `json:"deletes_per_hour"`
+	BytesPerHour   float64 `json:"bytes_per_hour"`
+
+	// Samples are the samples of WithStoreSampling, oldest first.
+	Samples []StoreSample `json:"samples,omitempty"`
+
+	Links      int64          `json:"links"`      // links created and not deleted, including expired ones
+	IDLength   int            `json:"id_length"`  // current length of the generated ids
+	Namespaces map[string]int `json:"namespaces"` // live links of each namespace, see Count
+
+	DiskFree   uint64        `json:"disk_free"`
+	DiskFullIn time.Duration `json:"disk_full_in"` // zero when the store isn't growing
+}
+
+// LevelStats describes a level of the badger LSM tree.
+type LevelStats struct {
+	Level        int     `json:"level"`
+	Tables       int     `json:"tables"`
+	Size         int64   `json:"size"`
+	BytesPerHour float64 `json:"bytes_per_hour"` // growth of the level over the samples
+}
+
+// StoreSample is the size of the store and the links written and deleted at a time.
+type StoreSample struct {
+	At       time.Time `json:"at"`
+	LSMSize  int64     `json:"lsm_size"`
+	VlogSize int64     `json:"vlog_size"`
+	Levels   []int64   `json:"levels"` // size of each level
+	Writes   uint64    `json:"writes"`
+	Deletes  uint64    `json:"deletes"`
+}
+
+// Forecast returns a human readable disk usage forecast, eg: "disk full in ~21 days at current rate".
+func (s StoreStats) Forecast() string {
+	if s.DiskFullIn <= 0 {
+		return "store size is stable"
+	}
+	days := s.DiskFullIn.Hours() / 24
+	if days < 1 {
+		return fmt.Sprintf("disk full in ~%d hours at current rate", int(math.Ceil(s.DiskFullIn.Hours())))
+	}
+	return fmt.Sprintf("disk full in ~%d days at current rate", int(math.Round(days)))
+}
+
+// WithStoreSampling samples the size of the store and of its levels, and the links written and deleted, at each
+// interval, keeping the last n samples. StoreStats returns them, with the rates and the forecast computed from them.
+func WithStoreSampling(interval time.Duration, n int) Options {
+	return func(h *Handler) {
+		h.sampling = interval
+		h.sampler.max = n
+	}
+}
+
+// storeSampler counts the store operations and keeps the samples of the store size.
+type storeSampler struct {
+	mu      sync.Mutex
+	writes  uint64
+	deletes uint64
+	samples []StoreSample
+	max     int
+}
+
+func (s *storeSampler) wrote(n uint64) {
+	s.mu.Lock()
+	s.writes += n
+	s.mu.Unlock()
+}
+
+func (s *storeSampler) deleted(n uint64) {
+	s.mu.Lock()
+	s.deletes += n
+	s.mu.Unlock()
+}
+
+// add records the sample with the current counts of writes and deletes.
+func (s *storeSampler) add(sample StoreSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample.Writes, sample.Deletes = s.writes, s.deletes
+	s.samples = append(s.samples, sample)
+	if s.max > 0 && len(s.samples) > s.max {
+		s.samples = s.samples[len(s.samples)-s.max:]
+	}
+}
+
+func (h *Handler) sampleStore() {
+	sample := StoreSample{At: time.Now()}
+	sample.LSMSize, sample.VlogSize = h.db.Size()
+	for _, l := range h.db.Levels() {
+		for len(sample.Levels) <= l.Level {
+			sample.Levels = append(sample.Levels, 0)
+		}
+		sample.Levels[l.Level] = l.Size
+	}
+	h.sampler.add(sample)
+}
+
+// StoreStats returns the current store size and its forecast.
+func (h *Handler) StoreStats() (*StoreStats, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	var s StoreStats
+	s.LSMSize, s.VlogSize = h.db.Size()
+	for _, l := range h.db.Levels() {
+		s.Levels = append(s.Levels, LevelStats{Level: l.Level, Tables: l.NumTables, Size: l.Size})
+	}
+
+	s.Links = h.links.get()
+	s.IDLength = h.getLength(req{})
+	namespaces, err := h.counts(IndexNamespace)
+	if err != nil {
+		return nil, err
+	}
+	s.Namespaces = namespaces
+
+	h.sampler.mu.Lock()
+	s.Writes, s.Deletes = h.sampler.writes, h.sampler.deletes
+	s.Samples = append([]StoreSample(nil), h.sampler.samples...)
+	h.sampler.mu.Unlock()
+	if n := len(s.Samples); n > 1 {
+		first, last := s.Samples[0], s.Samples[n-1]
+		hours := last.At.Sub(first.At).Hours()
+		s.WritesPerHour = float64(last.Writes-first.Writes) / hours
+		s.DeletesPerHour = float64(last.Deletes-first.Deletes) / hours
+		s.BytesPerHour = float64(last.LSMSize+last.VlogSize-first.LSMSize-first.VlogSize) / hours
+		for i, l := range s.Levels {
+			var from, to int64
+			if l.Level < len(first.Levels) {
+				from = first.Levels[l.Level]
+			}
+			if l.Level < len(last.Levels) {
+				to = last.Levels[l.Level]
+			}
+			s.Levels[i].BytesPerHour = float64(to-from) / hours
+		}
+	}
+
+	free, err := diskFree(h.getPath())
+	if err != nil {
+		h.log(LogStore).Warningf("Couldn't get free disk space: %s", err)
+	}
+	s.DiskFree = free
+	if s.BytesPerHour > 0 && free > 0 {
+		s.DiskFullIn = time.Duration(float64(free) / s.BytesPerHour * float64(time.Hour))
+	}
+
+	return &s, nil
+}
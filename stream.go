@@ -0,0 +1,79 @@
+package coopurl
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RedirectEvent is a redirect as sent to the subscribers of Subscribe.
+type RedirectEvent struct {
+	ID      string    `json:"id"`
+	Domain  string    `json:"domain,omitempty"`
+	Country string    `json:"country,omitempty"` // set with WithCountryLookup
+	Bot     bool      `json:"bot,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// WithCountryLookup sets the function returning the country of an ip address, eg: from a GeoIP database.
+// It fills the country of the redirect events, and the clicks are counted per country.
+func WithCountryLookup(fn func(ip string) string) Options {
+	return func(h *Handler) {
+		h.countryLookup = fn
+	}
+}
+
+// broadcaster sends the redirect events to the subscribers.
+type broadcaster struct {
+	mu   sync.Mutex
+	subs map[chan RedirectEvent]struct{}
+}
+
+// Subscribe returns a channel receiving the redirects as they happen, and the function to stop receiving them.
+// Events are dropped when the channel buffer is full, so slow subscribers don't slow down redirects.
+func (h *Handler) Subscribe(buffer int) (<-chan RedirectEvent, func()) {
+	c := make(chan RedirectEvent, buffer)
+
+	b := &h.redirects
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = map[chan RedirectEvent]struct{}{}
+	}
+	b.subs[c] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, c)
+			b.mu.Unlock()
+			close(c)
+		})
+	}
+}
+
+// publishRedirect sends the redirect to the subscribers.
+func (h *Handler) publishRedirect(r *http.Request, l linkRef, now time.Time) {
+	b := &h.redirects
+	b.mu.Lock()
+	n := len(b.subs)
+	b.mu.Unlock()
+	if n == 0 {
+		return
+	}
+
+	e := RedirectEvent{ID: l.id, Domain: l.domain, Bot: h.botKind(r) != NotBot, At: now}
+	if h.countryLookup != nil {
+		e.Country = h.countryLookup(clientIP(r))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.subs {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
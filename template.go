@@ -0,0 +1,105 @@
+package coopurl
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// templateVar matches the variables of a destination template, {name} or {name=default}.
+var templateVar = regexp.MustCompile(`\{([a-z][a-z0-9_]*)(?:=([^{}]*))?\}`)
+
+// templateBraces unescapes the braces escaped by the normalization of the destination.
+var templateBraces = strings.NewReplacer("%7B", "{", "%7b", "{", "%7D", "}", "%7d", "}")
+
+// WithTemplate makes the destination of the link a template whose variables, eg: https://example.com/docs/{lang},
+// are filled on each redirect from the query parameter of the same name of the short link.
+// The lang variable falls back to the language preferred by the Accept-Language header.
+// Variables without value are replaced by their default, {lang=en}, or removed.
+func WithTemplate() ReqOptions {
+	return func(r *req) {
+		r.template = true
+	}
+}
+
+// expand returns the destination of the link e for the request.
+func expand(r *http.Request, e entry) string {
+	if !e.Template {
+		return localized(r, e)
+	}
+
+	s := templateBraces.Replace(localized(r, e))
+	query := strings.IndexByte(s, '?')
+	var b strings.Builder
+	last := 0
+	for _, m := range templateVar.FindAllStringSubmatchIndex(s, -1) {
+		value := templateValue(r, s[m[2]:m[3]])
+		if value == "" && m[4] >= 0 {
+			value = s[m[4]:m[5]]
+		}
+		b.WriteString(s[last:m[0]])
+		// values are escaped for their place in the url, so they can't add segments or parameters
+		if query >= 0 && m[0] > query {
+			b.WriteString(url.QueryEscape(value))
+		} else {
+			b.WriteString(url.PathEscape(value))
+		}
+		last = m[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// templateValue returns the value of a variable for the request.
+func templateValue(r *http.Request, name string) string {
+	if v := r.URL.Query().Get(name); v != "" {
+		return v
+	}
+	if name == "lang" {
+		if langs := acceptLanguages(r.Header.Get("Accept-Language")); len(langs) > 0 {
+			return langs[0]
+		}
+	}
+	return ""
+}
+
+// acceptLanguages returns the primary subtags of the languages of an Accept-Language header,
+// most preferred first: "fr-CA,fr;q=0.9,en;q=0.8" gives fr, en.
+func acceptLanguages(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	seen := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		tag, params := part, ""
+		if i := strings.IndexByte(part, ';'); i >= 0 {
+			tag, params = part[:i], part[i+1:]
+		}
+		tag = primaryLanguage(tag)
+		if tag == "" || tag == "*" || seen[tag] {
+			continue
+		}
+		q := 1.0
+		if v := strings.TrimSpace(params); strings.HasPrefix(v, "q=") {
+			f, err := strconv.ParseFloat(v[2:], 64)
+			if err != nil || f <= 0 {
+				continue
+			}
+			q = f
+		}
+		seen[tag] = true
+		langs = append(langs, lang{tag: tag, q: q})
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.tag
+	}
+	return tags
+}
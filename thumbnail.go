@@ -0,0 +1,231 @@
+package coopurl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+const (
+	DefaultThumbnailMaxAge  = 7 * 24 * time.Hour
+	DefaultThumbnailTimeout = 30 * time.Second
+)
+
+// maxThumbnailSize bounds the images read from a ThumbnailProvider.
+const maxThumbnailSize = 4 << 20
+
+// Thumbnail is a screenshot of the destination of a link.
+type Thumbnail struct {
+	ContentType string    `json:"content_type"` // eg: image/png
+	Data        []byte    `json:"data"`
+	URL         string    `json:"url"` // destination captured
+	CapturedAt  time.Time `json:"captured_at"`
+}
+
+// ThumbnailProvider captures the thumbnails of destinations, eg: a ScreenshotService.
+// Capture only has to set the content type and the data of the thumbnail.
+type ThumbnailProvider interface {
+	Capture(ctx context.Context, url string) (*Thumbnail, error)
+}
+
+// ScreenshotService is a ThumbnailProvider posting the url to capture to a headless browser service, eg: the
+// /screenshot endpoint of browserless, as {"url": "...", "options": {"type": "png"}, "viewport": {...}}, which
+// answers the image.
+type ScreenshotService struct {
+	URL    string       // endpoint of the service, with its token if any, eg: http://browserless:3000/screenshot
+	Width  int          // width of the viewport, 1280 if 0
+	Height int          // height of the viewport, 800 if 0
+	Client *http.Client // http.DefaultClient if nil
+}
+
+func (s ScreenshotService) Capture(ctx context.Context, u string) (*Thumbnail, error) {
+	width, height := s.Width, s.Height
+	if width == 0 {
+		width = 1280
+	}
+	if height == 0 {
+		height = 800
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"url":      u,
+		"options":  map[string]string{"type": "png"},
+		"viewport": map[string]int{"width": width, "height": height},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("screenshot service returned %d", resp.StatusCode)
+	}
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mt, "image/") {
+		return nil, fmt.Errorf("screenshot service returned %q instead of an image", mt)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxThumbnailSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxThumbnailSize {
+		return nil, fmt.Errorf("screenshot over %d bytes", maxThumbnailSize)
+	}
+	return &Thumbnail{ContentType: mt, Data: data}, nil
+}
+
+// thumbnailer captures and caches the thumbnails of destinations.
+type thumbnailer struct {
+	provider ThumbnailProvider
+	maxAge   time.Duration
+}
+
+// WithThumbnails captures screenshots of the destinations with p on their first call to Thumbnail, and caches
+// them in the store for maxAge, DefaultThumbnailMaxAge if 0, or until the destination changes. They are served
+// at "{id}.png", and shown on the info pages. Destinations resolving to private addresses aren't captured.
+func WithThumbnails(p ThumbnailProvider, maxAge time.Duration) Options {
+	if maxAge == 0 {
+		maxAge = DefaultThumbnailMaxAge
+	}
+	return func(h *Handler) {
+		h.thumbnails = &thumbnailer{provider: p, maxAge: maxAge}
+	}
+}
+
+func thumbnailKey(l linkRef) []byte {
+	return metaKey("thumbnail", l.domain, l.id)
+}
+
+// Thumbnail returns the thumbnail of the link with the given id, capturing it if it isn't cached, nil without
+// WithThumbnails or for the pages hosted by the shortener.
+func (h *Handler) Thumbnail(id string, opts ...ReqOptions) (*Thumbnail, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	e, err := h.get(r, id)
+	if err != nil {
+		return nil, err
+	}
+	if h.thumbnails == nil || e.Page != nil {
+		return nil, nil
+	}
+	return h.thumbnail(context.Background(), r, h.normalizeID(id), e.URL)
+}
+
+// thumbnail returns the cached thumbnail of the destination u of the link, capturing it if missing or stale.
+func (h *Handler) thumbnail(ctx context.Context, r req, id, u string) (*Thumbnail, error) {
+	var cached *Thumbnail
+	err := h.db.View(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+		b, err := getValue(txn, thumbnailKey(linkRef{domain: r.domain, id: id}))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var t Thumbnail
+		if err := json.Unmarshal(b, &t); err != nil {
+			return err
+		}
+		if t.URL == u {
+			cached = &t
+		}
+		return nil
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil || cached != nil {
+		return cached, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultThumbnailTimeout)
+	defer cancel()
+	// the provider captures the destination from the local network, it must be public
+	if err := checkPublicHost(ctx, u); err != nil {
+		return nil, err
+	}
+	t, err := h.thumbnails.provider.Capture(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	t.URL, t.CapturedAt = u, time.Now().UTC()
+	if h.writable() != nil {
+		return t, nil
+	}
+
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	err = h.db.Update(func(txn *badger.Txn) error {
+		return setValue(txn, thumbnailKey(linkRef{domain: r.domain, id: id}), b, h.thumbnails.maxAge)
+	})
+	return t, err
+}
+
+// serveThumbnail serves the thumbnail of the link, without counting a click.
+func (h *Handler) serveThumbnail(w http.ResponseWriter, r *http.Request, id string) {
+	if h.thumbnails == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	e, domain, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	if e.Page != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	t, err := h.thumbnail(r.Context(), req{domain: domain}, id, e.URL)
+	if errors.Is(err, ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.log(LogRedirects).Warningf("Couldn't capture the thumbnail of %s (request %s): %s", id, RequestIDFrom(r.Context()), err)
+		serveError(w, r, http.StatusBadGateway)
+		return
+	}
+
+	if NotModified(w, r, etag([]interface{}{t.URL, t.CapturedAt}), t.CapturedAt) {
+		return
+	}
+	w.Header().Set("Content-Type", t.ContentType)
+	w.Header().Set("Cache-Control", "max-age=3600")
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(t.Data)
+}
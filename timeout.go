@@ -0,0 +1,37 @@
+package coopurl
+
+import "time"
+
+// WithStoreTimeout bounds the time the redirects wait for the store, eg: during a disk hiccup.
+// Redirects whose link isn't read in time are answered with a 503 status and a Retry-After header instead
+// of hanging, and clicks not counted in time are given up. The reads and writes timing out still complete
+// in the background.
+func WithStoreTimeout(timeout time.Duration) Options {
+	return func(h *Handler) {
+		h.storeTimeout = timeout
+	}
+}
+
+// withTimeout runs fn, which uses the store, and stops waiting for it after the store timeout.
+// It must be called during an operation, so that the store isn't closed until fn returns.
+func (h *Handler) withTimeout(fn func() error) error {
+	if h.storeTimeout <= 0 {
+		return fn()
+	}
+
+	done := make(chan error, 1)
+	h.detached.Add(1)
+	go func() {
+		defer h.detached.Done()
+		done <- fn()
+	}()
+
+	t := time.NewTimer(h.storeTimeout)
+	defer t.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-t.C:
+		return ErrTimeout
+	}
+}
@@ -0,0 +1,194 @@
+package coopurl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Scope is what an APIToken allows its owner to do.
+type Scope string
+
+const (
+	ScopeCreate    Scope = "create"     // create links
+	ScopeReadStats Scope = "read-stats" // read the stats of the links of the owner
+	ScopeDelete    Scope = "delete"     // delete the links of the owner
+)
+
+// tokenPrefix starts the tokens, so that they can be told apart, eg: by secret scanners.
+const tokenPrefix = "coopurl_"
+
+// APIToken is a credential of an owner, limited to its scopes.
+// The token itself is only returned by CreateToken, the store keeps its hash.
+type APIToken struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Name      string    `json:"name,omitempty"`
+	Scopes    []Scope   `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// storedToken is the value stored for each token.
+type storedToken struct {
+	APIToken
+	Hash []byte `json:"hash"` // sha256 of the secret
+}
+
+// Allows tells if the token has the scope.
+func (t *APIToken) Allows(s Scope) bool {
+	for _, scope := range t.Scopes {
+		if scope == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validScope(s Scope) bool {
+	return s == ScopeCreate || s == ScopeReadStats || s == ScopeDelete
+}
+
+func tokenKey(id string) []byte {
+	return metaKey("token", id)
+}
+
+// CreateToken issues a token of the owner with the given scopes, eg: for a script creating links.
+// It returns the token, which can't be read again, and its description.
+func (h *Handler) CreateToken(owner, name string, scopes ...Scope) (string, *APIToken, error) {
+	if err := h.ready(); err != nil {
+		return "", nil, err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return "", nil, err
+	}
+	if owner == "" {
+		return "", nil, ErrInvalidName
+	}
+	if len(scopes) == 0 {
+		return "", nil, ErrInvalidScope
+	}
+	for _, s := range scopes {
+		if !validScope(s) {
+			return "", nil, ErrInvalidScope
+		}
+	}
+
+	b := make([]byte, 40)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	id, secret := hex.EncodeToString(b[:8]), base64.RawURLEncoding.EncodeToString(b[8:])
+	hash := sha256.Sum256([]byte(secret))
+	t := storedToken{
+		APIToken: APIToken{ID: id, Owner: owner, Name: name, Scopes: scopes, CreatedAt: time.Now()},
+		Hash:     hash[:],
+	}
+	v, err := json.Marshal(t)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := h.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(tokenKey(id), v)
+	}); err != nil {
+		return "", nil, err
+	}
+
+	h.log(LogAdmin).Infof("Create token %s of %s with scopes %v", id, owner, scopes)
+	return tokenPrefix + id + "_" + secret, &t.APIToken, nil
+}
+
+// Authenticate returns the token, or ErrInvalidToken if it wasn't issued or was revoked.
+func (h *Handler) Authenticate(token string) (*APIToken, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	s := strings.TrimPrefix(token, tokenPrefix)
+	i := strings.Index(s, "_")
+	if len(s) == len(token) || i < 0 {
+		return nil, ErrInvalidToken
+	}
+	id, secret := s[:i], s[i+1:]
+
+	var t storedToken
+	err := h.db.View(func(txn *badger.Txn) error {
+		b, err := getValue(txn, tokenKey(id))
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, &t)
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], t.Hash) != 1 {
+		return nil, ErrInvalidToken
+	}
+	return &t.APIToken, nil
+}
+
+// Tokens returns the tokens of the owner, all the tokens if owner is empty, oldest first.
+func (h *Handler) Tokens(owner string) ([]APIToken, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	var tokens []APIToken
+	err := h.db.View(func(txn *badger.Txn) error {
+		return iteratePrefix(txn, metaPrefixKey("token"), func(_ string, value []byte) error {
+			var t storedToken
+			if err := json.Unmarshal(value, &t); err != nil {
+				return err
+			}
+			if owner == "" || t.Owner == owner {
+				tokens = append(tokens, t.APIToken)
+			}
+			return nil
+		})
+	})
+	sort.SliceStable(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens, err
+}
+
+// RevokeToken removes the token with the given id, it can't be used anymore.
+func (h *Handler) RevokeToken(id string) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+
+	err := h.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(tokenKey(id)); err != nil {
+			return err
+		}
+		return txn.Delete(tokenKey(id))
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrTokenNotFound
+	}
+	if err != nil {
+		return err
+	}
+	h.log(LogAdmin).Infof("Revoke token %s", id)
+	return nil
+}
@@ -0,0 +1,96 @@
+package coopurl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Touch sets the ttl of the link with the given id to ttl from now, 0 makes it never expire.
+// Links used during their grace period can be touched to keep them working.
+func (h *Handler) Touch(id string, ttl time.Duration, opts ...ReqOptions) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+	return h.touch(id, ttl, opts...)
+}
+
+func (h *Handler) touch(id string, ttl time.Duration, opts ...ReqOptions) error {
+	id = h.normalizeID(id)
+	if !validID(id) || !h.verify(id) {
+		return ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err := h.db.Update(func(txn *badger.Txn) error {
+		if err := resolveDomain(txn, &r); err != nil {
+			return err
+		}
+
+		b, err := getValue(txn, linkKey(r.domain, id))
+		if err != nil {
+			return err
+		}
+		e, err := decodeEntry(b)
+		if err != nil {
+			return err
+		}
+		old := e
+		if err := r.nextVersion(&e); err != nil {
+			return err
+		}
+
+		storedTTL := ttl
+		e.ExpiresAt = time.Time{}
+		if ttl != 0 {
+			e.ExpiresAt = time.Now().Add(ttl)
+			storedTTL += h.grace
+		}
+		e.UpdatedAt = time.Now()
+		if b, err = h.encodeEntry(e); err != nil {
+			return err
+		}
+		if err := setValue(txn, linkKey(r.domain, id), b, storedTTL); err != nil {
+			return err
+		}
+		if err := h.setExpired(txn, linkRef{domain: r.domain, id: id}, e.ExpiresAt, storedTTL); err != nil {
+			return err
+		}
+		var expiresAt uint64
+		if storedTTL != 0 {
+			expiresAt = uint64(time.Now().Add(storedTTL).Unix())
+		}
+		if err := h.updateIndexes(txn, linkRef{domain: r.domain, id: id}, old, e, expiresAt); err != nil {
+			return err
+		}
+		// The link may expire again, its owner is notified again.
+		return txn.Delete(graceNotifiedKey(r.domain, id))
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	h.log(LogStore).Infof("Touch entry: %s (ttl: %s)", id, ttl)
+	return nil
+}
+
+// GetTTL returns the time left before the link with the given id expires, 0 if it doesn't expire.
+func (h *Handler) GetTTL(id string, opts ...ReqOptions) (time.Duration, error) {
+	e, err := h.GetEntry(id, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return e.TTL, nil
+}
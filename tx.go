@@ -0,0 +1,181 @@
+package coopurl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Tx is a transaction of the store, given to the function run by Handler.Tx.
+// It must not be used after the function returns.
+type Tx struct {
+	h        *Handler
+	txn      *badger.Txn
+	created  int64
+	replaced int64
+	deleted  int64
+}
+
+// Tx runs fn in a single transaction of the store, eg: to check that an alias is free and create it with its
+// metadata without racing with other requests. The changes made by fn are committed if it returns nil, and
+// discarded otherwise. fn is run again if the transaction conflicts with another one, so it must not have other
+// side effects, and it must not call the methods of the handler.
+func (h *Handler) Tx(fn func(tx *Tx) error) error {
+	if err := h.ready(); err != nil {
+		return err
+	}
+	defer h.release()
+	if err := h.writable(); err != nil {
+		return err
+	}
+
+	var tx *Tx
+	err := h.updateRetry(func(txn *badger.Txn) error {
+		tx = &Tx{h: h, txn: txn}
+		return fn(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	h.sampler.wrote(uint64(tx.created + tx.replaced))
+	h.sampler.deleted(uint64(tx.deleted))
+	if n := tx.created - tx.deleted; n != 0 {
+		h.countLinks(n)
+	}
+	h.log(LogStore).Infof("Transaction: %d created, %d replaced, %d deleted", tx.created, tx.replaced, tx.deleted)
+	return nil
+}
+
+// Get returns the link with the given id as seen by the transaction, or ErrNotFound.
+func (tx *Tx) Get(id string, opts ...ReqOptions) (Entry, error) {
+	id = tx.h.normalizeID(id)
+	if !validID(id) || !tx.h.verify(id) {
+		return Entry{}, ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	e, err := getEntry(tx.txn, &r, id)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return Entry{}, ErrNotFound
+	}
+	return e, err
+}
+
+// Set stores the link with the given id and destination, replacing the link with this id if any.
+// It honors the options of Post, except WithAlias and WithLength as the id is given.
+// New ids are checked like aliases.
+func (tx *Tx) Set(id, url string, opts ...ReqOptions) error {
+	h := tx.h
+	id = h.normalizeID(id)
+
+	u, broken, err := h.destination(url)
+	if err != nil {
+		return err
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	if err := resolveDomain(tx.txn, &r); err != nil {
+		return err
+	}
+
+	l := linkRef{domain: r.domain, id: id}
+	b, err := getValue(tx.txn, l.key())
+	exists := err == nil
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return err
+	}
+	if exists {
+		old, err := decodeEntry(b)
+		if err != nil {
+			return err
+		}
+		if err := h.deleteIndexes(tx.txn, l, old); err != nil {
+			return err
+		}
+		if err := tx.txn.Delete(l.key()); err != nil {
+			return err
+		}
+	} else {
+		// a signature would change the id
+		if h.signSecret != nil {
+			return ErrInvalidAlias
+		}
+		if err := h.checkAlias(id); err != nil {
+			return err
+		}
+	}
+
+	languages, reason, err := h.languageURLs(r)
+	if err != nil {
+		return err
+	}
+	if err := checkHeaders(r.headers); err != nil {
+		return err
+	}
+	if broken == "" {
+		broken = reason
+	}
+
+	ttl := r.ttl
+	if ttl == 0 {
+		ttl = h.TTL
+	}
+	e := entry{
+		URL:       u.String(),
+		Languages: languages,
+		Headers:   r.headers,
+		Tags:      r.tags,
+		Owner:     r.owner,
+		Broken:    broken,
+		NoIndex:   r.noIndex,
+		Public:    r.public,
+		Template:  r.template,
+		CreatedAt: time.Now(),
+	}
+	if err := h.checkMetadata(e); err != nil {
+		return err
+	}
+	if err := h.store(tx.txn, id, e, &r, &ttl); err != nil {
+		return err
+	}
+
+	if exists {
+		tx.replaced++
+	} else {
+		tx.created++
+	}
+	return nil
+}
+
+// Delete removes the link with the given id, or returns ErrNotFound.
+// With WithSoftDelete(), the link can be restored.
+func (tx *Tx) Delete(id string, opts ...ReqOptions) error {
+	id = tx.h.normalizeID(id)
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	err := tx.h.remove(tx.txn, &r, id)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	tx.deleted++
+	return nil
+}
@@ -0,0 +1,66 @@
+package coopurl
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// unfurlPage carries the metadata of the destination for link preview bots,
+// and sends anyone else to the destination.
+var unfurlPage = template.Must(template.New("unfurl").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="canonical" href="{{.URL}}">
+<meta property="og:url" content="{{.URL}}">
+{{with .Title}}<meta property="og:title" content="{{.}}">
+{{end}}{{with .Description}}<meta property="og:description" content="{{.}}">
+<meta name="description" content="{{.}}">
+{{end}}{{with .Image}}<meta property="og:image" content="{{.}}">
+<meta name="twitter:card" content="summary_large_image">
+{{end}}<meta http-equiv="refresh" content="0; url={{.URL}}">
+</head>
+<body><a href="{{.URL}}">{{.URL}}</a></body>
+</html>
+`))
+
+// WithUnfurl answers link preview bots with a page holding the preview of the destination instead of a redirect,
+// so messaging apps show the destination rather than the shortener. Previews are set with WithPreviews,
+// links without preview are redirected and have their preview fetched for the next time.
+func WithUnfurl() Options {
+	return func(h *Handler) {
+		h.unfurl = true
+	}
+}
+
+// unfurls tells if the request is answered with serveUnfurl, fetching the missing preview of the link.
+func (h *Handler) unfurls(r *http.Request, id, domain string, e entry) bool {
+	if !h.unfurl || h.botKind(r) != PreviewBot {
+		return false
+	}
+	if e.Preview == nil {
+		if h.writable() == nil {
+			h.refreshPreview(id, req{domain: domain}, e.URL)
+		}
+		return false
+	}
+	return true
+}
+
+func (h *Handler) serveUnfurl(w http.ResponseWriter, r *http.Request, e entry) error {
+	data := struct {
+		URL string
+		Preview
+	}{URL: e.URL, Preview: *e.Preview}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if NotModified(w, r, etag(data), e.modified()) {
+		return nil
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	return unfurlPage.Execute(w, data)
+}
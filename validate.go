@@ -0,0 +1,134 @@
+package coopurl
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// maxLength bounds the length of ids and signatures, the length of an hexadecimal SHA-256 digest.
+const maxLength = 64
+
+func optionError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidOption}, args...)...)
+}
+
+// validate checks the options given to New, so that misconfigurations fail at construction
+// rather than at the first Post or Get.
+func (h *Handler) validate() error {
+	if h.TTL < 0 {
+		return optionError("negative default ttl %s", h.TTL)
+	}
+	if h.Length < 0 || h.Length > maxLength {
+		return optionError("default length %d isn't between 1 and %d", h.Length, maxLength)
+	}
+	if h.jobJitter < 0 || h.jobJitter > 1 {
+		return optionError("job jitter %g isn't between 0 and 1", h.jobJitter)
+	}
+	if h.signSecret != nil && h.signLength > maxLength {
+		return optionError("signature length %d is over %d", h.signLength, maxLength)
+	}
+	if h.writes != nil && h.writes.interval <= 0 {
+		return optionError("interval %s of the async writes isn't positive", h.writes.interval)
+	}
+	if h.writes != nil && h.writes.maxBatch <= 0 {
+		return optionError("batch size %d of the async writes isn't positive", h.writes.maxBatch)
+	}
+	if h.fallbackURL != "" && (h.primaryURL != "" || h.standbyURL != "") {
+		return optionError("a replica fallback is neither a replica nor a standby")
+	}
+	if err := h.checkConsistency(); err != nil {
+		return err
+	}
+	if h.visitors < VisitorsNone || h.visitors > VisitorsDailyHash {
+		return optionError("unknown visitor mode %d", h.visitors)
+	}
+	if h.cdn != nil && (h.cdn.MaxAge < 0 || h.cdn.SharedMaxAge < 0) {
+		return optionError("negative cdn max ages %s and %s", h.cdn.MaxAge, h.cdn.SharedMaxAge)
+	}
+	if h.cdn != nil && h.visitors == VisitorsCookie {
+		return optionError("the visitor cookie can't be set on redirects cached by a cdn")
+	}
+	if h.storeTimeout < 0 {
+		return optionError("negative store timeout %s", h.storeTimeout)
+	}
+	if h.breaker != nil && (h.breaker.failures <= 0 || h.breaker.cooldown <= 0) {
+		return optionError("circuit breaker failures %d and cooldown %s aren't positive", h.breaker.failures, h.breaker.cooldown)
+	}
+	if h.warmUp < 0 {
+		return optionError("negative number of links to warm up %d", h.warmUp)
+	}
+	if h.maxURLLength < 0 {
+		return optionError("negative max url length %d", h.maxURLLength)
+	}
+	if h.maxMetadataSize < 0 {
+		return optionError("negative max metadata size %d", h.maxMetadataSize)
+	}
+	if !validCompression(h.compression) {
+		return optionError("unknown compression %d", h.compression)
+	}
+	if h.grace < 0 {
+		return optionError("negative expiry grace %s", h.grace)
+	}
+	if h.staleWindow < 0 || h.staleWindow > 0 && h.cache == nil {
+		return optionError("stale while revalidate needs WithCache and a positive window")
+	}
+	if h.expiredRetention < 0 {
+		return optionError("negative expired retention %s", h.expiredRetention)
+	}
+	if h.retention < 0 {
+		return optionError("negative soft delete retention %s", h.retention)
+	}
+	if h.eventRetention < 0 {
+		return optionError("negative event retention %s", h.eventRetention)
+	}
+	if h.outbox != nil && (h.outbox.Deliver == nil || h.outbox.MaxAttempts < 0 || h.outbox.Backoff < 0) {
+		return optionError("the outbox needs a delivery function, positive attempts and backoff")
+	}
+	if h.thumbnails != nil && (h.thumbnails.provider == nil || h.thumbnails.maxAge < 0) {
+		return optionError("thumbnails need a provider and a positive max age, not %s", h.thumbnails.maxAge)
+	}
+	if h.idempotencyWindow < 0 {
+		return optionError("negative idempotency window %s", h.idempotencyWindow)
+	}
+	if h.creationLimit != nil && (h.creationLimit.Burst <= 0 || h.creationLimit.Interval <= 0) {
+		return optionError("creation limit burst %d and interval %s aren't positive", h.creationLimit.Burst, h.creationLimit.Interval)
+	}
+
+	intervals := []struct {
+		name     string
+		enabled  bool
+		interval time.Duration
+	}{
+		{"dead link checker", h.deadLinks != nil, h.deadLinksInterval},
+		{"archiver", h.archiver != nil, h.archiveInterval},
+		{"standby", h.standbyURL != "", h.standbyInterval},
+		{"expiry notifier", h.expiryNotifier != nil, h.expiryNoticeInterval},
+	}
+	for _, i := range intervals {
+		if i.enabled && i.interval <= 0 {
+			return optionError("interval %s of the %s isn't positive", i.interval, i.name)
+		}
+	}
+
+	if h.temporary {
+		if h.path != "" {
+			return optionError("a temporary store has no db path")
+		}
+		return nil
+	}
+	return checkWritable(h.getPath())
+}
+
+// checkWritable creates the directory of the store if needed and checks that files can be created in it.
+func checkWritable(path string) error {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return optionError("db path %s isn't writable: %s", path, err)
+	}
+	f, err := os.CreateTemp(path, ".write-check-*")
+	if err != nil {
+		return optionError("db path %s isn't writable: %s", path, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
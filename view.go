@@ -0,0 +1,293 @@
+package coopurl
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// linkView is what a request asks of its link, given by the suffix of the id in its path.
+type linkView int
+
+const (
+	viewRedirect  linkView = iota
+	viewInfo               // "{id}+", the destination without redirecting
+	viewStats              // "{id}.json", the public stats
+	viewThumbnail          // "{id}.png", the thumbnail of the destination
+)
+
+// parsePath returns the id of the request path and the view it asks for, ignoring a trailing slash.
+func parsePath(p string) (string, linkView) {
+	_, id := path.Split(strings.TrimSuffix(p, "/"))
+	switch {
+	case strings.HasSuffix(id, "+"):
+		return strings.TrimSuffix(id, "+"), viewInfo
+	case strings.HasSuffix(id, ".json"):
+		return strings.TrimSuffix(id, ".json"), viewStats
+	case strings.HasSuffix(id, ".png"):
+		return strings.TrimSuffix(id, ".png"), viewThumbnail
+	}
+	return id, viewRedirect
+}
+
+// WithPublicStats serves the PublicStats of the links at "{id}.json", so that anyone having a link can check how it
+// performs without admin access.
+func WithPublicStats() Options {
+	return func(h *Handler) {
+		h.publicStats = true
+	}
+}
+
+// maxTopReferrers bounds the referrers of PublicStats.
+const maxTopReferrers = 10
+
+// PublicStats are the stats of a link served with WithPublicStats.
+type PublicStats struct {
+	ID           string     `json:"id"`
+	Clicks       uint64     `json:"clicks"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at,omitempty"`
+	TopReferrers []Referrer `json:"top_referrers"` // most clicked first
+}
+
+// Referrer is the host clicks came from, "" for direct clicks.
+type Referrer struct {
+	Host   string `json:"host"`
+	Clicks uint64 `json:"clicks"`
+}
+
+// PublicStats returns the stats of a link served at "{id}.json" with WithPublicStats.
+// Disabled links return ErrNotFound, as they aren't served.
+func (h *Handler) PublicStats(id string, opts ...ReqOptions) (*PublicStats, error) {
+	if err := h.ready(); err != nil {
+		return nil, err
+	}
+	defer h.release()
+
+	r := req{}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return h.publicStatsOf(r, h.normalizeID(id))
+}
+
+func (h *Handler) publicStatsOf(r req, id string) (*PublicStats, error) {
+	if !validID(id) || !h.verify(id) {
+		return nil, ErrNotFound
+	}
+
+	var s *PublicStats
+	err := h.db.View(func(txn *badger.Txn) error {
+		e, err := getEntry(txn, &r, id)
+		if err != nil {
+			return err
+		}
+		if e.Disabled {
+			return ErrNotFound
+		}
+		s = &PublicStats{ID: id, Clicks: e.Clicks, CreatedAt: e.CreatedAt, ExpiresAt: e.ExpiresAt}
+
+		referrers := map[string]uint64{}
+		if err := iterateCounters(txn, referrersPrefix(linkRef{domain: r.domain, id: id}), referrers); err != nil {
+			return err
+		}
+		s.TopReferrers = topReferrers(referrers, maxTopReferrers)
+		return nil
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// topReferrers returns the n referrers with the most clicks.
+func topReferrers(counts map[string]uint64, n int) []Referrer {
+	hosts := rankCounts(counts)
+	if len(hosts) > n {
+		hosts = hosts[:n]
+	}
+	refs := make([]Referrer, len(hosts))
+	for i, host := range hosts {
+		refs[i] = Referrer{Host: host, Clicks: counts[host]}
+	}
+	return refs
+}
+
+// rankCounts returns the keys of counts, the highest count first.
+func rankCounts(counts map[string]uint64) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// InfoData is the data the info page is executed with: the destination of the link, its preview, its thumbnail
+// with WithThumbnails, and the language of the page, selected by the Accept-Language header.
+type InfoData struct {
+	URL       string
+	Lang      string
+	Thumbnail string // relative url of the thumbnail, empty without WithThumbnails
+	Preview
+}
+
+// T returns the text s of the page translated in its language, eg: {{.T "This link goes to:"}}.
+func (d InfoData) T(s string) string {
+	return translate(d.Lang, s)
+}
+
+// WithInfoTemplate renders the info pages showing the destination of the links with t instead of the default
+// template, t is executed with an InfoData.
+func WithInfoTemplate(t *template.Template) Options {
+	return func(h *Handler) {
+		h.infoTemplate = t
+	}
+}
+
+var infoPage = template.Must(template.New("info").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{with .Title}}{{.}}{{else}}{{.URL}}{{end}}</title>
+</head>
+<body>
+<p>{{.T "This link goes to:"}}</p>
+<p><a href="{{.URL}}" rel="nofollow">{{.URL}}</a></p>
+{{with .Image}}<img src="{{.}}" alt="" style="max-width:100%">
+{{else}}{{with .Thumbnail}}<img src="{{.}}" alt="" style="max-width:100%">
+{{end}}{{end}}{{with .Title}}<h1>{{.}}</h1>
+{{end}}{{with .Description}}<p>{{.}}</p>
+{{end}}</body>
+</html>
+`))
+
+// lookup resolves the link of the request, answering it if the link can't be served.
+func (h *Handler) lookup(w http.ResponseWriter, r *http.Request, id string) (entry, string, bool) {
+	var e entry
+	var domain string
+	err := h.withTimeout(func() error {
+		var err error
+		e, domain, err = h.resolve(requestDomain(r), id, nil)
+		return err
+	})
+	if errors.Is(err, ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return entry{}, "", false
+	}
+	if errors.Is(err, ErrTimeout) {
+		serveUnavailable(w, r, h.storeTimeout)
+		return entry{}, "", false
+	}
+	if err != nil {
+		h.log(LogRedirects).Errorf("Couldn't resolve %s (request %s): %s", id, RequestIDFrom(r.Context()), err)
+		serveError(w, r, http.StatusInternalServerError)
+		return entry{}, "", false
+	}
+	if hidden(r, e) {
+		w.WriteHeader(http.StatusNotFound)
+		return entry{}, "", false
+	}
+	if e.Disabled {
+		h.serveDisabled(w, r)
+		return entry{}, "", false
+	}
+	return e, domain, true
+}
+
+// serveInfo serves a page showing the destination of the link, without counting a click.
+func (h *Handler) serveInfo(w http.ResponseWriter, r *http.Request, id string) {
+	e, _, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	if e.NoIndex || h.noIndex && !e.Public {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+
+	data := InfoData{URL: expand(r, e), Lang: requestLanguage(r)}
+	if e.Preview != nil {
+		data.Preview = *e.Preview
+	}
+	if h.thumbnails != nil {
+		data.Thumbnail = "./" + id + ".png"
+	}
+	// pages are served by the shortener
+	if e.Page != nil {
+		data.Thumbnail = ""
+		data.URL = "./" + id
+		data.Preview = Preview{Title: e.Page.Title, Description: e.Page.Description}
+	}
+
+	if NotModified(w, r, etag(data), e.modified()) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	t := h.infoTemplate
+	if t == nil {
+		t = infoPage
+	}
+	if err := t.Execute(w, data); err != nil {
+		h.log(LogRedirects).Warningf("Couldn't render the info page of %s (request %s): %s", id, RequestIDFrom(r.Context()), err)
+	}
+}
+
+// servePublicStats serves the PublicStats of the link as json.
+func (h *Handler) servePublicStats(w http.ResponseWriter, r *http.Request, id string) {
+	if !h.publicStats {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_, domain, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+
+	var stats *PublicStats
+	err := h.withTimeout(func() error {
+		var err error
+		stats, err = h.publicStatsOf(req{domain: domain}, id)
+		return err
+	})
+	if errors.Is(err, ErrNotFound) {
+		// deleted since the lookup
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrTimeout) {
+		serveUnavailable(w, r, h.storeTimeout)
+		return
+	}
+	if err != nil {
+		h.log(LogRedirects).Errorf("Couldn't read the stats of %s (request %s): %s", id, RequestIDFrom(r.Context()), err)
+		serveError(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == http.MethodHead {
+		return
+	}
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,153 @@
+package coopurl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+type VisitorMode int
+
+const (
+	// VisitorsNone only counts the clicks. It is the default mode.
+	VisitorsNone VisitorMode = iota
+	// VisitorsCookie recognizes the visitors with a first-party cookie holding a random id.
+	VisitorsCookie
+	// VisitorsDailyHash recognizes the visitors by a hash of their ip address and user agent salted with a
+	// random salt changed every day, without cookie. Past salts are dropped, so visitors can't be followed
+	// from a day to the next.
+	VisitorsDailyHash
+)
+
+// VisitorCookie is the name of the cookie set with VisitorsCookie.
+const VisitorCookie = "coopurl_vid"
+
+// visitorCookieAge is the lifetime of the visitor cookie.
+const visitorCookieAge = 365 * 24 * time.Hour
+
+// visitorMarkTTL keeps the marks of the visitors of a day until the day is over in every time zone.
+const visitorMarkTTL = 48 * time.Hour
+
+// WithUniqueVisitors counts the unique visitors of the links along with their clicks: a visitor clicking a
+// link several times in a day is counted once that day.
+// Visitors are stored as hashes, kept two days.
+func WithUniqueVisitors(mode VisitorMode) Options {
+	return func(h *Handler) {
+		h.visitors = mode
+	}
+}
+
+func visitorsKey(l linkRef) []byte {
+	return metaKey("visitors", l.domain, l.id)
+}
+
+func dailyVisitorsPrefix(l linkRef) []byte {
+	return metaPrefixKey("daily-visitors", l.domain, l.id)
+}
+
+func visitorMarkKey(l linkRef, day, visitor string) []byte {
+	return metaKey("visitor", l.domain, l.id, day, visitor)
+}
+
+func visitorSaltKey(day string) []byte {
+	return metaKey("visitor-salt", day)
+}
+
+// visitorSalt is the salt of the visitor hashes of a day, shared by the handlers of a store.
+type visitorSalt struct {
+	mu   sync.Mutex
+	day  string
+	salt []byte
+}
+
+// visitor returns the hash identifying the client of the request, or "" if visitors aren't counted.
+// It must be called before the response is written, as it may set the visitor cookie.
+func (h *Handler) visitor(w http.ResponseWriter, r *http.Request, now time.Time) string {
+	switch h.visitors {
+	case VisitorsCookie:
+		var id string
+		if c, err := r.Cookie(VisitorCookie); err == nil && len(c.Value) == 32 {
+			id = c.Value
+		} else {
+			id = newRequestID()
+			http.SetCookie(w, &http.Cookie{
+				Name:     VisitorCookie,
+				Value:    id,
+				Path:     "/",
+				MaxAge:   int(visitorCookieAge / time.Second),
+				Secure:   r.TLS != nil,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		// ids are hashed so the stored marks can't be matched with the cookies
+		sum := sha256.Sum256([]byte(id))
+		return hex.EncodeToString(sum[:16])
+	case VisitorsDailyHash:
+		salt, err := h.dailySalt(now)
+		if err != nil {
+			h.log(LogRedirects).Warningf("Couldn't get the salt of the visitors: %s", err)
+			return ""
+		}
+		m := hmac.New(sha256.New, salt)
+		m.Write([]byte(clientIP(r) + "\n" + r.UserAgent()))
+		return hex.EncodeToString(m.Sum(nil)[:16])
+	default:
+		return ""
+	}
+}
+
+// dailySalt returns the salt of the day, creating it if needed.
+func (h *Handler) dailySalt(now time.Time) ([]byte, error) {
+	day := now.UTC().Format(dayFormat)
+	s := &h.visitorSalt
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.day == day {
+		return s.salt, nil
+	}
+
+	var salt []byte
+	err := h.updateRetry(func(txn *badger.Txn) error {
+		var err error
+		salt, err = getValue(txn, visitorSaltKey(day))
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		salt = make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		return setValue(txn, visitorSaltKey(day), salt, visitorMarkTTL)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.day, s.salt = day, salt
+	return salt, nil
+}
+
+// countVisitor counts the visitor of a click if it's the first of the day.
+func countVisitor(txn *badger.Txn, l linkRef, visitor string, now time.Time) error {
+	day := now.UTC().Format(dayFormat)
+	mark := visitorMarkKey(l, day, visitor)
+	if _, err := txn.Get(mark); err == nil {
+		return nil
+	} else if !errors.Is(err, badger.ErrKeyNotFound) {
+		return err
+	}
+	if err := setValue(txn, mark, nil, visitorMarkTTL); err != nil {
+		return err
+	}
+	if err := incr(txn, visitorsKey(l), 1); err != nil {
+		return err
+	}
+	return incr(txn, append(dailyVisitorsPrefix(l), day...), 1)
+}
@@ -0,0 +1,87 @@
+package coopurl
+
+import (
+	"container/heap"
+	"context"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// WithWarmUp reads the n most clicked links when the store is opened, so that the first redirects after a
+// restart don't wait for the disk: the links are loaded in the cache set by WithCache, and their values in the
+// page cache of the system. The warm-up runs in the background.
+func WithWarmUp(n int) Options {
+	return func(h *Handler) {
+		h.warmUp = n
+	}
+}
+
+type hotLink struct {
+	l      linkRef
+	clicks uint64
+}
+
+// hotLinks is a min-heap of links by clicks, keeping the most clicked ones.
+type hotLinks []hotLink
+
+func (s hotLinks) Len() int            { return len(s) }
+func (s hotLinks) Less(i, j int) bool  { return s[i].clicks < s[j].clicks }
+func (s hotLinks) Swap(i, j int)       { s[i], s[j] = s[j], s[i] }
+func (s *hotLinks) Push(x interface{}) { *s = append(*s, x.(hotLink)) }
+func (s *hotLinks) Pop() interface{} {
+	old := *s
+	x := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return x
+}
+
+// mostClicked returns the n most clicked links, most clicked first.
+func (h *Handler) mostClicked(n int) ([]linkRef, error) {
+	hot := make(hotLinks, 0, n)
+	prefix := metaPrefixKey("clicks")
+	err := h.db.View(func(txn *badger.Txn) error {
+		return iteratePrefix(txn, prefix, func(key string, value []byte) error {
+			domain, id := splitRef(key)
+			link := hotLink{l: linkRef{domain: domain, id: id}, clicks: decodeCounter(value)}
+			if hot.Len() < n {
+				heap.Push(&hot, link)
+			} else if link.clicks > hot[0].clicks {
+				hot[0] = link
+				heap.Fix(&hot, 0)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]linkRef, hot.Len())
+	for i := len(refs) - 1; i >= 0; i-- {
+		refs[i] = heap.Pop(&hot).(hotLink).l
+	}
+	return refs, nil
+}
+
+// warm reads the most clicked links, until ctx is done.
+func (h *Handler) warm(ctx context.Context) {
+	start := time.Now()
+	refs, err := h.mostClicked(h.warmUp)
+	if err != nil {
+		h.log(LogStore).Errorf("Couldn't find the links to warm up: %s", err)
+		return
+	}
+
+	var n int
+	for _, l := range refs {
+		if ctx.Err() != nil {
+			return
+		}
+		// expired and deleted links keep their clicks until archived
+		if _, err := h.get(req{domain: l.domain}, l.id); err == nil {
+			n++
+		}
+	}
+	h.log(LogStore).Infof("Warmed up %d links in %s", n, time.Since(start))
+}
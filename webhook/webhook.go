@@ -0,0 +1,183 @@
+// Package webhook signs the webhooks posted by the server, and verifies and decodes them in the services
+// receiving them, eg:
+//
+//	func serveAbuse(w http.ResponseWriter, r *http.Request) {
+//		event, err := webhook.Verify(r, secret)
+//		if err != nil {
+//			w.WriteHeader(http.StatusUnauthorized)
+//			return
+//		}
+//		var report webhook.AbuseReport
+//		if err := event.Decode(&report); err != nil {
+//			w.WriteHeader(http.StatusBadRequest)
+//			return
+//		}
+//		...
+//	}
+//
+// The event type and the body of a webhook are signed with HMAC-SHA256, given in the Coopurl-Signature header as
+// "t={unix time},v1={hex signature of "{unix time}.{event}.{body}"}", so that the Coopurl-Event header can't be
+// changed either. The header can have several v1 signatures while the secret is rotated, the webhook is valid if
+// one of them matches.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coopgo/coopurl/v2"
+)
+
+const (
+	SignatureHeader = "Coopurl-Signature"
+	EventHeader     = "Coopurl-Event" // type of the event, eg: EventAbuseReport
+)
+
+// DefaultTolerance is how old the signature of a webhook can be, so that captured webhooks can't be replayed later.
+const DefaultTolerance = 5 * time.Minute
+
+// maxBodySize bounds the bodies read by Verify.
+const maxBodySize = 1 << 20
+
+// Types of the events, given in the EventHeader.
+const (
+	EventAbuseReport  = coopurl.EventAbuseReport  // an AbuseReport
+	EventExpiryNotice = coopurl.EventExpiryNotice // an ExpiryNotice
+	EventPurge        = coopurl.EventPurge        // a Purge
+)
+
+// Payloads of the events.
+type (
+	AbuseReport  = coopurl.AbuseReport
+	ExpiryNotice = coopurl.ExpiryNotice
+	Purge        []string // surrogate keys of the changed links to purge from the cdn
+)
+
+var (
+	ErrNoSignature      = errors.New("webhook: no signature")
+	ErrInvalidSignature = errors.New("webhook: invalid signature")
+	ErrExpiredSignature = errors.New("webhook: expired signature")
+)
+
+// Event is a webhook whose signature was verified.
+type Event struct {
+	Type string // eg: EventAbuseReport, empty if not given, signed with the body
+	Time time.Time
+	Body []byte
+}
+
+// Decode decodes the JSON payload of the event into v, eg: an *AbuseReport.
+func (e *Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Body, v)
+}
+
+func signature(secret []byte, t int64, event string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d.%s.", t, event)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign sets the signature of body, the body of r, and of its EventHeader in the headers of r. The EventHeader
+// must be set before.
+func Sign(r *http.Request, body, secret []byte) {
+	t := time.Now().Unix()
+	r.Header.Set(SignatureHeader, "t="+strconv.FormatInt(t, 10)+",v1="+signature(secret, t, r.Header.Get(EventHeader), body))
+}
+
+// Verify checks the signature of the webhook r with secret, and that it was signed in the last DefaultTolerance,
+// then returns its event. The body of r can be read again afterwards.
+func Verify(r *http.Request, secret []byte) (*Event, error) {
+	return VerifyWithTolerance(r, secret, DefaultTolerance)
+}
+
+// VerifyWithTolerance is Verify accepting signatures up to tolerance old, whatever their age if 0.
+func VerifyWithTolerance(r *http.Request, secret []byte, tolerance time.Duration) (*Event, error) {
+	header := r.Header.Get(SignatureHeader)
+	if header == "" {
+		return nil, ErrNoSignature
+	}
+	var t int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			t, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	if t == 0 || len(signatures) == 0 {
+		return nil, ErrInvalidSignature
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBodySize {
+		return nil, fmt.Errorf("webhook: body over %d bytes", maxBodySize)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	event := r.Header.Get(EventHeader)
+	expected := []byte(signature(secret, t, event, body))
+	valid := false
+	for _, s := range signatures {
+		valid = valid || hmac.Equal([]byte(s), expected)
+	}
+	if !valid {
+		return nil, ErrInvalidSignature
+	}
+	at := time.Unix(t, 0)
+	age := time.Since(at)
+	if age < 0 {
+		age = -age // clocks of the server and the receiver may drift
+	}
+	if tolerance > 0 && age > tolerance {
+		return nil, ErrExpiredSignature
+	}
+	return &Event{Type: event, Time: at, Body: body}, nil
+}
+
+// Post posts the payload v of the event as JSON to url with client, signed with secret unless it is empty.
+// Answers other than 2xx are errors.
+func Post(ctx context.Context, client *http.Client, url, event string, secret []byte, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	if len(secret) > 0 {
+		Sign(req, body, secret)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
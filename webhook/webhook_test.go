@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signedRequest(t *testing.T, event string, body, secret []byte) *http.Request {
+	r, err := http.NewRequest(http.MethodPost, "https://example.com/hook", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set(EventHeader, event)
+	Sign(r, body, secret)
+	return r
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`["k1"]`)
+
+	r := signedRequest(t, EventPurge, body, secret)
+	e, err := Verify(r, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != EventPurge || !bytes.Equal(e.Body, body) {
+		t.Fatalf("got event %q %s", e.Type, e.Body)
+	}
+
+	r = signedRequest(t, EventPurge, body, secret)
+	r.Body = io.NopCloser(bytes.NewReader([]byte(`["k2"]`)))
+	if _, err := Verify(r, secret); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("tampered body: got %v", err)
+	}
+
+	r = signedRequest(t, EventPurge, body, secret)
+	r.Header.Set(EventHeader, EventAbuseReport)
+	if _, err := Verify(r, secret); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("tampered event: got %v", err)
+	}
+
+	r = signedRequest(t, EventPurge, body, secret)
+	if _, err := Verify(r, []byte("other")); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("other secret: got %v", err)
+	}
+
+	r = signedRequest(t, EventPurge, body, secret)
+	r.Header.Del(SignatureHeader)
+	if _, err := Verify(r, secret); !errors.Is(err, ErrNoSignature) {
+		t.Fatalf("no signature: got %v", err)
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`["k1"]`)
+	ts := time.Now().Add(-DefaultTolerance - time.Minute).Unix()
+
+	r := signedRequest(t, EventPurge, body, secret)
+	r.Header.Set(SignatureHeader, "t="+strconv.FormatInt(ts, 10)+",v1="+signature(secret, ts, EventPurge, body))
+	if _, err := Verify(r, secret); !errors.Is(err, ErrExpiredSignature) {
+		t.Fatalf("got %v", err)
+	}
+	r = signedRequest(t, EventPurge, body, secret)
+	r.Header.Set(SignatureHeader, "t="+strconv.FormatInt(ts, 10)+",v1="+signature(secret, ts, EventPurge, body))
+	if _, err := VerifyWithTolerance(r, secret, 0); err != nil {
+		t.Fatalf("no tolerance: got %v", err)
+	}
+}
+
+func TestVerifyRotation(t *testing.T) {
+	old, current := []byte("old"), []byte("current")
+	body := []byte(`["k1"]`)
+	ts := time.Now().Unix()
+	header := "t=" + strconv.FormatInt(ts, 10) + ",v1=" + signature(old, ts, EventPurge, body) + ",v1=" + signature(current, ts, EventPurge, body)
+
+	for _, secret := range [][]byte{old, current} {
+		r := signedRequest(t, EventPurge, body, secret)
+		r.Header.Set(SignatureHeader, header)
+		if _, err := Verify(r, secret); err != nil {
+			t.Fatalf("secret %s: got %v", secret, err)
+		}
+	}
+	r := signedRequest(t, EventPurge, body, old)
+	r.Header.Set(SignatureHeader, header)
+	if _, err := Verify(r, []byte("revoked")); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("revoked secret: got %v", err)
+	}
+}